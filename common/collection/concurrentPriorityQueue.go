@@ -22,12 +22,16 @@ package collection
 
 import (
 	"sync"
+	"sync/atomic"
 )
 
 type (
 	concurrentPriorityQueueImpl struct {
 		lock          sync.Mutex
 		priorityQueue Queue
+		// length is the number of items, it's updated while holding the lock
+		// and can be read without holding it
+		length int64
 	}
 )
 
@@ -51,7 +55,7 @@ func (pq *concurrentPriorityQueueImpl) Add(item interface{}) {
 	pq.lock.Lock()
 	defer pq.lock.Unlock()
 
-	pq.priorityQueue.Add(item)
+	pq.addLocked(item)
 }
 
 // Remove pop an item from priority queue
@@ -59,7 +63,9 @@ func (pq *concurrentPriorityQueueImpl) Remove() interface{} {
 	pq.lock.Lock()
 	defer pq.lock.Unlock()
 
-	return pq.priorityQueue.Remove()
+	item := pq.priorityQueue.Remove()
+	pq.updateLengthLocked()
+	return item
 }
 
 // IsEmpty indicate if the priority queue is empty
@@ -70,10 +76,58 @@ func (pq *concurrentPriorityQueueImpl) IsEmpty() bool {
 	return pq.priorityQueue.IsEmpty()
 }
 
-// Len return the size of the queue
+// Len return the size of the queue without acquiring the lock
 func (pq *concurrentPriorityQueueImpl) Len() int {
+	return int(atomic.LoadInt64(&pq.length))
+}
+
+// LenSnapshot return the size of the queue while holding the lock
+func (pq *concurrentPriorityQueueImpl) LenSnapshot() int {
 	pq.lock.Lock()
 	defer pq.lock.Unlock()
 
 	return pq.priorityQueue.Len()
 }
+
+// Snapshot returns a copy of all items in the priority queue,
+// items are not sorted by priority
+func (pq *concurrentPriorityQueueImpl) Snapshot() []interface{} {
+	pq.lock.Lock()
+	defer pq.lock.Unlock()
+
+	return pq.priorityQueue.Snapshot()
+}
+
+// Contains returns true if any item in the priority queue satisfies the predicate, the cost is O(n)
+func (pq *concurrentPriorityQueueImpl) Contains(predicate func(item interface{}) bool) bool {
+	pq.lock.Lock()
+	defer pq.lock.Unlock()
+
+	return pq.priorityQueue.Contains(predicate)
+}
+
+func (pq *concurrentPriorityQueueImpl) lockQueue() {
+	pq.lock.Lock()
+}
+
+func (pq *concurrentPriorityQueueImpl) unlockQueue() {
+	pq.lock.Unlock()
+}
+
+func (pq *concurrentPriorityQueueImpl) addLocked(item interface{}) {
+	pq.priorityQueue.Add(item)
+	pq.updateLengthLocked()
+}
+
+func (pq *concurrentPriorityQueueImpl) removeLocked() interface{} {
+	if pq.priorityQueue.IsEmpty() {
+		return nil
+	}
+	item := pq.priorityQueue.Remove()
+	pq.updateLengthLocked()
+	return item
+}
+
+func (pq *concurrentPriorityQueueImpl) updateLengthLocked() {
+	atomic.StoreInt64(&pq.length, int64(pq.priorityQueue.Len()))
+}
@@ -22,12 +22,16 @@ package collection
 
 import (
 	"sync"
+	"sync/atomic"
 )
 
 type (
 	concurrentQueueImpl struct {
 		sync.Mutex
 		items []interface{}
+		// length is the number of items, it's updated while holding the lock
+		// and can be read without holding it
+		length int64
 	}
 )
 
@@ -56,21 +60,14 @@ func (q *concurrentQueueImpl) Add(item interface{}) {
 	q.Lock()
 	defer q.Unlock()
 
-	q.items = append(q.items, item)
+	q.addLocked(item)
 }
 
 func (q *concurrentQueueImpl) Remove() interface{} {
 	q.Lock()
 	defer q.Unlock()
-	if q.isEmptyLocked() {
-		return nil
-	}
 
-	item := q.items[0]
-	q.items[0] = nil
-	q.items = q.items[1:]
-
-	return item
+	return q.removeLocked()
 }
 
 func (q *concurrentQueueImpl) IsEmpty() bool {
@@ -81,12 +78,65 @@ func (q *concurrentQueueImpl) IsEmpty() bool {
 }
 
 func (q *concurrentQueueImpl) Len() int {
+	return int(atomic.LoadInt64(&q.length))
+}
+
+func (q *concurrentQueueImpl) LenSnapshot() int {
 	q.Lock()
 	defer q.Unlock()
 
 	return len(q.items)
 }
 
+// Snapshot returns a copy of all items in the queue in FIFO order
+func (q *concurrentQueueImpl) Snapshot() []interface{} {
+	q.Lock()
+	defer q.Unlock()
+
+	items := make([]interface{}, len(q.items))
+	copy(items, q.items)
+	return items
+}
+
+// Contains returns true if any item in the queue satisfies the predicate, the cost is O(n)
+func (q *concurrentQueueImpl) Contains(predicate func(item interface{}) bool) bool {
+	q.Lock()
+	defer q.Unlock()
+
+	for _, item := range q.items {
+		if predicate(item) {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *concurrentQueueImpl) lockQueue() {
+	q.Lock()
+}
+
+func (q *concurrentQueueImpl) unlockQueue() {
+	q.Unlock()
+}
+
+func (q *concurrentQueueImpl) addLocked(item interface{}) {
+	q.items = append(q.items, item)
+	atomic.StoreInt64(&q.length, int64(len(q.items)))
+}
+
+func (q *concurrentQueueImpl) removeLocked() interface{} {
+	if q.isEmptyLocked() {
+		return nil
+	}
+
+	item := q.items[0]
+	q.items[0] = nil
+	q.items = q.items[1:]
+	atomic.StoreInt64(&q.length, int64(len(q.items)))
+
+	return item
+}
+
 func (q *concurrentQueueImpl) isEmptyLocked() bool {
 	return len(q.items) == 0
 }
@@ -79,6 +79,54 @@ func (s *concurrentQueueSuite) TestAddAndRemove() {
 	s.Nil(s.concurrentQueue.Remove())
 }
 
+func (s *concurrentQueueSuite) TestSnapshot() {
+	s.Empty(s.concurrentQueue.Snapshot())
+
+	numItems := 10
+	items := make([]interface{}, 0, numItems)
+	for i := 0; i != numItems; i++ {
+		num := rand.Int()
+		items = append(items, num)
+		s.concurrentQueue.Add(num)
+	}
+
+	snapshot := s.concurrentQueue.Snapshot()
+	s.Equal(items, snapshot)
+	s.Equal(numItems, s.concurrentQueue.Len())
+
+	// modifying the snapshot should not affect the queue
+	snapshot[0] = nil
+	s.Equal(items[0], s.concurrentQueue.Remove())
+	s.Equal(items[1:], s.concurrentQueue.Snapshot())
+}
+
+func (s *concurrentQueueSuite) TestContains() {
+	isItem := func(target int) func(interface{}) bool {
+		return func(item interface{}) bool {
+			return item.(int) == target
+		}
+	}
+	addIfAbsent := func(num int) {
+		if !s.concurrentQueue.Contains(isItem(num)) {
+			s.concurrentQueue.Add(num)
+		}
+	}
+
+	s.False(s.concurrentQueue.Contains(isItem(1)))
+
+	for _, num := range []int{1, 2, 1, 3, 2, 1} {
+		addIfAbsent(num)
+	}
+	s.Equal([]interface{}{1, 2, 3}, s.concurrentQueue.Snapshot())
+	s.True(s.concurrentQueue.Contains(isItem(2)))
+	s.False(s.concurrentQueue.Contains(isItem(4)))
+
+	// removed items can be added again
+	s.Equal(1, s.concurrentQueue.Remove())
+	addIfAbsent(1)
+	s.Equal([]interface{}{2, 3, 1}, s.concurrentQueue.Snapshot())
+}
+
 func (s *concurrentQueueSuite) TestMultipleProducer() {
 	concurrency := 10
 	numItemsPerProducer := 10
@@ -96,6 +144,7 @@ func (s *concurrentQueueSuite) TestMultipleProducer() {
 	wg.Wait()
 
 	expectedLength := concurrency * numItemsPerProducer
+	s.Equal(expectedLength, s.concurrentQueue.LenSnapshot())
 	s.Equal(expectedLength, s.concurrentQueue.Len())
 	s.False(s.concurrentQueue.IsEmpty())
 	for i := 0; i != expectedLength; i++ {
@@ -0,0 +1,181 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package collection
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/uber/cadence/common/clock"
+)
+
+type (
+	delayedQueueImpl struct {
+		sync.Mutex
+
+		timeSource clock.TimeSource
+		items      Queue // priority queue of *delayedItem ordered by ready time
+		nextSeq    int64
+	}
+
+	delayedItem struct {
+		item      interface{}
+		readyTime time.Time
+		seq       int64 // keeps FIFO order for items with the same ready time
+	}
+)
+
+// NewDelayedQueue creates a new delayed queue, readiness of
+// items is determined by the time from the given time source
+func NewDelayedQueue(
+	timeSource clock.TimeSource,
+) DelayedQueue {
+	return &delayedQueueImpl{
+		timeSource: timeSource,
+		items:      NewPriorityQueue(delayedItemLess),
+	}
+}
+
+func (q *delayedQueueImpl) Add(
+	item interface{},
+	readyTime time.Time,
+) {
+	if item == nil {
+		panic("cannot add nil item to queue")
+	}
+
+	q.Lock()
+	defer q.Unlock()
+
+	q.items.Add(&delayedItem{
+		item:      item,
+		readyTime: readyTime,
+		seq:       q.nextSeq,
+	})
+	q.nextSeq++
+}
+
+func (q *delayedQueueImpl) Peek() interface{} {
+	q.Lock()
+	defer q.Unlock()
+
+	if !q.hasReadyItemLocked(q.timeSource.Now()) {
+		return nil
+	}
+	return q.items.Peek().(*delayedItem).item
+}
+
+func (q *delayedQueueImpl) Remove() interface{} {
+	q.Lock()
+	defer q.Unlock()
+
+	if !q.hasReadyItemLocked(q.timeSource.Now()) {
+		return nil
+	}
+	return q.items.Remove().(*delayedItem).item
+}
+
+func (q *delayedQueueImpl) DrainTo(
+	queue Queue,
+) int {
+	q.Lock()
+	defer q.Unlock()
+
+	now := q.timeSource.Now()
+	numDrained := 0
+	for q.hasReadyItemLocked(now) {
+		queue.Add(q.items.Remove().(*delayedItem).item)
+		numDrained++
+	}
+	return numDrained
+}
+
+func (q *delayedQueueImpl) IsEmpty() bool {
+	q.Lock()
+	defer q.Unlock()
+
+	return !q.hasReadyItemLocked(q.timeSource.Now())
+}
+
+func (q *delayedQueueImpl) Len() int {
+	q.Lock()
+	defer q.Unlock()
+
+	now := q.timeSource.Now()
+	numReady := 0
+	for _, item := range q.items.Snapshot() {
+		if !item.(*delayedItem).readyTime.After(now) {
+			numReady++
+		}
+	}
+	return numReady
+}
+
+func (q *delayedQueueImpl) Size() int {
+	q.Lock()
+	defer q.Unlock()
+
+	return q.items.Len()
+}
+
+func (q *delayedQueueImpl) NextReadyTime() (time.Time, bool) {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.items.IsEmpty() {
+		return time.Time{}, false
+	}
+	return q.items.Peek().(*delayedItem).readyTime, true
+}
+
+func (q *delayedQueueImpl) Snapshot() []interface{} {
+	q.Lock()
+	defer q.Unlock()
+
+	delayedItems := q.items.Snapshot()
+	sort.Slice(delayedItems, func(i, j int) bool {
+		return delayedItemLess(delayedItems[i], delayedItems[j])
+	})
+	items := make([]interface{}, 0, len(delayedItems))
+	for _, item := range delayedItems {
+		items = append(items, item.(*delayedItem).item)
+	}
+	return items
+}
+
+func (q *delayedQueueImpl) hasReadyItemLocked(
+	now time.Time,
+) bool {
+	return !q.items.IsEmpty() && !q.items.Peek().(*delayedItem).readyTime.After(now)
+}
+
+func delayedItemLess(
+	this interface{},
+	other interface{},
+) bool {
+	thisItem := this.(*delayedItem)
+	otherItem := other.(*delayedItem)
+	if thisItem.readyTime.Equal(otherItem.readyTime) {
+		return thisItem.seq < otherItem.seq
+	}
+	return thisItem.readyTime.Before(otherItem.readyTime)
+}
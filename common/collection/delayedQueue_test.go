@@ -0,0 +1,168 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package collection
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/uber/cadence/common/clock"
+)
+
+type (
+	delayedQueueSuite struct {
+		*require.Assertions
+		suite.Suite
+
+		now          time.Time
+		timeSource   *clock.EventTimeSource
+		delayedQueue DelayedQueue
+	}
+)
+
+func TestDelayedQueueSuite(t *testing.T) {
+	s := new(delayedQueueSuite)
+	suite.Run(t, s)
+}
+
+func (s *delayedQueueSuite) SetupTest() {
+	s.Assertions = require.New(s.T())
+
+	s.now = time.Now()
+	s.timeSource = clock.NewEventTimeSource().Update(s.now)
+	s.delayedQueue = NewDelayedQueue(s.timeSource)
+}
+
+func (s *delayedQueueSuite) TestAddAndRemove() {
+	s.True(s.delayedQueue.IsEmpty())
+	s.Zero(s.delayedQueue.Len())
+	s.Nil(s.delayedQueue.Peek())
+	s.Nil(s.delayedQueue.Remove())
+	_, ok := s.delayedQueue.NextReadyTime()
+	s.False(ok)
+
+	s.delayedQueue.Add(3, s.now.Add(3*time.Second))
+	s.delayedQueue.Add(1, s.now.Add(time.Second))
+	s.delayedQueue.Add(0, s.now)
+	s.delayedQueue.Add(2, s.now.Add(time.Second))
+
+	s.Equal(4, s.delayedQueue.Size())
+	s.Equal(1, s.delayedQueue.Len())
+	nextReadyTime, ok := s.delayedQueue.NextReadyTime()
+	s.True(ok)
+	s.True(s.now.Equal(nextReadyTime))
+	s.Equal(0, s.delayedQueue.Peek())
+	s.Equal(0, s.delayedQueue.Remove())
+	s.True(s.delayedQueue.IsEmpty())
+	s.Nil(s.delayedQueue.Remove())
+
+	s.timeSource.Update(s.now.Add(time.Second))
+	s.False(s.delayedQueue.IsEmpty())
+	s.Equal(2, s.delayedQueue.Len())
+	// items with the same ready time are removed in FIFO order
+	s.Equal(1, s.delayedQueue.Remove())
+	s.Equal(2, s.delayedQueue.Remove())
+	s.Nil(s.delayedQueue.Remove())
+	s.Equal(1, s.delayedQueue.Size())
+
+	s.timeSource.Update(s.now.Add(2 * time.Second))
+	s.Nil(s.delayedQueue.Peek())
+
+	s.timeSource.Update(s.now.Add(3 * time.Second))
+	s.Equal(3, s.delayedQueue.Remove())
+	s.Zero(s.delayedQueue.Size())
+}
+
+func (s *delayedQueueSuite) TestDrainTo() {
+	numItems := 10
+	for i := 0; i != numItems; i++ {
+		s.delayedQueue.Add(i, s.now.Add(time.Duration(i)*time.Second))
+	}
+
+	queue := NewConcurrentQueue()
+	s.Equal(1, s.delayedQueue.DrainTo(queue))
+
+	s.timeSource.Update(s.now.Add(4 * time.Second))
+	s.Equal(4, s.delayedQueue.DrainTo(queue))
+	s.Zero(s.delayedQueue.DrainTo(queue))
+
+	s.timeSource.Update(s.now.Add(time.Duration(numItems) * time.Second))
+	s.Equal(numItems-5, s.delayedQueue.DrainTo(queue))
+	s.Zero(s.delayedQueue.Size())
+
+	for i := 0; i != numItems; i++ {
+		s.Equal(i, queue.Remove())
+	}
+}
+
+func (s *delayedQueueSuite) TestSnapshot() {
+	s.Empty(s.delayedQueue.Snapshot())
+
+	s.delayedQueue.Add(3, s.now.Add(3*time.Second))
+	s.delayedQueue.Add(1, s.now.Add(time.Second))
+	s.delayedQueue.Add(0, s.now)
+	s.delayedQueue.Add(2, s.now.Add(time.Second))
+
+	s.Equal([]interface{}{0, 1, 2, 3}, s.delayedQueue.Snapshot())
+	s.Equal(4, s.delayedQueue.Size())
+}
+
+func (s *delayedQueueSuite) TestConcurrentAddAndRemove() {
+	numRoutines := 10
+	numItemsPerRoutine := 100
+
+	var wg sync.WaitGroup
+	wg.Add(numRoutines)
+	for i := 0; i != numRoutines; i++ {
+		go func() {
+			defer wg.Done()
+			for j := 0; j != numItemsPerRoutine; j++ {
+				s.delayedQueue.Add(j, s.now.Add(time.Duration(j%2)*time.Second))
+			}
+		}()
+	}
+	wg.Wait()
+
+	numRemoved := 0
+	for s.delayedQueue.Remove() != nil {
+		numRemoved++
+	}
+	s.Equal(numRoutines*numItemsPerRoutine/2, numRemoved)
+
+	s.timeSource.Update(s.now.Add(time.Second))
+	wg.Add(numRoutines)
+	removed := make(chan interface{}, numRoutines*numItemsPerRoutine)
+	for i := 0; i != numRoutines; i++ {
+		go func() {
+			defer wg.Done()
+			for item := s.delayedQueue.Remove(); item != nil; item = s.delayedQueue.Remove() {
+				removed <- item
+			}
+		}()
+	}
+	wg.Wait()
+	s.Len(removed, numRoutines*numItemsPerRoutine/2)
+	s.Zero(s.delayedQueue.Size())
+}
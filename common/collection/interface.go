@@ -20,6 +20,10 @@
 
 package collection
 
+import (
+	"time"
+)
+
 type (
 	// Queue is the interface for queue
 	Queue interface {
@@ -31,8 +35,50 @@ type (
 		Remove() interface{}
 		// IsEmpty indicate if the queue is empty
 		IsEmpty() bool
-		// Len return the size of the queue
+		// Len return the size of the queue. Concurrent implementations read the size without
+		// acquiring the queue lock, so it may be transient, e.g. while items are moved by
+		// TransferAll. Use it for metrics and logging, and LenSnapshot for correctness
+		Len() int
+		// LenSnapshot returns the size of the queue while holding the queue lock for concurrent
+		// implementations, so it's consistent with all operations on the queue that have returned
+		// and never observes a partially applied TransferAll. It may be stale as soon as it's
+		// returned if there are other goroutines modifying the queue
+		LenSnapshot() int
+		// Snapshot returns a copy of all items in the queue without removing them
+		Snapshot() []interface{}
+		// Contains returns true if any item in the queue satisfies the predicate, e.g. to skip
+		// adding an item that's already in the queue. It scans all items, so the cost is O(n) and
+		// concurrent implementations hold the queue lock during the scan. The predicate must not
+		// access the queue. Callers on a hot path should track membership with a map keyed by
+		// item identity next to the queue instead
+		Contains(predicate func(item interface{}) bool) bool
+	}
+
+	// DelayedQueue is a concurrent queue where each item only becomes
+	// visible after its ready time. Ready items are returned in the order
+	// of their ready time, items with the same ready time are returned in FIFO order
+	DelayedQueue interface {
+		// Add pushes an item to the queue, the item can be removed only after readyTime
+		Add(item interface{}, readyTime time.Time)
+		// Peek returns the first ready item without removing it, nil if no item is ready
+		Peek() interface{}
+		// Remove pops the first ready item, nil if no item is ready
+		Remove() interface{}
+		// DrainTo removes all ready items and adds them to the given queue,
+		// returns the number of items moved
+		DrainTo(queue Queue) int
+		// IsEmpty indicates if there's no ready item in the queue
+		IsEmpty() bool
+		// Len returns the number of ready items in the queue
 		Len() int
+		// Size returns the number of all items in the queue, including those not ready yet
+		Size() int
+		// NextReadyTime returns the earliest ready time of all items,
+		// false is returned if there's no item in the queue
+		NextReadyTime() (time.Time, bool)
+		// Snapshot returns all items in the queue, including those not ready yet,
+		// in the order they will be removed
+		Snapshot() []interface{}
 	}
 
 	// HashFunc represents a hash function for string
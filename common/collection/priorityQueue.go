@@ -61,6 +61,29 @@ func (pq *priorityQueueImpl) IsEmpty() bool {
 	return pq.Len() == 0
 }
 
+// Snapshot returns a copy of all items in the priority queue,
+// items are not sorted by priority
+func (pq *priorityQueueImpl) Snapshot() []interface{} {
+	items := make([]interface{}, len(pq.items))
+	copy(items, pq.items)
+	return items
+}
+
+// Contains returns true if any item in the priority queue satisfies the predicate, the cost is O(n)
+func (pq *priorityQueueImpl) Contains(predicate func(item interface{}) bool) bool {
+	for _, item := range pq.items {
+		if predicate(item) {
+			return true
+		}
+	}
+	return false
+}
+
+// LenSnapshot is the same as Len as the priority queue is not concurrent
+func (pq *priorityQueueImpl) LenSnapshot() int {
+	return pq.Len()
+}
+
 // below are the functions used by heap.Interface and go internal heap implementation
 
 // Len implements sort.Interface
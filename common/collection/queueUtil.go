@@ -0,0 +1,90 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package collection
+
+import (
+	"reflect"
+)
+
+type (
+	// lockableQueue is implemented by concurrent queues so that
+	// operations across multiple queues can be performed atomically
+	lockableQueue interface {
+		lockQueue()
+		unlockQueue()
+		addLocked(item interface{})
+		removeLocked() interface{}
+	}
+)
+
+// TransferAll atomically moves all items in src to dst and returns the number of items moved.
+// Concurrent queues are locked for the entire transfer so that no other goroutine can observe
+// an item in both or neither of the queues. Locks are always acquired in the same order
+// regardless of the order of arguments, so concurrent transfers won't deadlock.
+func TransferAll(
+	src Queue,
+	dst Queue,
+) int {
+	if src == dst {
+		return 0
+	}
+
+	first, second := src, dst
+	if reflect.ValueOf(second).Pointer() < reflect.ValueOf(first).Pointer() {
+		first, second = second, first
+	}
+	for _, queue := range []Queue{first, second} {
+		if lockable, ok := queue.(lockableQueue); ok {
+			lockable.lockQueue()
+			defer lockable.unlockQueue()
+		}
+	}
+
+	numTransferred := 0
+	for item := removeLocked(src); item != nil; item = removeLocked(src) {
+		addLocked(dst, item)
+		numTransferred++
+	}
+	return numTransferred
+}
+
+func addLocked(
+	queue Queue,
+	item interface{},
+) {
+	if lockable, ok := queue.(lockableQueue); ok {
+		lockable.addLocked(item)
+		return
+	}
+	queue.Add(item)
+}
+
+func removeLocked(
+	queue Queue,
+) interface{} {
+	if lockable, ok := queue.(lockableQueue); ok {
+		return lockable.removeLocked()
+	}
+	if queue.IsEmpty() {
+		return nil
+	}
+	return queue.Remove()
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package collection
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type (
+	queueUtilSuite struct {
+		*require.Assertions
+		suite.Suite
+	}
+)
+
+func TestQueueUtilSuite(t *testing.T) {
+	s := new(queueUtilSuite)
+	suite.Run(t, s)
+}
+
+func (s *queueUtilSuite) SetupTest() {
+	s.Assertions = require.New(s.T())
+}
+
+func (s *queueUtilSuite) TestTransferAll() {
+	src := NewConcurrentQueue()
+	dst := NewConcurrentPriorityQueue(func(this interface{}, other interface{}) bool {
+		return this.(int) < other.(int)
+	})
+
+	numItems := 10
+	for i := numItems; i != 0; i-- {
+		src.Add(i)
+	}
+	dst.Add(0)
+
+	s.Equal(numItems, TransferAll(src, dst))
+	s.True(src.IsEmpty())
+	s.Equal(numItems+1, dst.Len())
+	for i := 0; i <= numItems; i++ {
+		s.Equal(i, dst.Remove())
+	}
+
+	s.Zero(TransferAll(src, dst))
+	s.Zero(TransferAll(dst, dst))
+}
+
+func (s *queueUtilSuite) TestTransferAll_NonConcurrentQueue() {
+	src := NewPriorityQueue(func(this interface{}, other interface{}) bool {
+		return this.(int) < other.(int)
+	})
+	dst := NewConcurrentQueue()
+
+	numItems := 10
+	for i := numItems; i != 0; i-- {
+		src.Add(i)
+	}
+
+	s.Equal(numItems, TransferAll(src, dst))
+	s.True(src.IsEmpty())
+	s.Equal(numItems, dst.Len())
+	for i := 1; i <= numItems; i++ {
+		s.Equal(i, dst.Remove())
+	}
+	s.Zero(TransferAll(src, dst))
+}
+
+func (s *queueUtilSuite) TestTransferAll_Concurrent() {
+	queue1 := NewConcurrentQueue()
+	queue2 := NewConcurrentQueue()
+
+	numItemsPerQueue := 1000
+	for i := 0; i != numItemsPerQueue; i++ {
+		queue1.Add(i)
+		queue2.Add(numItemsPerQueue + i)
+	}
+
+	numRoutines := 10
+	numTransfers := 100
+	numItemsAdded := 100
+	var wg sync.WaitGroup
+	wg.Add(3 * numRoutines)
+	for i := 0; i != numRoutines; i++ {
+		go func() {
+			defer wg.Done()
+			for j := 0; j != numTransfers; j++ {
+				TransferAll(queue1, queue2)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j != numTransfers; j++ {
+				TransferAll(queue2, queue1)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j != numItemsAdded; j++ {
+				if j%2 == 0 {
+					queue1.Add(-1)
+				} else {
+					queue2.Add(-1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	s.Equal(2*numItemsPerQueue+numRoutines*numItemsAdded, queue1.LenSnapshot()+queue2.LenSnapshot())
+
+	// no item is lost or duplicated during transfers
+	items := make(map[int]int)
+	for _, queue := range []Queue{queue1, queue2} {
+		for !queue.IsEmpty() {
+			items[queue.Remove().(int)]++
+		}
+	}
+	s.Equal(numRoutines*numItemsAdded, items[-1])
+	delete(items, -1)
+	s.Len(items, 2*numItemsPerQueue)
+	for _, count := range items {
+		s.Equal(1, count)
+	}
+}
+
+func (s *queueUtilSuite) TestLenSnapshot() {
+	compareLess := func(this interface{}, other interface{}) bool {
+		return this.(int) < other.(int)
+	}
+	queues := []Queue{
+		NewConcurrentQueue(),
+		NewRingBufferQueue(2),
+		NewConcurrentPriorityQueue(compareLess),
+		NewPriorityQueue(compareLess),
+	}
+
+	for _, queue := range queues {
+		numItems := 5
+		for i := 0; i != numItems; i++ {
+			queue.Add(i)
+		}
+		s.Equal(numItems, queue.Len())
+		s.Equal(numItems, queue.LenSnapshot())
+
+		queue.Remove()
+		s.Equal(numItems-1, queue.Len())
+		s.Equal(numItems-1, queue.LenSnapshot())
+
+		dst := NewConcurrentQueue()
+		s.Equal(numItems-1, TransferAll(queue, dst))
+		s.Zero(queue.Len())
+		s.Zero(queue.LenSnapshot())
+		s.Equal(numItems-1, dst.Len())
+		s.Equal(numItems-1, dst.LenSnapshot())
+	}
+}
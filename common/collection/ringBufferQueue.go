@@ -0,0 +1,166 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package collection
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+type (
+	ringBufferQueueImpl struct {
+		sync.Mutex
+		items []interface{}
+		head  int
+		size  int
+		// length is the same as size, but can be read without holding the lock
+		length int64
+	}
+)
+
+// NewRingBufferQueue creates a new concurrent queue backed by a preallocated circular buffer
+// of the given capacity. The buffer is doubled when it's full, so the capacity only bounds
+// allocations, not the number of items. Removed slots are reused, so unlike the queue created
+// by NewConcurrentQueue, the buffer is not reallocated under a steady churn of Add and Remove
+func NewRingBufferQueue(
+	capacity int,
+) Queue {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &ringBufferQueueImpl{
+		items: make([]interface{}, capacity),
+	}
+}
+
+func (q *ringBufferQueueImpl) Peek() interface{} {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.isEmptyLocked() {
+		return nil
+	}
+	return q.items[q.head]
+}
+
+func (q *ringBufferQueueImpl) Add(item interface{}) {
+	if item == nil {
+		panic("cannot add nil item to queue")
+	}
+
+	q.Lock()
+	defer q.Unlock()
+
+	q.addLocked(item)
+}
+
+func (q *ringBufferQueueImpl) Remove() interface{} {
+	q.Lock()
+	defer q.Unlock()
+
+	return q.removeLocked()
+}
+
+func (q *ringBufferQueueImpl) IsEmpty() bool {
+	q.Lock()
+	defer q.Unlock()
+
+	return q.isEmptyLocked()
+}
+
+func (q *ringBufferQueueImpl) Len() int {
+	return int(atomic.LoadInt64(&q.length))
+}
+
+func (q *ringBufferQueueImpl) LenSnapshot() int {
+	q.Lock()
+	defer q.Unlock()
+
+	return q.size
+}
+
+// Snapshot returns a copy of all items in the queue in FIFO order
+func (q *ringBufferQueueImpl) Snapshot() []interface{} {
+	q.Lock()
+	defer q.Unlock()
+
+	items := make([]interface{}, q.size)
+	q.copyLocked(items)
+	return items
+}
+
+// Contains returns true if any item in the queue satisfies the predicate, the cost is O(n)
+func (q *ringBufferQueueImpl) Contains(predicate func(item interface{}) bool) bool {
+	q.Lock()
+	defer q.Unlock()
+
+	for i := 0; i != q.size; i++ {
+		if predicate(q.items[(q.head+i)%len(q.items)]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *ringBufferQueueImpl) lockQueue() {
+	q.Lock()
+}
+
+func (q *ringBufferQueueImpl) unlockQueue() {
+	q.Unlock()
+}
+
+func (q *ringBufferQueueImpl) addLocked(item interface{}) {
+	if q.size == len(q.items) {
+		items := make([]interface{}, 2*len(q.items))
+		q.copyLocked(items)
+		q.items = items
+		q.head = 0
+	}
+
+	q.items[(q.head+q.size)%len(q.items)] = item
+	q.size++
+	atomic.StoreInt64(&q.length, int64(q.size))
+}
+
+func (q *ringBufferQueueImpl) removeLocked() interface{} {
+	if q.isEmptyLocked() {
+		return nil
+	}
+
+	item := q.items[q.head]
+	q.items[q.head] = nil
+	q.head = (q.head + 1) % len(q.items)
+	q.size--
+	atomic.StoreInt64(&q.length, int64(q.size))
+
+	return item
+}
+
+func (q *ringBufferQueueImpl) isEmptyLocked() bool {
+	return q.size == 0
+}
+
+// copyLocked copies items in FIFO order to dst, which must have a length of at least q.size
+func (q *ringBufferQueueImpl) copyLocked(dst []interface{}) {
+	n := copy(dst[:q.size], q.items[q.head:])
+	copy(dst[n:q.size], q.items[:q.size-n])
+}
@@ -0,0 +1,203 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package collection
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type (
+	ringBufferQueueSuite struct {
+		*require.Assertions
+		suite.Suite
+
+		ringBufferQueue *ringBufferQueueImpl
+	}
+)
+
+func TestRingBufferQueueSuite(t *testing.T) {
+	s := new(ringBufferQueueSuite)
+	suite.Run(t, s)
+}
+
+func (s *ringBufferQueueSuite) SetupTest() {
+	s.Assertions = require.New(s.T())
+
+	s.ringBufferQueue = NewRingBufferQueue(4).(*ringBufferQueueImpl)
+}
+
+func (s *ringBufferQueueSuite) TestAddAndRemove() {
+	s.Equal(0, s.ringBufferQueue.Len())
+	s.True(s.ringBufferQueue.IsEmpty())
+	s.Nil(s.ringBufferQueue.Peek())
+	s.Nil(s.ringBufferQueue.Remove())
+
+	// interleave adds and removes so that items wrap around the end of the buffer
+	nextItem, nextRemoved := 0, 0
+	for round := 0; round != 10; round++ {
+		for i := 0; i != 3; i++ {
+			s.ringBufferQueue.Add(nextItem)
+			nextItem++
+		}
+		for i := 0; i != 2; i++ {
+			s.Equal(nextRemoved, s.ringBufferQueue.Peek())
+			s.Equal(nextRemoved, s.ringBufferQueue.Remove())
+			nextRemoved++
+		}
+		s.Equal(nextItem-nextRemoved, s.ringBufferQueue.Len())
+	}
+	s.False(s.ringBufferQueue.IsEmpty())
+
+	for ; nextRemoved != nextItem; nextRemoved++ {
+		s.Equal(nextRemoved, s.ringBufferQueue.Remove())
+	}
+	s.True(s.ringBufferQueue.IsEmpty())
+	s.Nil(s.ringBufferQueue.Peek())
+	s.Nil(s.ringBufferQueue.Remove())
+}
+
+func (s *ringBufferQueueSuite) TestGrow() {
+	// move the head to the middle of the buffer so that the buffer is full while wrapped
+	s.ringBufferQueue.Add(-2)
+	s.ringBufferQueue.Add(-1)
+	s.Equal(-2, s.ringBufferQueue.Remove())
+	s.Equal(-1, s.ringBufferQueue.Remove())
+
+	numItems := 9
+	items := make([]interface{}, 0, numItems)
+	for i := 0; i != numItems; i++ {
+		items = append(items, i)
+		s.ringBufferQueue.Add(i)
+	}
+	s.Len(s.ringBufferQueue.items, 16)
+	s.Equal(items, s.ringBufferQueue.Snapshot())
+
+	for i := 0; i != numItems; i++ {
+		s.Equal(i, s.ringBufferQueue.Remove())
+	}
+	s.True(s.ringBufferQueue.IsEmpty())
+}
+
+func (s *ringBufferQueueSuite) TestSnapshot() {
+	s.Empty(s.ringBufferQueue.Snapshot())
+
+	for i := 0; i != 3; i++ {
+		s.ringBufferQueue.Add(i)
+	}
+	s.Equal(0, s.ringBufferQueue.Remove())
+	s.ringBufferQueue.Add(3)
+	s.ringBufferQueue.Add(4)
+
+	snapshot := s.ringBufferQueue.Snapshot()
+	s.Equal([]interface{}{1, 2, 3, 4}, snapshot)
+
+	// modifying the snapshot should not affect the queue
+	snapshot[0] = nil
+	s.Equal(1, s.ringBufferQueue.Remove())
+	s.Equal([]interface{}{2, 3, 4}, s.ringBufferQueue.Snapshot())
+}
+
+func (s *ringBufferQueueSuite) TestContains() {
+	isItem := func(target int) func(interface{}) bool {
+		return func(item interface{}) bool {
+			return item.(int) == target
+		}
+	}
+
+	s.False(s.ringBufferQueue.Contains(isItem(0)))
+
+	// wrap around the end of the buffer
+	for i := 0; i != 3; i++ {
+		s.ringBufferQueue.Add(i)
+	}
+	s.Equal(0, s.ringBufferQueue.Remove())
+	s.ringBufferQueue.Add(3)
+	s.ringBufferQueue.Add(4)
+
+	s.False(s.ringBufferQueue.Contains(isItem(0)))
+	for i := 1; i != 5; i++ {
+		s.True(s.ringBufferQueue.Contains(isItem(i)))
+	}
+	s.False(s.ringBufferQueue.Contains(isItem(5)))
+}
+
+func (s *ringBufferQueueSuite) TestTransferAll() {
+	for i := 0; i != 5; i++ {
+		s.ringBufferQueue.Add(i)
+	}
+	dst := NewConcurrentQueue()
+
+	s.Equal(5, TransferAll(s.ringBufferQueue, dst))
+	s.True(s.ringBufferQueue.IsEmpty())
+	s.Equal([]interface{}{0, 1, 2, 3, 4}, dst.(*concurrentQueueImpl).Snapshot())
+}
+
+func (s *ringBufferQueueSuite) TestMultipleProducer() {
+	concurrency := 10
+	numItemsPerProducer := 10
+
+	var wg sync.WaitGroup
+	for i := 0; i != concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j != numItemsPerProducer; j++ {
+				s.ringBufferQueue.Add(j)
+			}
+		}()
+	}
+	wg.Wait()
+
+	expectedLength := concurrency * numItemsPerProducer
+	s.Equal(expectedLength, s.ringBufferQueue.Len())
+	for i := 0; i != expectedLength; i++ {
+		s.NotNil(s.ringBufferQueue.Remove())
+	}
+	s.True(s.ringBufferQueue.IsEmpty())
+}
+
+func BenchmarkRingBufferQueue_AddRemove(b *testing.B) {
+	benchmarkQueueAddRemove(b, NewRingBufferQueue(1000))
+}
+
+func BenchmarkConcurrentQueue_AddRemove(b *testing.B) {
+	benchmarkQueueAddRemove(b, NewConcurrentQueue())
+}
+
+// benchmarkQueueAddRemove keeps a backlog of items in the queue and
+// measures allocations of adding and removing items under churn
+func benchmarkQueueAddRemove(b *testing.B, queue Queue) {
+	item := &struct{}{}
+	for i := 0; i != 500; i++ {
+		queue.Add(item)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		queue.Add(item)
+		queue.Remove()
+	}
+}
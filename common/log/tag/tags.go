@@ -480,6 +480,11 @@ func QueueSplitPolicyType(policyType int) Tag {
 	return newInt("split-policy-type", policyType)
 }
 
+// QueueSplitSkipReason returns tag for QueueSplitSkipReason
+func QueueSplitSkipReason(reason string) Tag {
+	return newStringTag("split-skip-reason", reason)
+}
+
 // TaskID returns tag for TaskID
 func TaskID(taskID int64) Tag {
 	return newInt64("queue-task-id", taskID)
@@ -20,7 +20,19 @@
 
 package metrics
 
-import "github.com/uber-go/tally"
+import (
+	"time"
+
+	"github.com/uber-go/tally"
+)
+
+// taskSubmitLatencyBuckets are histogram buckets for the latency of submitting a task
+// to the task processor, which is expected to be well below a millisecond
+var taskSubmitLatencyBuckets = tally.MustMakeExponentialDurationBuckets(time.Microsecond, 2, 20)
+
+// taskAgeBuckets are histogram buckets for how long a task has been waiting,
+// ranging from milliseconds to hours
+var taskAgeBuckets = tally.MustMakeExponentialDurationBuckets(time.Millisecond, 2, 25)
 
 // types used/defined by the package
 type (
@@ -872,6 +884,8 @@ const (
 	HistoryNotifyFailoverMarkersScope
 	// TaskPriorityAssignerScope is the scope used by all metric emitted by task priority assigner
 	TaskPriorityAssignerScope
+	// TaskCategoryFairProcessorScope is the scope used by all metric emitted by category fair task processor
+	TaskCategoryFairProcessorScope
 	// TransferQueueProcessorScope is the scope used by all metric emitted by transfer queue processor
 	TransferQueueProcessorScope
 	// TransferActiveQueueProcessorScope is the scope used by all metric emitted by transfer queue processor
@@ -1455,6 +1469,7 @@ var ScopeDefs = map[ServiceIdx]map[int]scopeDefinition{
 		HistoryRefreshWorkflowTasksScope:                       {operation: "RefreshWorkflowTasks"},
 		HistoryNotifyFailoverMarkersScope:                      {operation: "NotifyFailoverMarkers"},
 		TaskPriorityAssignerScope:                              {operation: "TaskPriorityAssigner"},
+		TaskCategoryFairProcessorScope:                         {operation: "TaskCategoryFairProcessor"},
 		TransferQueueProcessorScope:                            {operation: "TransferQueueProcessor"},
 		TransferActiveQueueProcessorScope:                      {operation: "TransferActiveQueueProcessor"},
 		TransferStandbyQueueProcessorScope:                     {operation: "TransferStandbyQueueProcessor"},
@@ -1719,6 +1734,11 @@ const (
 	TransferTaskMissingEventCounterPerDomain
 
 	TaskRedispatchQueuePendingTasksTimer
+	TaskRedispatchQueueOldestTaskAgeGauge
+	TaskRedispatchSubmitLatency
+	TaskRedispatchNilTaskCounter
+	TaskRedispatchHeldCounter
+	TaskRedispatchSubmitAge
 
 	TransferTaskThrottledCounter
 	TimerTaskThrottledCounter
@@ -1732,6 +1752,36 @@ const (
 	ProcessingQueueSelectedDomainSplitCounter
 	ProcessingQueueRandomSplitCounter
 	ProcessingQueueThrottledCounter
+	ProcessingQueueReadThrottledCounter
+	ProcessingQueueTaskTypeSplitCounter
+	ProcessingQueueLockHoldLatency
+	ProcessingQueueRedispatchSkippedCounter
+	ProcessingQueuePendingTasksTimer
+	ProcessingQueueRedispatchSubmittedCounter
+	ProcessingQueueRedispatchRejectedCounter
+	AckLevelStuckCounter
+	ProcessingQueueSplitPolicyEvaluationLatency
+	ProcessingQueueTaskCategoryMismatchCounter
+	ProcessingQueueSplitDomainCoverageLostCounter
+	ProcessingQueueTaskSubmittedCounter
+	ProcessingQueueDomainShedCounter
+	TaskCategoryFairProcessorSubmitFailedCounter
+	ProcessingQueueInFlightBudgetExceededCounter
+	ProcessingQueueAckLevelGauge
+	ProcessingQueueAckReadGapThrottledCounter
+	ProcessingQueuePinnedDomainSplitCounter
+	ProcessingQueueTaskEventDroppedCounter
+	ProcessingQueueSplitVetoedCounter
+	ProcessingQueueOverlapCounter
+	ProcessingQueueTaskDroppedCounter
+	ProcessingQueueTaskGapCounter
+	ProcessingQueueSplitCooldownCounter
+	ProcessingQueueRedispatchGrowthRateGauge
+	ProcessingQueueWorkflowGatedCounter
+	ProcessingQueuePumpIterationCounter
+	ProcessingQueuePumpIterationLatency
+	ProcessingQueueAckLevelRegressionCounter
+	ProcessingQueueRedispatchQueueFullCounter
 
 	ActivityE2ELatency
 	ActiveClusterGauge
@@ -2194,6 +2244,11 @@ var MetricDefs = map[ServiceIdx]map[int]metricDefinition{
 
 		TaskBatchCompleteCounter:                          {metricName: "task_batch_complete_counter", metricType: Counter},
 		TaskRedispatchQueuePendingTasksTimer:              {metricName: "task_redispatch_queue_pending_tasks", metricType: Timer},
+		TaskRedispatchQueueOldestTaskAgeGauge:             {metricName: "task_redispatch_queue_oldest_task_age", metricType: Gauge},
+		TaskRedispatchSubmitLatency:                       {metricName: "task_redispatch_submit_latency", metricType: Timer, buckets: taskSubmitLatencyBuckets},
+		TaskRedispatchNilTaskCounter:                      {metricName: "task_redispatch_nil_task", metricType: Counter},
+		TaskRedispatchHeldCounter:                         {metricName: "task_redispatch_held", metricType: Counter},
+		TaskRedispatchSubmitAge:                           {metricName: "task_redispatch_submit_age", metricType: Timer, buckets: taskAgeBuckets},
 		TransferTaskThrottledCounter:                      {metricName: "transfer_task_throttled_counter", metricType: Counter},
 		TimerTaskThrottledCounter:                         {metricName: "timer_task_throttled_counter", metricType: Counter},
 		TransferTaskMissingEventCounter:                   {metricName: "transfer_task_missing_event_counter", metricType: Counter},
@@ -2204,6 +2259,36 @@ var MetricDefs = map[ServiceIdx]map[int]metricDefinition{
 		ProcessingQueueSelectedDomainSplitCounter:         {metricName: "processing_queue_selected_domain_split_counter", metricType: Counter},
 		ProcessingQueueRandomSplitCounter:                 {metricName: "processing_queue_random_split_counter", metricType: Counter},
 		ProcessingQueueThrottledCounter:                   {metricName: "processing_queue_throttled_counter", metricType: Counter},
+		ProcessingQueueReadThrottledCounter:               {metricName: "processing_queue_read_throttled_counter", metricType: Counter},
+		ProcessingQueueTaskTypeSplitCounter:               {metricName: "processing_queue_task_type_split_counter", metricType: Counter},
+		ProcessingQueueLockHoldLatency:                    {metricName: "processing_queue_lock_hold_latency", metricType: Timer},
+		ProcessingQueueRedispatchSkippedCounter:           {metricName: "processing_queue_redispatch_skipped_counter", metricType: Counter},
+		ProcessingQueuePendingTasksTimer:                  {metricName: "processing_queue_pending_tasks", metricType: Timer},
+		ProcessingQueueRedispatchSubmittedCounter:         {metricName: "processing_queue_redispatch_submitted_counter", metricType: Counter},
+		ProcessingQueueRedispatchRejectedCounter:          {metricName: "processing_queue_redispatch_rejected_counter", metricType: Counter},
+		AckLevelStuckCounter:                              {metricName: "stuck_ack_level", metricType: Counter},
+		ProcessingQueueSplitPolicyEvaluationLatency:       {metricName: "processing_queue_split_policy_evaluation_latency", metricType: Timer},
+		ProcessingQueueTaskCategoryMismatchCounter:        {metricName: "processing_queue_task_category_mismatch", metricType: Counter},
+		ProcessingQueueSplitDomainCoverageLostCounter:     {metricName: "processing_queue_split_domain_coverage_lost", metricType: Counter},
+		ProcessingQueueTaskSubmittedCounter:               {metricName: "processing_queue_task_submitted_counter", metricType: Counter},
+		ProcessingQueueDomainShedCounter:                  {metricName: "processing_queue_domain_shed_counter", metricType: Counter},
+		TaskCategoryFairProcessorSubmitFailedCounter:      {metricName: "task_category_fair_processor_submit_failed", metricType: Counter},
+		ProcessingQueueInFlightBudgetExceededCounter:      {metricName: "processing_queue_in_flight_budget_exceeded_counter", metricType: Counter},
+		ProcessingQueueAckLevelGauge:                      {metricName: "processing_queue_ack_level", metricType: Gauge},
+		ProcessingQueueAckReadGapThrottledCounter:         {metricName: "processing_queue_ack_read_gap_throttled_counter", metricType: Counter},
+		ProcessingQueuePinnedDomainSplitCounter:           {metricName: "processing_queue_pinned_domain_split_counter", metricType: Counter},
+		ProcessingQueueTaskEventDroppedCounter:            {metricName: "processing_queue_task_event_dropped_counter", metricType: Counter},
+		ProcessingQueueSplitVetoedCounter:                 {metricName: "processing_queue_split_vetoed_counter", metricType: Counter},
+		ProcessingQueueOverlapCounter:                     {metricName: "processing_queue_overlap", metricType: Counter},
+		ProcessingQueueTaskDroppedCounter:                 {metricName: "processing_queue_task_dropped_counter", metricType: Counter},
+		ProcessingQueueTaskGapCounter:                     {metricName: "processing_queue_task_gap_counter", metricType: Counter},
+		ProcessingQueueSplitCooldownCounter:               {metricName: "processing_queue_split_cooldown_counter", metricType: Counter},
+		ProcessingQueueRedispatchGrowthRateGauge:          {metricName: "processing_queue_redispatch_growth_rate", metricType: Gauge},
+		ProcessingQueueWorkflowGatedCounter:               {metricName: "processing_queue_workflow_gated_counter", metricType: Counter},
+		ProcessingQueuePumpIterationCounter:               {metricName: "processing_queue_pump_iteration", metricType: Counter},
+		ProcessingQueuePumpIterationLatency:               {metricName: "processing_queue_pump_iteration_latency", metricType: Timer},
+		ProcessingQueueAckLevelRegressionCounter:          {metricName: "processing_queue_ack_level_regression", metricType: Counter},
+		ProcessingQueueRedispatchQueueFullCounter:         {metricName: "processing_queue_redispatch_queue_full", metricType: Counter},
 		ActivityE2ELatency:                                {metricName: "activity_end_to_end_latency", metricType: Timer},
 		AckLevelUpdateCounter:                             {metricName: "ack_level_update", metricType: Counter},
 		AckLevelUpdateFailedCounter:                       {metricName: "ack_level_update_failed", metricType: Counter},
@@ -20,6 +20,8 @@
 
 package metrics
 
+import "strconv"
+
 const (
 	revisionTag     = "revision"
 	branchTag       = "branch"
@@ -36,9 +38,18 @@ const (
 	activityType  = "activityType"
 	decisionType  = "decisionType"
 	invariantType = "invariantType"
+	lockOperation = "lockOperation"
+	queueLevel    = "queueLevel"
+	splitPolicy   = "splitPolicy"
+	submitResult  = "submitResult"
+	taskType      = "taskType"
+	pumpIteration = "pumpIteration"
 
 	domainAllValue = "all"
 	unknownValue   = "_unknown_"
+
+	submitResultSuccessValue = "success"
+	submitResultFailureValue = "failure"
 )
 
 // Tag is an interface to define metrics tags
@@ -87,6 +98,30 @@ type (
 	invariantTypeTag struct {
 		value string
 	}
+
+	lockOperationTag struct {
+		value string
+	}
+
+	queueLevelTag struct {
+		value string
+	}
+
+	splitPolicyTag struct {
+		value string
+	}
+
+	submitResultTag struct {
+		value string
+	}
+
+	taskTypeTag struct {
+		value string
+	}
+
+	pumpIterationTag struct {
+		value string
+	}
 )
 
 // DomainTag returns a new domain tag. For timers, this also ensures that we
@@ -279,3 +314,105 @@ func (d invariantTypeTag) Key() string {
 func (d invariantTypeTag) Value() string {
 	return d.value
 }
+
+// LockOperationTag returns a new lock operation tag.
+func LockOperationTag(value string) Tag {
+	if len(value) == 0 {
+		value = unknownValue
+	}
+	return lockOperationTag{value}
+}
+
+// Key returns the key of lock operation tag
+func (d lockOperationTag) Key() string {
+	return lockOperation
+}
+
+// Value returns the value of lock operation tag
+func (d lockOperationTag) Value() string {
+	return d.value
+}
+
+// QueueLevelTag returns a new processing queue level tag.
+func QueueLevelTag(level int) Tag {
+	return queueLevelTag{strconv.Itoa(level)}
+}
+
+// Key returns the key of processing queue level tag
+func (d queueLevelTag) Key() string {
+	return queueLevel
+}
+
+// Value returns the value of processing queue level tag
+func (d queueLevelTag) Value() string {
+	return d.value
+}
+
+// SplitPolicyTag returns a new processing queue split policy tag.
+func SplitPolicyTag(value string) Tag {
+	if len(value) == 0 {
+		value = unknownValue
+	}
+	return splitPolicyTag{value}
+}
+
+// Key returns the key of processing queue split policy tag
+func (d splitPolicyTag) Key() string {
+	return splitPolicy
+}
+
+// Value returns the value of processing queue split policy tag
+func (d splitPolicyTag) Value() string {
+	return d.value
+}
+
+// SubmitResultTag returns a new task submit result tag.
+func SubmitResultTag(submitted bool) Tag {
+	if submitted {
+		return submitResultTag{submitResultSuccessValue}
+	}
+	return submitResultTag{submitResultFailureValue}
+}
+
+// Key returns the key of task submit result tag
+func (d submitResultTag) Key() string {
+	return submitResult
+}
+
+// Value returns the value of task submit result tag
+func (d submitResultTag) Value() string {
+	return d.value
+}
+
+// TaskTypeTag returns a new history task type tag.
+func TaskTypeTag(taskType int) Tag {
+	return taskTypeTag{strconv.Itoa(taskType)}
+}
+
+// Key returns the key of task type tag
+func (d taskTypeTag) Key() string {
+	return taskType
+}
+
+// Value returns the value of task type tag
+func (d taskTypeTag) Value() string {
+	return d.value
+}
+
+// PumpIterationTag returns a new queue processor pump iteration type tag.
+func PumpIterationTag(value string) Tag {
+	if len(value) == 0 {
+		value = unknownValue
+	}
+	return pumpIterationTag{value}
+}
+
+// Key returns the key of pump iteration type tag
+func (d pumpIterationTag) Key() string {
+	return pumpIteration
+}
+
+// Value returns the value of pump iteration type tag
+func (d pumpIterationTag) Value() string {
+	return d.value
+}
@@ -93,6 +93,13 @@ func (rl *RateLimiter) Reserve() *rate.Reservation {
 	return limiter.Reserve()
 }
 
+// ReserveAt reserves a rate limit token at the given time, the returned reservation
+// should be used with DelayFrom and CancelAt so that the same time source is used
+func (rl *RateLimiter) ReserveAt(now time.Time) *rate.Reservation {
+	limiter := rl.goRateLimiter.Load().(*rate.Limiter)
+	return limiter.ReserveN(now, 1)
+}
+
 // Allow immediately returns with true or false indicating if a rate limit
 // token is available or not
 func (rl *RateLimiter) Allow() bool {
@@ -169,3 +176,10 @@ func (d *DynamicRateLimiter) Reserve() *rate.Reservation {
 	d.rl.UpdateMaxDispatch(&rps)
 	return d.rl.Reserve()
 }
+
+// ReserveAt reserves a rate limit token at the given time
+func (d *DynamicRateLimiter) ReserveAt(now time.Time) *rate.Reservation {
+	rps := d.rps()
+	d.rl.UpdateMaxDispatch(&rps)
+	return d.rl.ReserveAt(now)
+}
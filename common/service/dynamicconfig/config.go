@@ -90,6 +90,9 @@ type IntPropertyFn func(opts ...FilterOption) int
 // IntPropertyFnWithDomainFilter is a wrapper to get int property from dynamic config with domain as filter
 type IntPropertyFnWithDomainFilter func(domain string) int
 
+// IntPropertyFnWithDomainIDFilter is a wrapper to get int property from dynamic config with domainID as filter
+type IntPropertyFnWithDomainIDFilter func(domainID string) int
+
 // IntPropertyFnWithTaskListInfoFilters is a wrapper to get int property from dynamic config with three filters: domain, taskList, taskType
 type IntPropertyFnWithTaskListInfoFilters func(domain string, taskList string, taskType int) int
 
@@ -194,6 +197,23 @@ func (c *Collection) GetIntPropertyFilteredByDomain(key Key, defaultValue int) I
 	}
 }
 
+// GetIntPropertyFilteredByDomainID gets property with domainID filter and asserts that it's an integer
+func (c *Collection) GetIntPropertyFilteredByDomainID(key Key, defaultValue int) IntPropertyFnWithDomainIDFilter {
+	return func(domainID string) int {
+		filters := append([]FilterOption{DomainIDFilter(domainID)}, c.filterOptions...)
+		val, err := c.client.GetIntValue(
+			key,
+			getFilterMap(filters...),
+			defaultValue,
+		)
+		if err != nil {
+			c.logError(key, err)
+		}
+		c.logValue(key, val, defaultValue, intCompareEquals)
+		return val
+	}
+}
+
 // GetIntPropertyFilteredByTaskListInfo gets property with taskListInfo as filters and asserts that it's an integer
 func (c *Collection) GetIntPropertyFilteredByTaskListInfo(key Key, defaultValue int) IntPropertyFnWithTaskListInfoFilters {
 	return func(domain string, taskList string, taskType int) int {
@@ -72,6 +72,15 @@ func (s *configSuite) TestGetIntPropertyFilteredByDomain() {
 	s.Equal(50, value(domain))
 }
 
+func (s *configSuite) TestGetIntPropertyFilteredByDomainID() {
+	key := testGetIntPropertyFilteredByDomainIDKey
+	domainID := "testDomainID"
+	value := s.cln.GetIntPropertyFilteredByDomainID(key, 10)
+	s.Equal(10, value(domainID))
+	s.client.SetValue(key, 50)
+	s.Equal(50, value(domainID))
+}
+
 func (s *configSuite) TestGetStringPropertyFnWithDomainFilter() {
 	key := DefaultEventEncoding
 	domain := "testDomain"
@@ -44,6 +44,7 @@ var keys = map[Key]string{
 	testGetStringPropertyKey:                         "testGetStringPropertyKey",
 	testGetMapPropertyKey:                            "testGetMapPropertyKey",
 	testGetIntPropertyFilteredByDomainKey:            "testGetIntPropertyFilteredByDomainKey",
+	testGetIntPropertyFilteredByDomainIDKey:          "testGetIntPropertyFilteredByDomainIDKey",
 	testGetDurationPropertyFilteredByDomainKey:       "testGetDurationPropertyFilteredByDomainKey",
 	testGetIntPropertyFilteredByTaskListInfoKey:      "testGetIntPropertyFilteredByTaskListInfoKey",
 	testGetDurationPropertyFilteredByTaskListInfoKey: "testGetDurationPropertyFilteredByTaskListInfoKey",
@@ -168,6 +169,7 @@ var keys = map[Key]string{
 	TaskSchedulerShardQueueSize:                           "history.taskSchedulerShardQueueSize",
 	TaskSchedulerDispatcherCount:                          "history.taskSchedulerDispatcherCount",
 	TaskSchedulerRoundRobinWeights:                        "history.taskSchedulerRoundRobinWeight",
+	TaskSchedulerEnableCategoryFairness:                   "history.taskSchedulerEnableCategoryFairness",
 	ActiveTaskRedispatchInterval:                          "history.activeTaskRedispatchInterval",
 	StandbyTaskRedispatchInterval:                         "history.standbyTaskRedispatchInterval",
 	TaskRedispatchIntervalJitterCoefficient:               "history.taskRedispatchIntervalJitterCoefficient",
@@ -185,6 +187,39 @@ var keys = map[Key]string{
 	QueueProcessorPollBackoffIntervalJitterCoefficient:    "history.queueProcessorPollBackoffIntervalJitterCoefficient",
 	QueueProcessorEnablePersistQueueStates:                "history.queueProcessorEnablePersistQueueStates",
 	QueueProcessorEnableLoadQueueStates:                   "history.queueProcessorEnableLoadQueueStates",
+	QueueProcessorMaxPollRPSByLevel:                       "history.queueProcessorMaxPollRPSByLevel",
+	QueueProcessorShutdownTimeout:                         "history.queueProcessorShutdownTimeout",
+	QueueProcessorMinPollInterval:                         "history.queueProcessorMinPollInterval",
+	QueueProcessorLockMetricsSampleRate:                   "history.queueProcessorLockMetricsSampleRate",
+	QueueProcessorMaxConcurrentRedispatch:                 "history.queueProcessorMaxConcurrentRedispatch",
+	QueueProcessorSkipRedispatchWhenBusy:                  "history.queueProcessorSkipRedispatchWhenBusy",
+	QueueProcessorEnableBackgroundCompaction:              "history.queueProcessorEnableBackgroundCompaction",
+	QueueProcessorBackgroundCompactionInterval:            "history.queueProcessorBackgroundCompactionInterval",
+	QueueProcessorEnableDomainTaggedMetrics:               "history.queueProcessorEnableDomainTaggedMetrics",
+	QueueProcessorEnableLevelTaggedMetrics:                "history.queueProcessorEnableLevelTaggedMetrics",
+	QueueProcessorRedispatchBatchSizeByDomainID:           "history.queueProcessorRedispatchBatchSizeByDomainID",
+	QueueProcessorStuckAckLevelThreshold:                  "history.queueProcessorStuckAckLevelThreshold",
+	QueueProcessorRedispatchWeight:                        "history.queueProcessorRedispatchWeight",
+	QueueProcessorEnableOutstandingTaskShedding:           "history.queueProcessorEnableOutstandingTaskShedding",
+	QueueProcessorMaxOutstandingTasksPerDomain:            "history.queueProcessorMaxOutstandingTasksPerDomain",
+	QueueProcessorRedispatchMinBatchSize:                  "history.queueProcessorRedispatchMinBatchSize",
+	QueueProcessorRedispatchMaxBatchSize:                  "history.queueProcessorRedispatchMaxBatchSize",
+	QueueProcessorMaxInFlightTasks:                        "history.queueProcessorMaxInFlightTasks",
+	QueueProcessorMaxAckReadGap:                           "history.queueProcessorMaxAckReadGap",
+	QueueProcessorPinnedDomainLevels:                      "history.queueProcessorPinnedDomainLevels",
+	QueueProcessorRedispatchRequeueDelay:                  "history.queueProcessorRedispatchRequeueDelay",
+	QueueProcessorRedispatchRequeueMaxDelay:               "history.queueProcessorRedispatchRequeueMaxDelay",
+	QueueProcessorNewTaskLevelStrategy:                    "history.queueProcessorNewTaskLevelStrategy",
+	QueueProcessorDisableRedispatch:                       "history.queueProcessorDisableRedispatch",
+	QueueProcessorWarmupDuration:                          "history.queueProcessorWarmupDuration",
+	QueueProcessorEnableTaskSubmitAge:                     "history.queueProcessorEnableTaskSubmitAge",
+	QueueProcessorSplitCooldown:                           "history.queueProcessorSplitCooldown",
+	QueueProcessorEnableOrderedProcessingByDomainID:       "history.queueProcessorEnableOrderedProcessingByDomainID",
+	QueueProcessorRedispatchDecisionLogSize:               "history.queueProcessorRedispatchDecisionLogSize",
+	QueueProcessorMaxRedispatchQueueSizePerDomain:         "history.queueProcessorMaxRedispatchQueueSizePerDomain",
+	TimerProcessorRedispatchRequeueDelay:                  "history.timerProcessorRedispatchRequeueDelay",
+	TimerProcessorRedispatchRequeueMaxDelay:               "history.timerProcessorRedispatchRequeueMaxDelay",
+	TimerProcessorRedispatchMaxBatchSize:                  "history.timerProcessorRedispatchMaxBatchSize",
 	TimerTaskBatchSize:                                    "history.timerTaskBatchSize",
 	TimerTaskWorkerCount:                                  "history.timerTaskWorkerCount",
 	TimerTaskMaxRetryCount:                                "history.timerTaskMaxRetryCount",
@@ -201,6 +236,7 @@ var keys = map[Key]string{
 	TimerProcessorSplitQueueInterval:                      "history.timerProcessorSplitQueueInterval",
 	TimerProcessorSplitQueueIntervalJitterCoefficient:     "history.timerProcessorSplitQueueIntervalJitterCoefficient",
 	TimerProcessorMaxRedispatchQueueSize:                  "history.timerProcessorMaxRedispatchQueueSize",
+	TimerProcessorMaxReadTimeRange:                        "history.timerProcessorMaxReadTimeRange",
 	TimerProcessorEnablePriorityTaskProcessor:             "history.timerProcessorEnablePriorityTaskProcessor",
 	TimerProcessorEnableMultiCurosrProcessor:              "history.timerProcessorEnableMultiCursorProcessor",
 	TimerProcessorMaxTimeShift:                            "history.timerProcessorMaxTimeShift",
@@ -221,6 +257,7 @@ var keys = map[Key]string{
 	TransferProcessorUpdateAckIntervalJitterCoefficient:   "history.transferProcessorUpdateAckIntervalJitterCoefficient",
 	TransferProcessorCompleteTransferInterval:             "history.transferProcessorCompleteTransferInterval",
 	TransferProcessorMaxRedispatchQueueSize:               "history.transferProcessorMaxRedispatchQueueSize",
+	TransferProcessorTaskGapTimeout:                       "history.transferProcessorTaskGapTimeout",
 	TransferProcessorEnablePriorityTaskProcessor:          "history.transferProcessorEnablePriorityTaskProcessor",
 	TransferProcessorEnableMultiCurosrProcessor:           "history.transferProcessorEnableMultiCursorProcessor",
 	TransferProcessorVisibilityArchivalTimeLimit:          "history.transferProcessorVisibilityArchivalTimeLimit",
@@ -343,6 +380,7 @@ const (
 	testGetStringPropertyKey
 	testGetMapPropertyKey
 	testGetIntPropertyFilteredByDomainKey
+	testGetIntPropertyFilteredByDomainIDKey
 	testGetDurationPropertyFilteredByDomainKey
 	testGetIntPropertyFilteredByTaskListInfoKey
 	testGetDurationPropertyFilteredByTaskListInfoKey
@@ -577,6 +615,8 @@ const (
 	TaskSchedulerDispatcherCount
 	// TaskSchedulerRoundRobinWeights is the priority weight for weighted round robin task scheduler
 	TaskSchedulerRoundRobinWeights
+	// TaskSchedulerEnableCategoryFairness indicates whether transfer and timer tasks are submitted to the task scheduler in round robin order of task categories
+	TaskSchedulerEnableCategoryFairness
 	// ActiveTaskRedispatchInterval is the active task redispatch interval
 	ActiveTaskRedispatchInterval
 	// StandbyTaskRedispatchInterval is the standby task redispatch interval
@@ -611,6 +651,72 @@ const (
 	QueueProcessorEnablePersistQueueStates
 	// QueueProcessorEnableLoadQueueStates indicates whether processing queue states should be loaded
 	QueueProcessorEnableLoadQueueStates
+	// QueueProcessorMaxPollRPSByLevel is the max poll rate per second for each processing queue level, levels not specified are not limited
+	QueueProcessorMaxPollRPSByLevel
+	// QueueProcessorShutdownTimeout is the max duration to wait for queue processor to finish its shutdown procedure
+	QueueProcessorShutdownTimeout
+	// QueueProcessorMinPollInterval is the min poll interval for queue processor when the processing queue collection has caught up
+	QueueProcessorMinPollInterval
+	// QueueProcessorLockMetricsSampleRate is the sample rate for emitting queue processor lock hold duration metrics
+	QueueProcessorLockMetricsSampleRate
+	// QueueProcessorMaxConcurrentRedispatch is the max number of concurrent redispatch passes for each queue processor
+	QueueProcessorMaxConcurrentRedispatch
+	// QueueProcessorSkipRedispatchWhenBusy indicates whether a redispatch pass should be skipped instead of waiting when max concurrent redispatch passes is reached
+	QueueProcessorSkipRedispatchWhenBusy
+	// QueueProcessorEnableBackgroundCompaction indicates whether processing queues should be periodically pruned and compacted in the background
+	QueueProcessorEnableBackgroundCompaction
+	// QueueProcessorBackgroundCompactionInterval is the interval for pruning and compacting processing queues in the background
+	QueueProcessorBackgroundCompactionInterval
+	// QueueProcessorEnableDomainTaggedMetrics indicates whether queue processor metrics tagged with domain should be emitted
+	QueueProcessorEnableDomainTaggedMetrics
+	// QueueProcessorEnableLevelTaggedMetrics indicates whether queue processor metrics tagged with processing queue level should be emitted
+	QueueProcessorEnableLevelTaggedMetrics
+	// QueueProcessorRedispatchBatchSizeByDomainID is the max number of tasks of a domain resubmitted in one redispatch pass, 0 means no limit
+	QueueProcessorRedispatchBatchSizeByDomainID
+	// QueueProcessorStuckAckLevelThreshold is the number of consecutive ack level updates without advancing the ack level while there are pending tasks, after which the ack level is considered stuck. 0 disables the check
+	QueueProcessorStuckAckLevelThreshold
+	// QueueProcessorRedispatchWeight is the fraction of queue processor iterations used for redispatching tasks instead of reading new tasks when there are tasks pending redispatch. Value should be in [0, 1], the default 0.5 splits iterations evenly
+	QueueProcessorRedispatchWeight
+	// QueueProcessorEnableOutstandingTaskShedding indicates whether tasks of a domain exceeding QueueProcessorMaxOutstandingTasksPerDomain are deferred to the redispatch queue instead of being submitted
+	QueueProcessorEnableOutstandingTaskShedding
+	// QueueProcessorMaxOutstandingTasksPerDomain is the soft limit on the number of outstanding tasks of a domain in a queue processor, 0 means no limit
+	QueueProcessorMaxOutstandingTasksPerDomain
+	// QueueProcessorRedispatchMinBatchSize is the min number of tasks resubmitted in one redispatch pass when adaptive redispatch batch size is enabled
+	QueueProcessorRedispatchMinBatchSize
+	// QueueProcessorRedispatchMaxBatchSize is the max number of tasks resubmitted in one redispatch pass, the batch size adapts to the submit success ratio within the min and max batch size. 0 disables adaptive redispatch batch size
+	QueueProcessorRedispatchMaxBatchSize
+	// QueueProcessorMaxInFlightTasks is the max number of tasks a queue processor can have submitted to the task processor and not yet completed, 0 means no limit
+	QueueProcessorMaxInFlightTasks
+	// QueueProcessorMaxAckReadGap is the max number of tasks loaded by a processing queue level and not yet released by ack level updates, reads of the level are paused when the gap is reached until the ack level catches up. 0 means no limit
+	QueueProcessorMaxAckReadGap
+	// QueueProcessorPinnedDomainLevels is a map from domainID to the processing queue level the domain is pinned to, pinned domains are always moved back to their levels after queue splits and level collapses
+	QueueProcessorPinnedDomainLevels
+	// QueueProcessorRedispatchRequeueDelay is the delay before a task rejected during redispatch can be resubmitted, the delay doubles for each consecutive rejection of the task. 0 means the task can be resubmitted immediately
+	QueueProcessorRedispatchRequeueDelay
+	// QueueProcessorRedispatchRequeueMaxDelay is the max delay before a task rejected during redispatch can be resubmitted
+	QueueProcessorRedispatchRequeueMaxDelay
+	// QueueProcessorNewTaskLevelStrategy decides which level new tasks of a domain enter after the domain is split to a higher level. lowestMatch keeps new tasks in the lower level, highestMatch sends all new tasks to the higher level, and policy lets the split policy look ahead by SplitLookAheadDurationByDomainID
+	QueueProcessorNewTaskLevelStrategy
+	// QueueProcessorDisableRedispatch indicates whether tasks that fail to be submitted are dropped and read again from persistence instead of being kept in the redispatch queue
+	QueueProcessorDisableRedispatch
+	// QueueProcessorWarmupDuration is the duration over which the read and redispatch rate of a newly created queue processor ramps up from a low initial rate to the full rate, 0 disables warmup
+	QueueProcessorWarmupDuration
+	// QueueProcessorEnableTaskSubmitAge indicates whether queue processors record the age of tasks resubmitted by the redispatcher, tagged by domain and task type
+	QueueProcessorEnableTaskSubmitAge
+	// QueueProcessorSplitCooldown is the duration a domain can't be split or merged again after it's split or merged
+	QueueProcessorSplitCooldown
+	// QueueProcessorEnableOrderedProcessingByDomainID indicates whether tasks of the same workflow are submitted one at a time, each only after the previous one completes
+	QueueProcessorEnableOrderedProcessingByDomainID
+	// QueueProcessorRedispatchDecisionLogSize is the number of recent redispatch decisions kept in memory by each queue processor for debugging, 0 means disabled
+	QueueProcessorRedispatchDecisionLogSize
+	// QueueProcessorMaxRedispatchQueueSizePerDomain is the max number of tasks of a domain in the redispatch queue, new tasks of the domain are held back until the domain has room again when the limit is reached, 0 means no limit
+	QueueProcessorMaxRedispatchQueueSizePerDomain
+	// TimerProcessorRedispatchRequeueDelay is the initial delay before a timer task rejected by the task processor is redispatched again, it overrides QueueProcessorRedispatchRequeueDelay for timer processors
+	TimerProcessorRedispatchRequeueDelay
+	// TimerProcessorRedispatchRequeueMaxDelay is the max delay before a timer task rejected by the task processor is redispatched again, it overrides QueueProcessorRedispatchRequeueMaxDelay for timer processors as timer tasks are time sensitive
+	TimerProcessorRedispatchRequeueMaxDelay
+	// TimerProcessorRedispatchMaxBatchSize is the max number of timer tasks submitted by a redispatch pass, it overrides QueueProcessorRedispatchMaxBatchSize for timer processors
+	TimerProcessorRedispatchMaxBatchSize
 	// TimerTaskBatchSize is batch size for timer processor to process tasks
 	TimerTaskBatchSize
 	// TimerTaskWorkerCount is number of task workers for timer processor
@@ -643,6 +749,8 @@ const (
 	TimerProcessorSplitQueueIntervalJitterCoefficient
 	// TimerProcessorMaxRedispatchQueueSize is the threshold of the number of tasks in the redispatch queue for timer processor
 	TimerProcessorMaxRedispatchQueueSize
+	// TimerProcessorMaxReadTimeRange is the max visibility time range a single read of timer processor can cover, starting from the ack level of the processing queue. 0 means no limit
+	TimerProcessorMaxReadTimeRange
 	// TimerProcessorEnablePriorityTaskProcessor indicates whether priority task processor should be used for timer processor
 	TimerProcessorEnablePriorityTaskProcessor
 	// TimerProcessorEnableMultiCurosrProcessor indicates whether multi-cursor queue processor should be used for timer processor
@@ -683,6 +791,8 @@ const (
 	TransferProcessorCompleteTransferInterval
 	// TransferProcessorMaxRedispatchQueueSize is the threshold of the number of tasks in the redispatch queue for transferQueueProcessor
 	TransferProcessorMaxRedispatchQueueSize
+	// TransferProcessorTaskGapTimeout is how long task IDs missing between transfer tasks read from persistence can stay missing before they are reported. 0 disables gap detection
+	TransferProcessorTaskGapTimeout
 	// TransferProcessorEnablePriorityTaskProcessor indicates whether priority task processor should be used for transferQueueProcessor
 	TransferProcessorEnablePriorityTaskProcessor
 	// TransferProcessorEnableMultiCurosrProcessor indicates whether multi-cursor queue processor should be used for transferQueueProcessor
@@ -767,24 +767,48 @@ func ConvertDynamicConfigMapPropertyToIntMap(
 			return nil, fmt.Errorf("failed to convert key %v, error: %v", key, err)
 		}
 
-		var intValue int
-		switch value.(type) {
-		case float64:
-			intValue = int(value.(float64))
-		case int:
-			intValue = value.(int)
-		case int32:
-			intValue = int(value.(int32))
-		case int64:
-			intValue = int(value.(int64))
-		default:
-			return nil, fmt.Errorf("unknown value %v with type %T", value, value)
+		intValue, err := convertDynamicConfigIntValue(value)
+		if err != nil {
+			return nil, err
 		}
 		intMap[intKey] = intValue
 	}
 	return intMap, nil
 }
 
+// ConvertDynamicConfigMapPropertyToStringIntMap convert a map property from dynamic config to a map
+// whose value type is int
+func ConvertDynamicConfigMapPropertyToStringIntMap(
+	dcValue map[string]interface{},
+) (map[string]int, error) {
+	intMap := make(map[string]int)
+	for key, value := range dcValue {
+		intValue, err := convertDynamicConfigIntValue(value)
+		if err != nil {
+			return nil, err
+		}
+		intMap[strings.TrimSpace(key)] = intValue
+	}
+	return intMap, nil
+}
+
+func convertDynamicConfigIntValue(
+	value interface{},
+) (int, error) {
+	switch value := value.(type) {
+	case float64:
+		return int(value), nil
+	case int:
+		return value, nil
+	case int32:
+		return int(value), nil
+	case int64:
+		return int(value), nil
+	default:
+		return 0, fmt.Errorf("unknown value %v with type %T", value, value)
+	}
+}
+
 // IsStickyTaskConditionError is error from matching engine
 func IsStickyTaskConditionError(err error) bool {
 	if e, ok := err.(*workflow.InternalServiceError); ok {
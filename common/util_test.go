@@ -86,6 +86,23 @@ func TestConvertDynamicConfigMapPropertyToIntMap(t *testing.T) {
 	}
 }
 
+func TestConvertDynamicConfigMapPropertyToStringIntMap(t *testing.T) {
+	dcValue := make(map[string]interface{})
+	for idx, value := range []interface{}{int(0), int32(1), int64(2), float64(3.0)} {
+		dcValue["key"+strconv.Itoa(idx)] = value
+	}
+
+	intMap, err := ConvertDynamicConfigMapPropertyToStringIntMap(dcValue)
+	require.NoError(t, err)
+	require.Len(t, intMap, 4)
+	for i := 0; i != 4; i++ {
+		require.Equal(t, i, intMap["key"+strconv.Itoa(i)])
+	}
+
+	_, err = ConvertDynamicConfigMapPropertyToStringIntMap(map[string]interface{}{"key": "value"})
+	require.Error(t, err)
+}
+
 func TestCreateHistoryStartWorkflowRequest_ExpirationTimeWithCron(t *testing.T) {
 	domainID := uuid.New()
 	request := &workflow.StartWorkflowExecutionRequest{
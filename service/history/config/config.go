@@ -85,6 +85,7 @@ type Config struct {
 	TaskSchedulerShardQueueSize             dynamicconfig.IntPropertyFn
 	TaskSchedulerDispatcherCount            dynamicconfig.IntPropertyFn
 	TaskSchedulerRoundRobinWeights          dynamicconfig.MapPropertyFn
+	TaskSchedulerEnableCategoryFairness     dynamicconfig.BoolPropertyFn
 	ActiveTaskRedispatchInterval            dynamicconfig.DurationPropertyFn
 	StandbyTaskRedispatchInterval           dynamicconfig.DurationPropertyFn
 	TaskRedispatchIntervalJitterCoefficient dynamicconfig.FloatPropertyFn
@@ -105,6 +106,39 @@ type Config struct {
 	QueueProcessorPollBackoffIntervalJitterCoefficient dynamicconfig.FloatPropertyFn
 	QueueProcessorEnablePersistQueueStates             dynamicconfig.BoolPropertyFn
 	QueueProcessorEnableLoadQueueStates                dynamicconfig.BoolPropertyFn
+	QueueProcessorMaxPollRPSByLevel                    dynamicconfig.MapPropertyFn
+	QueueProcessorShutdownTimeout                      dynamicconfig.DurationPropertyFn
+	QueueProcessorMinPollInterval                      dynamicconfig.DurationPropertyFn
+	QueueProcessorLockMetricsSampleRate                dynamicconfig.FloatPropertyFn
+	QueueProcessorMaxConcurrentRedispatch              dynamicconfig.IntPropertyFn
+	QueueProcessorSkipRedispatchWhenBusy               dynamicconfig.BoolPropertyFn
+	QueueProcessorEnableBackgroundCompaction           dynamicconfig.BoolPropertyFn
+	QueueProcessorBackgroundCompactionInterval         dynamicconfig.DurationPropertyFn
+	QueueProcessorEnableDomainTaggedMetrics            dynamicconfig.BoolPropertyFn
+	QueueProcessorEnableLevelTaggedMetrics             dynamicconfig.BoolPropertyFn
+	QueueProcessorRedispatchBatchSizeByDomainID        dynamicconfig.IntPropertyFnWithDomainIDFilter
+	QueueProcessorStuckAckLevelThreshold               dynamicconfig.IntPropertyFn
+	QueueProcessorRedispatchWeight                     dynamicconfig.FloatPropertyFn
+	QueueProcessorEnableOutstandingTaskShedding        dynamicconfig.BoolPropertyFn
+	QueueProcessorMaxOutstandingTasksPerDomain         dynamicconfig.IntPropertyFnWithDomainIDFilter
+	QueueProcessorRedispatchMinBatchSize               dynamicconfig.IntPropertyFn
+	QueueProcessorRedispatchMaxBatchSize               dynamicconfig.IntPropertyFn
+	QueueProcessorMaxInFlightTasks                     dynamicconfig.IntPropertyFn
+	QueueProcessorMaxAckReadGap                        dynamicconfig.IntPropertyFn
+	QueueProcessorPinnedDomainLevels                   dynamicconfig.MapPropertyFn
+	QueueProcessorRedispatchRequeueDelay               dynamicconfig.DurationPropertyFn
+	QueueProcessorRedispatchRequeueMaxDelay            dynamicconfig.DurationPropertyFn
+	QueueProcessorNewTaskLevelStrategy                 dynamicconfig.StringPropertyFn
+	QueueProcessorDisableRedispatch                    dynamicconfig.BoolPropertyFn
+	QueueProcessorWarmupDuration                       dynamicconfig.DurationPropertyFn
+	QueueProcessorEnableTaskSubmitAge                  dynamicconfig.BoolPropertyFn
+	QueueProcessorSplitCooldown                        dynamicconfig.DurationPropertyFn
+	QueueProcessorEnableOrderedProcessingByDomainID    dynamicconfig.BoolPropertyFnWithDomainIDFilter
+	QueueProcessorRedispatchDecisionLogSize            dynamicconfig.IntPropertyFn
+	QueueProcessorMaxRedispatchQueueSizePerDomain      dynamicconfig.IntPropertyFnWithDomainIDFilter
+	TimerProcessorRedispatchRequeueDelay               dynamicconfig.DurationPropertyFn
+	TimerProcessorRedispatchRequeueMaxDelay            dynamicconfig.DurationPropertyFn
+	TimerProcessorRedispatchMaxBatchSize               dynamicconfig.IntPropertyFn
 
 	// TimerQueueProcessor settings
 	TimerTaskBatchSize                                dynamicconfig.IntPropertyFn
@@ -122,6 +156,7 @@ type Config struct {
 	TimerProcessorSplitQueueInterval                  dynamicconfig.DurationPropertyFn
 	TimerProcessorSplitQueueIntervalJitterCoefficient dynamicconfig.FloatPropertyFn
 	TimerProcessorMaxRedispatchQueueSize              dynamicconfig.IntPropertyFn
+	TimerProcessorMaxReadTimeRange                    dynamicconfig.DurationPropertyFn
 	TimerProcessorEnablePriorityTaskProcessor         dynamicconfig.BoolPropertyFn
 	TimerProcessorEnableMultiCurosrProcessor          dynamicconfig.BoolPropertyFn
 	TimerProcessorMaxTimeShift                        dynamicconfig.DurationPropertyFn
@@ -143,6 +178,7 @@ type Config struct {
 	TransferProcessorUpdateAckIntervalJitterCoefficient  dynamicconfig.FloatPropertyFn
 	TransferProcessorCompleteTransferInterval            dynamicconfig.DurationPropertyFn
 	TransferProcessorMaxRedispatchQueueSize              dynamicconfig.IntPropertyFn
+	TransferProcessorTaskGapTimeout                      dynamicconfig.DurationPropertyFn
 	TransferProcessorEnablePriorityTaskProcessor         dynamicconfig.BoolPropertyFn
 	TransferProcessorEnableMultiCurosrProcessor          dynamicconfig.BoolPropertyFn
 	TransferProcessorVisibilityArchivalTimeLimit         dynamicconfig.DurationPropertyFn
@@ -331,6 +367,7 @@ func New(dc *dynamicconfig.Collection, numberOfShards int, storeType string, isA
 		TaskSchedulerShardQueueSize:             dc.GetIntProperty(dynamicconfig.TaskSchedulerShardQueueSize, 200),
 		TaskSchedulerDispatcherCount:            dc.GetIntProperty(dynamicconfig.TaskSchedulerDispatcherCount, 1),
 		TaskSchedulerRoundRobinWeights:          dc.GetMapProperty(dynamicconfig.TaskSchedulerRoundRobinWeights, common.ConvertIntMapToDynamicConfigMapProperty(DefaultTaskPriorityWeight)),
+		TaskSchedulerEnableCategoryFairness:     dc.GetBoolProperty(dynamicconfig.TaskSchedulerEnableCategoryFairness, false),
 		ActiveTaskRedispatchInterval:            dc.GetDurationProperty(dynamicconfig.ActiveTaskRedispatchInterval, 5*time.Second),
 		StandbyTaskRedispatchInterval:           dc.GetDurationProperty(dynamicconfig.StandbyTaskRedispatchInterval, 30*time.Second),
 		TaskRedispatchIntervalJitterCoefficient: dc.GetFloat64Property(dynamicconfig.TaskRedispatchIntervalJitterCoefficient, 0.15),
@@ -350,6 +387,39 @@ func New(dc *dynamicconfig.Collection, numberOfShards int, storeType string, isA
 		QueueProcessorPollBackoffIntervalJitterCoefficient: dc.GetFloat64Property(dynamicconfig.QueueProcessorPollBackoffIntervalJitterCoefficient, 0.15),
 		QueueProcessorEnablePersistQueueStates:             dc.GetBoolProperty(dynamicconfig.QueueProcessorEnablePersistQueueStates, false),
 		QueueProcessorEnableLoadQueueStates:                dc.GetBoolProperty(dynamicconfig.QueueProcessorEnableLoadQueueStates, false),
+		QueueProcessorMaxPollRPSByLevel:                    dc.GetMapProperty(dynamicconfig.QueueProcessorMaxPollRPSByLevel, map[string]interface{}{}),
+		QueueProcessorShutdownTimeout:                      dc.GetDurationProperty(dynamicconfig.QueueProcessorShutdownTimeout, time.Minute),
+		QueueProcessorMinPollInterval:                      dc.GetDurationProperty(dynamicconfig.QueueProcessorMinPollInterval, 100*time.Millisecond),
+		QueueProcessorLockMetricsSampleRate:                dc.GetFloat64Property(dynamicconfig.QueueProcessorLockMetricsSampleRate, 0.01),
+		QueueProcessorMaxConcurrentRedispatch:              dc.GetIntProperty(dynamicconfig.QueueProcessorMaxConcurrentRedispatch, 1),
+		QueueProcessorSkipRedispatchWhenBusy:               dc.GetBoolProperty(dynamicconfig.QueueProcessorSkipRedispatchWhenBusy, false),
+		QueueProcessorEnableBackgroundCompaction:           dc.GetBoolProperty(dynamicconfig.QueueProcessorEnableBackgroundCompaction, false),
+		QueueProcessorBackgroundCompactionInterval:         dc.GetDurationProperty(dynamicconfig.QueueProcessorBackgroundCompactionInterval, time.Minute),
+		QueueProcessorEnableDomainTaggedMetrics:            dc.GetBoolProperty(dynamicconfig.QueueProcessorEnableDomainTaggedMetrics, false),
+		QueueProcessorEnableLevelTaggedMetrics:             dc.GetBoolProperty(dynamicconfig.QueueProcessorEnableLevelTaggedMetrics, false),
+		QueueProcessorRedispatchBatchSizeByDomainID:        dc.GetIntPropertyFilteredByDomainID(dynamicconfig.QueueProcessorRedispatchBatchSizeByDomainID, 0),
+		QueueProcessorStuckAckLevelThreshold:               dc.GetIntProperty(dynamicconfig.QueueProcessorStuckAckLevelThreshold, 20),
+		QueueProcessorRedispatchWeight:                     dc.GetFloat64Property(dynamicconfig.QueueProcessorRedispatchWeight, 0.5),
+		QueueProcessorEnableOutstandingTaskShedding:        dc.GetBoolProperty(dynamicconfig.QueueProcessorEnableOutstandingTaskShedding, false),
+		QueueProcessorMaxOutstandingTasksPerDomain:         dc.GetIntPropertyFilteredByDomainID(dynamicconfig.QueueProcessorMaxOutstandingTasksPerDomain, 0),
+		QueueProcessorRedispatchMinBatchSize:               dc.GetIntProperty(dynamicconfig.QueueProcessorRedispatchMinBatchSize, 10),
+		QueueProcessorRedispatchMaxBatchSize:               dc.GetIntProperty(dynamicconfig.QueueProcessorRedispatchMaxBatchSize, 0),
+		QueueProcessorMaxInFlightTasks:                     dc.GetIntProperty(dynamicconfig.QueueProcessorMaxInFlightTasks, 0),
+		QueueProcessorMaxAckReadGap:                        dc.GetIntProperty(dynamicconfig.QueueProcessorMaxAckReadGap, 0),
+		QueueProcessorPinnedDomainLevels:                   dc.GetMapProperty(dynamicconfig.QueueProcessorPinnedDomainLevels, map[string]interface{}{}),
+		QueueProcessorRedispatchRequeueDelay:               dc.GetDurationProperty(dynamicconfig.QueueProcessorRedispatchRequeueDelay, 0),
+		QueueProcessorRedispatchRequeueMaxDelay:            dc.GetDurationProperty(dynamicconfig.QueueProcessorRedispatchRequeueMaxDelay, time.Minute),
+		QueueProcessorNewTaskLevelStrategy:                 dc.GetStringProperty(dynamicconfig.QueueProcessorNewTaskLevelStrategy, "policy"),
+		QueueProcessorDisableRedispatch:                    dc.GetBoolProperty(dynamicconfig.QueueProcessorDisableRedispatch, false),
+		QueueProcessorWarmupDuration:                       dc.GetDurationProperty(dynamicconfig.QueueProcessorWarmupDuration, 0),
+		QueueProcessorEnableTaskSubmitAge:                  dc.GetBoolProperty(dynamicconfig.QueueProcessorEnableTaskSubmitAge, false),
+		QueueProcessorSplitCooldown:                        dc.GetDurationProperty(dynamicconfig.QueueProcessorSplitCooldown, 0),
+		QueueProcessorEnableOrderedProcessingByDomainID:    dc.GetBoolPropertyFilteredByDomainID(dynamicconfig.QueueProcessorEnableOrderedProcessingByDomainID, false),
+		QueueProcessorRedispatchDecisionLogSize:            dc.GetIntProperty(dynamicconfig.QueueProcessorRedispatchDecisionLogSize, 0),
+		QueueProcessorMaxRedispatchQueueSizePerDomain:      dc.GetIntPropertyFilteredByDomainID(dynamicconfig.QueueProcessorMaxRedispatchQueueSizePerDomain, 0),
+		TimerProcessorRedispatchRequeueDelay:               dc.GetDurationProperty(dynamicconfig.TimerProcessorRedispatchRequeueDelay, 0),
+		TimerProcessorRedispatchRequeueMaxDelay:            dc.GetDurationProperty(dynamicconfig.TimerProcessorRedispatchRequeueMaxDelay, 10*time.Second),
+		TimerProcessorRedispatchMaxBatchSize:               dc.GetIntProperty(dynamicconfig.TimerProcessorRedispatchMaxBatchSize, 0),
 
 		TimerTaskBatchSize:                                dc.GetIntProperty(dynamicconfig.TimerTaskBatchSize, 100),
 		TimerTaskWorkerCount:                              dc.GetIntProperty(dynamicconfig.TimerTaskWorkerCount, 10),
@@ -366,6 +436,7 @@ func New(dc *dynamicconfig.Collection, numberOfShards int, storeType string, isA
 		TimerProcessorSplitQueueInterval:                  dc.GetDurationProperty(dynamicconfig.TimerProcessorSplitQueueInterval, 1*time.Minute),
 		TimerProcessorSplitQueueIntervalJitterCoefficient: dc.GetFloat64Property(dynamicconfig.TimerProcessorSplitQueueIntervalJitterCoefficient, 0.15),
 		TimerProcessorMaxRedispatchQueueSize:              dc.GetIntProperty(dynamicconfig.TimerProcessorMaxRedispatchQueueSize, 10000),
+		TimerProcessorMaxReadTimeRange:                    dc.GetDurationProperty(dynamicconfig.TimerProcessorMaxReadTimeRange, 0),
 		TimerProcessorEnablePriorityTaskProcessor:         dc.GetBoolProperty(dynamicconfig.TimerProcessorEnablePriorityTaskProcessor, true),
 		TimerProcessorEnableMultiCurosrProcessor:          dc.GetBoolProperty(dynamicconfig.TimerProcessorEnableMultiCurosrProcessor, false),
 		TimerProcessorMaxTimeShift:                        dc.GetDurationProperty(dynamicconfig.TimerProcessorMaxTimeShift, 1*time.Second),
@@ -386,6 +457,7 @@ func New(dc *dynamicconfig.Collection, numberOfShards int, storeType string, isA
 		TransferProcessorUpdateAckIntervalJitterCoefficient:  dc.GetFloat64Property(dynamicconfig.TransferProcessorUpdateAckIntervalJitterCoefficient, 0.15),
 		TransferProcessorCompleteTransferInterval:            dc.GetDurationProperty(dynamicconfig.TransferProcessorCompleteTransferInterval, 60*time.Second),
 		TransferProcessorMaxRedispatchQueueSize:              dc.GetIntProperty(dynamicconfig.TransferProcessorMaxRedispatchQueueSize, 10000),
+		TransferProcessorTaskGapTimeout:                      dc.GetDurationProperty(dynamicconfig.TransferProcessorTaskGapTimeout, 0),
 		TransferProcessorEnablePriorityTaskProcessor:         dc.GetBoolProperty(dynamicconfig.TransferProcessorEnablePriorityTaskProcessor, true),
 		TransferProcessorEnableMultiCurosrProcessor:          dc.GetBoolProperty(dynamicconfig.TransferProcessorEnableMultiCurosrProcessor, false),
 		TransferProcessorVisibilityArchivalTimeLimit:         dc.GetDurationProperty(dynamicconfig.TransferProcessorVisibilityArchivalTimeLimit, 200*time.Millisecond),
@@ -185,6 +185,14 @@ func (h *handlerImpl) Start() {
 		if err != nil {
 			h.GetLogger().Fatal("Creating priority task processor failed", tag.Error(err))
 		}
+		if h.config.TaskSchedulerEnableCategoryFairness() {
+			h.queueTaskProcessor = task.NewCategoryFairProcessor(
+				h.queueTaskProcessor,
+				h.config.TaskSchedulerQueueSize(),
+				h.GetLogger(),
+				h.GetMetricsClient(),
+			)
+		}
 		h.queueTaskProcessor.Start()
 	}
 
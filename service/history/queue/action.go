@@ -20,23 +20,35 @@
 
 package queue
 
+import (
+	"github.com/uber/cadence/service/history/task"
+)
+
 type (
 	// ActionType specifies the type of the Action
 	ActionType int
 
 	// Action specifies the Action should be performed
 	Action struct {
-		ActionType               ActionType
-		ResetActionAttributes    *ResetActionAttributes
-		GetStateActionAttributes *GetStateActionAttributes
+		ActionType                  ActionType
+		ResetActionAttributes       *ResetActionAttributes
+		GetStateActionAttributes    *GetStateActionAttributes
+		CollapseLevelAttributes     *CollapseLevelActionAttributes
+		RecomputeAckLevelAttributes *RecomputeAckLevelActionAttributes
+		ReconcileAttributes         *ReconcileActionAttributes
+		SplitAttributes             *SplitActionAttributes
 		// add attributes for other action types here
 	}
 
 	// ActionResult is the result for performing an Action
 	ActionResult struct {
-		ActionType           ActionType
-		ResetActionResult    *ResetActionResult
-		GetStateActionResult *GetStateActionResult
+		ActionType              ActionType
+		ResetActionResult       *ResetActionResult
+		GetStateActionResult    *GetStateActionResult
+		CollapseLevelResult     *CollapseLevelActionResult
+		RecomputeAckLevelResult *RecomputeAckLevelActionResult
+		ReconcileResult         *ReconcileActionResult
+		SplitResult             *SplitActionResult
 	}
 
 	// ResetActionAttributes contains the parameter for performing Reset Action
@@ -48,7 +60,43 @@ type (
 	GetStateActionAttributes struct{}
 	// GetStateActionResult is the result for performing GetState Action
 	GetStateActionResult struct {
-		States []ProcessingQueueState
+		States          []ProcessingQueueState
+		PausedDomainIDs []string
+		// AckLevels is the ack level of each processing queue collection, keyed by level.
+		// The global ack level is the min of them
+		AckLevels map[int]task.Key
+	}
+
+	// CollapseLevelActionAttributes contains the parameter for performing CollapseLevel Action
+	CollapseLevelActionAttributes struct {
+		Level int
+	}
+	// CollapseLevelActionResult is the result for performing CollapseLevel Action
+	CollapseLevelActionResult struct{}
+
+	// RecomputeAckLevelActionAttributes contains the parameter for performing RecomputeAckLevel Action
+	RecomputeAckLevelActionAttributes struct{}
+	// RecomputeAckLevelActionResult is the result for performing RecomputeAckLevel Action
+	RecomputeAckLevelActionResult struct {
+		AckLevel task.Key
+	}
+
+	// ReconcileActionAttributes contains the parameter for performing Reconcile Action
+	ReconcileActionAttributes struct{}
+	// ReconcileActionResult is the result for performing Reconcile Action
+	ReconcileActionResult struct {
+		// NumDiscrepancies is the number of differences found between
+		// in-memory processing queue states and persistence
+		NumDiscrepancies int
+	}
+
+	// SplitActionAttributes contains the parameter for performing Split Action
+	SplitActionAttributes struct {
+		Policy ProcessingQueueSplitPolicy
+	}
+	// SplitActionResult is the result for performing Split Action
+	SplitActionResult struct {
+		Result *SplitResult
 	}
 )
 
@@ -57,6 +105,14 @@ const (
 	ActionTypeReset ActionType = iota + 1
 	// ActionTypeGetState is the ActionType for reading processing queue states
 	ActionTypeGetState
+	// ActionTypeCollapseLevel is the ActionType for collapsing a processing queue level to a lower level
+	ActionTypeCollapseLevel
+	// ActionTypeRecomputeAckLevel is the ActionType for recomputing and persisting the ack level from processing queue states
+	ActionTypeRecomputeAckLevel
+	// ActionTypeReconcile is the ActionType for replacing processing queue states with the persisted ones
+	ActionTypeReconcile
+	// ActionTypeSplit is the ActionType for splitting processing queues with a given policy, it's only for tests
+	ActionTypeSplit
 	// add more ActionType here
 )
 
@@ -75,3 +131,43 @@ func NewGetStateAction() *Action {
 		GetStateActionAttributes: &GetStateActionAttributes{},
 	}
 }
+
+// NewCollapseLevelAction creates a new action for collapsing the specified
+// processing queue level into the next lower level
+func NewCollapseLevelAction(level int) *Action {
+	return &Action{
+		ActionType: ActionTypeCollapseLevel,
+		CollapseLevelAttributes: &CollapseLevelActionAttributes{
+			Level: level,
+		},
+	}
+}
+
+// NewRecomputeAckLevelAction creates a new action for recomputing the ack level
+// from processing queue states and persisting it
+func NewRecomputeAckLevelAction() *Action {
+	return &Action{
+		ActionType:                  ActionTypeRecomputeAckLevel,
+		RecomputeAckLevelAttributes: &RecomputeAckLevelActionAttributes{},
+	}
+}
+
+// NewReconcileAction creates a new action for reconciling processing queue
+// states with the ones stored in persistence
+func NewReconcileAction() *Action {
+	return &Action{
+		ActionType:          ActionTypeReconcile,
+		ReconcileAttributes: &ReconcileActionAttributes{},
+	}
+}
+
+// NewSplitAction creates a new action for splitting processing queues with the given policy.
+// It's only performed if the processor has test hooks enabled, see processorBase.TriggerSplit
+func NewSplitAction(policy ProcessingQueueSplitPolicy) *Action {
+	return &Action{
+		ActionType: ActionTypeSplit,
+		SplitAttributes: &SplitActionAttributes{
+			Policy: policy,
+		},
+	}
+}
@@ -20,6 +20,21 @@
 
 package queue
 
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+type (
+	// domainFilterJSON is the JSON representation of DomainFilter
+	// with the domainID set as a sorted list
+	domainFilterJSON struct {
+		DomainIDs    []string
+		ReverseMatch bool
+	}
+)
+
 // NewDomainFilter creates a new domain filter
 func NewDomainFilter(
 	domainIDs map[string]struct{},
@@ -70,7 +85,17 @@ func (f DomainFilter) Exclude(domainIDs map[string]struct{}) DomainFilter {
 	return filter
 }
 
-// Merge merges the domainID sets specified by two domain filters
+// Merge merges the domainID sets specified by two domain filters.
+// The resulting filter matches a domain if either filter matches it:
+//
+//	f.ReverseMatch | f2.ReverseMatch | result.ReverseMatch | result.DomainIDs
+//	---------------+-----------------+---------------------+-------------------------------
+//	false          | false           | false               | f.DomainIDs ∪ f2.DomainIDs
+//	true           | true            | true                | f.DomainIDs ∩ f2.DomainIDs
+//	true           | false           | true                | f.DomainIDs - f2.DomainIDs
+//	false          | true            | true                | f2.DomainIDs - f.DomainIDs
+//
+// Neither f nor f2 is modified.
 func (f DomainFilter) Merge(f2 DomainFilter) DomainFilter {
 	// case 1: ReverseMatch field is false for both filters
 	if !f.ReverseMatch && !f2.ReverseMatch {
@@ -117,6 +142,65 @@ func (f DomainFilter) Merge(f2 DomainFilter) DomainFilter {
 	return filter
 }
 
+// Equal returns true if the two filters specify the same domain set
+func (f DomainFilter) Equal(f2 DomainFilter) bool {
+	if f.ReverseMatch != f2.ReverseMatch || len(f.DomainIDs) != len(f2.DomainIDs) {
+		return false
+	}
+
+	for domainID := range f.DomainIDs {
+		if _, ok := f2.DomainIDs[domainID]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Domains returns the domainIDs listed by the filter in sorted order. Note that the
+// filter matches domains not in the list instead if ReverseMatch is true
+func (f DomainFilter) Domains() []string {
+	domainIDs := make([]string, 0, len(f.DomainIDs))
+	for domainID := range f.DomainIDs {
+		domainIDs = append(domainIDs, domainID)
+	}
+	sort.Strings(domainIDs)
+	return domainIDs
+}
+
+func (f DomainFilter) String() string {
+	return fmt.Sprintf("{DomainIDs: %v, ReverseMatch: %v}", f.Domains(), f.ReverseMatch)
+}
+
+// MarshalJSON implements json.Marshaler, the domainID set is encoded as a sorted list
+func (f DomainFilter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(domainFilterJSON{
+		DomainIDs:    f.Domains(),
+		ReverseMatch: f.ReverseMatch,
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (f *DomainFilter) UnmarshalJSON(data []byte) error {
+	var filter domainFilterJSON
+	if err := json.Unmarshal(data, &filter); err != nil {
+		return err
+	}
+	*f = NewDomainFilter(covertToDomainIDSet(filter.DomainIDs), filter.ReverseMatch)
+	return nil
+}
+
+// intersect returns a non-reverse match filter that specifies domainIDs
+// that are both in the given set and the domainID set specified by the filter
+func (f DomainFilter) intersect(domainIDs map[string]struct{}) DomainFilter {
+	filter := NewDomainFilter(nil, false)
+	for domainID := range domainIDs {
+		if f.Filter(domainID) {
+			filter.DomainIDs[domainID] = struct{}{}
+		}
+	}
+	return filter
+}
+
 func (f DomainFilter) copy() DomainFilter {
 	domainIDs := make(map[string]struct{})
 	for domainID := range f.DomainIDs {
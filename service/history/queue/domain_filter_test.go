@@ -21,6 +21,7 @@
 package queue
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -214,3 +215,56 @@ func (s *domainFilterSuite) TestDomainFilter_Merge() {
 		s.Equal(tc.expectedReverseMatch, mergedFilter.ReverseMatch)
 	}
 }
+
+func (s *domainFilterSuite) TestDomainFilter_Merge_Commutative() {
+	domainIDs1 := covertToDomainIDSet([]string{"testDomain1", "testDomain2"})
+	domainIDs2 := covertToDomainIDSet([]string{"testDomain2", "testDomain3"})
+	testDomains := []string{"testDomain1", "testDomain2", "testDomain3", "testDomain4"}
+
+	for _, reverseMatch1 := range []bool{false, true} {
+		for _, reverseMatch2 := range []bool{false, true} {
+			f1 := NewDomainFilter(covertToDomainIDSet([]string{"testDomain1", "testDomain2"}), reverseMatch1)
+			f2 := NewDomainFilter(covertToDomainIDSet([]string{"testDomain2", "testDomain3"}), reverseMatch2)
+
+			merged := f1.Merge(f2)
+			s.True(merged.Equal(f2.Merge(f1)))
+			for _, domainID := range testDomains {
+				s.Equal(f1.Filter(domainID) || f2.Filter(domainID), merged.Filter(domainID))
+			}
+
+			// inputs should not be modified
+			s.Equal(domainIDs1, f1.DomainIDs)
+			s.Equal(domainIDs2, f2.DomainIDs)
+		}
+	}
+}
+
+func (s *domainFilterSuite) TestDomainFilter_SortedOutput() {
+	domainIDs := []string{"testDomain3", "testDomain1", "testDomain2"}
+	expectedDomains := []string{"testDomain1", "testDomain2", "testDomain3"}
+
+	var expectedJSON []byte
+	for _, order := range [][]int{{0, 1, 2}, {2, 1, 0}, {1, 2, 0}} {
+		filter := NewDomainFilter(nil, true)
+		for _, idx := range order {
+			filter = filter.Exclude(covertToDomainIDSet([]string{domainIDs[idx]}))
+		}
+
+		s.Equal(expectedDomains, filter.Domains())
+		s.Equal("{DomainIDs: [testDomain1 testDomain2 testDomain3], ReverseMatch: true}", filter.String())
+
+		data, err := json.Marshal(filter)
+		s.NoError(err)
+		if expectedJSON == nil {
+			expectedJSON = data
+		}
+		s.Equal(string(expectedJSON), string(data))
+
+		var decoded DomainFilter
+		s.NoError(json.Unmarshal(data, &decoded))
+		s.True(filter.Equal(decoded))
+	}
+	s.Equal(`{"DomainIDs":["testDomain1","testDomain2","testDomain3"],"ReverseMatch":true}`, string(expectedJSON))
+
+	s.Empty(NewDomainFilter(nil, false).Domains())
+}
@@ -23,6 +23,8 @@
 package queue
 
 import (
+	"context"
+
 	"github.com/uber/cadence/common"
 	"github.com/uber/cadence/common/persistence"
 	"github.com/uber/cadence/service/history/task"
@@ -38,6 +40,15 @@ type (
 		ReverseMatch bool
 	}
 
+	// TaskTypeFilter filters task type
+	TaskTypeFilter struct {
+		TaskTypes map[int]struct{}
+		// by default, a TaskTypeFilter matches task types listed in the TaskTypes field
+		// if reverseMatch is true then the TaskTypeFilter matches task types that are
+		// not in the TaskTypes field.
+		ReverseMatch bool
+	}
+
 	// ProcessingQueueState indicates the scope of a task processing queue and its current progress
 	ProcessingQueueState interface {
 		Level() int
@@ -45,6 +56,8 @@ type (
 		ReadLevel() task.Key
 		MaxLevel() task.Key
 		DomainFilter() DomainFilter
+		TaskTypeFilter() TaskTypeFilter
+		Priority() int
 	}
 
 	// ProcessingQueue is responsible for keeping track of the state of tasks
@@ -54,6 +67,7 @@ type (
 	ProcessingQueue interface {
 		State() ProcessingQueueState
 		Split(ProcessingQueueSplitPolicy) []ProcessingQueue
+		SplitAt(task.Key, map[string]struct{}, int) []ProcessingQueueState
 		Merge(ProcessingQueue) []ProcessingQueue
 		AddTasks(map[task.Key]task.Task, task.Key)
 		UpdateAckLevel() (task.Key, int) // return new ack level and number of pending tasks
@@ -66,12 +80,37 @@ type (
 		Evaluate(ProcessingQueue) []ProcessingQueueState
 	}
 
+	// ProcessingQueueSplitPolicyWithReason is an optional extension of ProcessingQueueSplitPolicy
+	// which also returns the reason when the policy decides not to split the ProcessingQueue
+	ProcessingQueueSplitPolicyWithReason interface {
+		ProcessingQueueSplitPolicy
+		EvaluateWithReason(ProcessingQueue) ([]ProcessingQueueState, SplitSkipReason)
+	}
+
+	// ProcessingQueueSplitPolicyWithContext is an optional extension of ProcessingQueueSplitPolicy
+	// for policies whose evaluation may be slow. The context is cancelled when the split pass should
+	// stop, e.g. the shard is closing, and the policy should return as soon as possible. Policies not
+	// implementing this interface still work, but their evaluation can't be interrupted
+	ProcessingQueueSplitPolicyWithContext interface {
+		ProcessingQueueSplitPolicy
+		EvaluateWithContext(context.Context, ProcessingQueue) []ProcessingQueueState
+	}
+
+	// SplitApprover is consulted before the states proposed by a split policy for a ProcessingQueue
+	// are applied. It approves the split by returning the proposed states, vetoes it by returning
+	// nil, or returns modified states to be applied instead. It's called while holding the lock for
+	// processing queue collections, so it should not block for long
+	SplitApprover interface {
+		ApproveSplit(queue ProcessingQueue, proposedStates []ProcessingQueueState) []ProcessingQueueState
+	}
+
 	// ProcessingQueueCollection manages a list of non-overlapping ProcessingQueues
 	// and keep track of the current active ProcessingQueue
 	ProcessingQueueCollection interface {
 		Level() int
 		Queues() []ProcessingQueue
 		ActiveQueue() ProcessingQueue
+		DomainFilter() DomainFilter // return the union of domain filters of all queues in the collection
 		AddTasks(map[task.Key]task.Task, task.Key)
 		UpdateAckLevels() (task.Key, int) // return min of all new ack levels and number of total pending tasks
 		Split(ProcessingQueueSplitPolicy) []ProcessingQueue
@@ -128,6 +128,34 @@ func (mr *MockProcessingQueueStateMockRecorder) DomainFilter() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DomainFilter", reflect.TypeOf((*MockProcessingQueueState)(nil).DomainFilter))
 }
 
+// TaskTypeFilter mocks base method
+func (m *MockProcessingQueueState) TaskTypeFilter() TaskTypeFilter {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TaskTypeFilter")
+	ret0, _ := ret[0].(TaskTypeFilter)
+	return ret0
+}
+
+// TaskTypeFilter indicates an expected call of TaskTypeFilter
+func (mr *MockProcessingQueueStateMockRecorder) TaskTypeFilter() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TaskTypeFilter", reflect.TypeOf((*MockProcessingQueueState)(nil).TaskTypeFilter))
+}
+
+// Priority mocks base method
+func (m *MockProcessingQueueState) Priority() int {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Priority")
+	ret0, _ := ret[0].(int)
+	return ret0
+}
+
+// Priority indicates an expected call of Priority
+func (mr *MockProcessingQueueStateMockRecorder) Priority() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Priority", reflect.TypeOf((*MockProcessingQueueState)(nil).Priority))
+}
+
 // MockProcessingQueue is a mock of ProcessingQueue interface
 type MockProcessingQueue struct {
 	ctrl     *gomock.Controller
@@ -179,6 +207,20 @@ func (mr *MockProcessingQueueMockRecorder) Split(arg0 interface{}) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Split", reflect.TypeOf((*MockProcessingQueue)(nil).Split), arg0)
 }
 
+// SplitAt mocks base method
+func (m *MockProcessingQueue) SplitAt(arg0 task.Key, arg1 map[string]struct{}, arg2 int) []ProcessingQueueState {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SplitAt", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]ProcessingQueueState)
+	return ret0
+}
+
+// SplitAt indicates an expected call of SplitAt
+func (mr *MockProcessingQueueMockRecorder) SplitAt(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SplitAt", reflect.TypeOf((*MockProcessingQueue)(nil).SplitAt), arg0, arg1, arg2)
+}
+
 // Merge mocks base method
 func (m *MockProcessingQueue) Merge(arg0 ProcessingQueue) []ProcessingQueue {
 	m.ctrl.T.Helper()
@@ -322,6 +364,20 @@ func (mr *MockProcessingQueueCollectionMockRecorder) ActiveQueue() *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ActiveQueue", reflect.TypeOf((*MockProcessingQueueCollection)(nil).ActiveQueue))
 }
 
+// DomainFilter mocks base method
+func (m *MockProcessingQueueCollection) DomainFilter() DomainFilter {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DomainFilter")
+	ret0, _ := ret[0].(DomainFilter)
+	return ret0
+}
+
+// DomainFilter indicates an expected call of DomainFilter
+func (mr *MockProcessingQueueCollectionMockRecorder) DomainFilter() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DomainFilter", reflect.TypeOf((*MockProcessingQueueCollection)(nil).DomainFilter))
+}
+
 // AddTasks mocks base method
 func (m *MockProcessingQueueCollection) AddTasks(arg0 map[task.Key]task.Task, arg1 task.Key) {
 	m.ctrl.T.Helper()
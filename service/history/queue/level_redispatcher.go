@@ -0,0 +1,197 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package queue
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/uber/cadence/common"
+	"github.com/uber/cadence/service/history/task"
+)
+
+type (
+	// levelRedispatcher is a task.Redispatcher that keeps a separate redispatch queue for each
+	// processing queue level, so that retries of tasks isolated to a higher level won't be buried
+	// behind a flood of tasks at lower levels. Redispatch passes drain the queues from the highest
+	// level to the lowest. Tasks added by AddTask go to the default level, use addTaskToLevel
+	// to specify the level
+	levelRedispatcher struct {
+		sync.Mutex
+
+		newRedispatcher func(level int) task.Redispatcher
+		status          int32
+		redispatchers   map[int]task.Redispatcher // level -> redispatcher
+	}
+)
+
+var _ task.Redispatcher = (*levelRedispatcher)(nil)
+
+// newLevelRedispatcher creates a levelRedispatcher, the redispatcher of each level
+// is created by newRedispatcher when a task is first added to the level
+func newLevelRedispatcher(
+	newRedispatcher func(level int) task.Redispatcher,
+) *levelRedispatcher {
+	return &levelRedispatcher{
+		newRedispatcher: newRedispatcher,
+		status:          common.DaemonStatusInitialized,
+		redispatchers:   make(map[int]task.Redispatcher),
+	}
+}
+
+func (r *levelRedispatcher) Start() {
+	r.Lock()
+	defer r.Unlock()
+
+	if !atomic.CompareAndSwapInt32(&r.status, common.DaemonStatusInitialized, common.DaemonStatusStarted) {
+		return
+	}
+	for _, redispatcher := range r.redispatchers {
+		redispatcher.Start()
+	}
+}
+
+func (r *levelRedispatcher) Stop() {
+	r.Lock()
+	defer r.Unlock()
+
+	if !atomic.CompareAndSwapInt32(&r.status, common.DaemonStatusStarted, common.DaemonStatusStopped) {
+		return
+	}
+	for _, redispatcher := range r.redispatchers {
+		redispatcher.Stop()
+	}
+}
+
+func (r *levelRedispatcher) AddTask(
+	task task.Task,
+) {
+	r.addTaskToLevel(defaultProcessingQueueLevel, task)
+}
+
+func (r *levelRedispatcher) addTaskToLevel(
+	level int,
+	task task.Task,
+) {
+	r.Lock()
+	redispatcher, ok := r.redispatchers[level]
+	if !ok {
+		redispatcher = r.newRedispatcher(level)
+		r.redispatchers[level] = redispatcher
+		if atomic.LoadInt32(&r.status) == common.DaemonStatusStarted {
+			redispatcher.Start()
+		}
+	}
+	r.Unlock()
+
+	redispatcher.AddTask(task)
+}
+
+func (r *levelRedispatcher) Redispatch(
+	targetSize int,
+) *task.RedispatchResult {
+	return r.RedispatchMatched(targetSize, nil)
+}
+
+// RedispatchMatched runs a redispatch pass on each level from the highest to the lowest,
+// until the total number of tasks left is no more than targetSize. Like a single redispatcher,
+// a level may redispatch more tasks than needed. An empty result is returned if the
+// redispatcher is not running
+func (r *levelRedispatcher) RedispatchMatched(
+	targetSize int,
+	filter task.MatchFn,
+) *task.RedispatchResult {
+	result := &task.RedispatchResult{
+		SubmitStatsByDomainID: make(map[string]*task.RedispatchSubmitStats),
+	}
+	if atomic.LoadInt32(&r.status) != common.DaemonStatusStarted {
+		return result
+	}
+
+	redispatchers := r.getRedispatchersByLevel()
+	numToRedispatch := r.Size() - targetSize
+	for _, redispatcher := range redispatchers {
+		size := redispatcher.Size()
+		levelResult := redispatcher.RedispatchMatched(common.MaxInt(0, size-common.MaxInt(0, numToRedispatch)), filter)
+		numToRedispatch -= size - redispatcher.Size()
+
+		if levelResult == nil {
+			continue
+		}
+		for domainID, levelStats := range levelResult.SubmitStatsByDomainID {
+			stats, ok := result.SubmitStatsByDomainID[domainID]
+			if !ok {
+				stats = &task.RedispatchSubmitStats{}
+				result.SubmitStatsByDomainID[domainID] = stats
+			}
+			stats.Submitted += levelStats.Submitted
+			stats.Rejected += levelStats.Rejected
+		}
+	}
+	return result
+}
+
+func (r *levelRedispatcher) Size() int {
+	size := 0
+	for _, redispatcher := range r.getRedispatchersByLevel() {
+		size += redispatcher.Size()
+	}
+	return size
+}
+
+// SizeByDomainID returns the total number of queued tasks of the domain across all levels
+func (r *levelRedispatcher) SizeByDomainID(
+	domainID string,
+) int {
+	size := 0
+	for _, redispatcher := range r.getRedispatchersByLevel() {
+		size += redispatcher.SizeByDomainID(domainID)
+	}
+	return size
+}
+
+// Snapshot returns all tasks in the redispatch queues, tasks of higher levels come first
+func (r *levelRedispatcher) Snapshot() []task.Task {
+	var tasks []task.Task
+	for _, redispatcher := range r.getRedispatchersByLevel() {
+		tasks = append(tasks, redispatcher.Snapshot()...)
+	}
+	return tasks
+}
+
+// getRedispatchersByLevel returns redispatchers of all levels ordered from the highest level to the lowest
+func (r *levelRedispatcher) getRedispatchersByLevel() []task.Redispatcher {
+	r.Lock()
+	defer r.Unlock()
+
+	levels := make([]int, 0, len(r.redispatchers))
+	for level := range r.redispatchers {
+		levels = append(levels, level)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(levels)))
+
+	redispatchers := make([]task.Redispatcher, 0, len(levels))
+	for _, level := range levels {
+		redispatchers = append(redispatchers, r.redispatchers[level])
+	}
+	return redispatchers
+}
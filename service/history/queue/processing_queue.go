@@ -33,11 +33,13 @@ import (
 
 type (
 	processingQueueStateImpl struct {
-		level        int
-		ackLevel     task.Key
-		readLevel    task.Key
-		maxLevel     task.Key
-		domainFilter DomainFilter
+		level          int
+		ackLevel       task.Key
+		readLevel      task.Key
+		maxLevel       task.Key
+		domainFilter   DomainFilter
+		taskTypeFilter TaskTypeFilter
+		priority       int
 	}
 
 	processingQueueImpl struct {
@@ -47,6 +49,13 @@ type (
 		logger        log.Logger
 		metricsClient metrics.Client // TODO: emit metrics
 	}
+
+	// ackLevelNormalizer is implemented by task keys that need to be adjusted before
+	// being used as an ack level. Other task keys are used as ack level as they are,
+	// so a new key type only needs to implement task.Key to be used by processing queues
+	ackLevelNormalizer interface {
+		normalizeAckLevel() task.Key
+	}
 )
 
 // NewProcessingQueueState creates a new state instance for processing queue
@@ -66,22 +75,67 @@ func NewProcessingQueueState(
 	)
 }
 
+// NewProcessingQueueStateWithPriority is the same as NewProcessingQueueState
+// but the priority will be assigned to all tasks submitted by the processing queue
+func NewProcessingQueueStateWithPriority(
+	level int,
+	ackLevel task.Key,
+	maxLevel task.Key,
+	domainFilter DomainFilter,
+	priority int,
+) ProcessingQueueState {
+	return newProcessingQueueState(
+		level,
+		ackLevel,
+		ackLevel,
+		maxLevel,
+		domainFilter,
+	).withPriority(priority)
+}
+
 func newProcessingQueueState(
 	level int,
 	ackLevel task.Key,
 	readLevel task.Key,
 	maxLevel task.Key,
 	domainFilter DomainFilter,
+) *processingQueueStateImpl {
+	return newProcessingQueueStateWithTaskTypeFilter(
+		level,
+		ackLevel,
+		readLevel,
+		maxLevel,
+		domainFilter,
+		NewTaskTypeFilter(nil, true), // match all task types
+	)
+}
+
+func newProcessingQueueStateWithTaskTypeFilter(
+	level int,
+	ackLevel task.Key,
+	readLevel task.Key,
+	maxLevel task.Key,
+	domainFilter DomainFilter,
+	taskTypeFilter TaskTypeFilter,
 ) *processingQueueStateImpl {
 	return &processingQueueStateImpl{
-		level:        level,
-		ackLevel:     ackLevel,
-		readLevel:    readLevel,
-		maxLevel:     maxLevel,
-		domainFilter: domainFilter,
+		level:          level,
+		ackLevel:       ackLevel,
+		readLevel:      readLevel,
+		maxLevel:       maxLevel,
+		domainFilter:   domainFilter,
+		taskTypeFilter: taskTypeFilter,
+		priority:       t.NoPriority,
 	}
 }
 
+func (s *processingQueueStateImpl) withPriority(
+	priority int,
+) *processingQueueStateImpl {
+	s.priority = priority
+	return s
+}
+
 // NewProcessingQueue creates a new processing queue based on its state
 func NewProcessingQueue(
 	state ProcessingQueueState,
@@ -149,9 +203,17 @@ func (s *processingQueueStateImpl) DomainFilter() DomainFilter {
 	return s.domainFilter
 }
 
+func (s *processingQueueStateImpl) TaskTypeFilter() TaskTypeFilter {
+	return s.taskTypeFilter
+}
+
+func (s *processingQueueStateImpl) Priority() int {
+	return s.priority
+}
+
 func (s *processingQueueStateImpl) String() string {
-	return fmt.Sprintf("&{level: %+v, ackLevel: %+v, readLevel: %+v, maxLevel: %+v, domainFilter: %+v}",
-		s.level, s.ackLevel, s.readLevel, s.maxLevel, s.domainFilter,
+	return fmt.Sprintf("&{level: %+v, ackLevel: %+v, readLevel: %+v, maxLevel: %+v, domainFilter: %+v, taskTypeFilter: %+v, priority: %+v}",
+		s.level, s.ackLevel, s.readLevel, s.maxLevel, s.domainFilter, s.taskTypeFilter, s.priority,
 	)
 }
 
@@ -171,6 +233,64 @@ func (q *processingQueueImpl) Split(
 	return splitProcessingQueue([]*processingQueueImpl{q}, newQueueStates, q.logger, q.metricsClient)
 }
 
+// SplitAt computes the states of the queues resulting from moving domainsToPeel
+// in range [ackLevel, atKey] to newQueueLevel. atKey will be adjusted to be within
+// [readLevel, maxLevel]. The returned states are:
+// 1. domains in domainsToPeel matched by the current domain filter, in range
+// [ackLevel, atKey], with level newQueueLevel
+// 2. the rest of domains matched by the current domain filter, in range
+// [ackLevel, atKey], with the current level
+// 3. all domains matched by the current domain filter, in range [atKey, maxLevel],
+// with the current level
+// States that contain no domain or an empty range are omitted.
+func (q *processingQueueImpl) SplitAt(
+	atKey task.Key,
+	domainsToPeel map[string]struct{},
+	newQueueLevel int,
+) []ProcessingQueueState {
+	splitKey := minTaskKey(maxTaskKey(atKey, q.state.readLevel), q.state.maxLevel)
+
+	newQueueStates := []ProcessingQueueState{}
+
+	peeledDomainFilter := q.state.domainFilter.intersect(domainsToPeel)
+	if len(peeledDomainFilter.DomainIDs) != 0 {
+		newQueueStates = append(newQueueStates, newProcessingQueueStateWithTaskTypeFilter(
+			newQueueLevel,
+			q.state.ackLevel,
+			q.state.readLevel,
+			splitKey,
+			peeledDomainFilter,
+			q.state.taskTypeFilter.copy(),
+		))
+	}
+
+	remainingDomainFilter := q.state.domainFilter.Exclude(domainsToPeel)
+	if remainingDomainFilter.ReverseMatch || len(remainingDomainFilter.DomainIDs) != 0 {
+		// this means the remaining domain filter still matches at least one domain
+		newQueueStates = append(newQueueStates, newProcessingQueueStateWithTaskTypeFilter(
+			q.state.level,
+			q.state.ackLevel,
+			q.state.readLevel,
+			splitKey,
+			remainingDomainFilter,
+			q.state.taskTypeFilter.copy(),
+		).withPriority(q.state.priority))
+	}
+
+	if !taskKeyEquals(splitKey, q.state.maxLevel) {
+		newQueueStates = append(newQueueStates, newProcessingQueueStateWithTaskTypeFilter(
+			q.state.level,
+			splitKey,
+			splitKey,
+			q.state.maxLevel,
+			q.state.domainFilter.copy(),
+			q.state.taskTypeFilter.copy(),
+		).withPriority(q.state.priority))
+	}
+
+	return newQueueStates
+}
+
 func (q *processingQueueImpl) Merge(
 	queue ProcessingQueue,
 ) []ProcessingQueue {
@@ -184,10 +304,7 @@ func (q *processingQueueImpl) Merge(
 		panic(errMsg)
 	}
 
-	if !q1.state.ackLevel.Less(q2.state.maxLevel) ||
-		!q2.state.ackLevel.Less(q1.state.maxLevel) {
-		// one queue's ackLevel is larger or equal than the other one's maxLevel
-		// this means there's no overlap between two queues
+	if !processingQueueStatesOverlap(q1.state, q2.state) {
 		return []ProcessingQueue{q1, q2}
 	}
 
@@ -198,13 +315,14 @@ func (q *processingQueueImpl) Merge(
 			q1, q2 = q2, q1
 		}
 
-		newQueueStates = append(newQueueStates, newProcessingQueueState(
+		newQueueStates = append(newQueueStates, newProcessingQueueStateWithTaskTypeFilter(
 			q1.state.level,
 			q1.state.ackLevel,
 			minTaskKey(q1.state.readLevel, q2.state.ackLevel),
 			q2.state.ackLevel,
 			q1.state.domainFilter.copy(),
-		))
+			q1.state.taskTypeFilter.copy(),
+		).withPriority(q1.state.priority))
 	}
 
 	if !taskKeyEquals(q1.state.maxLevel, q2.state.maxLevel) {
@@ -212,23 +330,29 @@ func (q *processingQueueImpl) Merge(
 			q1, q2 = q2, q1
 		}
 
-		newQueueStates = append(newQueueStates, newProcessingQueueState(
+		newQueueStates = append(newQueueStates, newProcessingQueueStateWithTaskTypeFilter(
 			q1.state.level,
 			q2.state.maxLevel,
 			maxTaskKey(q1.state.readLevel, q2.state.maxLevel),
 			q1.state.maxLevel,
 			q1.state.domainFilter.copy(),
-		))
+			q1.state.taskTypeFilter.copy(),
+		).withPriority(q1.state.priority))
 	}
 
+	// note that if the two queues have different task type filters, the merged queue
+	// may cover tasks that neither queue covers before, e.g. a task from q1's domain
+	// with a task type only specified by q2's filter. This is safe as tasks will just
+	// be processed more than once, which is already possible today.
 	overlappingQueueAckLevel := maxTaskKey(q1.state.ackLevel, q2.state.ackLevel)
-	newQueueStates = append(newQueueStates, newProcessingQueueState(
+	newQueueStates = append(newQueueStates, newProcessingQueueStateWithTaskTypeFilter(
 		q1.state.level,
 		overlappingQueueAckLevel,
 		maxTaskKey(minTaskKey(q1.state.readLevel, q2.state.readLevel), overlappingQueueAckLevel),
 		minTaskKey(q1.state.maxLevel, q2.state.maxLevel),
 		q1.state.domainFilter.Merge(q2.state.domainFilter),
-	))
+		q1.state.taskTypeFilter.Merge(q2.state.taskTypeFilter),
+	).withPriority(q1.state.priority))
 
 	for _, state := range newQueueStates {
 		if state.ReadLevel().Less(state.AckLevel()) || state.MaxLevel().Less(state.ReadLevel()) {
@@ -327,8 +451,8 @@ func (q *processingQueueImpl) UpdateAckLevel() (task.Key, int) {
 		q.state.ackLevel = q.state.readLevel
 	}
 
-	if timerKey, ok := q.state.ackLevel.(timerTaskKey); ok {
-		q.state.ackLevel = newTimerTaskKey(timerKey.visibilityTimestamp, 0)
+	if normalizer, ok := q.state.ackLevel.(ackLevelNormalizer); ok {
+		q.state.ackLevel = normalizer.normalizeAckLevel()
 	}
 
 	if q.state.readLevel.Less(q.state.ackLevel) {
@@ -340,6 +464,77 @@ func (q *processingQueueImpl) UpdateAckLevel() (task.Key, int) {
 	return q.state.ackLevel, len(q.outstandingTasks)
 }
 
+// processingQueueStatesOverlap returns true if the ranges of the two states overlap, i.e.
+// neither state's ack level is larger than or equal to the other one's max level
+func processingQueueStatesOverlap(
+	s1 ProcessingQueueState,
+	s2 ProcessingQueueState,
+) bool {
+	return s1.AckLevel().Less(s2.MaxLevel()) && s2.AckLevel().Less(s1.MaxLevel())
+}
+
+// compactProcessingQueues merges adjacent queues in a sorted, non-overlapping
+// list of queues from the same level into one queue when their ranges are
+// contiguous and their filters are equal
+func compactProcessingQueues(
+	queues []ProcessingQueue,
+) []ProcessingQueue {
+	if len(queues) <= 1 {
+		return queues
+	}
+
+	compactedQueues := []ProcessingQueue{queues[0]}
+	for _, queue := range queues[1:] {
+		lastQueue := compactedQueues[len(compactedQueues)-1].(*processingQueueImpl)
+		currentQueue := queue.(*processingQueueImpl)
+		if !processingQueuesCompactable(lastQueue, currentQueue) {
+			compactedQueues = append(compactedQueues, queue)
+			continue
+		}
+
+		readLevel := lastQueue.state.readLevel
+		if taskKeyEquals(lastQueue.state.readLevel, lastQueue.state.maxLevel) {
+			readLevel = currentQueue.state.readLevel
+		}
+
+		compactedQueues[len(compactedQueues)-1] = splitProcessingQueue(
+			[]*processingQueueImpl{lastQueue, currentQueue},
+			[]ProcessingQueueState{
+				newProcessingQueueStateWithTaskTypeFilter(
+					lastQueue.state.level,
+					lastQueue.state.ackLevel,
+					readLevel,
+					currentQueue.state.maxLevel,
+					lastQueue.state.domainFilter.copy(),
+					lastQueue.state.taskTypeFilter.copy(),
+				).withPriority(lastQueue.state.priority),
+			},
+			lastQueue.logger,
+			lastQueue.metricsClient,
+		)[0]
+	}
+
+	return compactedQueues
+}
+
+func processingQueuesCompactable(
+	q1 *processingQueueImpl,
+	q2 *processingQueueImpl,
+) bool {
+	if q1.state.level != q2.state.level ||
+		!taskKeyEquals(q1.state.maxLevel, q2.state.ackLevel) ||
+		!q1.state.domainFilter.Equal(q2.state.domainFilter) ||
+		!q1.state.taskTypeFilter.Equal(q2.state.taskTypeFilter) ||
+		q1.state.priority != q2.state.priority {
+		return false
+	}
+
+	// the range between the read levels of the two queues must be either fully read or not read,
+	// otherwise there's no valid read level for the compacted queue
+	return taskKeyEquals(q1.state.readLevel, q1.state.maxLevel) ||
+		taskKeyEquals(q2.state.readLevel, q2.state.ackLevel)
+}
+
 func splitProcessingQueue(
 	queues []*processingQueueImpl,
 	newQueueStates []ProcessingQueueState,
@@ -398,7 +593,21 @@ func taskBelongsToProcessQueue(
 ) bool {
 	return state.DomainFilter().Filter(task.GetDomainID()) &&
 		state.AckLevel().Less(key) &&
-		!state.MaxLevel().Less(key)
+		!state.MaxLevel().Less(key) &&
+		state.TaskTypeFilter().Filter(task.GetTaskType())
+}
+
+func processingQueueStateEquals(
+	state1 ProcessingQueueState,
+	state2 ProcessingQueueState,
+) bool {
+	return state1.Level() == state2.Level() &&
+		taskKeyEquals(state1.AckLevel(), state2.AckLevel()) &&
+		taskKeyEquals(state1.ReadLevel(), state2.ReadLevel()) &&
+		taskKeyEquals(state1.MaxLevel(), state2.MaxLevel()) &&
+		state1.DomainFilter().Equal(state2.DomainFilter()) &&
+		state1.TaskTypeFilter().Equal(state2.TaskTypeFilter()) &&
+		state1.Priority() == state2.Priority()
 }
 
 func taskKeyEquals(
@@ -431,11 +640,12 @@ func maxTaskKey(
 func copyQueueState(
 	state ProcessingQueueState,
 ) *processingQueueStateImpl {
-	return newProcessingQueueState(
+	return newProcessingQueueStateWithTaskTypeFilter(
 		state.Level(),
 		state.AckLevel(),
 		state.ReadLevel(),
 		state.MaxLevel(),
 		state.DomainFilter(),
-	)
+		state.TaskTypeFilter(),
+	).withPriority(state.Priority())
 }
@@ -62,6 +62,16 @@ func (c *processingQueueCollection) ActiveQueue() ProcessingQueue {
 	return c.activeQueue
 }
 
+// DomainFilter returns the union of the domain filters of all queues in the collection,
+// i.e. the domains covered by the level. An empty collection covers no domain
+func (c *processingQueueCollection) DomainFilter() DomainFilter {
+	domainFilter := NewDomainFilter(nil, false)
+	for _, queue := range c.queues {
+		domainFilter = domainFilter.Merge(queue.State().DomainFilter())
+	}
+	return domainFilter
+}
+
 func (c *processingQueueCollection) AddTasks(
 	tasks map[task.Key]task.Task,
 	newReadLevel task.Key,
@@ -174,15 +184,12 @@ func (c *processingQueueCollection) Merge(
 
 	c.queues = newQueues
 
-	// make sure the result is ordered and disjoint
-	for idx := 0; idx < len(c.queues)-1; idx++ {
-		if c.queues[idx+1].State().AckLevel().Less(c.queues[idx].State().MaxLevel()) {
-			errMsg := ""
-			for _, q := range c.queues {
-				errMsg += fmt.Sprintf("%v ", q)
-			}
-			panic("invalid processing queue merge result: " + errMsg)
+	if err := validateProcessingQueues(c.level, c.queues); err != nil {
+		errMsg := ""
+		for _, q := range c.queues {
+			errMsg += fmt.Sprintf("%v ", q)
 		}
+		panic(fmt.Sprintf("invalid processing queue merge result: %v, queues: %v", err, errMsg))
 	}
 
 	c.resetActiveQueue()
@@ -198,6 +205,25 @@ func (c *processingQueueCollection) resetActiveQueue() {
 	c.activeQueue = nil
 }
 
+// validateProcessingQueues returns a ProcessingQueueOverlapError if queues in
+// the collection at the given level are not sorted by ack level or overlap
+func validateProcessingQueues(
+	level int,
+	queues []ProcessingQueue,
+) error {
+	for idx := 0; idx < len(queues)-1; idx++ {
+		current, next := queues[idx].State(), queues[idx+1].State()
+		if processingQueueStatesOverlap(current, next) || next.AckLevel().Less(current.AckLevel()) {
+			return &ProcessingQueueOverlapError{
+				Level:    level,
+				Previous: current,
+				Next:     next,
+			}
+		}
+	}
+	return nil
+}
+
 func sortProcessingQueue(
 	queues []ProcessingQueue,
 ) {
@@ -208,3 +234,11 @@ func sortProcessingQueue(
 		return queues[i].State().Level() < queues[j].State().Level()
 	})
 }
+
+func sortProcessingQueueCollections(
+	queueCollections []ProcessingQueueCollection,
+) {
+	sort.Slice(queueCollections, func(i, j int) bool {
+		return queueCollections[i].Level() < queueCollections[j].Level()
+	})
+}
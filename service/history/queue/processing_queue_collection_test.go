@@ -106,6 +106,35 @@ func (s *processingQueueCollectionSuite) TestNewCollection_OutOfOrderQueues() {
 	s.True(s.isQueuesSorted(queueCollection.queues))
 }
 
+func (s *processingQueueCollectionSuite) TestDomainFilter() {
+	queueCollection := NewProcessingQueueCollection(s.level, nil)
+	s.True(queueCollection.DomainFilter().Equal(NewDomainFilter(nil, false)))
+
+	mockQueues := []ProcessingQueue{
+		NewMockProcessingQueue(s.controller),
+		NewMockProcessingQueue(s.controller),
+	}
+	mockQueues[0].(*MockProcessingQueue).EXPECT().State().Return(newProcessingQueueState(
+		s.level,
+		testKey{ID: 0},
+		testKey{ID: 10},
+		testKey{ID: 10},
+		NewDomainFilter(map[string]struct{}{"testDomain1": {}, "testDomain2": {}}, false),
+	)).AnyTimes()
+	mockQueues[1].(*MockProcessingQueue).EXPECT().State().Return(newProcessingQueueState(
+		s.level,
+		testKey{ID: 10},
+		testKey{ID: 10},
+		testKey{ID: 20},
+		NewDomainFilter(map[string]struct{}{"testDomain2": {}, "testDomain3": {}}, false),
+	)).AnyTimes()
+
+	queueCollection = NewProcessingQueueCollection(s.level, mockQueues)
+	s.True(queueCollection.DomainFilter().Equal(
+		NewDomainFilter(map[string]struct{}{"testDomain1": {}, "testDomain2": {}, "testDomain3": {}}, false),
+	))
+}
+
 func (s *processingQueueCollectionSuite) TestAddTasks_ReadNotFinished() {
 	totalQueues := 4
 	currentActiveIdx := 1
@@ -752,6 +781,54 @@ func (s *processingQueueCollectionSuite) TestMerge() {
 	}
 }
 
+func (s *processingQueueCollectionSuite) TestValidateProcessingQueues() {
+	newQueue := func(ackLevel, maxLevel int) ProcessingQueue {
+		return NewProcessingQueue(
+			newProcessingQueueState(
+				s.level,
+				testKey{ID: ackLevel},
+				testKey{ID: ackLevel},
+				testKey{ID: maxLevel},
+				DomainFilter{ReverseMatch: true},
+			),
+			nil,
+			nil,
+		)
+	}
+
+	testCases := []struct {
+		queues      []ProcessingQueue
+		expectedErr bool
+	}{
+		{
+			queues: nil,
+		},
+		{
+			queues: []ProcessingQueue{newQueue(0, 10), newQueue(10, 20), newQueue(30, 40)},
+		},
+		{
+			// overlapping ranges
+			queues:      []ProcessingQueue{newQueue(0, 10), newQueue(5, 20)},
+			expectedErr: true,
+		},
+		{
+			// disjoint but out of order
+			queues:      []ProcessingQueue{newQueue(10, 20), newQueue(0, 10)},
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		err := validateProcessingQueues(s.level, tc.queues)
+		if !tc.expectedErr {
+			s.NoError(err)
+			continue
+		}
+		s.IsType(&ProcessingQueueOverlapError{}, err)
+		s.Equal(s.level, err.(*ProcessingQueueOverlapError).Level)
+	}
+}
+
 func (s *processingQueueCollectionSuite) isQueuesSorted(
 	queues []ProcessingQueue,
 ) bool {
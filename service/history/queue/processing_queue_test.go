@@ -23,6 +23,7 @@ package queue
 import (
 	"sort"
 	"testing"
+	"time"
 
 	gomock "github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
@@ -279,6 +280,18 @@ func (s *processingQueueSuite) TestUpdateAckLevel_DeleteTaskBeyondAckLevel() {
 	s.Equal(6, pendingTasks) // 2 5 6 7 9 10
 }
 
+func (s *processingQueueSuite) TestMinTaskKey_TimerSameVisibilityTimestamp() {
+	now := time.Now()
+	key1 := newTimerTaskKey(now, 3)
+	key2 := newTimerTaskKey(now, 1)
+	key3 := newTimerTaskKey(now, 2)
+
+	s.Equal(key2, minTaskKey(minTaskKey(key1, key2), key3))
+	s.Equal(key2, minTaskKey(minTaskKey(key3, key2), key1))
+	s.Equal(key2, minTaskKey(key2, key2))
+	s.Equal(newTimerTaskKey(now.Add(-time.Nanosecond), 5), minTaskKey(key2, newTimerTaskKey(now.Add(-time.Nanosecond), 5)))
+}
+
 func (s *processingQueueSuite) TestSplit() {
 	testCases := []struct {
 		queue             *processingQueueImpl
@@ -579,6 +592,141 @@ func (s *processingQueueSuite) TestSplit() {
 	}
 }
 
+func (s *processingQueueSuite) TestSplitAt() {
+	testCases := []struct {
+		queue          *processingQueueImpl
+		atKey          task.Key
+		domainsToPeel  map[string]struct{}
+		newQueueLevel  int
+		expectedStates []ProcessingQueueState
+	}{
+		{
+			// test 1: peel part of the domains from a non-reverse match filter
+			queue: s.newTestProcessingQueue(
+				0,
+				testKey{ID: 0},
+				testKey{ID: 5},
+				testKey{ID: 10},
+				NewDomainFilter(map[string]struct{}{"testDomain1": {}, "testDomain2": {}, "testDomain3": {}}, false),
+				nil,
+			),
+			atKey:         testKey{ID: 7},
+			domainsToPeel: map[string]struct{}{"testDomain2": {}, "testDomain4": {}},
+			newQueueLevel: 1,
+			expectedStates: []ProcessingQueueState{
+				newProcessingQueueState(
+					1,
+					testKey{ID: 0},
+					testKey{ID: 5},
+					testKey{ID: 7},
+					NewDomainFilter(map[string]struct{}{"testDomain2": {}}, false),
+				),
+				newProcessingQueueState(
+					0,
+					testKey{ID: 0},
+					testKey{ID: 5},
+					testKey{ID: 7},
+					NewDomainFilter(map[string]struct{}{"testDomain1": {}, "testDomain3": {}}, false),
+				),
+				newProcessingQueueState(
+					0,
+					testKey{ID: 7},
+					testKey{ID: 7},
+					testKey{ID: 10},
+					NewDomainFilter(map[string]struct{}{"testDomain1": {}, "testDomain2": {}, "testDomain3": {}}, false),
+				),
+			},
+		},
+		{
+			// test 2: peel all domains from a non-reverse match filter
+			queue: s.newTestProcessingQueue(
+				0,
+				testKey{ID: 0},
+				testKey{ID: 5},
+				testKey{ID: 10},
+				NewDomainFilter(map[string]struct{}{"testDomain1": {}, "testDomain2": {}}, false),
+				nil,
+			),
+			atKey:         testKey{ID: 10},
+			domainsToPeel: map[string]struct{}{"testDomain1": {}, "testDomain2": {}, "testDomain3": {}},
+			newQueueLevel: 1,
+			expectedStates: []ProcessingQueueState{
+				newProcessingQueueState(
+					1,
+					testKey{ID: 0},
+					testKey{ID: 5},
+					testKey{ID: 10},
+					NewDomainFilter(map[string]struct{}{"testDomain1": {}, "testDomain2": {}}, false),
+				),
+			},
+		},
+		{
+			// test 3: peel domains from a reverse match filter, atKey is adjusted to readLevel
+			queue: s.newTestProcessingQueue(
+				0,
+				testKey{ID: 0},
+				testKey{ID: 5},
+				testKey{ID: 10},
+				NewDomainFilter(map[string]struct{}{"testDomain1": {}}, true),
+				nil,
+			),
+			atKey:         testKey{ID: 3},
+			domainsToPeel: map[string]struct{}{"testDomain1": {}, "testDomain2": {}},
+			newQueueLevel: 2,
+			expectedStates: []ProcessingQueueState{
+				newProcessingQueueState(
+					2,
+					testKey{ID: 0},
+					testKey{ID: 5},
+					testKey{ID: 5},
+					NewDomainFilter(map[string]struct{}{"testDomain2": {}}, false),
+				),
+				newProcessingQueueState(
+					0,
+					testKey{ID: 0},
+					testKey{ID: 5},
+					testKey{ID: 5},
+					NewDomainFilter(map[string]struct{}{"testDomain1": {}, "testDomain2": {}}, true),
+				),
+				newProcessingQueueState(
+					0,
+					testKey{ID: 5},
+					testKey{ID: 5},
+					testKey{ID: 10},
+					NewDomainFilter(map[string]struct{}{"testDomain1": {}}, true),
+				),
+			},
+		},
+		{
+			// test 4: peel domains not matched by a reverse match filter, atKey is adjusted to maxLevel
+			queue: s.newTestProcessingQueue(
+				0,
+				testKey{ID: 0},
+				testKey{ID: 5},
+				testKey{ID: 10},
+				NewDomainFilter(map[string]struct{}{"testDomain1": {}}, true),
+				nil,
+			),
+			atKey:         testKey{ID: 20},
+			domainsToPeel: map[string]struct{}{"testDomain1": {}},
+			newQueueLevel: 1,
+			expectedStates: []ProcessingQueueState{
+				newProcessingQueueState(
+					0,
+					testKey{ID: 0},
+					testKey{ID: 5},
+					testKey{ID: 10},
+					NewDomainFilter(map[string]struct{}{"testDomain1": {}}, true),
+				),
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		s.Equal(tc.expectedStates, tc.queue.SplitAt(tc.atKey, tc.domainsToPeel, tc.newQueueLevel))
+	}
+}
+
 func (s *processingQueueSuite) TestMerge() {
 	testCases := []struct {
 		queue1            *processingQueueImpl
@@ -1002,13 +1150,13 @@ func (s *processingQueueSuite) newTestProcessingQueue(
 	outstandingTasks map[task.Key]task.Task,
 ) *processingQueueImpl {
 	return newProcessingQueue(
-		&processingQueueStateImpl{
-			level:        level,
-			ackLevel:     ackLevel,
-			readLevel:    readLevel,
-			maxLevel:     maxLevel,
-			domainFilter: domainFilter,
-		},
+		newProcessingQueueState(
+			level,
+			ackLevel,
+			readLevel,
+			maxLevel,
+			domainFilter,
+		),
 		outstandingTasks,
 		s.logger,
 		s.metricsClient,
@@ -1025,6 +1173,7 @@ func (s *processingQueueSuite) newMockTasksForDomain(
 	for i := 0; i != len(keys); i++ {
 		mockTask := task.NewMockTask(s.controller)
 		mockTask.EXPECT().GetDomainID().Return(domainID[i]).AnyTimes()
+		mockTask.EXPECT().GetTaskType().Return(0).AnyTimes()
 		tasks[keys[i]] = mockTask
 	}
 
@@ -21,22 +21,112 @@
 package queue
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	otlog "github.com/opentracing/opentracing-go/log"
+	"golang.org/x/time/rate"
+
 	"github.com/uber/cadence/common"
+	"github.com/uber/cadence/common/backoff"
+	"github.com/uber/cadence/common/definition"
 	"github.com/uber/cadence/common/log"
 	"github.com/uber/cadence/common/log/tag"
 	"github.com/uber/cadence/common/metrics"
+	"github.com/uber/cadence/common/persistence"
 	"github.com/uber/cadence/common/quotas"
 	"github.com/uber/cadence/common/service/dynamicconfig"
+	t "github.com/uber/cadence/common/task"
 	"github.com/uber/cadence/service/history/shard"
 	"github.com/uber/cadence/service/history/task"
 )
 
 const (
 	warnPendingTasks = 2000
+
+	// taskEventBufferSize is the number of task events buffered for TaskEventSink
+	taskEventBufferSize = 1000
+
+	// redispatchSubmitRateSmoothingFactor is the weight of the latest
+	// sample in the moving average of redispatch submit rate
+	redispatchSubmitRateSmoothingFactor = 0.3
+
+	// RedispatchNotDraining is returned by EstimateRedispatchDrainTime
+	// when no task is being submitted by redispatch passes
+	RedispatchNotDraining time.Duration = -1
+
+	// newTaskLevelStrategyLowestMatch, newTaskLevelStrategyHighestMatch and newTaskLevelStrategyPolicy
+	// are the values of NewTaskLevelStrategy, see initializeSplitPolicy
+	newTaskLevelStrategyLowestMatch  = "lowestMatch"
+	newTaskLevelStrategyHighestMatch = "highestMatch"
+	newTaskLevelStrategyPolicy       = "policy"
+
+	// caughtUpHysteresis is the number of consecutive ack level updates
+	// required to change the caught up state of the processor
+	caughtUpHysteresis = 2
+
+	// drainCheckInterval is how often DrainNow redispatches tasks,
+	// triggers polls and checks if the processor has been drained
+	drainCheckInterval = 50 * time.Millisecond
+
+	// warmupInitialRateFactor is the fraction of the full read and redispatch
+	// rate a processor starts with during warmup, see getWarmupRateFactor
+	warmupInitialRateFactor = 0.1
+
+	// maxRedispatchDecisionLogSize caps RedispatchDecisionLogSize to bound the memory used by the log
+	maxRedispatchDecisionLogSize = 10000
+)
+
+const (
+	lockOperationUpdateAckLevel   = "updateAckLevel"
+	lockOperationSplit            = "split"
+	lockOperationReset            = "reset"
+	lockOperationAddTasks         = "addTasks"
+	lockOperationGetStates        = "getStates"
+	lockOperationPendingTaskCount = "pendingTaskCount"
+	lockOperationCollapseLevel    = "collapseLevel"
+	lockOperationPrune            = "prune"
+	lockOperationNextFireTime     = "nextFireTime"
+	lockOperationExportState      = "exportState"
+	lockOperationImportState      = "importState"
+	lockOperationMetricsSnapshot  = "metricsSnapshot"
+	lockOperationDrain            = "drain"
+	lockOperationTasksInKeyRange  = "tasksInKeyRange"
+	lockOperationReconcile        = "reconcile"
+)
+
+// pump iteration types are what an iteration of the processor pump loop did, see finishPumpIteration
+const (
+	pumpIterationRead           = "read"
+	pumpIterationRedispatch     = "redispatch"
+	pumpIterationSplit          = "split"
+	pumpIterationUpdateAckLevel = "updateAckLevel"
+	pumpIterationCompact        = "compact"
+	pumpIterationAction         = "action"
+	pumpIterationIdle           = "idle"
+)
+
+var (
+	// ErrProcessorShuttingDown is returned by ack level updates when the shard ownership is lost,
+	// the processor stops itself so that the shard can be released instead of retrying the update
+	ErrProcessorShuttingDown = errors.New("queue processor is shutting down as shard ownership is lost")
+
+	errQueueShutdownTimeout     = errors.New("queue shutdown timed out")
+	errImportStateAfterStart    = errors.New("unable to import state after queue processor is started")
+	errImportStateInvalidStates = errors.New("unable to import state: no processing queue state found")
+	errTaskDropped              = errors.New("task dropped as it can't be added to the redispatch queue")
+	errReconcileNotSupported    = errors.New("unable to reconcile: processing queue states can't be loaded from persistence")
+	errTestHooksDisabled        = errors.New("test hooks are not enabled for the queue processor")
 )
 
 type (
@@ -44,10 +134,12 @@ type (
 	updateClusterAckLevelFn       func(task.Key) error // TODO: deprecate this in favor of updateProcessingQueueStatesFn
 	updateProcessingQueueStatesFn func([]ProcessingQueueState) error
 	queueShutdownFn               func() error
+	domainDrainedFn               func(domainID string)
 
 	queueProcessorOptions struct {
 		BatchSize                            dynamicconfig.IntPropertyFn
 		MaxPollRPS                           dynamicconfig.IntPropertyFn
+		MaxPollRPSByLevel                    dynamicconfig.MapPropertyFn
 		MaxPollInterval                      dynamicconfig.DurationPropertyFn
 		MaxPollIntervalJitterCoefficient     dynamicconfig.FloatPropertyFn
 		UpdateAckInterval                    dynamicconfig.DurationPropertyFn
@@ -55,6 +147,7 @@ type (
 		RedispatchInterval                   dynamicconfig.DurationPropertyFn
 		RedispatchIntervalJitterCoefficient  dynamicconfig.FloatPropertyFn
 		MaxRedispatchQueueSize               dynamicconfig.IntPropertyFn
+		RedispatchBatchSizeByDomainID        dynamicconfig.IntPropertyFnWithDomainIDFilter
 		SplitQueueInterval                   dynamicconfig.DurationPropertyFn
 		SplitQueueIntervalJitterCoefficient  dynamicconfig.FloatPropertyFn
 		EnableSplit                          dynamicconfig.BoolPropertyFn
@@ -70,7 +163,128 @@ type (
 		PollBackoffIntervalJitterCoefficient dynamicconfig.FloatPropertyFn
 		EnablePersistQueueStates             dynamicconfig.BoolPropertyFn
 		EnableLoadQueueStates                dynamicconfig.BoolPropertyFn
+		ShutdownTimeout                      dynamicconfig.DurationPropertyFn
+		MinPollInterval                      dynamicconfig.DurationPropertyFn
+		LockMetricsSampleRate                dynamicconfig.FloatPropertyFn
+		RedispatchTaskTransform              task.TransformFn
+		MaxConcurrentRedispatch              dynamicconfig.IntPropertyFn
+		SkipRedispatchWhenBusy               dynamicconfig.BoolPropertyFn
+		EnableBackgroundCompaction           dynamicconfig.BoolPropertyFn
+		BackgroundCompactionInterval         dynamicconfig.DurationPropertyFn
+		EnableDomainTaggedMetrics            dynamicconfig.BoolPropertyFn
+		EnableLevelTaggedMetrics             dynamicconfig.BoolPropertyFn
+		StuckAckLevelThreshold               dynamicconfig.IntPropertyFn
+		RedispatchWeight                     dynamicconfig.FloatPropertyFn
+		EnableOutstandingTaskShedding        dynamicconfig.BoolPropertyFn
+		MaxOutstandingTasksPerDomain         dynamicconfig.IntPropertyFnWithDomainIDFilter
+		RedispatchMinBatchSize               dynamicconfig.IntPropertyFn
+		RedispatchMaxBatchSize               dynamicconfig.IntPropertyFn
+		MaxInFlightTasks                     dynamicconfig.IntPropertyFn
+		MaxAckReadGap                        dynamicconfig.IntPropertyFn
+		PinnedDomainLevels                   dynamicconfig.MapPropertyFn
+		RedispatchRequeueDelay               dynamicconfig.DurationPropertyFn
+		RedispatchRequeueMaxDelay            dynamicconfig.DurationPropertyFn
+		NewTaskLevelStrategy                 dynamicconfig.StringPropertyFn
 		MetricScope                          int
+
+		// Tracer is optional and used to create spans for redispatch
+		// passes and ack level updates
+		Tracer opentracing.Tracer
+
+		// OnSplitPolicyEvaluated is optional and invoked once per processing queue collection
+		// with the split policy name and the time spent evaluating the policy. It's called
+		// while holding the lock for processing queue collections, so it should not block.
+		OnSplitPolicyEvaluated func(policyName string, duration time.Duration)
+
+		// OnLevelCreated is optional and invoked when a split creates a new processing queue level,
+		// with the new level and the sorted IDs of domains moved to it. Domains is nil if the new level
+		// matches all domains except some. It's called while holding the lock for processing queue
+		// collections, so it should not block.
+		OnLevelCreated func(level int, domains []string)
+
+		// ReadOnly specifies if the processor starts in read-only mode, see processorBase.SetReadOnly
+		ReadOnly bool
+
+		// RedispatchPolicies is optional and overrides the redispatch options for the category
+		// of tasks processed by the processor, see processorBase.getRedispatchPolicy
+		RedispatchPolicies map[task.Category]*RedispatchPolicy
+
+		// EnableTestHooks enables methods that are only meant for tests, e.g. processorBase.TriggerSplit.
+		// It must not be set in production
+		EnableTestHooks bool
+
+		// TaskEventSink is optional and receives an event for each submitted task
+		TaskEventSink TaskEventSink
+
+		// SplitApprover is optional and consulted before applying the states proposed by split
+		// policies, see SplitApprover. All proposed splits are approved if it's nil. Moving pinned
+		// domains back to their levels is not subject to approval
+		SplitApprover SplitApprover
+
+		// OnCaughtUp is optional and invoked from the processor pump goroutine when the processor
+		// becomes caught up, see processorBase.IsCaughtUp. It should not block.
+		OnCaughtUp func()
+
+		// OnAckLevelAdvanced is optional and invoked from the processor pump goroutine after an ack
+		// level update moves the ack level forward and the new ack level is persisted, tasks in
+		// [from, to) are all acked. It should not block.
+		OnAckLevelAdvanced func(from, to task.Key)
+
+		// DisableRedispatch is optional and specifies if tasks that can't be submitted are dropped
+		// instead of being kept in the redispatcher, see processorBase.deferTask and
+		// processorBase.redispatchNackedTask. nil means false
+		DisableRedispatch dynamicconfig.BoolPropertyFn
+
+		// TaskGapTimeout is only used by transfer queue processor and specifies how long task IDs missing
+		// between tasks read by the default level can stay missing before they are reported, see
+		// transferQueueProcessorBase.observeTaskIDGaps. nil or 0 disables gap detection
+		TaskGapTimeout dynamicconfig.DurationPropertyFn
+
+		// MaxReadTimeRange is only used by timer queue processor and caps the visibility time range
+		// covered by a single read to [ackLevel, ackLevel + MaxReadTimeRange). nil or 0 means no limit
+		MaxReadTimeRange dynamicconfig.DurationPropertyFn
+
+		// WarmupDuration is optional and specifies how long it takes for the read and redispatch rate of
+		// a newly created processor to ramp up to the full rate, see processorBase.getWarmupRateFactor.
+		// nil or 0 disables warmup
+		WarmupDuration dynamicconfig.DurationPropertyFn
+
+		// EnableTaskSubmitAge is optional and specifies if the age of tasks resubmitted by
+		// the redispatcher is recorded, see task.RedispatcherOptions. nil means false
+		EnableTaskSubmitAge dynamicconfig.BoolPropertyFn
+
+		// SplitCooldown is optional and specifies how long a domain can't be split or merged again
+		// after it's split or merged, regardless of the split policy. nil or 0 disables the cooldown
+		SplitCooldown dynamicconfig.DurationPropertyFn
+
+		// ShouldSubmitTask is optional and consulted right before submitting a task, tasks for which it
+		// returns false are held in the redispatcher and retried by later redispatch passes, see
+		// task.RedispatcherOptions.TaskShouldSubmit. nil means all tasks can be submitted
+		ShouldSubmitTask func(task.Task) bool
+
+		// EnableOrderedProcessingByDomainID is optional and specifies if tasks of the same workflow in
+		// the domain are submitted one at a time, each only after the previous one is acked or nacked.
+		// Tasks blocked by an in-flight task of their workflow are kept in the redispatcher. nil means false
+		EnableOrderedProcessingByDomainID dynamicconfig.BoolPropertyFnWithDomainIDFilter
+
+		// RedispatchDecisionLogSize is optional and specifies the number of recent redispatch decisions
+		// kept in memory for debugging, see MetricsSnapshot.RecentRedispatchDecisions. It's read once when
+		// the processor is created and capped by maxRedispatchDecisionLogSize. nil or 0 means disabled
+		RedispatchDecisionLogSize dynamicconfig.IntPropertyFn
+
+		// MaxRedispatchQueueSizePerDomain is optional and specifies the max number of tasks of a domain
+		// in the redispatch queue. When the limit is reached, new tasks of the domain are parked and only
+		// added once the domain has room again, while other domains can still defer tasks. Tasks nacked by
+		// the task processor are always added. nil or 0 means no limit
+		MaxRedispatchQueueSizePerDomain dynamicconfig.IntPropertyFnWithDomainIDFilter
+
+		// LoadProcessingQueueStates is optional and re-reads the processing queue states stored
+		// in persistence, see processorBase.Reconcile. nil means reconciliation is not supported
+		LoadProcessingQueueStates func() []ProcessingQueueState
+
+		// MetricsScopeFn is optional and selects the scope all processor metrics are emitted under,
+		// given the metrics client and MetricScope. nil means metricsClient.Scope(MetricScope)
+		MetricsScopeFn func(metricsClient metrics.Client, scopeIdx int) metrics.Scope
 	}
 
 	actionNotification struct {
@@ -83,11 +297,213 @@ type (
 		err    error
 	}
 
+	// parkedTask is a newly read task held back from the redispatcher as its domain
+	// has reached MaxRedispatchQueueSizePerDomain, along with the level it's read from
+	parkedTask struct {
+		level int
+		task  task.Task
+	}
+
+	// TaskKeyTypeMismatchError is returned when a task key has a different type than the
+	// one expected by the queue processor, e.g. a timer task key is used in a transfer queue processor
+	TaskKeyTypeMismatchError struct {
+		ExpectedType string
+		ActualType   string
+		Key          task.Key
+	}
+
+	// RedispatchTaskInfo identifies a task in the redispatch queue
+	RedispatchTaskInfo struct {
+		DomainID   string
+		WorkflowID string
+		RunID      string
+		TaskID     int64
+		TaskType   int
+		Attempt    int
+	}
+
+	// TaskEvent describes a task submitted to the task processor
+	TaskEvent struct {
+		DomainID   string
+		WorkflowID string
+		RunID      string
+		TaskType   int
+		Key        task.Key
+		// Redispatched is true if the task is submitted by the redispatcher
+		// instead of right after it's read
+		Redispatched bool
+	}
+
+	// TaskEventSink receives an event for each task submitted by the processor, e.g. for auditing.
+	// Events are delivered from a single goroutine in the order tasks are submitted, events
+	// are dropped instead of blocking task submission if the sink falls behind
+	TaskEventSink interface {
+		OnTaskSubmitted(event TaskEvent)
+	}
+
+	// ProcessorState is the state of a queue processor exported for a warm handover
+	// when the shard is moved. Tasks in the redispatch queue are bound to the current
+	// shard owner, so only their identifiers are exported, the tasks themselves are
+	// read again by the new owner as they are not acked by the exported states
+	ProcessorState struct {
+		ProcessingQueueStates []ProcessingQueueState
+		RedispatchTasks       []RedispatchTaskInfo
+		PausedDomainIDs       []string
+	}
+
+	// MetricsSnapshot is a point-in-time view of the processor metrics, see processorBase.MetricsSnapshot
+	MetricsSnapshot struct {
+		// AckLevels is the ack level of each processing queue level
+		AckLevels map[int]task.Key
+		// AckLagByLevel is the number of tasks loaded by each level and not yet released by ack level updates
+		AckLagByLevel map[int]int
+		// RedispatchQueueSize is the number of tasks in the redispatcher
+		RedispatchQueueSize int
+		// RedispatchDrainTime is the estimated time to drain the redispatcher, see EstimateRedispatchDrainTime
+		RedispatchDrainTime time.Duration
+		// InFlightTasks is the number of tracked in-flight tasks, it's always 0 if MaxInFlightTasks is not set
+		InFlightTasks int
+		// PendingTaskCountByDomain is the number of loaded but not acked tasks of each domain
+		PendingTaskCountByDomain map[string]int
+		// TasksSubmittedByLevel is the number of tasks submitted to the task processor by each
+		// level since the processor is created, tasks submitted by the redispatcher are not included
+		TasksSubmittedByLevel map[int]int64
+		// CaughtUp is true if the processor is caught up, see IsCaughtUp
+		CaughtUp bool
+		// BoostedDomains is the deadline of each domain currently boosted by BoostDomain
+		BoostedDomains map[string]time.Time
+		// RecentRedispatchDecisions is the most recent redispatch decisions in the order they're made,
+		// it's always empty if RedispatchDecisionLogSize is not set
+		RecentRedispatchDecisions []RedispatchDecision
+	}
+
+	// CollapseLevelError is returned when a processing queue level can't be collapsed
+	CollapseLevelError struct {
+		Level   int
+		Message string
+	}
+
+	// SplitDomainCoverageError is returned when the queues resulting from a split
+	// no longer cover all domains covered by the queues before the split
+	SplitDomainCoverageError struct {
+		Level  int
+		Before DomainFilter
+		After  DomainFilter
+	}
+
+	// ProcessingQueueOverlapError is returned when queues in a processing queue collection
+	// overlap or are not sorted by ack level, which indicates a bug in merging queues
+	ProcessingQueueOverlapError struct {
+		Level    int
+		Previous ProcessingQueueState
+		Next     ProcessingQueueState
+	}
+
+	// SplitResult summarizes a split pass over all processing queue collections
+	SplitResult struct {
+		// SplitsPerformed is the number of processing queues split by the policy
+		SplitsPerformed int
+		// SplitsVetoed is the number of splits proposed by the policy but vetoed by the SplitApprover
+		SplitsVetoed int
+		// LevelsCreated is the number of processing queue collections created for new levels
+		LevelsCreated int
+		// StatesCreated is the number of processing queues moved to another level by the split
+		StatesCreated int
+		// NextPollTimes is the next poll time of each level after the split,
+		// zero time means the level should be polled immediately
+		NextPollTimes map[int]time.Time
+	}
+
 	processorBase struct {
 		shard         shard.Context
 		taskProcessor task.Processor
 		redispatcher  task.Redispatcher
 
+		// lastAckLevel and numStuckAckLevelUpdates track consecutive ack level updates
+		// that didn't advance the ack level, they are only accessed by the processor pump goroutine
+		lastAckLevel            task.Key
+		numStuckAckLevelUpdates int
+
+		// persistedAckLevel is the ack level last persisted, or the ack level loaded with
+		// the processing queue states. Ack level updates never persist an ack level lower
+		// than it, see updateAckLevel. It's only accessed by the processor pump goroutine
+		persistedAckLevel task.Key
+
+		// redispatchCredit accumulates RedispatchWeight for each processing iteration
+		// and decides whether the iteration should redispatch tasks or read new tasks,
+		// it's only accessed by the processor pump goroutine
+		redispatchCredit float64
+
+		// outstandingTasksByDomain is the number of outstanding tasks of each domain when outstanding
+		// task shedding is enabled, it's refreshed before submitting each batch of newly read tasks and
+		// only accessed by the processor pump goroutine
+		outstandingTasksByDomain map[string]int
+
+		// redispatchAllowanceLock guards redispatchAllowanceByDomain, the number of tasks of each domain
+		// with MaxOutstandingTasksPerDomain set that the redispatcher can still submit before the domain
+		// reaches its limit. It's recomputed by refreshOutstandingTaskCount and nil when shedding is disabled
+		redispatchAllowanceLock     sync.Mutex
+		redispatchAllowanceByDomain map[string]int
+
+		// parkedTasksByDomain are newly read tasks of each domain that has reached
+		// MaxRedispatchQueueSizePerDomain. They stay pending in their processing queues, so the ack
+		// level is not advanced past them, and are moved to the redispatcher by releaseParkedTasks
+		// once the domain has room again. It's only accessed by the processor pump goroutine
+		parkedTasksByDomain map[string][]parkedTask
+
+		// taskEventCh buffers events for TaskEventSink, it's nil if there's no sink
+		taskEventCh chan TaskEvent
+
+		// inFlightLock guards inFlightTasks, the IDs of tasks submitted to the task processor
+		// and not yet acked or nacked. Tasks are only tracked when MaxInFlightTasks is set
+		inFlightLock  sync.Mutex
+		inFlightTasks map[int64]struct{}
+
+		// workflowGateLock guards inFlightWorkflows and inFlightWorkflowTasks, the ID of the in-flight
+		// task of each workflow and the workflow of each in-flight task. Only workflows in domains with
+		// ordered processing enabled are tracked, see EnableOrderedProcessingByDomainID
+		workflowGateLock      sync.Mutex
+		inFlightWorkflows     map[definition.WorkflowIdentifier]int64
+		inFlightWorkflowTasks map[int64]definition.WorkflowIdentifier
+
+		// boostLock guards boostedDomains, the deadline of each domain boosted by BoostDomain
+		boostLock      sync.RWMutex
+		boostedDomains map[string]time.Time
+
+		// redispatchDecisionsLock guards redispatchDecisions, a ring buffer of the most recent
+		// redispatch decisions, and numRedispatchDecisions, the number of decisions ever recorded.
+		// redispatchDecisions is nil if RedispatchDecisionLogSize is not set
+		redispatchDecisionsLock sync.Mutex
+		redispatchDecisions     []RedispatchDecision
+		numRedispatchDecisions  int
+
+		// submittedTasksLock guards submittedTasksByLevel, the number of newly read
+		// tasks submitted to the task processor by each level
+		submittedTasksLock    sync.Mutex
+		submittedTasksByLevel map[int]int64
+
+		// redispatchLock and redispatchCond guard numRedispatching,
+		// the number of redispatch passes currently running
+		redispatchLock   sync.Mutex
+		redispatchCond   *sync.Cond
+		numRedispatching int
+
+		// redispatchSubmitRate is the exponential moving average of the number of tasks
+		// submitted by redispatch passes per second. redispatchLock guards these fields
+		redispatchSubmitRate        float64
+		redispatchSubmitRateSampled bool
+		lastRedispatchTime          time.Time
+		unsampledRedispatchSubmits  int
+
+		// lastRedispatchSize is the redispatcher size sampled at lastRedispatchSizeSampleTime,
+		// see recordRedispatchQueueSize. They're only accessed by the processor pump goroutine
+		lastRedispatchSize           int
+		lastRedispatchSizeSampleTime time.Time
+
+		// pumpIterationScopes are the metrics scopes tagged by pump iteration type,
+		// they're only accessed by the processor pump goroutine
+		pumpIterationScopes map[string]metrics.Scope
+
 		options                     *queueProcessorOptions
 		updateMaxReadLevel          updateMaxReadLevelFn
 		updateClusterAckLevel       updateClusterAckLevelFn
@@ -98,14 +514,110 @@ type (
 		metricsClient metrics.Client
 		metricsScope  metrics.Scope
 
-		rateLimiter quotas.Limiter
+		rateLimiter       quotas.Limiter
+		levelRateLimiters map[int]*quotas.DynamicRateLimiter
+
+		// warmupStartTime is when the processor is created, and warmupRateLimiter
+		// limits reads to a fraction of MaxPollRPS until warmup is done
+		warmupStartTime   time.Time
+		warmupRateLimiter *rate.Limiter
 
 		status         int32
 		shutdownWG     sync.WaitGroup
 		shutdownCh     chan struct{}
 		actionNotifyCh chan actionNotification
 
+		// compactNotifyCh is signaled by the compactor goroutine so that
+		// the processor pump prunes and compacts processingQueueCollections
+		compactNotifyCh chan struct{}
+
+		// drainNotifyCh is signaled by DrainNow so that the processor pump polls all levels
+		// immediately. draining is the number of running DrainNow calls, read rate limits,
+		// read backoffs and outstanding task shedding are bypassed while it's not 0
+		drainNotifyCh chan struct{}
+		draining      int32
+
+		// droppedTaskResetPending is 1 while a reset requested by requestDroppedTaskReset is pending
+		droppedTaskResetPending int32
+
+		// Locks must be acquired in the following order to avoid deadlock:
+		// queueCollectionsLock, ackStateLock, redispatcher's internal locks, pausedDomainsLock,
+		// inFlightLock. redispatchLock, pollTimeLock, newTimeLock, submittedTasksLock,
+		// workflowGateLock, boostLock, redispatchDecisionsLock and redispatchAllowanceLock
+		// are never held while acquiring another lock. A goroutine may skip locks in the order, but must never
+		// acquire a lock that comes earlier than one it's holding.
+		//
+		// queueCollectionsLock protects processingQueueCollections. processingQueueCollections
+		// are only modified by the processor pump goroutine, which must hold the write lock when
+		// doing so, but can read them without holding the lock. Any other goroutine must hold
+		// the read lock when accessing processingQueueCollections.
+		// processingQueueCollections are always sorted by level and queues within each
+		// collection are sorted by ack level.
+		queueCollectionsLock       sync.RWMutex
 		processingQueueCollections []ProcessingQueueCollection
+
+		// domainSplitTimes is the last time each domain is moved to another level by a split
+		// or merged by collapsing its level, see SplitCooldown. It's protected by queueCollectionsLock
+		domainSplitTimes map[string]time.Time
+
+		// ackStateLock protects onDomainDrained and pendingDomains, the state derived from
+		// ack level updates. It's separate from queueCollectionsLock so that registering
+		// a callback or reporting drained domains doesn't block readers of the collections.
+		// pendingDomains contains domains that had pending tasks and haven't been
+		// reported as drained
+		ackStateLock    sync.Mutex
+		onDomainDrained domainDrainedFn
+		pendingDomains  map[string]struct{}
+
+		// pausedDomains specifies domains whose tasks are kept in the
+		// redispatcher instead of being submitted to the task processor
+		pausedDomainsLock sync.RWMutex
+		pausedDomains     DomainFilter
+
+		// readOnly is 1 if the processor is in read-only mode and
+		// tasks are kept in the redispatcher instead of being submitted
+		readOnly int32
+
+		// caughtUp is 1 if the processor is caught up, see processorBase.IsCaughtUp.
+		// numCaughtUpStateChanges is the number of consecutive ack level updates that
+		// observed a different state, it's only accessed by the processor pump goroutine
+		caughtUp                int32
+		numCaughtUpStateChanges int
+	}
+
+	// RedispatchPolicy specifies how tasks of a category are redispatched. Nil fields
+	// fall back to the corresponding redispatch options of queueProcessorOptions
+	RedispatchPolicy struct {
+		RequeueDelay    dynamicconfig.DurationPropertyFn
+		RequeueMaxDelay dynamicconfig.DurationPropertyFn
+		MinBatchSize    dynamicconfig.IntPropertyFn
+		MaxBatchSize    dynamicconfig.IntPropertyFn
+		// ShouldDrop returns true if the task should be acked and dropped instead of being redispatched
+		ShouldDrop func(task.Task) bool
+	}
+
+	// RedispatchDecision is the action taken for a task resubmitted by the redispatcher
+	RedispatchDecision struct {
+		Key      task.Key
+		DomainID string
+		Action   task.SubmitAction
+		Attempt  int
+		Time     time.Time
+	}
+
+	// pumpIteration is an iteration of the processor pump loop, iterationType is what the iteration
+	// did and startTime is when the pump starts handling the event that triggered the iteration
+	pumpIteration struct {
+		iterationType string
+		startTime     time.Time
+	}
+
+	// inFlightBudgetProcessor wraps the task processor used by the redispatcher
+	// so that redispatched tasks also respect MaxInFlightTasks
+	inFlightBudgetProcessor struct {
+		task.Processor
+
+		processorBase *processorBase
 	}
 )
 
@@ -121,19 +633,46 @@ func newProcessorBase(
 	logger log.Logger,
 	metricsClient metrics.Client,
 ) *processorBase {
-	metricsScope := metricsClient.Scope(options.MetricScope)
-	return &processorBase{
+	processorBase, err := newProcessorBaseWithValidation(
+		shard,
+		processingQueueStates,
+		taskProcessor,
+		options,
+		updateMaxReadLevel,
+		updateClusterAckLevel,
+		updateProcessingQueueStates,
+		queueShutdown,
+		logger,
+		metricsClient,
+	)
+	if err != nil {
+		panic(fmt.Sprintf("invalid processing queue states: %v", err))
+	}
+	return processorBase
+}
+
+// newProcessorBaseWithValidation is the same as newProcessorBase
+// but returns an error instead of panicking when processingQueueStates are invalid
+func newProcessorBaseWithValidation(
+	shard shard.Context,
+	processingQueueStates []ProcessingQueueState,
+	taskProcessor task.Processor,
+	options *queueProcessorOptions,
+	updateMaxReadLevel updateMaxReadLevelFn,
+	updateClusterAckLevel updateClusterAckLevelFn,
+	updateProcessingQueueStates updateProcessingQueueStatesFn,
+	queueShutdown queueShutdownFn,
+	logger log.Logger,
+	metricsClient metrics.Client,
+) (*processorBase, error) {
+	if err := verifyProcessingQueueStates(processingQueueStates); err != nil {
+		return nil, err
+	}
+
+	metricsScope := newProcessorMetricsScope(options, metricsClient)
+	processorBase := &processorBase{
 		shard:         shard,
 		taskProcessor: taskProcessor,
-		redispatcher: task.NewRedispatcher(
-			taskProcessor,
-			&task.RedispatcherOptions{
-				TaskRedispatchInterval:                  options.RedispatchInterval,
-				TaskRedispatchIntervalJitterCoefficient: options.RedispatchIntervalJitterCoefficient,
-			},
-			logger,
-			metricsScope,
-		),
 
 		options:                     options,
 		updateMaxReadLevel:          updateMaxReadLevel,
@@ -150,30 +689,190 @@ func newProcessorBase(
 				return float64(options.MaxPollRPS())
 			},
 		),
+		levelRateLimiters: make(map[int]*quotas.DynamicRateLimiter),
+		warmupStartTime:   shard.GetTimeSource().Now(),
+		warmupRateLimiter: rate.NewLimiter(rate.Inf, 1),
 
-		status:         common.DaemonStatusInitialized,
-		shutdownCh:     make(chan struct{}),
-		actionNotifyCh: make(chan actionNotification),
+		status:          common.DaemonStatusInitialized,
+		shutdownCh:      make(chan struct{}),
+		actionNotifyCh:  make(chan actionNotification),
+		compactNotifyCh: make(chan struct{}, 1),
+		drainNotifyCh:   make(chan struct{}, 1),
 
 		processingQueueCollections: newProcessingQueueCollections(
 			processingQueueStates,
 			logger,
 			metricsClient,
 		),
+		domainSplitTimes: make(map[string]time.Time),
+		pendingDomains:   make(map[string]struct{}),
+		pausedDomains:    NewDomainFilter(nil, false),
+		inFlightTasks:    make(map[int64]struct{}),
+
+		parkedTasksByDomain: make(map[string][]parkedTask),
+
+		inFlightWorkflows:     make(map[definition.WorkflowIdentifier]int64),
+		inFlightWorkflowTasks: make(map[int64]definition.WorkflowIdentifier),
+		boostedDomains:        make(map[string]time.Time),
+
+		submittedTasksByLevel: make(map[int]int64),
+		pumpIterationScopes:   make(map[string]metrics.Scope),
+	}
+	if options.ReadOnly {
+		processorBase.readOnly = 1
+	}
+	if options.TaskEventSink != nil {
+		processorBase.taskEventCh = make(chan TaskEvent, taskEventBufferSize)
+	}
+	processorBase.enforcePinnedDomainLevelsLocked(&SplitResult{})
+	for _, queueCollection := range processorBase.processingQueueCollections {
+		ackLevel := getCollectionAckLevel(queueCollection)
+		if ackLevel == nil {
+			continue
+		}
+		if processorBase.persistedAckLevel == nil {
+			processorBase.persistedAckLevel = ackLevel
+		} else {
+			processorBase.persistedAckLevel = minTaskKey(processorBase.persistedAckLevel, ackLevel)
+		}
+	}
+	processorBase.redispatchCond = sync.NewCond(&processorBase.redispatchLock)
+	if options.RedispatchDecisionLogSize != nil {
+		if size := common.MinInt(options.RedispatchDecisionLogSize(), maxRedispatchDecisionLogSize); size > 0 {
+			processorBase.redispatchDecisions = make([]RedispatchDecision, size)
+		}
+	}
+	processorBase.redispatcher = processorBase.newRedispatcher()
+
+	return processorBase, nil
+}
+
+// newRedispatcher creates a redispatcher with a separate redispatch queue for each processing queue level
+func (p *processorBase) newRedispatcher() task.Redispatcher {
+	return newLevelRedispatcher(p.newLevelRedispatcher)
+}
+
+func (p *processorBase) newLevelRedispatcher(
+	level int,
+) task.Redispatcher {
+	policy := p.getRedispatchPolicy()
+	return task.NewRedispatcher(
+		&inFlightBudgetProcessor{
+			Processor:     p.taskProcessor,
+			processorBase: p,
+		},
+		p.shard.GetTimeSource(),
+		&task.RedispatcherOptions{
+			TaskRedispatchInterval:                  p.options.RedispatchInterval,
+			TaskRedispatchIntervalJitterCoefficient: p.options.RedispatchIntervalJitterCoefficient,
+			TaskTransform:                           policy.getTaskTransform(p.options.RedispatchTaskTransform),
+			TaskPaused:                              p.isTaskPaused,
+			TaskShouldSubmit:                        p.shouldSubmitRedispatchedTask,
+			TaskRedispatchBatchSizeByDomainID:       p.options.RedispatchBatchSizeByDomainID,
+			TaskRedispatchMinBatchSize:              policy.MinBatchSize,
+			TaskRedispatchMaxBatchSize:              policy.MaxBatchSize,
+			TaskRequeueDelay:                        policy.RequeueDelay,
+			TaskRequeueMaxDelay:                     policy.RequeueMaxDelay,
+			TaskRedispatchRateFactor:                p.getWarmupRateFactor,
+			TaskSubmitAgeEnabled:                    p.options.EnableTaskSubmitAge,
+			TaskRedispatched:                        p.getTaskRedispatchedFn(),
+			TaskSizeByDomainIDTracked:               p.options.MaxRedispatchQueueSizePerDomain != nil || p.options.MaxOutstandingTasksPerDomain != nil,
+		},
+		p.logger.WithTags(tag.QueueLevel(level)),
+		p.metricsScope.Tagged(metrics.QueueLevelTag(level)),
+	)
+}
+
+// getRedispatchPolicy returns the redispatch policy for the category of tasks processed by the
+// processor, with fields not specified by the policy taken from the processor's redispatch options
+func (p *processorBase) getRedispatchPolicy() *RedispatchPolicy {
+	policy := &RedispatchPolicy{
+		RequeueDelay:    p.options.RedispatchRequeueDelay,
+		RequeueMaxDelay: p.options.RedispatchRequeueMaxDelay,
+		MinBatchSize:    p.options.RedispatchMinBatchSize,
+		MaxBatchSize:    p.options.RedispatchMaxBatchSize,
+	}
+
+	category, ok := p.getTaskCategory()
+	if !ok {
+		return policy
+	}
+	categoryPolicy, ok := p.options.RedispatchPolicies[category]
+	if !ok || categoryPolicy == nil {
+		return policy
+	}
+
+	if categoryPolicy.RequeueDelay != nil {
+		policy.RequeueDelay = categoryPolicy.RequeueDelay
+	}
+	if categoryPolicy.RequeueMaxDelay != nil {
+		policy.RequeueMaxDelay = categoryPolicy.RequeueMaxDelay
+	}
+	if categoryPolicy.MinBatchSize != nil {
+		policy.MinBatchSize = categoryPolicy.MinBatchSize
+	}
+	if categoryPolicy.MaxBatchSize != nil {
+		policy.MaxBatchSize = categoryPolicy.MaxBatchSize
+	}
+	policy.ShouldDrop = categoryPolicy.ShouldDrop
+	return policy
+}
+
+// getTaskTransform returns a transform that drops tasks matched by ShouldDrop
+// and transforms other tasks with the given transform, which can be nil
+func (p *RedispatchPolicy) getTaskTransform(
+	transform task.TransformFn,
+) task.TransformFn {
+	if p.ShouldDrop == nil {
+		return transform
+	}
+
+	return func(redispatchTask task.Task) (task.Task, bool) {
+		if p.ShouldDrop(redispatchTask) {
+			return nil, false
+		}
+		if transform == nil {
+			return redispatchTask, true
+		}
+		return transform(redispatchTask)
 	}
 }
 
-func (p *processorBase) updateAckLevel() (bool, error) {
+func (p *processorBase) updateAckLevel(
+	ctx context.Context,
+) (processFinished bool, retError error) {
+	span := p.startSpan(ctx, "queue.updateAckLevel")
+	defer func() {
+		finishSpan(span, retError)
+	}()
+
 	p.metricsScope.IncCounter(metrics.AckLevelUpdateCounter)
+	p.recordRedispatchQueueSize(p.redispatcher.Size())
 	var minAckLevel task.Key
 	totalPengingTasks := 0
+	unlock := p.lockQueueCollections(lockOperationUpdateAckLevel)
+	if err := p.verifyTaskKeyTypes(); err != nil {
+		unlock()
+		p.logger.Error("Error updating ack level", tag.Error(err), tag.OperationFailed)
+		p.metricsScope.IncCounter(metrics.AckLevelUpdateFailedCounter)
+		return false, err
+	}
+	emitLevelTaggedMetrics := p.options.EnableLevelTaggedMetrics()
 	for _, queueCollection := range p.processingQueueCollections {
 		ackLevel, numPendingTasks := queueCollection.UpdateAckLevels()
+		if emitLevelTaggedMetrics {
+			p.metricsScope.Tagged(metrics.QueueLevelTag(queueCollection.Level())).
+				RecordTimer(metrics.ProcessingQueuePendingTasksTimer, time.Duration(numPendingTasks))
+		}
 		if ackLevel == nil {
 			// ack level may be nil if the queueCollection doesn't contain any processing queue
 			// after updating ack levels
 			continue
 		}
+		if gaugeValue, ok := getTaskKeyGaugeValue(ackLevel); ok && emitLevelTaggedMetrics {
+			p.metricsScope.Tagged(metrics.QueueLevelTag(queueCollection.Level())).
+				UpdateGauge(metrics.ProcessingQueueAckLevelGauge, gaugeValue)
+		}
 
 		totalPengingTasks += numPendingTasks
 		if minAckLevel == nil {
@@ -182,10 +881,43 @@ func (p *processorBase) updateAckLevel() (bool, error) {
 			minAckLevel = minTaskKey(minAckLevel, ackLevel)
 		}
 	}
+	unlock()
+	p.releaseParkedTasks()
+	p.refreshOutstandingTaskCount()
+
+	// the write lock is only needed for updating ack levels, the processor pump goroutine
+	// is the only writer of processingQueueCollections, so it can read them without the lock
+	var pendingTaskCount map[string]int
+	if p.options.EnableDomainTaggedMetrics() {
+		pendingTaskCount = p.pendingTaskCountByDomainLocked()
+	}
+	p.ackStateLock.Lock()
+	onDomainDrained := p.onDomainDrained
+	drainedDomains := p.updateDrainedDomains()
+	p.ackStateLock.Unlock()
+	span.SetTag("queue.collections", len(p.processingQueueCollections))
+	span.SetTag("pending.tasks", totalPengingTasks)
+
+	for domainID, count := range pendingTaskCount {
+		p.metricsScope.Tagged(metrics.DomainTag(domainID)).
+			RecordTimer(metrics.ProcessingQueuePendingTasksTimer, time.Duration(count))
+	}
+
+	if onDomainDrained != nil {
+		for _, domainID := range drainedDomains {
+			onDomainDrained(domainID)
+		}
+	}
+
+	p.updateCaughtUp()
 
 	if minAckLevel == nil {
 		// note that only failover processor will meet this condition
-		err := p.queueShutdown()
+		err := p.shutdownQueue()
+		if err == errQueueShutdownTimeout {
+			// give up the shutdown so that the processor can be stopped
+			return true, err
+		}
 		if err != nil {
 			p.logger.Error("Error shutdown queue", tag.Error(err))
 			// return error so that shutdown callback can be retried
@@ -194,28 +926,179 @@ func (p *processorBase) updateAckLevel() (bool, error) {
 		return true, nil
 	}
 
+	p.checkAckLevelStuck(minAckLevel, totalPengingTasks)
+
+	if p.persistedAckLevel != nil && minAckLevel.Less(p.persistedAckLevel) {
+		// acked tasks would be processed again after the shard is reloaded,
+		// keep the previous ack level and let later updates catch up with it
+		p.logger.Error("Refused to persist ack level lower than the last persisted ack level",
+			tag.Key(fmt.Sprintf("%v", minAckLevel)),
+			tag.Value(p.persistedAckLevel),
+		)
+		p.metricsScope.IncCounter(metrics.ProcessingQueueAckLevelRegressionCounter)
+		return false, nil
+	}
+
 	if totalPengingTasks > warnPendingTasks {
 		p.logger.Warn("Too many pending tasks.")
 	}
 	// TODO: consider move pendingTasksTime metrics from shardInfoScope to queue processor scope
 	p.metricsClient.RecordTimer(metrics.ShardInfoScope, getPendingTasksMetricIdx(p.options.MetricScope), time.Duration(totalPengingTasks))
 
+	if err := p.persistAckLevel(minAckLevel); err != nil {
+		return false, convertShardOwnershipLostError(err)
+	}
+
+	p.notifyAckLevelAdvanced(minAckLevel)
+	return false, nil
+}
+
+// persistAckLevel persists the processing queue states if EnablePersistQueueStates is true,
+// otherwise only the ack level is persisted
+func (p *processorBase) persistAckLevel(
+	ackLevel task.Key,
+) error {
 	if p.options.EnablePersistQueueStates() && p.updateProcessingQueueStates != nil {
 		states := p.getProcessingQueueStates().GetStateActionResult.States
 		if err := p.updateProcessingQueueStates(states); err != nil {
 			p.logger.Error("Error persisting processing queue states", tag.Error(err), tag.OperationFailed)
 			p.metricsScope.IncCounter(metrics.AckLevelUpdateFailedCounter)
-			return false, err
+			return err
 		}
-	} else {
-		if err := p.updateClusterAckLevel(minAckLevel); err != nil {
-			p.logger.Error("Error updating ack level for shard", tag.Error(err), tag.OperationFailed)
-			p.metricsScope.IncCounter(metrics.AckLevelUpdateFailedCounter)
-			return false, err
+		return nil
+	}
+
+	if err := p.updateClusterAckLevel(ackLevel); err != nil {
+		p.logger.Error("Error updating ack level for shard", tag.Error(err), tag.OperationFailed)
+		p.metricsScope.IncCounter(metrics.AckLevelUpdateFailedCounter)
+		return err
+	}
+	return nil
+}
+
+// convertShardOwnershipLostError returns ErrProcessorShuttingDown if err means the shard
+// ownership is lost, as retrying the ack level update will never succeed, otherwise err is returned
+func convertShardOwnershipLostError(
+	err error,
+) error {
+	if _, ok := err.(*persistence.ShardOwnershipLostError); ok {
+		return ErrProcessorShuttingDown
+	}
+	return err
+}
+
+// notifyAckLevelAdvanced records the persisted ack level and invokes OnAckLevelAdvanced
+// if it has moved forward since the last persisted ack level
+func (p *processorBase) notifyAckLevelAdvanced(
+	ackLevel task.Key,
+) {
+	previousAckLevel := p.persistedAckLevel
+	p.persistedAckLevel = ackLevel
+	if p.options.OnAckLevelAdvanced == nil || previousAckLevel == nil || !previousAckLevel.Less(ackLevel) {
+		return
+	}
+
+	p.options.OnAckLevelAdvanced(previousAckLevel, ackLevel)
+}
+
+// verifyTaskKeyTypes checks that the task keys in all processing queue states
+// have the type expected by the queue processor, so that a misconfigured processor
+// returns an error instead of panicking on type assertion.
+// Caller must hold queueCollectionsLock
+func (p *processorBase) verifyTaskKeyTypes() error {
+	var expectedKeyType reflect.Type
+	switch p.options.MetricScope {
+	case metrics.TransferActiveQueueProcessorScope, metrics.TransferStandbyQueueProcessorScope:
+		expectedKeyType = reflect.TypeOf(transferTaskKey{})
+	case metrics.TimerActiveQueueProcessorScope, metrics.TimerStandbyQueueProcessorScope:
+		expectedKeyType = reflect.TypeOf(timerTaskKey{})
+	default:
+		// unknown processor type, skip the check
+		return nil
+	}
+
+	for _, queueCollection := range p.processingQueueCollections {
+		for _, queue := range queueCollection.Queues() {
+			state := queue.State()
+			for _, key := range []task.Key{state.AckLevel(), state.ReadLevel(), state.MaxLevel()} {
+				if actualKeyType := reflect.TypeOf(key); actualKeyType != expectedKeyType {
+					return &TaskKeyTypeMismatchError{
+						ExpectedType: expectedKeyType.String(),
+						ActualType:   fmt.Sprintf("%v", actualKeyType),
+						Key:          key,
+					}
+				}
+			}
 		}
 	}
 
-	return false, nil
+	return nil
+}
+
+// getTaskCategory returns the category of tasks processed by the processor, which is derived
+// from the metric scope in the processor options. False is returned for unknown processor types
+func (p *processorBase) getTaskCategory() (task.Category, bool) {
+	switch p.options.MetricScope {
+	case metrics.TransferActiveQueueProcessorScope, metrics.TransferStandbyQueueProcessorScope:
+		return task.CategoryTransfer, true
+	case metrics.TimerActiveQueueProcessorScope, metrics.TimerStandbyQueueProcessorScope:
+		return task.CategoryTimer, true
+	default:
+		return 0, false
+	}
+}
+
+// verifyTaskCategory checks that the task has the category expected by the queue processor.
+// Tasks of other categories can't be ordered with the task keys in processing queues,
+// so they are logged and should be dropped instead of being added to a processing queue.
+func (p *processorBase) verifyTaskCategory(
+	queueTask task.Task,
+) bool {
+	expectedCategory, ok := p.getTaskCategory()
+	if !ok {
+		// unknown processor type, skip the check
+		return true
+	}
+
+	if actualCategory := queueTask.GetTaskCategory(); actualCategory != expectedCategory {
+		p.logger.Error("Task category mismatch, dropping task",
+			tag.WorkflowDomainID(queueTask.GetDomainID()),
+			tag.WorkflowID(queueTask.GetWorkflowID()),
+			tag.WorkflowRunID(queueTask.GetRunID()),
+			tag.TaskID(queueTask.GetTaskID()),
+			tag.Value(actualCategory),
+		)
+		p.metricsScope.IncCounter(metrics.ProcessingQueueTaskCategoryMismatchCounter)
+		return false
+	}
+	return true
+}
+
+// shutdownQueue invokes queueShutdown and waits for at most ShutdownTimeout
+// for it to complete. If the deadline is exceeded, the outstanding queue states
+// are logged and errQueueShutdownTimeout is returned, the queueShutdown call
+// is left running in the background.
+func (p *processorBase) shutdownQueue() error {
+	resultCh := make(chan error, 1)
+	go func() {
+		resultCh <- p.queueShutdown()
+	}()
+
+	timeSource := p.shard.GetTimeSource()
+	timerGate := NewLocalTimerGate(timeSource)
+	defer timerGate.Close()
+	timerGate.Update(timeSource.Now().Add(p.options.ShutdownTimeout()))
+
+	select {
+	case err := <-resultCh:
+		return err
+	case <-timerGate.FireChan():
+		p.logger.Error("Timed out shutting down queue",
+			tag.Error(errQueueShutdownTimeout),
+			tag.Value(p.getProcessingQueueStates().GetStateActionResult.States),
+		)
+		return errQueueShutdownTimeout
+	}
 }
 
 func (p *processorBase) initializeSplitPolicy(
@@ -225,70 +1108,174 @@ func (p *processorBase) initializeSplitPolicy(
 		return nil
 	}
 
-	// note the order of policies matters, check the comment for aggregated split policy
-	var policies []ProcessingQueueSplitPolicy
-	maxNewQueueLevel := p.options.SplitMaxLevel()
+	lookAheadFunc = p.newTaskLevelLookAheadFunc(lookAheadFunc)
 
-	pendingTaskThresholds, err := common.ConvertDynamicConfigMapPropertyToIntMap(p.options.PendingTaskSplitThreshold())
-	if err != nil {
-		p.logger.Error("Failed to convert pending task threshold", tag.Error(err))
-	} else {
-		policies = append(policies, NewPendingTaskSplitPolicy(
-			pendingTaskThresholds,
+	// note the order of policies matters, check the comment for aggregated split policy
+	// all policy parameters are read from dynamic config when the policy is evaluated,
+	// so changes will take effect on the next split without restarting the processor
+	policies := []ProcessingQueueSplitPolicy{
+		NewPendingTaskSplitPolicy(
+			p.options.PendingTaskSplitThreshold,
 			p.options.EnablePendingTaskSplitByDomainID,
 			lookAheadFunc,
-			maxNewQueueLevel,
+			p.options.SplitMaxLevel,
 			p.logger,
 			p.metricsScope,
-		))
-	}
-
-	taskAttemptThresholds, err := common.ConvertDynamicConfigMapPropertyToIntMap(p.options.StuckTaskSplitThreshold())
-	if err != nil {
-		p.logger.Error("Failed to convert stuck task threshold", tag.Error(err))
-	} else {
-		policies = append(policies, NewStuckTaskSplitPolicy(
-			taskAttemptThresholds,
+		),
+		NewStuckTaskSplitPolicy(
+			p.options.StuckTaskSplitThreshold,
 			p.options.EnableStuckTaskSplitByDomainID,
-			maxNewQueueLevel,
+			p.options.SplitMaxLevel,
 			p.logger,
 			p.metricsScope,
-		))
-	}
-
-	randomSplitProbability := p.options.RandomSplitProbability()
-	if randomSplitProbability != float64(0) {
-		policies = append(policies, NewRandomSplitPolicy(
-			randomSplitProbability,
+		),
+		NewRandomSplitPolicy(
+			p.options.RandomSplitProbability,
 			p.options.EnableRandomSplitByDomainID,
-			maxNewQueueLevel,
+			p.options.SplitMaxLevel,
 			lookAheadFunc,
 			p.logger,
 			p.metricsScope,
-		))
-	}
-
-	if len(policies) == 0 {
-		return nil
+		),
 	}
 
 	return NewAggregatedSplitPolicy(policies...)
 }
 
-func (p *processorBase) splitProcessingQueueCollection(
-	splitPolicy ProcessingQueueSplitPolicy,
-	upsertPollTimeFn func(int, time.Time),
-) {
-	defer p.emitProcessingQueueMetrics()
-
-	if splitPolicy == nil {
-		return
+// newTaskLevelLookAheadFunc applies NewTaskLevelStrategy to the look ahead function of split policies,
+// which decides the range of tasks moved to the new level when a domain is split:
+// - lowestMatch: only tasks already read are moved, new tasks still enter the lowest level matching
+// the domain until the next split
+// - highestMatch: the whole remaining range of the queue is moved, so new tasks of the domain enter
+// the new level immediately
+// - policy: tasks are moved up to the key returned by the given look ahead function
+// The strategy is read each time the returned function is invoked
+func (p *processorBase) newTaskLevelLookAheadFunc(
+	policyLookAheadFunc lookAheadFunc,
+) lookAheadFunc {
+	if p.options.NewTaskLevelStrategy == nil {
+		return policyLookAheadFunc
 	}
 
-	newQueuesMap := make(map[int][][]ProcessingQueue)
+	return func(key task.Key, domainID string) task.Key {
+		switch strategy := p.options.NewTaskLevelStrategy(); strategy {
+		case newTaskLevelStrategyLowestMatch:
+			return key
+		case newTaskLevelStrategyHighestMatch:
+			if maximumKey := p.getMaximumTaskKey(); maximumKey != nil {
+				return maximumKey
+			}
+		case newTaskLevelStrategyPolicy:
+		default:
+			p.logger.Warn("Unknown new task level strategy, falling back to policy", tag.Value(strategy))
+		}
+
+		if policyLookAheadFunc == nil {
+			return key
+		}
+		return policyLookAheadFunc(key, domainID)
+	}
+}
+
+// getMaximumTaskKey returns the maximum task key of the processor,
+// nil is returned if the processor type is unknown
+func (p *processorBase) getMaximumTaskKey() task.Key {
+	switch p.options.MetricScope {
+	case metrics.TransferActiveQueueProcessorScope, metrics.TransferStandbyQueueProcessorScope:
+		return maximumTransferTaskKey
+	case metrics.TimerActiveQueueProcessorScope, metrics.TimerStandbyQueueProcessorScope:
+		return maximumTimerTaskKey
+	default:
+		return nil
+	}
+}
+
+// splitProcessingQueueCollection splits queues in all collections with the policy.
+// Once ctx is cancelled, the remaining queues are not split, queues already split
+// are still moved to their new levels so that the collections stay consistent.
+// upsertPollTimeFn, if not nil, is invoked with the next poll time of each level,
+// which is also included in the returned SplitResult
+func (p *processorBase) splitProcessingQueueCollection(
+	ctx context.Context,
+	splitPolicy ProcessingQueueSplitPolicy,
+	upsertPollTimeFn func(int, time.Time),
+) *SplitResult {
+	defer p.emitProcessingQueueMetrics()
+
+	result := &SplitResult{
+		NextPollTimes: make(map[int]time.Time),
+	}
+	if splitPolicy == nil {
+		return result
+	}
+
+	defer p.lockQueueCollections(lockOperationSplit)()
+
+	splitPolicy, timedPolicies := newTimedSplitPolicy(splitPolicy)
+	approvedPolicy := newApprovedSplitPolicy(splitPolicy, p.getSplitApproverLocked())
+	p.splitQueueCollectionsLocked(ctx, newContextSplitPolicy(ctx, approvedPolicy), timedPolicies, result)
+	if approvedPolicy.numVetoed != 0 {
+		// timed policies count vetoed splits as performed
+		result.SplitsPerformed -= approvedPolicy.numVetoed
+		result.SplitsVetoed = approvedPolicy.numVetoed
+		p.metricsScope.AddCounter(metrics.ProcessingQueueSplitVetoedCounter, int64(approvedPolicy.numVetoed))
+	}
+	p.enforcePinnedDomainLevelsLocked(result)
+
+	if err := p.compactProcessingQueueCollections(); err != nil {
+		p.logger.Error("Processing queue compaction produced invalid queues", tag.Error(err))
+		p.metricsScope.IncCounter(metrics.ProcessingQueueOverlapCounter)
+	}
+
+	// there can be new queue collections created or new queues added to an existing collection
+	// poll immediately if there're pending tasks for the collection, otherwise apply the min poll
+	// interval to avoid busy looping a collection that has caught up
+	maxReadLevel := p.getMaxReadLevel()
+	minPollTime := p.shard.GetTimeSource().Now().Add(p.options.MinPollInterval())
+	for _, queueCollections := range p.processingQueueCollections {
+		activeQueue := queueCollections.ActiveQueue()
+		pollTime := time.Time{}
+		if activeQueue == nil || queueCaughtUp(activeQueue.State(), maxReadLevel) {
+			pollTime = minPollTime
+		}
+		result.NextPollTimes[queueCollections.Level()] = pollTime
+		if upsertPollTimeFn != nil {
+			upsertPollTimeFn(queueCollections.Level(), pollTime)
+		}
+	}
+	return result
+}
+
+// splitQueueCollectionsLocked splits queues in all collections with the policy and moves the
+// resulting queues to their new levels, creating collections for new levels if necessary.
+// timedPolicies are the timed policies wrapped in splitPolicy, if any.
+// Caller must hold the write lock of queueCollectionsLock
+func (p *processorBase) splitQueueCollectionsLocked(
+	ctx context.Context,
+	splitPolicy ProcessingQueueSplitPolicy,
+	timedPolicies []*timedSplitPolicy,
+	result *SplitResult,
+) {
+	newQueuesMap := make(map[int][][]ProcessingQueue)
 	for _, queueCollection := range p.processingQueueCollections {
+		if ctx.Err() != nil {
+			p.logger.Info("Processing queue split cancelled", tag.Error(ctx.Err()))
+			break
+		}
 		currentNewQueuesMap := make(map[int][]ProcessingQueue)
+		domainFilterBeforeSplit := mergeDomainFilters(queueCollection.Queues())
 		newQueues := queueCollection.Split(splitPolicy)
+		result.SplitsPerformed += countSplits(timedPolicies)
+		result.StatesCreated += len(newQueues)
+		p.emitSplitPolicyEvaluationMetrics(timedPolicies)
+		if err := checkSplitDomainCoverage(
+			queueCollection.Level(),
+			domainFilterBeforeSplit,
+			mergeDomainFilters(queueCollection.Queues()).Merge(mergeDomainFilters(newQueues)),
+		); err != nil {
+			p.logger.Error("Processing queue split lost domains", tag.Error(err), tag.QueueLevel(queueCollection.Level()))
+			p.metricsScope.IncCounter(metrics.ProcessingQueueSplitDomainCoverageLostCounter)
+		}
 		for _, newQueue := range newQueues {
 			newQueueLevel := newQueue.State().Level()
 			currentNewQueuesMap[newQueueLevel] = append(currentNewQueuesMap[newQueueLevel], newQueue)
@@ -318,11 +1305,237 @@ func (p *processorBase) splitProcessingQueueCollection(
 		}
 		p.processingQueueCollections = append(p.processingQueueCollections, newQueueCollection)
 		delete(newQueuesMap, level)
+		result.LevelsCreated++
+		if p.options.OnLevelCreated != nil {
+			p.options.OnLevelCreated(level, getFilteredDomainIDs(mergeDomainFilters(newQueueCollection.Queues())))
+		}
+	}
+	sortProcessingQueueCollections(p.processingQueueCollections)
+}
+
+// getSplitApproverLocked returns the SplitApprover for a split pass, it vetoes splits of domains
+// in split cooldown before consulting the approver specified in options.
+// Caller must hold the write lock of queueCollectionsLock
+func (p *processorBase) getSplitApproverLocked() SplitApprover {
+	var approver SplitApprover = defaultSplitApprover{}
+	if p.options.SplitApprover != nil {
+		approver = p.options.SplitApprover
 	}
 
-	// there can be new queue collections created or new queues added to an existing collection
-	for _, queueCollections := range p.processingQueueCollections {
-		upsertPollTimeFn(queueCollections.Level(), time.Time{})
+	cooldown := p.getSplitCooldown()
+	if cooldown <= 0 {
+		return approver
+	}
+
+	now := p.shard.GetTimeSource().Now()
+	for domainID, splitTime := range p.domainSplitTimes {
+		if now.Sub(splitTime) >= cooldown {
+			delete(p.domainSplitTimes, domainID)
+		}
+	}
+	return newCooldownSplitApprover(approver, p.domainSplitTimes, now, cooldown, p.metricsScope)
+}
+
+func (p *processorBase) getSplitCooldown() time.Duration {
+	if p.options.SplitCooldown == nil {
+		return 0
+	}
+	return p.options.SplitCooldown()
+}
+
+// enforcePinnedDomainLevelsLocked moves pinned domains back to their pinned levels,
+// so that they stay isolated regardless of split policies and collapsed levels.
+// Caller must hold the write lock of queueCollectionsLock
+func (p *processorBase) enforcePinnedDomainLevelsLocked(
+	result *SplitResult,
+) {
+	pinnedDomainLevels := p.getPinnedDomainLevels()
+	if len(pinnedDomainLevels) == 0 {
+		return
+	}
+
+	// each pass moves the pinned domains of a queue to one of their levels,
+	// so at most one pass is needed for each distinct pinned level
+	pinnedLevels := make(map[int]struct{})
+	for _, level := range pinnedDomainLevels {
+		pinnedLevels[level] = struct{}{}
+	}
+	policy := newPinnedDomainSplitPolicy(pinnedDomainLevels, p.logger, p.metricsScope)
+	for pass := 0; pass != len(pinnedLevels); pass++ {
+		statesCreated := result.StatesCreated
+		p.splitQueueCollectionsLocked(context.Background(), policy, nil, result)
+		if result.StatesCreated == statesCreated {
+			return
+		}
+	}
+}
+
+// getPinnedDomainLevels returns the level each pinned domain should stay at,
+// domains pinned to a negative level are ignored
+func (p *processorBase) getPinnedDomainLevels() map[string]int {
+	if p.options.PinnedDomainLevels == nil {
+		return nil
+	}
+
+	pinnedDomainLevels, err := common.ConvertDynamicConfigMapPropertyToStringIntMap(p.options.PinnedDomainLevels())
+	if err != nil {
+		p.logger.Error("Failed to convert pinned domain levels", tag.Error(err))
+		return nil
+	}
+
+	for domainID, level := range pinnedDomainLevels {
+		if level < defaultProcessingQueueLevel {
+			delete(pinnedDomainLevels, domainID)
+		}
+	}
+	return pinnedDomainLevels
+}
+
+// newShutdownContext returns a context which is cancelled when the processor is shutting down,
+// the returned cancel func must be called to release resources once the context is no longer used
+func (p *processorBase) newShutdownContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-p.shutdownCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// compactProcessingQueueCollections merges adjacent queues with the same filters
+// within each queue collection to reduce the number of queues. A collection is left
+// as is and a ProcessingQueueOverlapError is returned if the compacted queues overlap.
+// caller must hold the write lock of queueCollectionsLock
+func (p *processorBase) compactProcessingQueueCollections() error {
+	var validationErr error
+	for idx, queueCollection := range p.processingQueueCollections {
+		queues := queueCollection.Queues()
+		compactedQueues := compactProcessingQueues(queues)
+		if err := validateProcessingQueues(queueCollection.Level(), compactedQueues); err != nil {
+			validationErr = err
+			continue
+		}
+		if len(compactedQueues) == len(queues) {
+			continue
+		}
+
+		p.processingQueueCollections[idx] = NewProcessingQueueCollection(
+			queueCollection.Level(),
+			compactedQueues,
+		)
+	}
+	return validationErr
+}
+
+// compactorPump periodically notifies the processor pump to prune and compact
+// processing queue collections when background compaction is enabled.
+// The collections are not modified here as only the processor pump
+// goroutine is allowed to do so
+func (p *processorBase) compactorPump() {
+	defer p.shutdownWG.Done()
+
+	compactTimer := time.NewTimer(p.options.BackgroundCompactionInterval())
+	defer compactTimer.Stop()
+
+	for {
+		select {
+		case <-p.shutdownCh:
+			return
+		case <-compactTimer.C:
+			if p.options.EnableBackgroundCompaction() {
+				select {
+				case p.compactNotifyCh <- struct{}{}:
+				default:
+				}
+			}
+			compactTimer.Reset(p.options.BackgroundCompactionInterval())
+		}
+	}
+}
+
+// startTaskEventPump starts delivering task events to TaskEventSink if there's one
+func (p *processorBase) startTaskEventPump() {
+	if p.taskEventCh == nil {
+		return
+	}
+
+	p.shutdownWG.Add(1)
+	go p.taskEventPump()
+}
+
+func (p *processorBase) taskEventPump() {
+	defer p.shutdownWG.Done()
+
+	for {
+		select {
+		case <-p.shutdownCh:
+			return
+		case event := <-p.taskEventCh:
+			p.options.TaskEventSink.OnTaskSubmitted(event)
+		}
+	}
+}
+
+// emitTaskEvent queues an event for the submitted task if there's a TaskEventSink,
+// the event is dropped if the buffer is full so that task submission is never blocked
+func (p *processorBase) emitTaskEvent(
+	submittedTask task.Task,
+	redispatched bool,
+) {
+	if p.taskEventCh == nil {
+		return
+	}
+
+	event := TaskEvent{
+		DomainID:     submittedTask.GetDomainID(),
+		WorkflowID:   submittedTask.GetWorkflowID(),
+		RunID:        submittedTask.GetRunID(),
+		TaskType:     submittedTask.GetTaskType(),
+		Key:          newTaskKeyFromTask(submittedTask),
+		Redispatched: redispatched,
+	}
+	select {
+	case p.taskEventCh <- event:
+	default:
+		p.metricsScope.IncCounter(metrics.ProcessingQueueTaskEventDroppedCounter)
+	}
+}
+
+// pruneProcessingQueueCollections removes exhausted processing queues and
+// empty non-default queue collections, then compacts the remaining queues
+func (p *processorBase) pruneProcessingQueueCollections() {
+	defer p.lockQueueCollections(lockOperationPrune)()
+
+	remainingCollections := make([]ProcessingQueueCollection, 0, len(p.processingQueueCollections))
+	for _, queueCollection := range p.processingQueueCollections {
+		queues := queueCollection.Queues()
+		remainingQueues := make([]ProcessingQueue, 0, len(queues))
+		for _, queue := range queues {
+			if !taskKeyEquals(queue.State().AckLevel(), queue.State().MaxLevel()) {
+				remainingQueues = append(remainingQueues, queue)
+			}
+		}
+
+		if len(remainingQueues) == 0 && queueCollection.Level() != defaultProcessingQueueLevel {
+			continue
+		}
+
+		if len(remainingQueues) != len(queues) {
+			queueCollection = NewProcessingQueueCollection(
+				queueCollection.Level(),
+				remainingQueues,
+			)
+		}
+		remainingCollections = append(remainingCollections, queueCollection)
+	}
+	p.processingQueueCollections = remainingCollections
+
+	if err := p.compactProcessingQueueCollections(); err != nil {
+		p.logger.Error("Processing queue compaction produced invalid queues", tag.Error(err))
+		p.metricsScope.IncCounter(metrics.ProcessingQueueOverlapCounter)
 	}
 }
 
@@ -365,6 +1578,27 @@ func (p *processorBase) handleActionNotification(
 		result, err = p.resetProcessingQueueStates()
 	case ActionTypeGetState:
 		result = p.getProcessingQueueStates()
+	case ActionTypeCollapseLevel:
+		if err = p.CollapseLevel(notification.action.CollapseLevelAttributes.Level); err == nil {
+			result = &ActionResult{
+				ActionType:          ActionTypeCollapseLevel,
+				CollapseLevelResult: &CollapseLevelActionResult{},
+			}
+		}
+	case ActionTypeRecomputeAckLevel:
+		var ackLevel task.Key
+		if ackLevel, err = p.RecomputeAckLevel(); err == nil {
+			result = &ActionResult{
+				ActionType: ActionTypeRecomputeAckLevel,
+				RecomputeAckLevelResult: &RecomputeAckLevelActionResult{
+					AckLevel: ackLevel,
+				},
+			}
+		}
+	case ActionTypeReconcile:
+		result, err = p.reconcileProcessingQueueStates()
+	case ActionTypeSplit:
+		result, err = p.triggerSplit(notification.action.SplitAttributes.Policy)
 	default:
 		err = fmt.Errorf("unknown queue action type: %v", notification.action.ActionType)
 	}
@@ -383,6 +1617,8 @@ func (p *processorBase) handleActionNotification(
 }
 
 func (p *processorBase) resetProcessingQueueStates() (*ActionResult, error) {
+	defer p.lockQueueCollections(lockOperationReset)()
+
 	var minAckLevel task.Key
 	for _, queueCollection := range p.processingQueueCollections {
 		ackLevel, _ := queueCollection.UpdateAckLevels()
@@ -405,14 +1641,7 @@ func (p *processorBase) resetProcessingQueueStates() (*ActionResult, error) {
 		p.logger.Fatal("unable to find minAckLevel during reset", tag.Value(p.processingQueueCollections))
 	}
 
-	var maxReadLevel task.Key
-	switch p.options.MetricScope {
-	case metrics.TransferActiveQueueProcessorScope, metrics.TransferStandbyQueueProcessorScope:
-		maxReadLevel = maximumTransferTaskKey
-	case metrics.TimerActiveQueueProcessorScope, metrics.TimerStandbyQueueProcessorScope:
-		maxReadLevel = maximumTimerTaskKey
-	}
-
+	maxReadLevel := p.getMaximumTaskKey()
 	p.processingQueueCollections = newProcessingQueueCollections(
 		[]ProcessingQueueState{
 			NewProcessingQueueState(
@@ -432,84 +1661,2099 @@ func (p *processorBase) resetProcessingQueueStates() (*ActionResult, error) {
 	}, nil
 }
 
-func (p *processorBase) getProcessingQueueStates() *ActionResult {
-	var queueStates []ProcessingQueueState
+// RecomputeAckLevel derives the ack level from the current states of all processing queues,
+// ignoring the ack level recorded by previous ack level updates, and persists it.
+// It's used to recover from ack level bookkeeping drifting away from processing queue states.
+// This method must be invoked from the processor pump goroutine, other
+// goroutines should use the action created by NewRecomputeAckLevelAction.
+func (p *processorBase) RecomputeAckLevel() (task.Key, error) {
+	var minAckLevel task.Key
 	for _, queueCollection := range p.processingQueueCollections {
 		for _, queue := range queueCollection.Queues() {
-			queueStates = append(queueStates, copyQueueState(queue.State()))
+			ackLevel := queue.State().AckLevel()
+			if minAckLevel == nil {
+				minAckLevel = ackLevel
+			} else {
+				minAckLevel = minTaskKey(minAckLevel, ackLevel)
+			}
 		}
 	}
 
-	return &ActionResult{
-		ActionType: ActionTypeGetState,
-		GetStateActionResult: &GetStateActionResult{
-			States: queueStates,
-		},
+	if minAckLevel == nil {
+		return nil, errors.New("unable to recompute ack level: no processing queue found")
+	}
+
+	if err := p.persistAckLevel(minAckLevel); err != nil {
+		return nil, err
 	}
+
+	p.logger.Info("Recomputed ack level from processing queue states", tag.Value(minAckLevel))
+	p.lastAckLevel = minAckLevel
+	p.persistedAckLevel = minAckLevel
+	p.numStuckAckLevelUpdates = 0
+	return minAckLevel, nil
 }
 
-func (p *processorBase) submitTask(
-	task task.Task,
-) (bool, error) {
-	submitted, err := p.taskProcessor.TrySubmit(task)
-	if err != nil {
-		select {
-		case <-p.shutdownCh:
-			// if error is due to shard shutdown
-			return false, err
-		default:
-			// otherwise it might be error from domain cache etc, add
-			// the task to redispatch queue so that it can be retried
-			p.logger.Error("Failed to submit task", tag.Error(err))
+// Reconcile replaces the in-memory processing queue states with the ones stored in persistence,
+// e.g. after persistence was modified manually. Discrepancies between in-memory ack levels and
+// persisted ack levels, and read levels beyond the persisted max read level are logged.
+// Read levels are reset to the persisted ack levels so that all unacked tasks are read again.
+// The processor pump must be running for the states to be replaced
+func (p *processorBase) Reconcile(
+	ctx context.Context,
+) error {
+	if p.options.LoadProcessingQueueStates == nil {
+		return errReconcileNotSupported
+	}
+
+	resultNotificationCh, added := p.addAction(NewReconcileAction())
+	if !added {
+		return errProcessorShutdown
+	}
+
+	select {
+	case resultNotification := <-resultNotificationCh:
+		return resultNotification.err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.shutdownCh:
+		return errProcessorShutdown
+	}
+}
+
+// TriggerSplit synchronously splits processing queues with the given policy, so that tests can
+// split queues at a precise moment instead of waiting for the split timer. It's not for production
+// use and returns an error unless EnableTestHooks is set. If the processor is started, the split is
+// performed by the processor pump and all levels are polled afterwards, otherwise it's performed by
+// the calling goroutine
+func (p *processorBase) TriggerSplit(
+	policy ProcessingQueueSplitPolicy,
+) (*SplitResult, error) {
+	if !p.options.EnableTestHooks {
+		return nil, errTestHooksDisabled
+	}
+
+	if atomic.LoadInt32(&p.status) != common.DaemonStatusStarted {
+		result, err := p.triggerSplit(policy)
+		if err != nil {
+			return nil, err
 		}
+		return result.SplitResult.Result, nil
 	}
-	if err != nil || !submitted {
-		p.redispatcher.AddTask(task)
-		return false, nil
+
+	resultNotificationCh, added := p.addAction(NewSplitAction(policy))
+	if !added {
+		return nil, errProcessorShutdown
 	}
 
-	return true, nil
+	select {
+	case resultNotification := <-resultNotificationCh:
+		if resultNotification.err != nil {
+			return nil, resultNotification.err
+		}
+		return resultNotification.result.SplitResult.Result, nil
+	case <-p.shutdownCh:
+		return nil, errProcessorShutdown
+	}
 }
 
-func newProcessingQueueCollections(
-	processingQueueStates []ProcessingQueueState,
-	logger log.Logger,
-	metricsClient metrics.Client,
-) []ProcessingQueueCollection {
-	processingQueuesMap := make(map[int][]ProcessingQueue) // level -> state
-	for _, queueState := range processingQueueStates {
-		processingQueuesMap[queueState.Level()] = append(processingQueuesMap[queueState.Level()], NewProcessingQueue(
-			queueState,
-			logger,
-			metricsClient,
-		))
+func (p *processorBase) triggerSplit(
+	policy ProcessingQueueSplitPolicy,
+) (*ActionResult, error) {
+	if !p.options.EnableTestHooks {
+		return nil, errTestHooksDisabled
 	}
-	processingQueueCollections := make([]ProcessingQueueCollection, 0, len(processingQueuesMap))
-	for level, queues := range processingQueuesMap {
-		processingQueueCollections = append(processingQueueCollections, NewProcessingQueueCollection(
-			level,
-			queues,
-		))
+
+	ctx, cancel := p.newShutdownContext()
+	defer cancel()
+
+	return &ActionResult{
+		ActionType: ActionTypeSplit,
+		SplitResult: &SplitActionResult{
+			Result: p.splitProcessingQueueCollection(ctx, policy, nil),
+		},
+	}, nil
+}
+
+func (p *processorBase) reconcileProcessingQueueStates() (*ActionResult, error) {
+	if p.options.LoadProcessingQueueStates == nil {
+		return nil, errReconcileNotSupported
 	}
 
-	return processingQueueCollections
+	persistedStates := p.options.LoadProcessingQueueStates()
+	queueStates := make([]ProcessingQueueState, 0, len(persistedStates))
+	persistedAckLevels := make(map[int]task.Key)
+	for _, state := range persistedStates {
+		queueStates = append(queueStates, newProcessingQueueStateWithTaskTypeFilter(
+			state.Level(),
+			state.AckLevel(),
+			state.AckLevel(),
+			state.MaxLevel(),
+			state.DomainFilter().copy(),
+			state.TaskTypeFilter().copy(),
+		).withPriority(state.Priority()))
+		if ackLevel, ok := persistedAckLevels[state.Level()]; !ok || state.AckLevel().Less(ackLevel) {
+			persistedAckLevels[state.Level()] = state.AckLevel()
+		}
+	}
+	if len(queueStates) == 0 {
+		return nil, errors.New("unable to reconcile: no processing queue state found in persistence")
+	}
+	if err := verifyProcessingQueueStates(queueStates); err != nil {
+		return nil, err
+	}
+
+	defer p.lockQueueCollections(lockOperationReconcile)()
+
+	numDiscrepancies := 0
+	persistedMaxReadLevel := p.getMaxReadLevel()
+	ackLevels := make(map[int]task.Key)
+	for _, queueCollection := range p.processingQueueCollections {
+		for _, queue := range queueCollection.Queues() {
+			state := queue.State()
+			if ackLevel, ok := ackLevels[state.Level()]; !ok || state.AckLevel().Less(ackLevel) {
+				ackLevels[state.Level()] = state.AckLevel()
+			}
+			if persistedMaxReadLevel != nil && persistedMaxReadLevel.Less(state.ReadLevel()) {
+				numDiscrepancies++
+				p.logger.Warn("Processing queue read level is beyond persisted max read level",
+					tag.QueueLevel(state.Level()),
+					tag.Value(state.ReadLevel()),
+					tag.Key(fmt.Sprintf("%v", persistedMaxReadLevel)),
+				)
+			}
+		}
+	}
+	for level, ackLevel := range ackLevels {
+		if persistedAckLevel, ok := persistedAckLevels[level]; !ok || !taskKeyEquals(ackLevel, persistedAckLevel) {
+			numDiscrepancies++
+			p.logger.Warn("Processing queue ack level differs from persistence",
+				tag.QueueLevel(level),
+				tag.Value(ackLevel),
+				tag.Key(fmt.Sprintf("%v", persistedAckLevel)),
+			)
+		}
+	}
+	for level, persistedAckLevel := range persistedAckLevels {
+		if _, ok := ackLevels[level]; !ok {
+			numDiscrepancies++
+			p.logger.Warn("Processing queue level only exists in persistence",
+				tag.QueueLevel(level),
+				tag.Key(fmt.Sprintf("%v", persistedAckLevel)),
+			)
+		}
+	}
+
+	p.processingQueueCollections = newProcessingQueueCollections(queueStates, p.logger, p.metricsClient)
+	var minAckLevel task.Key
+	for _, ackLevel := range persistedAckLevels {
+		if minAckLevel == nil {
+			minAckLevel = ackLevel
+		} else {
+			minAckLevel = minTaskKey(minAckLevel, ackLevel)
+		}
+	}
+	p.lastAckLevel = minAckLevel
+	p.persistedAckLevel = minAckLevel
+	p.numStuckAckLevelUpdates = 0
+
+	p.logger.Info("Reconciled processing queue states with persistence", tag.Counter(numDiscrepancies))
+	return &ActionResult{
+		ActionType: ActionTypeReconcile,
+		ReconcileResult: &ReconcileActionResult{
+			NumDiscrepancies: numDiscrepancies,
+		},
+	}, nil
 }
 
-func getPendingTasksMetricIdx(
-	scopeIdx int,
-) int {
-	switch scopeIdx {
-	case metrics.TimerActiveQueueProcessorScope:
-		return metrics.ShardInfoTimerActivePendingTasksTimer
-	case metrics.TimerStandbyQueueProcessorScope:
-		return metrics.ShardInfoTimerStandbyPendingTasksTimer
-	case metrics.TransferActiveQueueProcessorScope:
-		return metrics.ShardInfoTransferActivePendingTasksTimer
-	case metrics.TransferStandbyQueueProcessorScope:
-		return metrics.ShardInfoTransferStandbyPendingTasksTimer
-	case metrics.ReplicatorQueueProcessorScope:
+// CollapseLevel moves all processing queues in the specified level to the
+// next lower level and merges them with the queues in that level.
+// The collection for the specified level will be removed.
+// This method must be invoked from the processor pump goroutine, other
+// goroutines should use the action created by NewCollapseLevelAction.
+func (p *processorBase) CollapseLevel(
+	level int,
+) error {
+	if level <= defaultProcessingQueueLevel {
+		return &CollapseLevelError{
+			Level:   level,
+			Message: "default processing queue level can't be collapsed",
+		}
+	}
+
+	defer p.emitProcessingQueueMetrics()
+	defer p.lockQueueCollections(lockOperationCollapseLevel)()
+
+	sourceIdx, targetIdx := -1, -1
+	for idx, queueCollection := range p.processingQueueCollections {
+		currentLevel := queueCollection.Level()
+		if currentLevel == level {
+			sourceIdx = idx
+		} else if currentLevel < level &&
+			(targetIdx == -1 || currentLevel > p.processingQueueCollections[targetIdx].Level()) {
+			targetIdx = idx
+		}
+	}
+
+	if sourceIdx == -1 {
+		return &CollapseLevelError{
+			Level:   level,
+			Message: "processing queue level not found",
+		}
+	}
+
+	if targetIdx == -1 {
+		p.processingQueueCollections = append(p.processingQueueCollections, NewProcessingQueueCollection(
+			defaultProcessingQueueLevel,
+			[]ProcessingQueue{},
+		))
+		targetIdx = len(p.processingQueueCollections) - 1
+	}
+
+	sourceCollection := p.processingQueueCollections[sourceIdx]
+	targetCollection := p.processingQueueCollections[targetIdx]
+
+	queues := make([]ProcessingQueue, 0, len(sourceCollection.Queues()))
+	for _, queue := range sourceCollection.Queues() {
+		queueImpl := queue.(*processingQueueImpl)
+		queues = append(queues, newProcessingQueue(
+			newProcessingQueueStateWithTaskTypeFilter(
+				targetCollection.Level(),
+				queueImpl.state.ackLevel,
+				queueImpl.state.readLevel,
+				queueImpl.state.maxLevel,
+				queueImpl.state.domainFilter.copy(),
+				queueImpl.state.taskTypeFilter.copy(),
+			).withPriority(queueImpl.state.Priority()),
+			queueImpl.outstandingTasks,
+			p.logger,
+			p.metricsClient,
+		))
+	}
+	targetCollection.Merge(queues)
+	if p.getSplitCooldown() > 0 {
+		now := p.shard.GetTimeSource().Now()
+		for _, domainID := range getFilteredDomainIDs(mergeDomainFilters(sourceCollection.Queues())) {
+			p.domainSplitTimes[domainID] = now
+		}
+	}
+
+	p.processingQueueCollections = append(
+		p.processingQueueCollections[:sourceIdx],
+		p.processingQueueCollections[sourceIdx+1:]...,
+	)
+	sortProcessingQueueCollections(p.processingQueueCollections)
+	p.enforcePinnedDomainLevelsLocked(&SplitResult{})
+
+	p.logger.Info("Collapsed processing queue level",
+		tag.PreviousQueueLevel(level),
+		tag.QueueLevel(targetCollection.Level()),
+	)
+	return nil
+}
+
+func (e *TaskKeyTypeMismatchError) Error() string {
+	return fmt.Sprintf("unexpected task key type, expected: %v, actual: %v, key: %v", e.ExpectedType, e.ActualType, e.Key)
+}
+
+func (e *CollapseLevelError) Error() string {
+	return fmt.Sprintf("failed to collapse processing queue level %v: %v", e.Level, e.Message)
+}
+
+func (e *SplitDomainCoverageError) Error() string {
+	return fmt.Sprintf("split of processing queue level %v shrank domain coverage, before: %v, after: %v", e.Level, e.Before, e.After)
+}
+
+func (e *ProcessingQueueOverlapError) Error() string {
+	return fmt.Sprintf("processing queues at level %v overlap or are out of order, previous: %v, next: %v", e.Level, e.Previous, e.Next)
+}
+
+func (p *processorBase) getProcessingQueueStates() *ActionResult {
+	defer p.rLockQueueCollections(lockOperationGetStates)()
+
+	var queueStates []ProcessingQueueState
+	ackLevels := make(map[int]task.Key)
+	for _, queueCollection := range p.processingQueueCollections {
+		for _, queue := range queueCollection.Queues() {
+			queueStates = append(queueStates, copyQueueState(queue.State()))
+		}
+		if ackLevel := getCollectionAckLevel(queueCollection); ackLevel != nil {
+			ackLevels[queueCollection.Level()] = ackLevel
+		}
+	}
+
+	return &ActionResult{
+		ActionType: ActionTypeGetState,
+		GetStateActionResult: &GetStateActionResult{
+			States:          queueStates,
+			PausedDomainIDs: p.getPausedDomainIDs(),
+			AckLevels:       ackLevels,
+		},
+	}
+}
+
+// getCollectionAckLevel returns the min ack level of the processing queues in
+// the collection, or nil if the collection doesn't contain any processing queue
+func getCollectionAckLevel(
+	queueCollection ProcessingQueueCollection,
+) task.Key {
+	var ackLevel task.Key
+	for _, queue := range queueCollection.Queues() {
+		if ackLevel == nil {
+			ackLevel = queue.State().AckLevel()
+		} else {
+			ackLevel = minTaskKey(ackLevel, queue.State().AckLevel())
+		}
+	}
+	return ackLevel
+}
+
+// DumpRedispatchQueue returns the identifiers and attempt counts of all
+// tasks in the redispatch queue, ordered by task priority, for debugging purpose.
+// Tasks are not removed from the redispatch queue
+func (p *processorBase) DumpRedispatchQueue() []RedispatchTaskInfo {
+	tasks := p.redispatcher.Snapshot()
+	taskInfos := make([]RedispatchTaskInfo, 0, len(tasks))
+	for _, task := range tasks {
+		taskInfos = append(taskInfos, RedispatchTaskInfo{
+			DomainID:   task.GetDomainID(),
+			WorkflowID: task.GetWorkflowID(),
+			RunID:      task.GetRunID(),
+			TaskID:     task.GetTaskID(),
+			TaskType:   task.GetTaskType(),
+			Attempt:    task.GetAttempt(),
+		})
+	}
+	return taskInfos
+}
+
+// RedispatchTaskKeys returns the keys of all tasks in the redispatch queue,
+// ordered by task priority, so that they can be reconciled against persistence.
+// Tasks are not removed from the redispatch queue
+func (p *processorBase) RedispatchTaskKeys() []task.Key {
+	tasks := p.redispatcher.Snapshot()
+	taskKeys := make([]task.Key, 0, len(tasks))
+	for _, queueTask := range tasks {
+		taskKeys = append(taskKeys, newTaskKeyFromTask(queueTask))
+	}
+	return taskKeys
+}
+
+// ExportState returns the processing queue states, tasks in the redispatch queue
+// and paused domains of the processor, so that a new shard owner can import them
+// with ImportState. The processing queue states can also be persisted with the
+// existing processing queue state converters and loaded by the new owner
+func (p *processorBase) ExportState() *ProcessorState {
+	unlock := p.rLockQueueCollections(lockOperationExportState)
+	var queueStates []ProcessingQueueState
+	for _, queueCollection := range p.processingQueueCollections {
+		for _, queue := range queueCollection.Queues() {
+			state := queue.State()
+			queueStates = append(queueStates, newProcessingQueueStateWithTaskTypeFilter(
+				state.Level(),
+				state.AckLevel(),
+				state.ReadLevel(),
+				state.MaxLevel(),
+				state.DomainFilter().copy(),
+				state.TaskTypeFilter().copy(),
+			).withPriority(state.Priority()))
+		}
+	}
+	unlock()
+
+	return &ProcessorState{
+		ProcessingQueueStates: queueStates,
+		RedispatchTasks:       p.DumpRedispatchQueue(),
+		PausedDomainIDs:       p.getPausedDomainIDs(),
+	}
+}
+
+// ImportState replaces the processing queue states and paused domains of the processor
+// with the exported state. Read levels are reset to ack levels so that all unacked tasks,
+// including those in the exported redispatch queue, are read again. It can only be called
+// before the processor is started
+func (p *processorBase) ImportState(
+	state *ProcessorState,
+) error {
+	if atomic.LoadInt32(&p.status) != common.DaemonStatusInitialized {
+		return errImportStateAfterStart
+	}
+	if state == nil || len(state.ProcessingQueueStates) == 0 {
+		return errImportStateInvalidStates
+	}
+
+	queueStates := make([]ProcessingQueueState, 0, len(state.ProcessingQueueStates))
+	for _, queueState := range state.ProcessingQueueStates {
+		queueStates = append(queueStates, newProcessingQueueStateWithTaskTypeFilter(
+			queueState.Level(),
+			queueState.AckLevel(),
+			queueState.AckLevel(),
+			queueState.MaxLevel(),
+			queueState.DomainFilter().copy(),
+			queueState.TaskTypeFilter().copy(),
+		).withPriority(queueState.Priority()))
+	}
+	if err := verifyProcessingQueueStates(queueStates); err != nil {
+		return err
+	}
+
+	unlock := p.lockQueueCollections(lockOperationImportState)
+	p.processingQueueCollections = newProcessingQueueCollections(queueStates, p.logger, p.metricsClient)
+	unlock()
+
+	// the imported ack level may be lower than the persisted one and becomes the new baseline
+	p.persistedAckLevel = nil
+	for _, queueState := range queueStates {
+		if p.persistedAckLevel == nil {
+			p.persistedAckLevel = queueState.AckLevel()
+		} else {
+			p.persistedAckLevel = minTaskKey(p.persistedAckLevel, queueState.AckLevel())
+		}
+	}
+
+	p.pausedDomainsLock.Lock()
+	p.pausedDomains = NewDomainFilter(covertToDomainIDSet(state.PausedDomainIDs), false)
+	p.pausedDomainsLock.Unlock()
+
+	p.logger.Info("Imported queue processor state")
+	return nil
+}
+
+// PauseDomain stops submitting tasks of the given domain to the task processor.
+// Tasks of a paused domain are kept in the redispatcher, so that they are still
+// pending and block the ack level, until the domain is resumed
+func (p *processorBase) PauseDomain(
+	domainID string,
+) {
+	p.pausedDomainsLock.Lock()
+	defer p.pausedDomainsLock.Unlock()
+
+	p.pausedDomains = p.pausedDomains.Include(map[string]struct{}{domainID: {}})
+	p.logger.Info("Paused domain", tag.WorkflowDomainID(domainID))
+}
+
+// ResumeDomain resumes submitting tasks of the given domain to the task processor.
+// Tasks that have been kept in the redispatcher will be submitted on the next redispatch
+func (p *processorBase) ResumeDomain(
+	domainID string,
+) {
+	p.pausedDomainsLock.Lock()
+	defer p.pausedDomainsLock.Unlock()
+
+	p.pausedDomains = p.pausedDomains.Exclude(map[string]struct{}{domainID: {}})
+	p.logger.Info("Resumed domain", tag.WorkflowDomainID(domainID))
+}
+
+// BoostDomain prioritizes tasks of the given domain until the deadline. While the domain is boosted,
+// its tasks in the redispatcher are submitted ahead of tasks of other domains, and newly read tasks
+// bypass outstanding task shedding and read backoffs of levels covering the domain. The boost is
+// reverted automatically after the deadline, a deadline that has passed removes the boost immediately
+func (p *processorBase) BoostDomain(
+	domainID string,
+	until time.Time,
+) {
+	p.boostLock.Lock()
+	defer p.boostLock.Unlock()
+
+	if !until.After(p.shard.GetTimeSource().Now()) {
+		delete(p.boostedDomains, domainID)
+		p.logger.Info("Removed domain boost", tag.WorkflowDomainID(domainID))
+		return
+	}
+	p.boostedDomains[domainID] = until
+	p.logger.Info("Boosted domain", tag.WorkflowDomainID(domainID), tag.Timestamp(until))
+}
+
+// getBoostedDomains returns the deadline of each domain currently boosted, expired boosts are removed
+func (p *processorBase) getBoostedDomains() map[string]time.Time {
+	p.boostLock.Lock()
+	defer p.boostLock.Unlock()
+
+	now := p.shard.GetTimeSource().Now()
+	boostedDomains := make(map[string]time.Time, len(p.boostedDomains))
+	for domainID, until := range p.boostedDomains {
+		if !until.After(now) {
+			delete(p.boostedDomains, domainID)
+			p.logger.Info("Domain boost expired", tag.WorkflowDomainID(domainID))
+			continue
+		}
+		boostedDomains[domainID] = until
+	}
+	return boostedDomains
+}
+
+// isDomainBoosted returns true if the domain is boosted and the boost hasn't expired. It's called
+// for every submitted task, so it only takes the read lock and leaves pruning to getBoostedDomains
+func (p *processorBase) isDomainBoosted(
+	domainID string,
+) bool {
+	p.boostLock.RLock()
+	until, ok := p.boostedDomains[domainID]
+	p.boostLock.RUnlock()
+
+	return ok && until.After(p.shard.GetTimeSource().Now())
+}
+
+// isLevelBoosted returns true if any processing queue at the given level covers a boosted domain.
+// Caller must hold queueCollectionsLock or be the processor pump goroutine
+func (p *processorBase) isLevelBoosted(
+	level int,
+) bool {
+	boostedDomains := p.getBoostedDomains()
+	if len(boostedDomains) == 0 {
+		return false
+	}
+
+	for _, queueCollection := range p.processingQueueCollections {
+		if queueCollection.Level() != level {
+			continue
+		}
+		domainFilter := queueCollection.DomainFilter()
+		for domainID := range boostedDomains {
+			if domainFilter.Filter(domainID) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SetReadOnly switches the processor between read-only and active mode. In read-only mode,
+// e.g. for a standby cluster, ack levels are still updated and queues are still split, but
+// no task is submitted to the task processor. Tasks are kept in the redispatcher, so that
+// they are still pending and block the ack level, and will be submitted on the next
+// redispatch after the processor becomes active
+func (p *processorBase) SetReadOnly(
+	readOnly bool,
+) {
+	var value int32
+	if readOnly {
+		value = 1
+	}
+	if atomic.SwapInt32(&p.readOnly, value) == value {
+		return
+	}
+
+	if readOnly {
+		p.logger.Info("Queue processor switched to read-only mode")
+	} else {
+		p.logger.Info("Queue processor switched to active mode")
+	}
+}
+
+func (p *processorBase) isReadOnly() bool {
+	return atomic.LoadInt32(&p.readOnly) == 1
+}
+
+// IsCaughtUp returns true if the ack levels of all processing queues have reached
+// the max read level and there's no task in the redispatcher. The state is updated
+// on ack level updates and only changes after caughtUpHysteresis consecutive updates
+// observe the new state, so that it doesn't flap when new tasks keep arriving
+func (p *processorBase) IsCaughtUp() bool {
+	return atomic.LoadInt32(&p.caughtUp) == 1
+}
+
+// updateCaughtUp updates the caught up state and invokes OnCaughtUp on the transition
+// into caught up. It's only called by the processor pump goroutine
+func (p *processorBase) updateCaughtUp() {
+	caughtUp := p.queuesCaughtUp() && p.redispatcher.Size() == 0
+	if caughtUp == p.IsCaughtUp() {
+		p.numCaughtUpStateChanges = 0
+		return
+	}
+
+	p.numCaughtUpStateChanges++
+	if p.numCaughtUpStateChanges < caughtUpHysteresis {
+		return
+	}
+	p.numCaughtUpStateChanges = 0
+
+	if !caughtUp {
+		atomic.StoreInt32(&p.caughtUp, 0)
+		p.logger.Info("Queue processor fell behind")
+		return
+	}
+
+	atomic.StoreInt32(&p.caughtUp, 1)
+	p.logger.Info("Queue processor caught up")
+	if p.options.OnCaughtUp != nil {
+		p.options.OnCaughtUp()
+	}
+}
+
+// queuesCaughtUp returns true if all processing queues have read and acked
+// all tasks up to the max read level. Caller must hold queueCollectionsLock
+// or be the processor pump goroutine
+func (p *processorBase) queuesCaughtUp() bool {
+	maxReadLevel := p.getMaxReadLevel()
+	for _, queueCollection := range p.processingQueueCollections {
+		for _, queue := range queueCollection.Queues() {
+			state := queue.State()
+			if !queueCaughtUp(state, maxReadLevel) || state.AckLevel().Less(state.ReadLevel()) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func (p *processorBase) isTaskPaused(
+	task task.Task,
+) bool {
+	if p.isReadOnly() {
+		return true
+	}
+
+	p.pausedDomainsLock.RLock()
+	defer p.pausedDomainsLock.RUnlock()
+
+	if len(p.pausedDomains.DomainIDs) == 0 {
+		return false
+	}
+	return p.pausedDomains.Filter(task.GetDomainID())
+}
+
+func (p *processorBase) getPausedDomainIDs() []string {
+	p.pausedDomainsLock.RLock()
+	defer p.pausedDomainsLock.RUnlock()
+
+	return p.pausedDomains.Domains()
+}
+
+// PendingTaskCountByDomain returns the number of tasks that have been loaded into
+// memory but not yet acked, grouped by domainID, across all processing queues.
+// The result is consistent with concurrent split and ack level update operations,
+// but it only reflects tasks that have already been read from persistence, and
+// acked tasks are only excluded once their state has been updated by the task processor.
+func (p *processorBase) PendingTaskCountByDomain() map[string]int {
+	defer p.rLockQueueCollections(lockOperationPendingTaskCount)()
+
+	return p.pendingTaskCountByDomainLocked()
+}
+
+// NextFireTime returns the earliest visibility timestamp among timer tasks that have
+// been loaded into memory but not yet acked, across all processing queues.
+// False is returned if there's no such task or the processor is not a timer processor.
+func (p *processorBase) NextFireTime() (time.Time, bool) {
+	defer p.rLockQueueCollections(lockOperationNextFireTime)()
+
+	var nextFireTime time.Time
+	found := false
+	for _, queueCollection := range p.processingQueueCollections {
+		for _, queue := range queueCollection.Queues() {
+			for key, task := range queue.(*processingQueueImpl).outstandingTasks {
+				timerKey, ok := key.(timerTaskKey)
+				if !ok {
+					return time.Time{}, false
+				}
+				if task.State() == t.TaskStateAcked {
+					continue
+				}
+				if !found || timerKey.visibilityTimestamp.Before(nextFireTime) {
+					nextFireTime = timerKey.visibilityTimestamp
+					found = true
+				}
+			}
+		}
+	}
+
+	return nextFireTime, found
+}
+
+// TasksInKeyRange returns tasks that have been loaded into memory but not yet acked and have
+// keys in [minKey, maxKey), across all processing queues, ordered by task key.
+// Nil is returned if the keys are not of the key type used by the processor.
+func (p *processorBase) TasksInKeyRange(
+	minKey task.Key,
+	maxKey task.Key,
+) []task.Task {
+	if expectedKey := p.getMaximumTaskKey(); expectedKey != nil &&
+		(reflect.TypeOf(minKey) != reflect.TypeOf(expectedKey) || reflect.TypeOf(maxKey) != reflect.TypeOf(expectedKey)) {
+		return nil
+	}
+
+	defer p.rLockQueueCollections(lockOperationTasksInKeyRange)()
+
+	var keys []task.Key
+	tasks := make(map[task.Key]task.Task)
+	for _, queueCollection := range p.processingQueueCollections {
+		for _, queue := range queueCollection.Queues() {
+			for key, task := range queue.(*processingQueueImpl).outstandingTasks {
+				if key.Less(minKey) || !key.Less(maxKey) || task.State() == t.TaskStateAcked {
+					continue
+				}
+				keys = append(keys, key)
+				tasks[key] = task
+			}
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Less(keys[j])
+	})
+	result := make([]task.Task, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, tasks[key])
+	}
+	return result
+}
+
+// pendingTaskCountByDomainLocked is the same as PendingTaskCountByDomain,
+// but caller must hold queueCollectionsLock or be the processor pump goroutine
+func (p *processorBase) pendingTaskCountByDomainLocked() map[string]int {
+	pendingTaskCount := make(map[string]int)
+	for _, queueCollection := range p.processingQueueCollections {
+		for _, queue := range queueCollection.Queues() {
+			for _, task := range queue.(*processingQueueImpl).outstandingTasks {
+				if task.State() != t.TaskStateAcked {
+					pendingTaskCount[task.GetDomainID()]++
+				}
+			}
+		}
+	}
+
+	return pendingTaskCount
+}
+
+// SetDomainDrainedCallback registers a callback which will be invoked when all
+// tasks of a domain on this shard have been acked and the queues that may contain
+// tasks of the domain have caught up with the max read level.
+// The callback is invoked from the processor goroutine after ack level update
+// and should not block.
+func (p *processorBase) SetDomainDrainedCallback(
+	callback domainDrainedFn,
+) {
+	p.ackStateLock.Lock()
+	defer p.ackStateLock.Unlock()
+
+	p.onDomainDrained = callback
+}
+
+// updateDrainedDomains returns domains that have pending tasks in previous calls,
+// but no longer have any pending tasks or unread task ranges.
+// caller must hold ackStateLock and be the processor pump goroutine or hold queueCollectionsLock
+func (p *processorBase) updateDrainedDomains() []string {
+	currentPendingDomains := make(map[string]struct{})
+	for _, queueCollection := range p.processingQueueCollections {
+		for _, queue := range queueCollection.Queues() {
+			for _, task := range queue.(*processingQueueImpl).outstandingTasks {
+				if task.State() != t.TaskStateAcked {
+					currentPendingDomains[task.GetDomainID()] = struct{}{}
+				}
+			}
+		}
+	}
+
+	var drainedDomains []string
+	for domainID := range p.pendingDomains {
+		if _, ok := currentPendingDomains[domainID]; ok {
+			continue
+		}
+
+		if p.hasUnreadTasks(domainID) {
+			continue
+		}
+
+		drainedDomains = append(drainedDomains, domainID)
+		delete(p.pendingDomains, domainID)
+	}
+
+	for domainID := range currentPendingDomains {
+		p.pendingDomains[domainID] = struct{}{}
+	}
+
+	return drainedDomains
+}
+
+func (p *processorBase) hasUnreadTasks(
+	domainID string,
+) bool {
+	maxReadLevel := p.getMaxReadLevel()
+	for _, queueCollection := range p.processingQueueCollections {
+		for _, queue := range queueCollection.Queues() {
+			state := queue.State()
+			if state.DomainFilter().Filter(domainID) && !queueCaughtUp(state, maxReadLevel) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// getMaxReadLevel returns nil if updateMaxReadLevel is not specified
+func (p *processorBase) getMaxReadLevel() task.Key {
+	if p.updateMaxReadLevel == nil {
+		return nil
+	}
+	return p.updateMaxReadLevel()
+}
+
+// queueCaughtUp returns true if the queue has read all tasks up to
+// its max level or the specified maxReadLevel if not nil
+func queueCaughtUp(
+	state ProcessingQueueState,
+	maxReadLevel task.Key,
+) bool {
+	queueMaxReadLevel := state.MaxLevel()
+	if maxReadLevel != nil {
+		queueMaxReadLevel = minTaskKey(queueMaxReadLevel, maxReadLevel)
+	}
+	return !state.ReadLevel().Less(queueMaxReadLevel)
+}
+
+// lockQueueCollections acquires the write lock of queueCollectionsLock and
+// returns a function for releasing the lock. Lock hold duration is recorded
+// for a sample of the calls.
+func (p *processorBase) lockQueueCollections(
+	operation string,
+) func() {
+	p.queueCollectionsLock.Lock()
+	return p.lockReleaseFn(operation, p.queueCollectionsLock.Unlock)
+}
+
+// rLockQueueCollections is the same as lockQueueCollections,
+// but acquires the read lock
+func (p *processorBase) rLockQueueCollections(
+	operation string,
+) func() {
+	p.queueCollectionsLock.RLock()
+	return p.lockReleaseFn(operation, p.queueCollectionsLock.RUnlock)
+}
+
+func (p *processorBase) lockReleaseFn(
+	operation string,
+	unlock func(),
+) func() {
+	if p.options.LockMetricsSampleRate == nil || rand.Float64() >= p.options.LockMetricsSampleRate() {
+		return unlock
+	}
+
+	sw := p.metricsScope.Tagged(metrics.LockOperationTag(operation)).StartTimer(metrics.ProcessingQueueLockHoldLatency)
+	return func() {
+		sw.Stop()
+		unlock()
+	}
+}
+
+// assignQueuePriority stamps the priority of the processing queue onto the task,
+// so that tasks from isolated queues can be scheduled accordingly.
+// Task priority won't be changed if the queue doesn't specify a priority.
+func assignQueuePriority(
+	task task.Task,
+	state ProcessingQueueState,
+) {
+	if priority := state.Priority(); priority != t.NoPriority {
+		task.SetPriority(priority)
+	}
+}
+
+// shouldRedispatch decides if the current processing iteration should run a redispatch pass
+// instead of reading new tasks. When there are tasks pending redispatch, RedispatchWeight
+// of all iterations will run a redispatch pass, e.g. with the default weight 0.5, redispatch and
+// read alternate. Redispatcher having more than MaxRedispatchQueueSize tasks is handled separately
+// by the caller and always blocks reading new tasks.
+// Only the processor pump goroutine should call this method
+func (p *processorBase) shouldRedispatch() bool {
+	if p.redispatcher.Size() == 0 {
+		p.redispatchCredit = 0
+		return false
+	}
+
+	weight := math.Max(0, math.Min(1, p.options.RedispatchWeight()))
+	p.redispatchCredit += weight
+	if p.redispatchCredit >= 1 {
+		p.redispatchCredit--
+		return true
+	}
+	return false
+}
+
+// redispatch runs a redispatch pass on the redispatcher. At most MaxConcurrentRedispatch
+// passes can run at the same time, additional callers will either wait for a running
+// pass to complete or skip the pass if SkipRedispatchWhenBusy is true.
+// Returns false if the pass is skipped.
+func (p *processorBase) redispatch(
+	ctx context.Context,
+	targetSize int,
+) bool {
+	span := p.startSpan(ctx, "queue.redispatch")
+	defer span.Finish()
+	span.SetTag("redispatch.target.size", targetSize)
+
+	p.redispatchLock.Lock()
+	for p.numRedispatching >= common.MaxInt(1, p.options.MaxConcurrentRedispatch()) {
+		if p.options.SkipRedispatchWhenBusy() {
+			p.redispatchLock.Unlock()
+			p.metricsScope.IncCounter(metrics.ProcessingQueueRedispatchSkippedCounter)
+			span.SetTag("redispatch.skipped", true)
+			return false
+		}
+		p.redispatchCond.Wait()
+	}
+	p.numRedispatching++
+	p.redispatchLock.Unlock()
+
+	defer func() {
+		p.redispatchLock.Lock()
+		p.numRedispatching--
+		p.redispatchLock.Unlock()
+		p.redispatchCond.Signal()
+	}()
+
+	var result *task.RedispatchResult
+	if boostedDomains := p.getBoostedDomains(); len(boostedDomains) != 0 {
+		// submit tasks of boosted domains first, then fill the rest of the pass with other tasks
+		result = p.redispatcher.RedispatchMatched(targetSize, func(task task.Task) bool {
+			_, ok := boostedDomains[task.GetDomainID()]
+			return ok
+		})
+		span.SetTag("redispatch.boosted.domains", len(boostedDomains))
+	}
+	result = mergeRedispatchResults(result, p.redispatcher.Redispatch(targetSize))
+	p.emitRedispatchMetrics(result)
+	submitted, rejected := 0, 0
+	if result != nil {
+		for _, stats := range result.SubmitStatsByDomainID {
+			submitted += stats.Submitted
+			rejected += stats.Rejected
+		}
+		span.SetTag("redispatch.submitted", submitted)
+		span.SetTag("redispatch.rejected", rejected)
+	}
+	p.recordRedispatchSubmits(submitted)
+	return true
+}
+
+// recordRedispatchSubmits updates the moving average of redispatch submit rate
+// with the number of tasks submitted since the last redispatch pass
+func (p *processorBase) recordRedispatchSubmits(
+	submitted int,
+) {
+	now := p.shard.GetTimeSource().Now()
+
+	p.redispatchLock.Lock()
+	defer p.redispatchLock.Unlock()
+
+	p.unsampledRedispatchSubmits += submitted
+	if p.lastRedispatchTime.IsZero() {
+		// the first pass only starts the measurement
+		p.lastRedispatchTime = now
+		p.unsampledRedispatchSubmits = 0
+		return
+	}
+
+	elapsed := now.Sub(p.lastRedispatchTime)
+	if elapsed <= 0 {
+		// passes completed at the same time are sampled together
+		return
+	}
+
+	rate := float64(p.unsampledRedispatchSubmits) / elapsed.Seconds()
+	if p.redispatchSubmitRateSampled {
+		rate = redispatchSubmitRateSmoothingFactor*rate + (1-redispatchSubmitRateSmoothingFactor)*p.redispatchSubmitRate
+	}
+	p.redispatchSubmitRate = rate
+	p.redispatchSubmitRateSampled = true
+	p.lastRedispatchTime = now
+	p.unsampledRedispatchSubmits = 0
+}
+
+// recordRedispatchQueueSize samples the redispatcher size and emits its growth rate since
+// the last sample, i.e. the number of tasks added minus the number of tasks drained per second.
+// A positive rate means the redispatcher is growing faster than it drains. It returns false
+// if there's no previous sample to compute the rate from
+func (p *processorBase) recordRedispatchQueueSize(
+	size int,
+) (float64, bool) {
+	now := p.shard.GetTimeSource().Now()
+	lastSize, lastSampleTime := p.lastRedispatchSize, p.lastRedispatchSizeSampleTime
+	elapsed := now.Sub(lastSampleTime)
+	if !lastSampleTime.IsZero() && elapsed <= 0 {
+		// keep the previous sample so that the rate covers a non-zero duration
+		return 0, false
+	}
+
+	p.lastRedispatchSize = size
+	p.lastRedispatchSizeSampleTime = now
+	if lastSampleTime.IsZero() {
+		return 0, false
+	}
+
+	rate := float64(size-lastSize) / elapsed.Seconds()
+	p.metricsScope.UpdateGauge(metrics.ProcessingQueueRedispatchGrowthRateGauge, rate)
+	return rate, true
+}
+
+// startPumpIteration is invoked by the processor pump when it starts handling an event, the
+// iteration type can be changed once it's known what the iteration does.
+// Only the processor pump goroutine should call this method
+func (p *processorBase) startPumpIteration(
+	iterationType string,
+) *pumpIteration {
+	return &pumpIteration{
+		iterationType: iterationType,
+		startTime:     p.shard.GetTimeSource().Now(),
+	}
+}
+
+// finishPumpIteration emits the number of processor pump iterations and the time spent on each
+// iteration tagged by iteration type, so that a spinning or stalled pump can be diagnosed.
+// Only the processor pump goroutine should call this method
+func (p *processorBase) finishPumpIteration(
+	iteration *pumpIteration,
+) {
+	scope, ok := p.pumpIterationScopes[iteration.iterationType]
+	if !ok {
+		scope = p.metricsScope.Tagged(metrics.PumpIterationTag(iteration.iterationType))
+		p.pumpIterationScopes[iteration.iterationType] = scope
+	}
+	scope.IncCounter(metrics.ProcessingQueuePumpIterationCounter)
+	scope.RecordTimer(metrics.ProcessingQueuePumpIterationLatency, p.shard.GetTimeSource().Now().Sub(iteration.startTime))
+}
+
+// EstimateRedispatchDrainTime estimates how long it takes to submit all tasks in the
+// redispatcher based on the moving average of redispatch submit rate, assuming no new
+// task is added. RedispatchNotDraining is returned if the submit rate is zero
+func (p *processorBase) EstimateRedispatchDrainTime() time.Duration {
+	size := p.redispatcher.Size()
+	if size == 0 {
+		return 0
+	}
+
+	p.redispatchLock.Lock()
+	rate := p.redispatchSubmitRate
+	p.redispatchLock.Unlock()
+
+	if rate <= 0 {
+		return RedispatchNotDraining
+	}
+	return time.Duration(float64(size) / rate * float64(time.Second))
+}
+
+// MetricsSnapshot returns a snapshot of the processor metrics for diagnostics. Values
+// derived from processing queue collections are consistent with each other, the others
+// are read separately and may reflect a slightly different point in time
+func (p *processorBase) MetricsSnapshot() *MetricsSnapshot {
+	unlock := p.rLockQueueCollections(lockOperationMetricsSnapshot)
+	ackLevels := make(map[int]task.Key, len(p.processingQueueCollections))
+	for _, queueCollection := range p.processingQueueCollections {
+		if ackLevel := getCollectionAckLevel(queueCollection); ackLevel != nil {
+			ackLevels[queueCollection.Level()] = ackLevel
+		}
+	}
+	snapshot := &MetricsSnapshot{
+		AckLevels:                ackLevels,
+		AckLagByLevel:            p.ackReadGapsLocked(),
+		PendingTaskCountByDomain: p.pendingTaskCountByDomainLocked(),
+	}
+	unlock()
+
+	snapshot.RedispatchQueueSize = p.redispatcher.Size()
+	snapshot.RedispatchDrainTime = p.EstimateRedispatchDrainTime()
+	snapshot.InFlightTasks = p.numInFlightTasks()
+	snapshot.CaughtUp = p.IsCaughtUp()
+	snapshot.BoostedDomains = p.getBoostedDomains()
+	snapshot.RecentRedispatchDecisions = p.getRecentRedispatchDecisions()
+
+	p.submittedTasksLock.Lock()
+	snapshot.TasksSubmittedByLevel = make(map[int]int64, len(p.submittedTasksByLevel))
+	for level, count := range p.submittedTasksByLevel {
+		snapshot.TasksSubmittedByLevel[level] = count
+	}
+	p.submittedTasksLock.Unlock()
+
+	return snapshot
+}
+
+// DrainNow submits tasks as fast as possible to drain the processor, e.g. before the shard goes
+// offline for planned maintenance. While it's running, read rate limits, read backoffs and outstanding
+// task shedding are bypassed, all levels are polled immediately and tasks in the redispatcher are
+// redispatched repeatedly. It returns when all tasks up to the max read level have been read and
+// acked, or when ctx is done or the processor is shut down, and returns the number of loaded
+// tasks not yet acked. The processor pump must be running for new tasks to be read
+func (p *processorBase) DrainNow(
+	ctx context.Context,
+) int {
+	atomic.AddInt32(&p.draining, 1)
+	defer atomic.AddInt32(&p.draining, -1)
+
+	ticker := time.NewTicker(drainCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case p.drainNotifyCh <- struct{}{}:
+		default:
+		}
+
+		if p.redispatcher.Size() != 0 {
+			p.redispatch(ctx, 0)
+		}
+
+		remaining, readFinished := p.getDrainProgress()
+		if remaining == 0 && readFinished {
+			return 0
+		}
+
+		select {
+		case <-ctx.Done():
+			return remaining
+		case <-p.shutdownCh:
+			return remaining
+		case <-ticker.C:
+		}
+	}
+}
+
+// getDrainProgress returns the number of loaded tasks not yet acked, including tasks in the
+// redispatcher, and whether all processing queues have read tasks up to the max read level
+func (p *processorBase) getDrainProgress() (int, bool) {
+	defer p.rLockQueueCollections(lockOperationDrain)()
+
+	remaining := 0
+	for _, count := range p.pendingTaskCountByDomainLocked() {
+		remaining += count
+	}
+
+	maxReadLevel := p.getMaxReadLevel()
+	for _, queueCollection := range p.processingQueueCollections {
+		for _, queue := range queueCollection.Queues() {
+			if !queueCaughtUp(queue.State(), maxReadLevel) {
+				return remaining, false
+			}
+		}
+	}
+	return remaining, true
+}
+
+func (p *processorBase) isDraining() bool {
+	return atomic.LoadInt32(&p.draining) != 0
+}
+
+// waitReadRateLimit blocks until a read is allowed by the processor's read rate limiter,
+// the read rate is further limited during warmup. Rate limiters are bypassed if the processor is draining
+func (p *processorBase) waitReadRateLimit(
+	ctx context.Context,
+) error {
+	if p.isDraining() {
+		return nil
+	}
+
+	if warmupReadRPS, ok := p.getWarmupReadRPS(); ok {
+		p.warmupRateLimiter.SetLimit(rate.Limit(warmupReadRPS))
+		if err := p.warmupRateLimiter.Wait(ctx); err != nil {
+			return err
+		}
+	}
+	return p.rateLimiter.Wait(ctx)
+}
+
+// getWarmupReadRPS returns the max read rate during warmup,
+// false is returned if the read rate is not limited by warmup
+func (p *processorBase) getWarmupReadRPS() (float64, bool) {
+	factor := p.getWarmupRateFactor()
+	maxPollRPS := float64(p.options.MaxPollRPS())
+	if factor >= 1 || maxPollRPS <= 0 {
+		return 0, false
+	}
+	return maxPollRPS * factor, true
+}
+
+// getWarmupRateFactor returns the fraction of the full rate that reads and redispatch passes
+// are limited to. It increases linearly from warmupInitialRateFactor to 1 over WarmupDuration
+// since the processor is created, and it's always 1 if the processor is draining
+func (p *processorBase) getWarmupRateFactor() float64 {
+	if p.options.WarmupDuration == nil || p.isDraining() {
+		return 1
+	}
+	warmupDuration := p.options.WarmupDuration()
+	if warmupDuration <= 0 {
+		return 1
+	}
+
+	elapsed := p.shard.GetTimeSource().Now().Sub(p.warmupStartTime)
+	if elapsed >= warmupDuration {
+		return 1
+	}
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return warmupInitialRateFactor + (1-warmupInitialRateFactor)*float64(elapsed)/float64(warmupDuration)
+}
+
+// startSpan starts a span for the queue processor operation, the span is a child of
+// the span in ctx if there's one. Tracer in options is used to create the span and if it's
+// not specified, tracer of the parent span is used. If there's no tracer, a noop span is returned.
+func (p *processorBase) startSpan(
+	ctx context.Context,
+	operationName string,
+) opentracing.Span {
+	tracer := p.options.Tracer
+	parentSpan := opentracing.SpanFromContext(ctx)
+	if tracer == nil && parentSpan != nil {
+		tracer = parentSpan.Tracer()
+	}
+	if tracer == nil {
+		return opentracing.NoopTracer{}.StartSpan(operationName)
+	}
+
+	options := []opentracing.StartSpanOption{
+		opentracing.Tag{Key: "shard.id", Value: p.shard.GetShardID()},
+		opentracing.Tag{Key: "queue.type", Value: getQueueTypeName(p.options.MetricScope)},
+	}
+	if parentSpan != nil {
+		options = append(options, opentracing.ChildOf(parentSpan.Context()))
+	}
+	return tracer.StartSpan(operationName, options...)
+}
+
+func finishSpan(
+	span opentracing.Span,
+	err error,
+) {
+	if err != nil {
+		ext.Error.Set(span, true)
+		span.LogFields(otlog.Error(err))
+	}
+	span.Finish()
+}
+
+// countSplits returns the number of evaluations that split a processing queue
+// since the timed policies are last reset
+func countSplits(
+	timedPolicies []*timedSplitPolicy,
+) int {
+	numSplits := 0
+	for _, timedPolicy := range timedPolicies {
+		numSplits += timedPolicy.numEvaluated - len(timedPolicy.skipReasons)
+	}
+	return numSplits
+}
+
+// emitSplitPolicyEvaluationMetrics emits the evaluation time of each evaluated split policy,
+// logs why queues are not split and notifies OnSplitPolicyEvaluated, then resets the recorded evaluations
+func (p *processorBase) emitSplitPolicyEvaluationMetrics(
+	timedPolicies []*timedSplitPolicy,
+) {
+	for _, timedPolicy := range timedPolicies {
+		if timedPolicy.numEvaluated == 0 {
+			continue
+		}
+
+		p.metricsScope.Tagged(metrics.SplitPolicyTag(timedPolicy.name)).
+			RecordTimer(metrics.ProcessingQueueSplitPolicyEvaluationLatency, timedPolicy.duration)
+		for _, skipRecord := range timedPolicy.skipReasons {
+			p.logger.Debug("Split policy didn't split processing queue",
+				tag.Name(timedPolicy.name),
+				tag.QueueLevel(skipRecord.level),
+				tag.QueueSplitSkipReason(string(skipRecord.reason)),
+			)
+		}
+		if p.options.OnSplitPolicyEvaluated != nil {
+			p.options.OnSplitPolicyEvaluated(timedPolicy.name, timedPolicy.duration)
+		}
+		timedPolicy.reset()
+	}
+}
+
+// checkAckLevelStuck counts consecutive ack level updates where the ack level didn't advance
+// while there are pending tasks, and emits the stuck ack level metric once the count reaches
+// StuckAckLevelThreshold. Ack level not advancing without pending tasks is not considered stuck.
+func (p *processorBase) checkAckLevelStuck(
+	ackLevel task.Key,
+	numPendingTasks int,
+) {
+	if numPendingTasks > 0 && p.lastAckLevel != nil && !p.lastAckLevel.Less(ackLevel) {
+		p.numStuckAckLevelUpdates++
+	} else {
+		p.numStuckAckLevelUpdates = 0
+	}
+	p.lastAckLevel = ackLevel
+
+	threshold := p.options.StuckAckLevelThreshold()
+	if threshold <= 0 || p.numStuckAckLevelUpdates < threshold {
+		return
+	}
+
+	p.metricsScope.IncCounter(metrics.AckLevelStuckCounter)
+	if p.numStuckAckLevelUpdates == threshold {
+		p.logger.Warn("Ack level stopped advancing.",
+			tag.Counter(p.numStuckAckLevelUpdates),
+			tag.Value(ackLevel),
+		)
+	}
+}
+
+// mergeRedispatchResults adds the submit stats of other to result and returns the merged result,
+// either of them can be nil
+func mergeRedispatchResults(
+	result *task.RedispatchResult,
+	other *task.RedispatchResult,
+) *task.RedispatchResult {
+	if result == nil {
+		return other
+	}
+	if other == nil {
+		return result
+	}
+
+	if result.SubmitStatsByDomainID == nil {
+		result.SubmitStatsByDomainID = make(map[string]*task.RedispatchSubmitStats)
+	}
+	for domainID, otherStats := range other.SubmitStatsByDomainID {
+		stats, ok := result.SubmitStatsByDomainID[domainID]
+		if !ok {
+			stats = &task.RedispatchSubmitStats{}
+			result.SubmitStatsByDomainID[domainID] = stats
+		}
+		stats.Submitted += otherStats.Submitted
+		stats.Rejected += otherStats.Rejected
+	}
+	return result
+}
+
+// emitRedispatchMetrics emits the number of tasks submitted and rejected
+// during a redispatch pass, tagged by domain if EnableDomainTaggedMetrics is true
+func (p *processorBase) emitRedispatchMetrics(
+	result *task.RedispatchResult,
+) {
+	if result == nil {
+		return
+	}
+
+	emitDomainTaggedMetrics := p.options.EnableDomainTaggedMetrics()
+	for domainID, stats := range result.SubmitStatsByDomainID {
+		scope := p.metricsScope
+		if emitDomainTaggedMetrics {
+			scope = scope.Tagged(metrics.DomainTag(domainID))
+		}
+		scope.AddCounter(metrics.ProcessingQueueRedispatchSubmittedCounter, int64(stats.Submitted))
+		scope.AddCounter(metrics.ProcessingQueueRedispatchRejectedCounter, int64(stats.Rejected))
+	}
+}
+
+// getTaskRedispatchedFn returns the callback recording redispatch decisions,
+// nil is returned if the redispatch decision log is disabled
+func (p *processorBase) getTaskRedispatchedFn() func(task.Task, task.SubmitAction) {
+	if p.redispatchDecisions == nil {
+		return nil
+	}
+	return p.recordRedispatchDecision
+}
+
+// recordRedispatchDecision adds the decision to the ring buffer, evicting the oldest decision if it's full
+func (p *processorBase) recordRedispatchDecision(
+	redispatchedTask task.Task,
+	action task.SubmitAction,
+) {
+	decision := RedispatchDecision{
+		Key:      newTaskKeyFromTask(redispatchedTask),
+		DomainID: redispatchedTask.GetDomainID(),
+		Action:   action,
+		Attempt:  redispatchedTask.GetAttempt(),
+		Time:     p.shard.GetTimeSource().Now(),
+	}
+
+	p.redispatchDecisionsLock.Lock()
+	defer p.redispatchDecisionsLock.Unlock()
+
+	p.redispatchDecisions[p.numRedispatchDecisions%len(p.redispatchDecisions)] = decision
+	p.numRedispatchDecisions++
+}
+
+// getRecentRedispatchDecisions returns the decisions in the ring buffer from the oldest to the newest
+func (p *processorBase) getRecentRedispatchDecisions() []RedispatchDecision {
+	p.redispatchDecisionsLock.Lock()
+	defer p.redispatchDecisionsLock.Unlock()
+
+	size := len(p.redispatchDecisions)
+	if size == 0 {
+		return nil
+	}
+
+	numDecisions := common.MinInt(p.numRedispatchDecisions, size)
+	decisions := make([]RedispatchDecision, 0, numDecisions)
+	for idx := p.numRedispatchDecisions - numDecisions; idx != p.numRedispatchDecisions; idx++ {
+		decisions = append(decisions, p.redispatchDecisions[idx%size])
+	}
+	return decisions
+}
+
+// submitTask submits a task read by the processing queue collection at the given level
+// to the task processor, the task is added to the redispatcher if it's not submitted.
+// errTaskDropped is returned if the task is not submitted and can't be added to the redispatcher
+func (p *processorBase) submitTask(
+	level int,
+	task task.Task,
+) (bool, error) {
+	if p.shouldShedTask(task) {
+		// defer the task to the redispatcher to give tasks of other domains room,
+		// report it as submitted so that reading tasks for other domains won't be throttled
+		p.metricsScope.Tagged(metrics.DomainTag(task.GetDomainID())).IncCounter(metrics.ProcessingQueueDomainShedCounter)
+		if err := p.deferTask(level, task); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	if p.isTaskPaused(task) {
+		// keep the task in the redispatcher until the domain is resumed,
+		// report it as submitted so that reading tasks for other domains won't be throttled
+		if err := p.deferTask(level, task); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	if p.options.ShouldSubmitTask != nil && !p.options.ShouldSubmitTask(task) {
+		// hold the task in the redispatcher until it can be submitted,
+		// report it as submitted so that reading other tasks won't be throttled
+		if err := p.deferTask(level, task); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	if p.isOrderedProcessingEnabled(task) && !p.acquireWorkflowGate(task) {
+		// keep the task in the redispatcher until the in-flight task of its workflow completes,
+		// report it as submitted so that reading tasks for other workflows won't be throttled
+		if err := p.deferTask(level, task); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	submitted, err := p.trySubmitTask(task)
+	if err != nil {
+		select {
+		case <-p.shutdownCh:
+			// if error is due to shard shutdown
+			return false, err
+		default:
+			// otherwise it might be error from domain cache etc, add
+			// the task to redispatch queue so that it can be retried
+			p.logger.Error("Failed to submit task", tag.Error(err))
+		}
+	}
+	if err != nil || !submitted {
+		return false, p.deferTask(level, task)
+	}
+
+	p.metricsScope.Tagged(metrics.QueueLevelTag(level)).IncCounter(metrics.ProcessingQueueTaskSubmittedCounter)
+	p.submittedTasksLock.Lock()
+	p.submittedTasksByLevel[level]++
+	p.submittedTasksLock.Unlock()
+	p.emitTaskEvent(task, false)
+	return true, nil
+}
+
+// deferTask adds a newly read task that can't be submitted now to the redispatcher. If redispatch
+// is disabled, the task is dropped from memory and errTaskDropped is returned, the caller must then
+// stop reading before the task so that the read level and thus the ack level is not advanced past it
+// and the task is read again from persistence. This trades persistence load for memory, note that
+// tasks of paused domains or tasks read in read-only mode are dropped and read again as well.
+// If the domain of the task has reached MaxRedispatchQueueSizePerDomain, the task is parked instead,
+// so that reading tasks of other domains is not blocked by the domain.
+// Only the processor pump goroutine should call this method
+func (p *processorBase) deferTask(
+	level int,
+	task task.Task,
+) error {
+	if p.options.DisableRedispatch != nil && p.options.DisableRedispatch() {
+		p.metricsScope.IncCounter(metrics.ProcessingQueueTaskDroppedCounter)
+		return errTaskDropped
+	}
+
+	if p.isRedispatchQueueFull(task) {
+		domainID := task.GetDomainID()
+		p.metricsScope.Tagged(metrics.DomainTag(domainID)).IncCounter(metrics.ProcessingQueueRedispatchQueueFullCounter)
+		p.parkedTasksByDomain[domainID] = append(p.parkedTasksByDomain[domainID], parkedTask{
+			level: level,
+			task:  task,
+		})
+		return nil
+	}
+
+	p.addTaskToRedispatcher(level, task)
+	return nil
+}
+
+// isRedispatchQueueFull returns true if the domain of the task has reached MaxRedispatchQueueSizePerDomain
+func (p *processorBase) isRedispatchQueueFull(
+	task task.Task,
+) bool {
+	if p.options.MaxRedispatchQueueSizePerDomain == nil {
+		return false
+	}
+
+	domainID := task.GetDomainID()
+	maxSize := p.options.MaxRedispatchQueueSizePerDomain(domainID)
+	return maxSize > 0 && p.redispatcher.SizeByDomainID(domainID) >= maxSize
+}
+
+// releaseParkedTasks moves parked tasks of each domain to the redispatcher as long as the domain
+// has room under MaxRedispatchQueueSizePerDomain, in the order they are parked.
+// Only the processor pump goroutine should call this method
+func (p *processorBase) releaseParkedTasks() {
+	for domainID, parkedTasks := range p.parkedTasksByDomain {
+		numReleased := len(parkedTasks)
+		if p.options.MaxRedispatchQueueSizePerDomain != nil {
+			if maxSize := p.options.MaxRedispatchQueueSizePerDomain(domainID); maxSize > 0 {
+				room := common.MaxInt(maxSize-p.redispatcher.SizeByDomainID(domainID), 0)
+				numReleased = common.MinInt(numReleased, room)
+			}
+		}
+		for _, parked := range parkedTasks[:numReleased] {
+			p.addTaskToRedispatcher(parked.level, parked.task)
+		}
+		if numReleased == len(parkedTasks) {
+			delete(p.parkedTasksByDomain, domainID)
+		} else {
+			p.parkedTasksByDomain[domainID] = parkedTasks[numReleased:]
+		}
+	}
+}
+
+// addTaskToRedispatcher adds the task to the redispatch queue of the given processing queue level.
+// If the redispatcher doesn't keep separate queues for levels, the task is added to its only queue
+func (p *processorBase) addTaskToRedispatcher(
+	level int,
+	task task.Task,
+) {
+	if redispatcher, ok := p.redispatcher.(*levelRedispatcher); ok {
+		redispatcher.addTaskToLevel(level, task)
+		return
+	}
+	p.redispatcher.AddTask(task)
+}
+
+// refreshOutstandingTaskCount recounts outstanding tasks, both queued and in-flight, of each domain
+// if outstanding task shedding is enabled. Tasks submitted after the refresh are counted by shouldShedTask.
+// Only the processor pump goroutine should call this method
+func (p *processorBase) refreshOutstandingTaskCount() {
+	if !p.options.EnableOutstandingTaskShedding() {
+		p.outstandingTasksByDomain = nil
+		p.redispatchAllowanceLock.Lock()
+		p.redispatchAllowanceByDomain = nil
+		p.redispatchAllowanceLock.Unlock()
+		return
+	}
+	p.outstandingTasksByDomain = p.pendingTaskCountByDomainLocked()
+
+	// tasks queued in the redispatcher or parked are outstanding but not in flight, the redispatcher
+	// can only submit as many of them as the domain has room for under its limit
+	allowanceByDomain := make(map[string]int)
+	for domainID, numOutstanding := range p.outstandingTasksByDomain {
+		limit := p.options.MaxOutstandingTasksPerDomain(domainID)
+		if limit <= 0 {
+			continue
+		}
+		numInFlight := numOutstanding - p.redispatcher.SizeByDomainID(domainID) - len(p.parkedTasksByDomain[domainID])
+		allowanceByDomain[domainID] = common.MaxInt(limit-numInFlight, 0)
+	}
+	p.redispatchAllowanceLock.Lock()
+	p.redispatchAllowanceByDomain = allowanceByDomain
+	p.redispatchAllowanceLock.Unlock()
+}
+
+// shouldSubmitRedispatchedTask is the TaskShouldSubmit of the redispatcher. Besides ShouldSubmitTask,
+// it holds back tasks of domains that have reached MaxOutstandingTasksPerDomain, so tasks shed by
+// shouldShedTask are not submitted by the next redispatch pass while their domain is still over its limit
+func (p *processorBase) shouldSubmitRedispatchedTask(
+	task task.Task,
+) bool {
+	if p.options.ShouldSubmitTask != nil && !p.options.ShouldSubmitTask(task) {
+		return false
+	}
+	if p.isDraining() {
+		return true
+	}
+
+	p.redispatchAllowanceLock.Lock()
+	sheddingEnabled := p.redispatchAllowanceByDomain != nil
+	p.redispatchAllowanceLock.Unlock()
+	if !sheddingEnabled {
+		return true
+	}
+
+	domainID := task.GetDomainID()
+	if p.isDomainBoosted(domainID) {
+		return true
+	}
+	p.redispatchAllowanceLock.Lock()
+	defer p.redispatchAllowanceLock.Unlock()
+	allowance, ok := p.redispatchAllowanceByDomain[domainID]
+	if !ok {
+		return true
+	}
+	if allowance <= 0 {
+		return false
+	}
+	p.redispatchAllowanceByDomain[domainID] = allowance - 1
+	return true
+}
+
+// shouldShedTask returns true if the domain of the task has reached MaxOutstandingTasksPerDomain
+// and the task should be deferred to the redispatcher instead of being submitted.
+// Only the processor pump goroutine should call this method
+func (p *processorBase) shouldShedTask(
+	task task.Task,
+) bool {
+	if p.outstandingTasksByDomain == nil || p.isDraining() {
+		return false
+	}
+
+	domainID := task.GetDomainID()
+	if p.isDomainBoosted(domainID) {
+		return false
+	}
+	limit := p.options.MaxOutstandingTasksPerDomain(domainID)
+	if limit <= 0 {
+		return false
+	}
+	if p.outstandingTasksByDomain[domainID] >= limit {
+		return true
+	}
+	p.outstandingTasksByDomain[domainID]++
+	return false
+}
+
+// trySubmitTask submits the task to the task processor if no other task of its workflow is in flight
+// when ordered processing is enabled for its domain, and the number of in-flight tasks is below
+// MaxInFlightTasks, otherwise the task is not submitted and false is returned
+func (p *processorBase) trySubmitTask(
+	task task.Task,
+) (bool, error) {
+	if !p.isOrderedProcessingEnabled(task) {
+		return p.trySubmitTaskWithinBudget(task)
+	}
+	if !p.acquireWorkflowGate(task) {
+		return false, nil
+	}
+
+	submitted, err := p.trySubmitTaskWithinBudget(task)
+	if err != nil || !submitted {
+		p.releaseWorkflowGate(task.GetTaskID())
+	}
+	return submitted, err
+}
+
+// trySubmitTaskWithinBudget submits the task to the task processor if the number of in-flight
+// tasks is below MaxInFlightTasks, otherwise the task is not submitted and false is returned
+func (p *processorBase) trySubmitTaskWithinBudget(
+	task task.Task,
+) (bool, error) {
+	maxInFlightTasks := p.options.MaxInFlightTasks()
+	if maxInFlightTasks <= 0 {
+		return p.taskProcessor.TrySubmit(task)
+	}
+
+	taskID := task.GetTaskID()
+	p.inFlightLock.Lock()
+	if len(p.inFlightTasks) >= maxInFlightTasks {
+		p.inFlightLock.Unlock()
+		p.metricsScope.IncCounter(metrics.ProcessingQueueInFlightBudgetExceededCounter)
+		return false, nil
+	}
+	// track the task before submitting, as it may complete before TrySubmit returns
+	p.inFlightTasks[taskID] = struct{}{}
+	p.inFlightLock.Unlock()
+
+	submitted, err := p.taskProcessor.TrySubmit(task)
+	if err != nil || !submitted {
+		p.releaseInFlightTask(taskID)
+	}
+	return submitted, err
+}
+
+// releaseInFlightTask stops tracking the task as in-flight, it's a no-op
+// if the task is not tracked, e.g. tasks acked without being submitted
+func (p *processorBase) releaseInFlightTask(
+	taskID int64,
+) {
+	p.inFlightLock.Lock()
+	defer p.inFlightLock.Unlock()
+
+	delete(p.inFlightTasks, taskID)
+}
+
+// isOrderedProcessingEnabled returns true if tasks of the same workflow in the
+// domain of the task should be submitted one at a time
+func (p *processorBase) isOrderedProcessingEnabled(
+	task task.Task,
+) bool {
+	return p.options.EnableOrderedProcessingByDomainID != nil &&
+		p.options.EnableOrderedProcessingByDomainID(task.GetDomainID())
+}
+
+// acquireWorkflowGate records the task as the in-flight task of its workflow.
+// It returns false if another task of the workflow is already in flight
+func (p *processorBase) acquireWorkflowGate(
+	task task.Task,
+) bool {
+	workflow := definition.NewWorkflowIdentifier(task.GetDomainID(), task.GetWorkflowID(), task.GetRunID())
+	taskID := task.GetTaskID()
+
+	p.workflowGateLock.Lock()
+	defer p.workflowGateLock.Unlock()
+
+	if inFlightTaskID, ok := p.inFlightWorkflows[workflow]; ok && inFlightTaskID != taskID {
+		p.metricsScope.IncCounter(metrics.ProcessingQueueWorkflowGatedCounter)
+		return false
+	}
+	p.inFlightWorkflows[workflow] = taskID
+	p.inFlightWorkflowTasks[taskID] = workflow
+	return true
+}
+
+// releaseWorkflowGate allows the next task of the workflow of the task to be submitted,
+// it's a no-op if the task is not the in-flight task of its workflow
+func (p *processorBase) releaseWorkflowGate(
+	taskID int64,
+) {
+	p.workflowGateLock.Lock()
+	defer p.workflowGateLock.Unlock()
+
+	workflow, ok := p.inFlightWorkflowTasks[taskID]
+	if !ok {
+		return
+	}
+	delete(p.inFlightWorkflowTasks, taskID)
+	delete(p.inFlightWorkflows, workflow)
+}
+
+// numInFlightTasks returns the number of tracked in-flight tasks
+func (p *processorBase) numInFlightTasks() int {
+	p.inFlightLock.Lock()
+	defer p.inFlightLock.Unlock()
+
+	return len(p.inFlightTasks)
+}
+
+// CompleteQueueTask implements task.QueueAckMgr and is invoked when a transfer task is acked
+func (p *processorBase) CompleteQueueTask(
+	taskID int64,
+) {
+	p.releaseInFlightTask(taskID)
+	p.releaseWorkflowGate(taskID)
+}
+
+// CompleteTimerTask implements task.TimerQueueAckMgr and is invoked when a timer task is acked
+func (p *processorBase) CompleteTimerTask(
+	timerTask *persistence.TimerTaskInfo,
+) {
+	p.releaseInFlightTask(timerTask.TaskID)
+	p.releaseWorkflowGate(timerTask.TaskID)
+}
+
+// redispatchNackedTask is the redispatch function of tasks created by the processor for the processing
+// queue collection at the given level, it's invoked when a nacked task can't be resubmitted, releases
+// the task's in-flight slot and workflow gate and adds the task to the redispatch queue of the level.
+// If redispatch is disabled, the task is dropped instead and read again after a reset, see requestDroppedTaskReset
+func (p *processorBase) redispatchNackedTask(
+	level int,
+	task task.Task,
+) {
+	p.releaseInFlightTask(task.GetTaskID())
+	p.releaseWorkflowGate(task.GetTaskID())
+	if p.options.DisableRedispatch != nil && p.options.DisableRedispatch() {
+		p.metricsScope.IncCounter(metrics.ProcessingQueueTaskDroppedCounter)
+		p.requestDroppedTaskReset()
+		return
+	}
+	p.addTaskToRedispatcher(level, task)
+}
+
+// requestDroppedTaskReset asynchronously resets the processing queues after a nacked task is dropped.
+// The dropped task stays pending in its processing queue, so the ack level is not advanced past it,
+// but it's already read and would be skipped by later reads. The reset reads all tasks again from the
+// ack level instead. Only one reset is requested at a time, tasks dropped before the processor pump
+// picks up the pending reset are covered by it as well
+func (p *processorBase) requestDroppedTaskReset() {
+	if !atomic.CompareAndSwapInt32(&p.droppedTaskResetPending, 0, 1) {
+		return
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&p.droppedTaskResetPending, 0)
+		p.addAction(NewResetAction())
+	}()
+}
+
+// getReadBackoffDuration returns how long the next read for the processing queue
+// collection at the given level should be delayed based on the per level max poll rps
+// and the number of loaded tasks not yet released by ack level updates.
+// Zero is returned if the read can be performed immediately, the processor is draining
+// or the level covers a boosted domain.
+func (p *processorBase) getReadBackoffDuration(
+	level int,
+) time.Duration {
+	if p.isDraining() || p.isLevelBoosted(level) {
+		return 0
+	}
+
+	if maxGap := p.options.MaxAckReadGap(); maxGap > 0 && p.getAckReadGap(level) >= maxGap {
+		// reading more tasks only grows the in-memory backlog,
+		// pause reads until ack level update releases loaded tasks
+		p.metricsScope.Tagged(metrics.QueueLevelTag(level)).IncCounter(metrics.ProcessingQueueAckReadGapThrottledCounter)
+		return backoff.JitDuration(
+			p.options.PollBackoffInterval(),
+			p.options.PollBackoffIntervalJitterCoefficient(),
+		)
+	}
+
+	if _, ok := p.getMaxPollRPSByLevel()[level]; !ok {
+		// no rate limit specified for the level
+		return 0
+	}
+
+	rateLimiter, ok := p.levelRateLimiters[level]
+	if !ok {
+		rateLimiter = quotas.NewDynamicRateLimiter(
+			func() float64 {
+				return float64(p.getMaxPollRPSByLevel()[level])
+			},
+		)
+		p.levelRateLimiters[level] = rateLimiter
+	}
+
+	now := p.shard.GetTimeSource().Now()
+	reservation := rateLimiter.ReserveAt(now)
+	if !reservation.OK() {
+		p.metricsScope.IncCounter(metrics.ProcessingQueueReadThrottledCounter)
+		return backoff.JitDuration(
+			p.options.PollBackoffInterval(),
+			p.options.PollBackoffIntervalJitterCoefficient(),
+		)
+	}
+
+	delay := reservation.DelayFrom(now)
+	if delay > 0 {
+		// return the token as the read won't happen now
+		reservation.CancelAt(now)
+		p.metricsScope.IncCounter(metrics.ProcessingQueueReadThrottledCounter)
+	}
+	return delay
+}
+
+// getAckReadGap returns the number of tasks loaded by the processing queue collection
+// at the given level and not yet released by ack level updates
+func (p *processorBase) getAckReadGap(
+	level int,
+) int {
+	defer p.rLockQueueCollections(lockOperationPendingTaskCount)()
+
+	return p.ackReadGapsLocked()[level]
+}
+
+// ackReadGapsLocked returns the ack read gap of each processing queue level, see getAckReadGap.
+// Caller must hold queueCollectionsLock or be the processor pump goroutine
+func (p *processorBase) ackReadGapsLocked() map[int]int {
+	gaps := make(map[int]int, len(p.processingQueueCollections))
+	for _, queueCollection := range p.processingQueueCollections {
+		gap := 0
+		for _, queue := range queueCollection.Queues() {
+			gap += len(queue.(*processingQueueImpl).outstandingTasks)
+		}
+		gaps[queueCollection.Level()] += gap
+	}
+	return gaps
+}
+
+func (p *processorBase) getMaxPollRPSByLevel() map[int]int {
+	if p.options.MaxPollRPSByLevel == nil {
+		return nil
+	}
+
+	maxPollRPSByLevel, err := common.ConvertDynamicConfigMapPropertyToIntMap(p.options.MaxPollRPSByLevel())
+	if err != nil {
+		p.logger.Error("Failed to convert max poll rps by level", tag.Error(err))
+		return nil
+	}
+
+	for level, maxPollRPS := range maxPollRPSByLevel {
+		if maxPollRPS <= 0 {
+			// only positive values are enforced
+			delete(maxPollRPSByLevel, level)
+		}
+	}
+	return maxPollRPSByLevel
+}
+
+// verifyProcessingQueueStates checks that for each state ackLevel <= readLevel <= maxLevel,
+// and states within the same level don't overlap with each other
+func verifyProcessingQueueStates(
+	processingQueueStates []ProcessingQueueState,
+) error {
+	statesByLevel := make(map[int][]ProcessingQueueState)
+	for _, state := range processingQueueStates {
+		if state.ReadLevel().Less(state.AckLevel()) || state.MaxLevel().Less(state.ReadLevel()) {
+			return fmt.Errorf("processing queue state has invalid ack/read/max level: %v", state)
+		}
+		statesByLevel[state.Level()] = append(statesByLevel[state.Level()], state)
+	}
+
+	for _, states := range statesByLevel {
+		sort.Slice(states, func(i, j int) bool {
+			return states[i].AckLevel().Less(states[j].AckLevel())
+		})
+		for idx := 1; idx < len(states); idx++ {
+			if states[idx].AckLevel().Less(states[idx-1].MaxLevel()) {
+				return fmt.Errorf("processing queue states overlap within the same level: %v, %v", states[idx-1], states[idx])
+			}
+		}
+	}
+
+	return nil
+}
+
+// newProcessorMetricsScope returns the scope used for all metrics emitted by the processor
+func newProcessorMetricsScope(
+	options *queueProcessorOptions,
+	metricsClient metrics.Client,
+) metrics.Scope {
+	if options.MetricsScopeFn != nil {
+		return options.MetricsScopeFn(metricsClient, options.MetricScope)
+	}
+	return metricsClient.Scope(options.MetricScope)
+}
+
+func newProcessingQueueCollections(
+	processingQueueStates []ProcessingQueueState,
+	logger log.Logger,
+	metricsClient metrics.Client,
+) []ProcessingQueueCollection {
+	processingQueuesMap := make(map[int][]ProcessingQueue) // level -> state
+	for _, queueState := range processingQueueStates {
+		processingQueuesMap[queueState.Level()] = append(processingQueuesMap[queueState.Level()], NewProcessingQueue(
+			queueState,
+			logger,
+			metricsClient,
+		))
+	}
+	processingQueueCollections := make([]ProcessingQueueCollection, 0, len(processingQueuesMap))
+	for level, queues := range processingQueuesMap {
+		processingQueueCollections = append(processingQueueCollections, NewProcessingQueueCollection(
+			level,
+			queues,
+		))
+	}
+	sortProcessingQueueCollections(processingQueueCollections)
+
+	return processingQueueCollections
+}
+
+func getPendingTasksMetricIdx(
+	scopeIdx int,
+) int {
+	switch scopeIdx {
+	case metrics.TimerActiveQueueProcessorScope:
+		return metrics.ShardInfoTimerActivePendingTasksTimer
+	case metrics.TimerStandbyQueueProcessorScope:
+		return metrics.ShardInfoTimerStandbyPendingTasksTimer
+	case metrics.TransferActiveQueueProcessorScope:
+		return metrics.ShardInfoTransferActivePendingTasksTimer
+	case metrics.TransferStandbyQueueProcessorScope:
+		return metrics.ShardInfoTransferStandbyPendingTasksTimer
+	case metrics.ReplicatorQueueProcessorScope:
 		return metrics.ShardInfoReplicationPendingTasksTimer
 	default:
 		panic("unknown queue processor metric scope")
 	}
 }
+
+func getQueueTypeName(
+	scopeIdx int,
+) string {
+	switch scopeIdx {
+	case metrics.TimerActiveQueueProcessorScope:
+		return "timer-active"
+	case metrics.TimerStandbyQueueProcessorScope:
+		return "timer-standby"
+	case metrics.TransferActiveQueueProcessorScope:
+		return "transfer-active"
+	case metrics.TransferStandbyQueueProcessorScope:
+		return "transfer-standby"
+	case metrics.ReplicatorQueueProcessorScope:
+		return "replication"
+	default:
+		return "unknown"
+	}
+}
+
+// mergeDomainFilters returns a domain filter matching all domains matched by any of the queues
+func mergeDomainFilters(
+	queues []ProcessingQueue,
+) DomainFilter {
+	merged := NewDomainFilter(nil, false)
+	for _, queue := range queues {
+		merged = merged.Merge(queue.State().DomainFilter())
+	}
+	return merged
+}
+
+// getFilteredDomainIDs returns the sorted IDs of domains matched by the filter,
+// nil is returned if the filter matches all domains except some
+func getFilteredDomainIDs(
+	filter DomainFilter,
+) []string {
+	if filter.ReverseMatch {
+		return nil
+	}
+
+	return filter.Domains()
+}
+
+// checkSplitDomainCoverage returns an error if after doesn't match every domain matched by before
+func checkSplitDomainCoverage(
+	level int,
+	before DomainFilter,
+	after DomainFilter,
+) error {
+	if after.Merge(before).Equal(after) {
+		return nil
+	}
+	return &SplitDomainCoverageError{
+		Level:  level,
+		Before: before,
+		After:  after,
+	}
+}
+
+// TrySubmit submits the task through the in-flight budget of the processor base
+func (p *inFlightBudgetProcessor) TrySubmit(
+	task task.Task,
+) (bool, error) {
+	submitted, err := p.processorBase.trySubmitTask(task)
+	if err == nil && submitted {
+		p.processorBase.emitTaskEvent(task, true)
+	}
+	return submitted, err
+}
+
+// getTaskKeyGaugeValue converts the task key to a gauge value, task ID is used for
+// transfer task keys and visibility timestamp in nanoseconds is used for timer task keys
+func getTaskKeyGaugeValue(
+	key task.Key,
+) (float64, bool) {
+	switch key := key.(type) {
+	case transferTaskKey:
+		return float64(key.taskID), true
+	case timerTaskKey:
+		return float64(key.visibilityTimestamp.UnixNano()), true
+	default:
+		return 0, false
+	}
+}
+
+// newTaskKeyFromTask returns the key of the task in its processing queue
+func newTaskKeyFromTask(
+	queueTask task.Task,
+) task.Key {
+	if queueTask.GetTaskCategory() == task.CategoryTimer {
+		return newTimerTaskKey(queueTask.GetVisibilityTimestamp(), queueTask.GetTaskID())
+	}
+	return newTransferTaskKey(queueTask.GetTaskID())
+}
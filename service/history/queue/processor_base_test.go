@@ -21,22 +21,32 @@
 package queue
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"sort"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/golang/mock/gomock"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 	"github.com/uber-go/tally"
 
 	h "github.com/uber/cadence/.gen/go/history"
+	"github.com/uber/cadence/common"
+	"github.com/uber/cadence/common/clock"
 	"github.com/uber/cadence/common/collection"
 	"github.com/uber/cadence/common/log"
 	"github.com/uber/cadence/common/log/loggerimpl"
 	"github.com/uber/cadence/common/metrics"
 	"github.com/uber/cadence/common/persistence"
 	"github.com/uber/cadence/common/service/dynamicconfig"
+	t "github.com/uber/cadence/common/task"
 	"github.com/uber/cadence/service/history/config"
 	"github.com/uber/cadence/service/history/shard"
 	"github.com/uber/cadence/service/history/task"
@@ -89,6 +99,85 @@ func (s *processorBaseSuite) TearDownTest() {
 	s.mockShard.Finish(s.T())
 }
 
+func (s *processorBaseSuite) TestNewProcessorBase_InvalidStates() {
+	testCases := [][]ProcessingQueueState{
+		{
+			// ack level larger than max level
+			NewProcessingQueueState(
+				0,
+				newTransferTaskKey(100),
+				newTransferTaskKey(10),
+				NewDomainFilter(nil, true),
+			),
+		},
+		{
+			// read level larger than max level
+			newProcessingQueueState(
+				0,
+				newTimerTaskKey(time.Unix(0, 0), 0),
+				newTimerTaskKey(time.Unix(0, 100), 0),
+				newTimerTaskKey(time.Unix(0, 10), 0),
+				NewDomainFilter(nil, true),
+			),
+		},
+		{
+			// overlapping states within the same level
+			NewProcessingQueueState(
+				1,
+				newTransferTaskKey(0),
+				newTransferTaskKey(100),
+				NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+			),
+			NewProcessingQueueState(
+				1,
+				newTransferTaskKey(50),
+				newTransferTaskKey(150),
+				NewDomainFilter(map[string]struct{}{"testDomain2": {}}, false),
+			),
+		},
+	}
+
+	for _, states := range testCases {
+		processorBase, err := newProcessorBaseWithValidation(
+			s.mockShard,
+			states,
+			s.mockTaskProcessor,
+			newTransferQueueProcessorOptions(s.mockShard.GetConfig(), true, false),
+			nil,
+			nil,
+			nil,
+			nil,
+			s.logger,
+			s.metricsClient,
+		)
+		s.Error(err)
+		s.Nil(processorBase)
+
+		s.Panics(func() {
+			s.newTestProcessorBase(states, nil, nil, nil, nil)
+		})
+	}
+
+	// adjacent states and states from different levels are valid
+	_, err := newProcessorBaseWithValidation(
+		s.mockShard,
+		[]ProcessingQueueState{
+			NewProcessingQueueState(0, newTransferTaskKey(0), newTransferTaskKey(100), NewDomainFilter(nil, true)),
+			NewProcessingQueueState(0, newTransferTaskKey(100), newTransferTaskKey(150), NewDomainFilter(nil, true)),
+			NewProcessingQueueState(1, newTransferTaskKey(50), newTransferTaskKey(150), NewDomainFilter(nil, true)),
+		},
+		s.mockTaskProcessor,
+		newTransferQueueProcessorOptions(s.mockShard.GetConfig(), true, false),
+		nil,
+		nil,
+		nil,
+		nil,
+		s.logger,
+		s.metricsClient,
+	)
+	s.NoError(err)
+}
+
 func (s *processorBaseSuite) TestSplitQueue() {
 	mockQueueSplitPolicy := NewMockProcessingQueueSplitPolicy(s.controller)
 
@@ -150,14 +239,34 @@ func (s *processorBaseSuite) TestSplitQueue() {
 		nil,
 	)
 
+	createdLevels := make(map[int][]string)
+	processorBase.options.OnLevelCreated = func(level int, domains []string) {
+		createdLevels[level] = domains
+	}
+
 	nextPollTime := make(map[int]time.Time)
-	processorBase.splitProcessingQueueCollection(
+	splitResult := processorBase.splitProcessingQueueCollection(
+		context.Background(),
 		mockQueueSplitPolicy,
 		func(level int, pollTime time.Time) {
 			nextPollTime[level] = pollTime
 		},
 	)
 
+	// the queue at level 1 is moved to level 2 and the second queue
+	// at level 0 is split into level 0, 1 and 2
+	s.Equal(&SplitResult{
+		SplitsPerformed: 2,
+		LevelsCreated:   1,
+		StatesCreated:   3,
+		NextPollTimes: map[int]time.Time{
+			0: {},
+			1: {},
+			2: {},
+		},
+	}, splitResult)
+	s.Equal(splitResult.NextPollTimes, nextPollTime)
+	s.Equal(map[int][]string{2: {"testDomain1", "testDomain3"}}, createdLevels)
 	processingQueueCollections := processorBase.processingQueueCollections
 	sort.Slice(processingQueueCollections, func(i, j int) bool {
 		return processingQueueCollections[i].Level() < processingQueueCollections[j].Level()
@@ -172,159 +281,3396 @@ func (s *processorBaseSuite) TestSplitQueue() {
 			processingQueueCollections[idx].Level(),
 		)
 	}
-	s.Len(nextPollTime, 3)
-	for _, nextPollTime := range nextPollTime {
-		s.Zero(nextPollTime)
-	}
+
+	// level 1 only contains the queue split from [100, 1000),
+	// other levels are held back by the queues starting from 0
+	s.Equal(map[int]task.Key{
+		0: newTransferTaskKey(0),
+		1: newTransferTaskKey(100),
+		2: newTransferTaskKey(0),
+	}, processorBase.getProcessingQueueStates().GetStateActionResult.AckLevels)
 }
 
-func (s *processorBaseSuite) TestUpdateAckLevel_Transfer_ProcessedFinished() {
+func (s *processorBaseSuite) TestTriggerSplit() {
+	mockQueueSplitPolicy := NewMockProcessingQueueSplitPolicy(s.controller)
+
 	processingQueueStates := []ProcessingQueueState{
 		NewProcessingQueueState(
-			2,
+			0,
+			newTransferTaskKey(0),
 			newTransferTaskKey(100),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, true),
+		),
+		NewProcessingQueueState(
+			1,
+			newTransferTaskKey(0),
 			newTransferTaskKey(100),
 			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
 		),
 		NewProcessingQueueState(
 			0,
+			newTransferTaskKey(100),
 			newTransferTaskKey(1000),
-			newTransferTaskKey(1000),
-			NewDomainFilter(map[string]struct{}{"testDomain1": {}, "testDomain2": {}}, true),
+			NewDomainFilter(map[string]struct{}{}, true),
 		),
 	}
-	queueShutdown := false
-	queueShutdownFn := func() error {
-		queueShutdown = true
-		return nil
-	}
+	mockQueueSplitPolicy.EXPECT().Evaluate(NewProcessingQueue(processingQueueStates[0], s.logger, s.metricsClient)).Return(nil).Times(1)
+	mockQueueSplitPolicy.EXPECT().Evaluate(NewProcessingQueue(processingQueueStates[1], s.logger, s.metricsClient)).Return([]ProcessingQueueState{
+		NewProcessingQueueState(
+			2,
+			newTransferTaskKey(0),
+			newTransferTaskKey(100),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+		),
+	}).Times(1)
+	mockQueueSplitPolicy.EXPECT().Evaluate(NewProcessingQueue(processingQueueStates[2], s.logger, s.metricsClient)).Return([]ProcessingQueueState{
+		NewProcessingQueueState(
+			0,
+			newTransferTaskKey(100),
+			newTransferTaskKey(1000),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}, "testDomain2": {}, "testDomain3": {}}, false),
+		),
+		NewProcessingQueueState(
+			1,
+			newTransferTaskKey(100),
+			newTransferTaskKey(1000),
+			NewDomainFilter(map[string]struct{}{"testDomain2": {}}, false),
+		),
+		NewProcessingQueueState(
+			2,
+			newTransferTaskKey(100),
+			newTransferTaskKey(1000),
+			NewDomainFilter(map[string]struct{}{"testDomain3": {}}, false),
+		),
+	}).Times(1)
 
 	processorBase := s.newTestProcessorBase(
 		processingQueueStates,
 		nil,
 		nil,
 		nil,
-		queueShutdownFn,
+		nil,
 	)
 
-	processFinished, err := processorBase.updateAckLevel()
+	// the hook is rejected unless test hooks are enabled
+	_, err := processorBase.TriggerSplit(mockQueueSplitPolicy)
+	s.Equal(errTestHooksDisabled, err)
+
+	processorBase.options.EnableTestHooks = true
+	splitResult, err := processorBase.TriggerSplit(mockQueueSplitPolicy)
 	s.NoError(err)
-	s.True(processFinished)
-	s.True(queueShutdown)
+	s.Equal(2, splitResult.SplitsPerformed)
+	s.Equal(1, splitResult.LevelsCreated)
+	s.Equal(3, splitResult.StatesCreated)
+	s.Equal(map[int]task.Key{
+		0: newTransferTaskKey(0),
+		1: newTransferTaskKey(100),
+		2: newTransferTaskKey(0),
+	}, processorBase.getProcessingQueueStates().GetStateActionResult.AckLevels)
 }
 
-func (s *processorBaseSuite) TestUpdateAckLevel_Tranfer_ProcessNotFinished() {
+func (s *processorBaseSuite) TestSplitQueue_OnLevelCreated() {
+	processingQueueStates := []ProcessingQueueState{
+		newProcessingQueueState(
+			0,
+			newTransferTaskKey(0),
+			newTransferTaskKey(50),
+			newTransferTaskKey(100),
+			NewDomainFilter(nil, true),
+		),
+		newProcessingQueueState(
+			1,
+			newTransferTaskKey(0),
+			newTransferTaskKey(50),
+			newTransferTaskKey(100),
+			NewDomainFilter(map[string]struct{}{"testDomain3": {}}, false),
+		),
+	}
+	processorBase := s.newTestProcessorBase(processingQueueStates, nil, nil, nil, nil)
+	var createdLevels []int
+	var createdDomains [][]string
+	processorBase.options.OnLevelCreated = func(level int, domains []string) {
+		createdLevels = append(createdLevels, level)
+		createdDomains = append(createdDomains, domains)
+	}
+
+	splitPolicy := NewSelectedDomainSplitPolicy(
+		map[string]struct{}{"testDomain1": {}, "testDomain2": {}},
+		2,
+		s.logger,
+		s.metricsClient.Scope(metrics.TransferActiveQueueProcessorScope),
+	)
+	processorBase.splitProcessingQueueCollection(context.Background(), splitPolicy, func(level int, pollTime time.Time) {})
+	s.Equal([]int{2}, createdLevels)
+	s.Equal([][]string{{"testDomain1", "testDomain2"}}, createdDomains)
+
+	// splitting to an existing level doesn't create a new level
+	processorBase.splitProcessingQueueCollection(context.Background(), splitPolicy, func(level int, pollTime time.Time) {})
+	s.Equal([]int{2}, createdLevels)
+}
+
+func (s *processorBaseSuite) TestSplitQueue_Cancelled() {
 	processingQueueStates := []ProcessingQueueState{
 		NewProcessingQueueState(
-			2,
-			newTransferTaskKey(5),
+			0,
+			newTransferTaskKey(0),
+			newTransferTaskKey(100),
+			NewDomainFilter(nil, true),
+		),
+		NewProcessingQueueState(
+			1,
+			newTransferTaskKey(0),
 			newTransferTaskKey(100),
 			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
 		),
+	}
+	processorBase := s.newTestProcessorBase(processingQueueStates, nil, nil, nil, nil)
+	splitPolicy := &blockingSplitPolicy{
+		evaluatedCh: make(chan struct{}, len(processingQueueStates)),
+	}
+
+	ctx, cancel := processorBase.newShutdownContext()
+	defer cancel()
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		processorBase.splitProcessingQueueCollection(ctx, splitPolicy, func(level int, pollTime time.Time) {})
+	}()
+
+	<-splitPolicy.evaluatedCh
+	close(processorBase.shutdownCh)
+	select {
+	case <-doneCh:
+	case <-time.After(5 * time.Second):
+		s.FailNow("split pass is not cancelled")
+	}
+
+	// the queue at level 1 is not evaluated after the split pass is cancelled
+	s.Len(splitPolicy.evaluatedCh, 0)
+	s.Equal(processingQueueStates, processorBase.getProcessingQueueStates().GetStateActionResult.States)
+}
+
+func (s *processorBaseSuite) TestSplitQueue_Vetoed() {
+	processingQueueStates := []ProcessingQueueState{
+		NewProcessingQueueState(
+			0,
+			newTransferTaskKey(0),
+			newTransferTaskKey(100),
+			NewDomainFilter(nil, true),
+		),
+	}
+	proposedStates := []ProcessingQueueState{
+		NewProcessingQueueState(
+			0,
+			newTransferTaskKey(0),
+			newTransferTaskKey(100),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, true),
+		),
 		NewProcessingQueueState(
 			1,
-			newTransferTaskKey(2),
+			newTransferTaskKey(0),
 			newTransferTaskKey(100),
 			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
 		),
+	}
+	mockQueueSplitPolicy := NewMockProcessingQueueSplitPolicy(s.controller)
+	mockQueueSplitPolicy.EXPECT().Evaluate(gomock.Any()).Return(proposedStates).Times(1)
+
+	processorBase := s.newTestProcessorBase(processingQueueStates, nil, nil, nil, nil)
+	approver := &testSplitApprover{veto: true}
+	processorBase.options.SplitApprover = approver
+
+	splitResult := processorBase.splitProcessingQueueCollection(context.Background(), mockQueueSplitPolicy, nil)
+
+	s.Equal([][]ProcessingQueueState{proposedStates}, approver.proposedStates)
+	s.Zero(splitResult.SplitsPerformed)
+	s.Equal(1, splitResult.SplitsVetoed)
+	s.Zero(splitResult.LevelsCreated)
+	s.Len(processorBase.processingQueueCollections, 1)
+	s.Equal(processingQueueStates, processorBase.getProcessingQueueStates().GetStateActionResult.States)
+
+	// proposed splits are applied once approved
+	approver.veto = false
+	mockQueueSplitPolicy.EXPECT().Evaluate(gomock.Any()).Return(proposedStates).Times(1)
+	splitResult = processorBase.splitProcessingQueueCollection(context.Background(), mockQueueSplitPolicy, nil)
+
+	s.Equal(1, splitResult.SplitsPerformed)
+	s.Zero(splitResult.SplitsVetoed)
+	s.Equal(1, splitResult.LevelsCreated)
+	s.Len(processorBase.processingQueueCollections, 2)
+}
+
+func (s *processorBaseSuite) TestSplitQueue_Cooldown() {
+	now := time.Now()
+	timeSource := clock.NewEventTimeSource().Update(now)
+	s.mockShard.Resource.TimeSource = timeSource
+
+	processingQueueStates := []ProcessingQueueState{
 		NewProcessingQueueState(
 			0,
+			newTransferTaskKey(0),
 			newTransferTaskKey(100),
-			newTransferTaskKey(1000),
-			NewDomainFilter(map[string]struct{}{"testDomain1": {}, "testDomain2": {}}, true),
+			NewDomainFilter(nil, true),
 		),
 	}
-	updateAckLevel := int64(0)
-	updateTransferAckLevelFn := func(ackLevel task.Key) error {
-		updateAckLevel = ackLevel.(transferTaskKey).taskID
-		return nil
+	processorBase := s.newTestProcessorBase(processingQueueStates, nil, nil, nil, nil)
+	processorBase.options.SplitCooldown = dynamicconfig.GetDurationPropertyFn(time.Minute)
+	metricsScope := s.metricsClient.Scope(metrics.TransferActiveQueueProcessorScope)
+	getDomainLevel := func(domainID string) int {
+		for _, state := range processorBase.getProcessingQueueStates().GetStateActionResult.States {
+			if state.DomainFilter().Filter(domainID) {
+				return state.Level()
+			}
+		}
+		return -1
 	}
 
-	processorBase := s.newTestProcessorBase(
-		processingQueueStates,
-		nil,
-		updateTransferAckLevelFn,
+	splitResult := processorBase.splitProcessingQueueCollection(
+		context.Background(),
+		NewSelectedDomainSplitPolicy(map[string]struct{}{"testDomain1": {}}, 1, s.logger, metricsScope),
 		nil,
+	)
+	s.Equal(1, splitResult.SplitsPerformed)
+	s.Equal(1, getDomainLevel("testDomain1"))
+
+	// a second split of the same domain within the cooldown is suppressed
+	timeSource.Update(now.Add(30 * time.Second))
+	splitPolicy := NewSelectedDomainSplitPolicy(map[string]struct{}{"testDomain1": {}}, 2, s.logger, metricsScope)
+	splitResult = processorBase.splitProcessingQueueCollection(context.Background(), splitPolicy, nil)
+	s.Zero(splitResult.SplitsPerformed)
+	s.Equal(1, splitResult.SplitsVetoed)
+	s.Equal(1, getDomainLevel("testDomain1"))
+
+	// other domains are not affected by the cooldown
+	splitResult = processorBase.splitProcessingQueueCollection(
+		context.Background(),
+		NewSelectedDomainSplitPolicy(map[string]struct{}{"testDomain2": {}}, 1, s.logger, metricsScope),
 		nil,
 	)
+	s.Equal(1, splitResult.SplitsPerformed)
+	s.Equal(1, getDomainLevel("testDomain2"))
 
-	processFinished, err := processorBase.updateAckLevel()
-	s.NoError(err)
-	s.False(processFinished)
-	s.Equal(int64(2), updateAckLevel)
+	// the domain can be split again once the cooldown passes
+	timeSource.Update(now.Add(time.Minute))
+	splitResult = processorBase.splitProcessingQueueCollection(context.Background(), splitPolicy, nil)
+	s.Equal(1, splitResult.SplitsPerformed)
+	s.Equal(2, getDomainLevel("testDomain1"))
 }
 
-func (s *processorBaseSuite) TestUpdateAckLevel_Timer_UpdateAckLevel() {
-	now := time.Now()
+func (s *processorBaseSuite) TestSplitQueue_NewTaskLevelStrategy() {
+	testCases := []struct {
+		strategy string
+		// splitKey is the max level of the queue moved to level 1 for testDomain1,
+		// nil means all remaining tasks of the domain are moved
+		splitKey task.Key
+	}{
+		{strategy: newTaskLevelStrategyLowestMatch, splitKey: newTransferTaskKey(50)},
+		{strategy: newTaskLevelStrategyPolicy, splitKey: newTransferTaskKey(60)},
+		{strategy: newTaskLevelStrategyHighestMatch, splitKey: nil},
+	}
+
+	for _, tc := range testCases {
+		processorBase := s.newTestProcessorBase(nil, nil, nil, nil, nil)
+		processorBase.options.EnableSplit = dynamicconfig.GetBoolPropertyFn(true)
+		processorBase.options.NewTaskLevelStrategy = dynamicconfig.GetStringPropertyFn(tc.strategy)
+
+		mockTask := task.NewMockTask(s.controller)
+		mockTask.EXPECT().GetDomainID().Return("testDomain1").AnyTimes()
+		mockTask.EXPECT().GetTaskType().Return(0).AnyTimes()
+		mockTask.EXPECT().State().Return(t.TaskStatePending).AnyTimes()
+		// testDomain2 is already isolated at level 1
+		processorBase.processingQueueCollections = []ProcessingQueueCollection{
+			NewProcessingQueueCollection(0, []ProcessingQueue{
+				newProcessingQueue(
+					newProcessingQueueState(
+						0,
+						newTransferTaskKey(0),
+						newTransferTaskKey(50),
+						maximumTransferTaskKey,
+						NewDomainFilter(map[string]struct{}{"testDomain2": {}}, true),
+					),
+					map[task.Key]task.Task{newTransferTaskKey(10): mockTask},
+					s.logger,
+					s.metricsClient,
+				),
+			}),
+			NewProcessingQueueCollection(1, []ProcessingQueue{
+				newProcessingQueue(
+					newProcessingQueueState(
+						1,
+						newTransferTaskKey(0),
+						newTransferTaskKey(0),
+						newTransferTaskKey(100),
+						NewDomainFilter(map[string]struct{}{"testDomain2": {}}, false),
+					),
+					nil,
+					s.logger,
+					s.metricsClient,
+				),
+			}),
+		}
+
+		splitPolicy := NewRandomSplitPolicy(
+			dynamicconfig.GetFloatPropertyFn(1),
+			dynamicconfig.GetBoolPropertyFnFilteredByDomain(true),
+			dynamicconfig.GetIntPropertyFn(2),
+			processorBase.newTaskLevelLookAheadFunc(func(key task.Key, _ string) task.Key {
+				return newTransferTaskKey(key.(transferTaskKey).taskID + 10)
+			}),
+			s.logger,
+			s.metricsScope,
+		)
+		processorBase.splitProcessingQueueCollection(context.Background(), splitPolicy, nil)
+
+		var domain1MaxLevel task.Key
+		domain1AtLevel0 := false
+		for _, state := range processorBase.getProcessingQueueStates().GetStateActionResult.States {
+			if !state.DomainFilter().Filter("testDomain1") {
+				continue
+			}
+			switch state.Level() {
+			case 0:
+				domain1AtLevel0 = true
+			case 1:
+				domain1MaxLevel = state.MaxLevel()
+			}
+		}
+		if tc.splitKey == nil {
+			s.Equal(maximumTransferTaskKey, domain1MaxLevel, tc.strategy)
+			s.False(domain1AtLevel0, tc.strategy)
+		} else {
+			s.Equal(tc.splitKey, domain1MaxLevel, tc.strategy)
+			s.True(domain1AtLevel0, tc.strategy)
+		}
+	}
+}
+
+func (s *processorBaseSuite) TestSplitQueue_CompactAdjacentQueues() {
+	mockQueueSplitPolicy := NewMockProcessingQueueSplitPolicy(s.controller)
+	mockQueueSplitPolicy.EXPECT().Evaluate(gomock.Any()).Return(nil).AnyTimes()
+
 	processingQueueStates := []ProcessingQueueState{
 		NewProcessingQueueState(
-			2,
-			newTimerTaskKey(now.Add(-5*time.Second), 0),
-			newTimerTaskKey(now, 0),
-			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+			0,
+			newTransferTaskKey(0),
+			newTransferTaskKey(100),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, true),
+		),
+		NewProcessingQueueState(
+			0,
+			newTransferTaskKey(100),
+			newTransferTaskKey(1000),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, true),
 		),
 		NewProcessingQueueState(
 			1,
-			newTimerTaskKey(now.Add(-3*time.Second), 0),
-			newTimerTaskKey(now.Add(5*time.Second), 0),
+			newTransferTaskKey(0),
+			newTransferTaskKey(100),
 			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
 		),
 		NewProcessingQueueState(
-			0,
-			newTimerTaskKey(now.Add(-1*time.Second), 0),
-			newTimerTaskKey(now.Add(100*time.Second), 0),
-			NewDomainFilter(map[string]struct{}{"testDomain1": {}, "testDomain2": {}}, true),
+			1,
+			newTransferTaskKey(100),
+			newTransferTaskKey(1000),
+			NewDomainFilter(map[string]struct{}{"testDomain2": {}}, false),
 		),
 	}
-	updateAckLevel := time.Time{}
-	updateTransferAckLevelFn := func(ackLevel task.Key) error {
-		updateAckLevel = ackLevel.(timerTaskKey).visibilityTimestamp
-		return nil
-	}
 
-	timerQueueProcessBase := s.newTestProcessorBase(processingQueueStates, nil, updateTransferAckLevelFn, nil, nil)
-	timerQueueProcessBase.options.EnablePersistQueueStates = dynamicconfig.GetBoolPropertyFn(true)
-	processFinished, err := timerQueueProcessBase.updateAckLevel()
-	s.NoError(err)
-	s.False(processFinished)
-	s.Equal(now.Add(-5*time.Second), updateAckLevel)
+	processorBase := s.newTestProcessorBase(
+		processingQueueStates,
+		nil,
+		nil,
+		nil,
+		nil,
+	)
+
+	processorBase.splitProcessingQueueCollection(
+		context.Background(),
+		mockQueueSplitPolicy,
+		func(level int, pollTime time.Time) {},
+	)
+
+	processingQueueCollections := processorBase.processingQueueCollections
+	sort.Slice(processingQueueCollections, func(i, j int) bool {
+		return processingQueueCollections[i].Level() < processingQueueCollections[j].Level()
+	})
+	s.Len(processingQueueCollections, 2)
+	s.Len(processingQueueCollections[0].Queues(), 1)
+	s.Equal(newProcessingQueueState(
+		0,
+		newTransferTaskKey(0),
+		newTransferTaskKey(0),
+		newTransferTaskKey(1000),
+		NewDomainFilter(map[string]struct{}{"testDomain1": {}}, true),
+	), processingQueueCollections[0].Queues()[0].State())
+	s.Equal(processingQueueCollections[0].Queues()[0], processingQueueCollections[0].ActiveQueue())
+	s.Len(processingQueueCollections[1].Queues(), 2)
 }
 
-func (s *processorBaseSuite) TestUpdateAckLevel_Timer_UpdateQueueStates() {
-	now := time.Now()
+func (s *processorBaseSuite) TestSplitQueue_PolicyEvaluationMetrics() {
+	mockQueueSplitPolicy := NewMockProcessingQueueSplitPolicy(s.controller)
+	mockQueueSplitPolicy.EXPECT().Evaluate(gomock.Any()).Return(nil).AnyTimes()
+	policyName := splitPolicyName(mockQueueSplitPolicy)
+
 	processingQueueStates := []ProcessingQueueState{
 		NewProcessingQueueState(
-			2,
-			newTimerTaskKey(now.Add(-5*time.Second), 0),
-			newTimerTaskKey(now, 0),
-			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+			0,
+			newTransferTaskKey(0),
+			newTransferTaskKey(100),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, true),
 		),
 		NewProcessingQueueState(
 			1,
-			newTimerTaskKey(now.Add(-3*time.Second), 0),
-			newTimerTaskKey(now.Add(5*time.Second), 0),
+			newTransferTaskKey(0),
+			newTransferTaskKey(100),
 			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
 		),
-		NewProcessingQueueState(
-			0,
-			newTimerTaskKey(now.Add(-1*time.Second), 0),
-			newTimerTaskKey(now.Add(100*time.Second), 0),
-			NewDomainFilter(map[string]struct{}{"testDomain1": {}, "testDomain2": {}}, true),
-		),
 	}
 
-	var pState []*h.ProcessingQueueState
-	updateProcessingQueueStates := func(states []ProcessingQueueState) error {
-		pState = convertToPersistenceTimerProcessingQueueStates(states)
-		return nil
+	processorBase := s.newTestProcessorBase(processingQueueStates, nil, nil, nil, nil)
+	testScope := tally.NewTestScope("", nil)
+	processorBase.metricsScope = metrics.NewClient(testScope, metrics.History).Scope(metrics.TransferActiveQueueProcessorScope)
+	var evaluatedPolicies []string
+	processorBase.options.OnSplitPolicyEvaluated = func(name string, _ time.Duration) {
+		evaluatedPolicies = append(evaluatedPolicies, name)
 	}
 
-	timerQueueProcessBase := s.newTestProcessorBase(processingQueueStates, nil, nil, updateProcessingQueueStates, nil)
-	timerQueueProcessBase.options.EnablePersistQueueStates = dynamicconfig.GetBoolPropertyFn(true)
-	processFinished, err := timerQueueProcessBase.updateAckLevel()
+	processorBase.splitProcessingQueueCollection(
+		context.Background(),
+		mockQueueSplitPolicy,
+		func(level int, pollTime time.Time) {},
+	)
+
+	s.Equal([]string{policyName, policyName}, evaluatedPolicies)
+	numTimerSamples := 0
+	for _, timer := range testScope.Snapshot().Timers() {
+		if timer.Name() == "processing_queue_split_policy_evaluation_latency" {
+			s.Equal(policyName, timer.Tags()["splitPolicy"])
+			numTimerSamples += len(timer.Values())
+		}
+	}
+	s.Equal(len(processingQueueStates), numTimerSamples)
+}
+
+func (s *processorBaseSuite) TestSplitQueue_DomainCoverageLost() {
+	processingQueueStates := []ProcessingQueueState{
+		NewProcessingQueueState(
+			0,
+			newTransferTaskKey(0),
+			newTransferTaskKey(100),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}, "testDomain2": {}}, false),
+		),
+	}
+
+	// a buggy policy that peels testDomain1 to a new level but drops testDomain2
+	mockQueueSplitPolicy := NewMockProcessingQueueSplitPolicy(s.controller)
+	mockQueueSplitPolicy.EXPECT().Evaluate(gomock.Any()).Return([]ProcessingQueueState{
+		NewProcessingQueueState(
+			1,
+			newTransferTaskKey(0),
+			newTransferTaskKey(100),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+		),
+	}).Times(1)
+
+	processorBase := s.newTestProcessorBase(processingQueueStates, nil, nil, nil, nil)
+	testScope := tally.NewTestScope("", nil)
+	processorBase.metricsScope = metrics.NewClient(testScope, metrics.History).Scope(metrics.TransferActiveQueueProcessorScope)
+
+	processorBase.splitProcessingQueueCollection(
+		context.Background(),
+		mockQueueSplitPolicy,
+		func(level int, pollTime time.Time) {},
+	)
+
+	var numCoverageLost int64
+	for _, counter := range testScope.Snapshot().Counters() {
+		if counter.Name() == "processing_queue_split_domain_coverage_lost" {
+			numCoverageLost += counter.Value()
+		}
+	}
+	s.Equal(int64(1), numCoverageLost)
+}
+
+func (s *processorBaseSuite) TestCheckSplitDomainCoverage() {
+	testCases := []struct {
+		before      DomainFilter
+		after       DomainFilter
+		expectError bool
+	}{
+		{
+			before: NewDomainFilter(map[string]struct{}{"testDomain1": {}, "testDomain2": {}}, false),
+			after:  NewDomainFilter(map[string]struct{}{"testDomain1": {}, "testDomain2": {}, "testDomain3": {}}, false),
+		},
+		{
+			before:      NewDomainFilter(map[string]struct{}{"testDomain1": {}, "testDomain2": {}}, false),
+			after:       NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+			expectError: true,
+		},
+		{
+			before: NewDomainFilter(map[string]struct{}{"testDomain1": {}}, true),
+			after:  NewDomainFilter(nil, true),
+		},
+		{
+			before:      NewDomainFilter(map[string]struct{}{"testDomain1": {}}, true),
+			after:       NewDomainFilter(map[string]struct{}{"testDomain1": {}, "testDomain2": {}}, true),
+			expectError: true,
+		},
+		{
+			before:      NewDomainFilter(nil, true),
+			after:       NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		err := checkSplitDomainCoverage(0, tc.before, tc.after)
+		if tc.expectError {
+			s.IsType(&SplitDomainCoverageError{}, err)
+		} else {
+			s.NoError(err)
+		}
+	}
+}
+
+func (s *processorBaseSuite) TestCompactorPump_PruneQueues() {
+	processingQueueStates := []ProcessingQueueState{
+		newProcessingQueueState(
+			0,
+			newTransferTaskKey(100),
+			newTransferTaskKey(100),
+			newTransferTaskKey(100),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, true),
+		),
+		NewProcessingQueueState(
+			0,
+			newTransferTaskKey(100),
+			newTransferTaskKey(1000),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, true),
+		),
+		newProcessingQueueState(
+			1,
+			newTransferTaskKey(500),
+			newTransferTaskKey(500),
+			newTransferTaskKey(500),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+		),
+		NewProcessingQueueState(
+			2,
+			newTransferTaskKey(0),
+			newTransferTaskKey(100),
+			NewDomainFilter(map[string]struct{}{"testDomain2": {}}, false),
+		),
+		NewProcessingQueueState(
+			2,
+			newTransferTaskKey(100),
+			newTransferTaskKey(1000),
+			NewDomainFilter(map[string]struct{}{"testDomain2": {}}, false),
+		),
+	}
+
+	processorBase := s.newTestProcessorBase(
+		processingQueueStates,
+		nil,
+		nil,
+		nil,
+		nil,
+	)
+	processorBase.options.EnableBackgroundCompaction = dynamicconfig.GetBoolPropertyFn(true)
+	processorBase.options.BackgroundCompactionInterval = dynamicconfig.GetDurationPropertyFn(time.Millisecond * 10)
+
+	processorBase.shutdownWG.Add(1)
+	go processorBase.compactorPump()
+
+	select {
+	case <-processorBase.compactNotifyCh:
+		processorBase.pruneProcessingQueueCollections()
+	case <-time.After(time.Second):
+		s.Fail("compactor pump should notify the processor pump")
+	}
+
+	close(processorBase.shutdownCh)
+	s.True(common.AwaitWaitGroup(&processorBase.shutdownWG, time.Second))
+
+	processingQueueCollections := processorBase.processingQueueCollections
+	sort.Slice(processingQueueCollections, func(i, j int) bool {
+		return processingQueueCollections[i].Level() < processingQueueCollections[j].Level()
+	})
+	s.Len(processingQueueCollections, 2)
+	s.Equal(0, processingQueueCollections[0].Level())
+	s.Len(processingQueueCollections[0].Queues(), 1)
+	s.Equal(newTransferTaskKey(100), processingQueueCollections[0].Queues()[0].State().AckLevel())
+	s.Equal(2, processingQueueCollections[1].Level())
+	s.Len(processingQueueCollections[1].Queues(), 1)
+	s.Equal(newProcessingQueueState(
+		2,
+		newTransferTaskKey(0),
+		newTransferTaskKey(0),
+		newTransferTaskKey(1000),
+		NewDomainFilter(map[string]struct{}{"testDomain2": {}}, false),
+	), processingQueueCollections[1].Queues()[0].State())
+}
+
+func (s *processorBaseSuite) TestCompactProcessingQueueCollections_Overlap() {
+	processingQueueStates := []ProcessingQueueState{
+		NewProcessingQueueState(
+			1,
+			newTransferTaskKey(0),
+			newTransferTaskKey(100),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+		),
+		NewProcessingQueueState(
+			1,
+			newTransferTaskKey(100),
+			newTransferTaskKey(1000),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+		),
+	}
+
+	processorBase := s.newTestProcessorBase(
+		processingQueueStates,
+		nil,
+		nil,
+		nil,
+		nil,
+	)
+	// overlapping queues can't be loaded, so add them to the collection directly as a buggy merge would
+	overlappingQueues := []ProcessingQueue{}
+	for _, ackLevel := range []int64{0, 50} {
+		overlappingQueues = append(overlappingQueues, newProcessingQueue(
+			NewProcessingQueueState(
+				0,
+				newTransferTaskKey(ackLevel),
+				newTransferTaskKey(1000),
+				NewDomainFilter(map[string]struct{}{"testDomain1": {}}, true),
+			),
+			nil,
+			s.logger,
+			s.metricsClient,
+		))
+	}
+	processorBase.processingQueueCollections = append(
+		[]ProcessingQueueCollection{NewProcessingQueueCollection(0, overlappingQueues)},
+		processorBase.processingQueueCollections...,
+	)
+
+	err := processorBase.compactProcessingQueueCollections()
+	overlapErr, ok := err.(*ProcessingQueueOverlapError)
+	s.True(ok)
+	s.Equal(0, overlapErr.Level)
+	s.Equal(newTransferTaskKey(0), overlapErr.Previous.AckLevel())
+	s.Equal(newTransferTaskKey(50), overlapErr.Next.AckLevel())
+
+	processingQueueCollections := processorBase.processingQueueCollections
+	s.Len(processingQueueCollections, 2)
+	s.Equal(0, processingQueueCollections[0].Level())
+	s.Len(processingQueueCollections[0].Queues(), 2)
+	s.Equal(1, processingQueueCollections[1].Level())
+	s.Len(processingQueueCollections[1].Queues(), 1)
+}
+
+func (s *processorBaseSuite) TestProcessingQueueCollections_Sorted() {
+	processingQueueStates := []ProcessingQueueState{
+		NewProcessingQueueState(
+			2,
+			newTransferTaskKey(100),
+			newTransferTaskKey(1000),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+		),
+		NewProcessingQueueState(
+			0,
+			newTransferTaskKey(500),
+			newTransferTaskKey(1000),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, true),
+		),
+		NewProcessingQueueState(
+			1,
+			newTransferTaskKey(0),
+			newTransferTaskKey(100),
+			NewDomainFilter(map[string]struct{}{"testDomain2": {}}, false),
+		),
+		NewProcessingQueueState(
+			0,
+			newTransferTaskKey(0),
+			newTransferTaskKey(500),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, true),
+		),
+		NewProcessingQueueState(
+			2,
+			newTransferTaskKey(0),
+			newTransferTaskKey(100),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+		),
+	}
+
+	processorBase := s.newTestProcessorBase(processingQueueStates, nil, nil, nil, nil)
+
+	var levels []int
+	for _, queueCollection := range processorBase.processingQueueCollections {
+		levels = append(levels, queueCollection.Level())
+		queues := queueCollection.Queues()
+		for idx := 1; idx < len(queues); idx++ {
+			s.True(queues[idx-1].State().AckLevel().Less(queues[idx].State().AckLevel()))
+		}
+	}
+	s.Equal([]int{0, 1, 2}, levels)
+
+	states := processorBase.getProcessingQueueStates().GetStateActionResult.States
+	s.Len(states, len(processingQueueStates))
+	for idx := 1; idx < len(states); idx++ {
+		if states[idx-1].Level() == states[idx].Level() {
+			s.True(states[idx-1].AckLevel().Less(states[idx].AckLevel()))
+		} else {
+			s.True(states[idx-1].Level() < states[idx].Level())
+		}
+	}
+}
+
+func (s *processorBaseSuite) TestSplitQueue_MinPollInterval() {
+	mockQueueSplitPolicy := NewMockProcessingQueueSplitPolicy(s.controller)
+	mockQueueSplitPolicy.EXPECT().Evaluate(gomock.Any()).Return(nil).AnyTimes()
+
+	processingQueueStates := []ProcessingQueueState{
+		// idle queue, caught up with max read level
+		newProcessingQueueState(
+			0,
+			newTransferTaskKey(0),
+			newTransferTaskKey(100),
+			newTransferTaskKey(1000),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, true),
+		),
+		// busy queue, has pending tasks to read
+		newProcessingQueueState(
+			1,
+			newTransferTaskKey(0),
+			newTransferTaskKey(50),
+			newTransferTaskKey(1000),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+		),
+	}
+	updateMaxReadLevel := func() task.Key {
+		return newTransferTaskKey(100)
+	}
+
+	processorBase := s.newTestProcessorBase(
+		processingQueueStates,
+		updateMaxReadLevel,
+		nil,
+		nil,
+		nil,
+	)
+	minPollInterval := time.Minute
+	processorBase.options.MinPollInterval = dynamicconfig.GetDurationPropertyFn(minPollInterval)
+
+	now := s.mockShard.GetTimeSource().Now()
+	nextPollTime := make(map[int]time.Time)
+	processorBase.splitProcessingQueueCollection(
+		context.Background(),
+		mockQueueSplitPolicy,
+		func(level int, pollTime time.Time) {
+			nextPollTime[level] = pollTime
+		},
+	)
+
+	s.Len(nextPollTime, 2)
+	s.False(nextPollTime[0].Before(now.Add(minPollInterval)))
+	s.Zero(nextPollTime[1])
+}
+
+func (s *processorBaseSuite) TestRecomputeAckLevel() {
+	processingQueueStates := []ProcessingQueueState{
+		NewProcessingQueueState(
+			0,
+			newTransferTaskKey(100),
+			newTransferTaskKey(1000),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, true),
+		),
+		NewProcessingQueueState(
+			1,
+			newTransferTaskKey(20),
+			newTransferTaskKey(1000),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+		),
+	}
+	var persistedAckLevel task.Key
+	updateClusterAckLevel := func(ackLevel task.Key) error {
+		persistedAckLevel = ackLevel
+		return nil
+	}
+
+	processorBase := s.newTestProcessorBase(processingQueueStates, nil, updateClusterAckLevel, nil, nil)
+	// inject an ack level that is inconsistent with processing queue states
+	processorBase.lastAckLevel = newTransferTaskKey(500)
+	processorBase.numStuckAckLevelUpdates = 10
+
+	ackLevel, err := processorBase.RecomputeAckLevel()
+	s.NoError(err)
+	s.Equal(newTransferTaskKey(20), ackLevel)
+	s.Equal(newTransferTaskKey(20), persistedAckLevel)
+	s.Equal(newTransferTaskKey(20), processorBase.lastAckLevel)
+	s.Zero(processorBase.numStuckAckLevelUpdates)
+
+	errPersistence := errors.New("some random error")
+	processorBase.updateClusterAckLevel = func(task.Key) error {
+		return errPersistence
+	}
+	_, err = processorBase.RecomputeAckLevel()
+	s.Equal(errPersistence, err)
+
+	processorBase.processingQueueCollections = nil
+	_, err = processorBase.RecomputeAckLevel()
+	s.Error(err)
+}
+
+func (s *processorBaseSuite) TestRecomputeAckLevel_PersistQueueStates() {
+	processingQueueStates := []ProcessingQueueState{
+		NewProcessingQueueState(
+			0,
+			newTransferTaskKey(100),
+			newTransferTaskKey(1000),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, true),
+		),
+		NewProcessingQueueState(
+			1,
+			newTransferTaskKey(20),
+			newTransferTaskKey(1000),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+		),
+	}
+	updateClusterAckLevel := func(task.Key) error {
+		s.Fail("cluster ack level should not be updated when processing queue states are persisted")
+		return nil
+	}
+	var persistedStates []ProcessingQueueState
+	updateProcessingQueueStates := func(states []ProcessingQueueState) error {
+		persistedStates = states
+		return nil
+	}
+
+	processorBase := s.newTestProcessorBase(processingQueueStates, nil, updateClusterAckLevel, updateProcessingQueueStates, nil)
+	processorBase.options.EnablePersistQueueStates = dynamicconfig.GetBoolPropertyFn(true)
+	processorBase.lastAckLevel = newTransferTaskKey(500)
+
+	ackLevel, err := processorBase.RecomputeAckLevel()
+	s.NoError(err)
+	s.Equal(newTransferTaskKey(20), ackLevel)
+	s.Len(persistedStates, 2)
+	for idx, state := range persistedStates {
+		s.Equal(processingQueueStates[idx].AckLevel(), state.AckLevel())
+	}
+	s.Equal(newTransferTaskKey(20), processorBase.persistedAckLevel)
+
+	errPersistence := errors.New("some random error")
+	processorBase.updateProcessingQueueStates = func([]ProcessingQueueState) error {
+		return errPersistence
+	}
+	_, err = processorBase.RecomputeAckLevel()
+	s.Equal(errPersistence, err)
+}
+
+func (s *processorBaseSuite) TestReconcile() {
+	processingQueueStates := []ProcessingQueueState{
+		NewProcessingQueueState(
+			0,
+			newTransferTaskKey(100),
+			newTransferTaskKey(1000),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, true),
+		),
+		NewProcessingQueueState(
+			1,
+			newTransferTaskKey(100),
+			newTransferTaskKey(1000),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+		),
+	}
+	updateMaxReadLevel := func() task.Key {
+		return newTransferTaskKey(500)
+	}
+
+	processorBase := s.newTestProcessorBase(processingQueueStates, updateMaxReadLevel, nil, nil, nil)
+	s.Equal(errReconcileNotSupported, processorBase.Reconcile(context.Background()))
+
+	persistedStates := []ProcessingQueueState{
+		NewProcessingQueueState(
+			0,
+			newTransferTaskKey(50),
+			newTransferTaskKey(maximumTransferTaskKey.(transferTaskKey).taskID),
+			NewDomainFilter(nil, true),
+		),
+	}
+	processorBase.options.LoadProcessingQueueStates = func() []ProcessingQueueState {
+		return persistedStates
+	}
+	// simulate in-memory states diverged from persistence
+	processorBase.processingQueueCollections[0].Queues()[0].(*processingQueueImpl).state.readLevel = newTransferTaskKey(800)
+	processorBase.lastAckLevel = newTransferTaskKey(100)
+	processorBase.numStuckAckLevelUpdates = 5
+
+	var actionResult *ActionResult
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		// act as the processor pump and record the action result
+		notification := <-processorBase.actionNotifyCh
+		resultNotificationCh := make(chan actionResultNotification, 1)
+		processorBase.handleActionNotification(actionNotification{
+			action:               notification.action,
+			resultNotificationCh: resultNotificationCh,
+		}, func() {})
+		resultNotification := <-resultNotificationCh
+		actionResult = resultNotification.result
+		notification.resultNotificationCh <- resultNotification
+	}()
+	s.NoError(processorBase.Reconcile(context.Background()))
+	<-doneCh
+	s.Equal(3, actionResult.ReconcileResult.NumDiscrepancies)
+
+	states := processorBase.getProcessingQueueStates().GetStateActionResult.States
+	s.Len(states, 1)
+	s.Equal(0, states[0].Level())
+	s.Equal(newTransferTaskKey(50), states[0].AckLevel())
+	s.Equal(newTransferTaskKey(50), states[0].ReadLevel())
+	s.Equal(persistedStates[0].MaxLevel(), states[0].MaxLevel())
+	s.Equal(newTransferTaskKey(50), processorBase.lastAckLevel)
+	s.Zero(processorBase.numStuckAckLevelUpdates)
+
+	actionResult, err := processorBase.reconcileProcessingQueueStates()
+	s.NoError(err)
+	s.Zero(actionResult.ReconcileResult.NumDiscrepancies)
+}
+
+func (s *processorBaseSuite) TestCollapseLevel() {
+	processingQueueStates := []ProcessingQueueState{
+		NewProcessingQueueState(
+			0,
+			newTransferTaskKey(0),
+			newTransferTaskKey(1000),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}, "testDomain2": {}}, true),
+		),
+		NewProcessingQueueState(
+			1,
+			newTransferTaskKey(0),
+			newTransferTaskKey(1000),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+		),
+		NewProcessingQueueState(
+			2,
+			newTransferTaskKey(0),
+			newTransferTaskKey(1000),
+			NewDomainFilter(map[string]struct{}{"testDomain2": {}}, false),
+		),
+	}
+
+	processorBase := s.newTestProcessorBase(
+		processingQueueStates,
+		nil,
+		nil,
+		nil,
+		nil,
+	)
+
+	err := processorBase.CollapseLevel(defaultProcessingQueueLevel)
+	s.IsType(&CollapseLevelError{}, err)
+	err = processorBase.CollapseLevel(3)
+	s.IsType(&CollapseLevelError{}, err)
+
+	err = processorBase.CollapseLevel(2)
+	s.NoError(err)
+
+	processingQueueCollections := processorBase.processingQueueCollections
+	sort.Slice(processingQueueCollections, func(i, j int) bool {
+		return processingQueueCollections[i].Level() < processingQueueCollections[j].Level()
+	})
+	s.Len(processingQueueCollections, 2)
+	s.Equal(0, processingQueueCollections[0].Level())
+	s.Equal(1, processingQueueCollections[1].Level())
+
+	// all domains should still be covered by the same task key range
+	for _, domainID := range []string{"testDomain1", "testDomain2", "testDomain3"} {
+		for taskID := int64(1); taskID != 1000; taskID++ {
+			covered := false
+			for _, queueCollection := range processingQueueCollections {
+				for _, queue := range queueCollection.Queues() {
+					state := queue.State()
+					if state.DomainFilter().Filter(domainID) &&
+						state.AckLevel().Less(newTransferTaskKey(taskID)) &&
+						!state.MaxLevel().Less(newTransferTaskKey(taskID)) {
+						covered = true
+					}
+				}
+			}
+			s.True(covered, "domain %v task %v is not covered", domainID, taskID)
+		}
+	}
+	for _, queue := range processingQueueCollections[1].Queues() {
+		s.Equal(1, queue.State().Level())
+		s.True(queue.State().DomainFilter().Filter("testDomain2"))
+	}
+
+	// the priority of collapsed queues should be kept
+	processorBase = s.newTestProcessorBase(
+		[]ProcessingQueueState{
+			NewProcessingQueueState(
+				0,
+				newTransferTaskKey(0),
+				newTransferTaskKey(1000),
+				NewDomainFilter(nil, true),
+			),
+			NewProcessingQueueStateWithPriority(
+				1,
+				newTransferTaskKey(1000),
+				newTransferTaskKey(2000),
+				NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+				5,
+			),
+		},
+		nil,
+		nil,
+		nil,
+		nil,
+	)
+	s.NoError(processorBase.CollapseLevel(1))
+	s.Len(processorBase.processingQueueCollections, 1)
+	collapsed := false
+	for _, queue := range processorBase.processingQueueCollections[0].Queues() {
+		s.Equal(0, queue.State().Level())
+		if queue.State().AckLevel() == newTransferTaskKey(1000) {
+			s.Equal(5, queue.State().Priority())
+			collapsed = true
+		}
+	}
+	s.True(collapsed)
+}
+
+func (s *processorBaseSuite) TestCollapseLevel_PinnedDomain() {
+	processingQueueStates := []ProcessingQueueState{
+		NewProcessingQueueState(
+			0,
+			newTransferTaskKey(0),
+			newTransferTaskKey(1000),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, true),
+		),
+		NewProcessingQueueState(
+			1,
+			newTransferTaskKey(0),
+			newTransferTaskKey(1000),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+		),
+	}
+	s.mockShard.GetConfig().QueueProcessorPinnedDomainLevels = dynamicconfig.GetMapPropertyFn(
+		map[string]interface{}{"testDomain2": 2},
+	)
+	processorBase := s.newTestProcessorBase(processingQueueStates, nil, nil, nil, nil)
+
+	domainLevels := func(domainID string) []int {
+		var levels []int
+		for _, queueCollection := range processorBase.processingQueueCollections {
+			for _, queue := range queueCollection.Queues() {
+				if queue.State().DomainFilter().Filter(domainID) {
+					levels = append(levels, queue.State().Level())
+					break
+				}
+			}
+		}
+		sort.Ints(levels)
+		return levels
+	}
+
+	// pinned domain is moved to its level on startup
+	s.Equal([]int{2}, domainLevels("testDomain2"))
+	s.Equal([]int{1}, domainLevels("testDomain1"))
+	s.Equal([]int{0}, domainLevels("testDomain3"))
+
+	// collapsing the pinned level doesn't reabsorb the pinned domain
+	s.NoError(processorBase.CollapseLevel(2))
+	s.Equal([]int{2}, domainLevels("testDomain2"))
+	s.Equal([]int{1}, domainLevels("testDomain1"))
+
+	s.NoError(processorBase.CollapseLevel(1))
+	s.Equal([]int{2}, domainLevels("testDomain2"))
+	s.Equal([]int{0}, domainLevels("testDomain1"))
+
+	// split policies can't move the pinned domain off its level either
+	splitPolicy := NewSelectedDomainSplitPolicy(
+		map[string]struct{}{"testDomain2": {}},
+		3,
+		s.logger,
+		s.metricsClient.Scope(metrics.TransferActiveQueueProcessorScope),
+	)
+	processorBase.splitProcessingQueueCollection(context.Background(), splitPolicy, nil)
+	s.Equal([]int{2}, domainLevels("testDomain2"))
+	s.Equal([]int{0}, domainLevels("testDomain1"))
+}
+
+func (s *processorBaseSuite) TestUpdateAckLevel_Transfer_ProcessedFinished() {
+	processingQueueStates := []ProcessingQueueState{
+		NewProcessingQueueState(
+			2,
+			newTransferTaskKey(100),
+			newTransferTaskKey(100),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+		),
+		NewProcessingQueueState(
+			0,
+			newTransferTaskKey(1000),
+			newTransferTaskKey(1000),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}, "testDomain2": {}}, true),
+		),
+	}
+	queueShutdown := false
+	queueShutdownFn := func() error {
+		queueShutdown = true
+		return nil
+	}
+
+	processorBase := s.newTestProcessorBase(
+		processingQueueStates,
+		nil,
+		nil,
+		nil,
+		queueShutdownFn,
+	)
+
+	processFinished, err := processorBase.updateAckLevel(context.Background())
+	s.NoError(err)
+	s.True(processFinished)
+	s.True(queueShutdown)
+}
+
+func (s *processorBaseSuite) TestUpdateAckLevel_Transfer_ShutdownTimeout() {
+	processingQueueStates := []ProcessingQueueState{
+		NewProcessingQueueState(
+			0,
+			newTransferTaskKey(1000),
+			newTransferTaskKey(1000),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+		),
+	}
+	blockCh := make(chan struct{})
+	defer close(blockCh)
+	queueShutdownFn := func() error {
+		<-blockCh
+		return nil
+	}
+
+	processorBase := s.newTestProcessorBase(
+		processingQueueStates,
+		nil,
+		nil,
+		nil,
+		queueShutdownFn,
+	)
+	shutdownTimeout := 100 * time.Millisecond
+	processorBase.options.ShutdownTimeout = dynamicconfig.GetDurationPropertyFn(shutdownTimeout)
+
+	startTime := time.Now()
+	processFinished, err := processorBase.updateAckLevel(context.Background())
+	s.Equal(errQueueShutdownTimeout, err)
+	s.True(processFinished)
+	s.True(time.Since(startTime) >= shutdownTimeout)
+}
+
+func (s *processorBaseSuite) TestUpdateAckLevel_Transfer_TaskKeyTypeMismatch() {
+	processingQueueStates := []ProcessingQueueState{
+		NewProcessingQueueState(
+			0,
+			newTimerTaskKey(time.Unix(0, 0), 0),
+			newTimerTaskKey(time.Unix(0, 1000), 0),
+			NewDomainFilter(nil, true),
+		),
+	}
+	updateTransferAckLevelFn := func(ackLevel task.Key) error {
+		s.Fail("ack level should not be updated")
+		return nil
+	}
+
+	processorBase := s.newTestProcessorBase(
+		processingQueueStates,
+		nil,
+		updateTransferAckLevelFn,
+		nil,
+		nil,
+	)
+
+	processFinished, err := processorBase.updateAckLevel(context.Background())
+	s.False(processFinished)
+	s.IsType(&TaskKeyTypeMismatchError{}, err)
+	mismatchErr := err.(*TaskKeyTypeMismatchError)
+	s.Equal("queue.transferTaskKey", mismatchErr.ExpectedType)
+	s.Equal("queue.timerTaskKey", mismatchErr.ActualType)
+}
+
+func (s *processorBaseSuite) TestUpdateAckLevel_Tranfer_ProcessNotFinished() {
+	processingQueueStates := []ProcessingQueueState{
+		NewProcessingQueueState(
+			2,
+			newTransferTaskKey(5),
+			newTransferTaskKey(100),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+		),
+		NewProcessingQueueState(
+			1,
+			newTransferTaskKey(2),
+			newTransferTaskKey(100),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+		),
+		NewProcessingQueueState(
+			0,
+			newTransferTaskKey(100),
+			newTransferTaskKey(1000),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}, "testDomain2": {}}, true),
+		),
+	}
+	updateAckLevel := int64(0)
+	updateTransferAckLevelFn := func(ackLevel task.Key) error {
+		updateAckLevel = ackLevel.(transferTaskKey).taskID
+		return nil
+	}
+
+	processorBase := s.newTestProcessorBase(
+		processingQueueStates,
+		nil,
+		updateTransferAckLevelFn,
+		nil,
+		nil,
+	)
+
+	processFinished, err := processorBase.updateAckLevel(context.Background())
+	s.NoError(err)
+	s.False(processFinished)
+	s.Equal(int64(2), updateAckLevel)
+}
+
+func (s *processorBaseSuite) TestUpdateAckLevel_CustomTaskKey() {
+	newTask := func(state t.State) task.Task {
+		mockTask := task.NewMockTask(s.controller)
+		mockTask.EXPECT().State().Return(state).AnyTimes()
+		mockTask.EXPECT().GetDomainID().Return("testDomain1").AnyTimes()
+		mockTask.EXPECT().GetTaskType().Return(0).AnyTimes()
+		return mockTask
+	}
+	processingQueueStates := []ProcessingQueueState{
+		newProcessingQueueState(
+			0,
+			testTaskKey{version: 1, id: 0},
+			testTaskKey{version: 1, id: 10},
+			testTaskKey{version: 2, id: 0},
+			NewDomainFilter(nil, true),
+		),
+		newProcessingQueueState(
+			1,
+			testTaskKey{version: 1, id: 6},
+			testTaskKey{version: 1, id: 6},
+			testTaskKey{version: 2, id: 0},
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+		),
+	}
+	var updatedAckLevel task.Key
+	updateClusterAckLevel := func(ackLevel task.Key) error {
+		updatedAckLevel = ackLevel
+		return nil
+	}
+
+	processorBase := s.newTestProcessorBase(nil, nil, updateClusterAckLevel, nil, nil)
+	processorBase.options.MetricScope = metrics.ReplicatorQueueProcessorScope
+	processorBase.processingQueueCollections = newProcessingQueueCollections(processingQueueStates, s.logger, s.metricsClient)
+	processorBase.processingQueueCollections[0].Queues()[0].AddTasks(map[task.Key]task.Task{
+		testTaskKey{version: 1, id: 5}: newTask(t.TaskStateAcked),
+		testTaskKey{version: 1, id: 7}: newTask(t.TaskStatePending),
+	}, testTaskKey{version: 1, id: 10})
+
+	processFinished, err := processorBase.updateAckLevel(context.Background())
+	s.NoError(err)
+	s.False(processFinished)
+	s.Equal(testTaskKey{version: 1, id: 5}, updatedAckLevel)
+}
+
+func (s *processorBaseSuite) TestUpdateAckLevel_Timer_UpdateAckLevel() {
+	now := time.Now()
+	processingQueueStates := []ProcessingQueueState{
+		NewProcessingQueueState(
+			2,
+			newTimerTaskKey(now.Add(-5*time.Second), 0),
+			newTimerTaskKey(now, 0),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+		),
+		NewProcessingQueueState(
+			1,
+			newTimerTaskKey(now.Add(-3*time.Second), 0),
+			newTimerTaskKey(now.Add(5*time.Second), 0),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+		),
+		NewProcessingQueueState(
+			0,
+			newTimerTaskKey(now.Add(-1*time.Second), 0),
+			newTimerTaskKey(now.Add(100*time.Second), 0),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}, "testDomain2": {}}, true),
+		),
+	}
+	updateAckLevel := time.Time{}
+	updateTransferAckLevelFn := func(ackLevel task.Key) error {
+		updateAckLevel = ackLevel.(timerTaskKey).visibilityTimestamp
+		return nil
+	}
+
+	timerQueueProcessBase := s.newTestProcessorBase(processingQueueStates, nil, updateTransferAckLevelFn, nil, nil)
+	timerQueueProcessBase.options.MetricScope = metrics.TimerActiveQueueProcessorScope
+	timerQueueProcessBase.options.EnablePersistQueueStates = dynamicconfig.GetBoolPropertyFn(true)
+	processFinished, err := timerQueueProcessBase.updateAckLevel(context.Background())
+	s.NoError(err)
+	s.False(processFinished)
+	s.Equal(now.Add(-5*time.Second), updateAckLevel)
+}
+
+func (s *processorBaseSuite) TestUpdateAckLevel_Timer_UpdateQueueStates() {
+	now := time.Now()
+	processingQueueStates := []ProcessingQueueState{
+		NewProcessingQueueState(
+			2,
+			newTimerTaskKey(now.Add(-5*time.Second), 0),
+			newTimerTaskKey(now, 0),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+		),
+		NewProcessingQueueState(
+			1,
+			newTimerTaskKey(now.Add(-3*time.Second), 0),
+			newTimerTaskKey(now.Add(5*time.Second), 0),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+		),
+		NewProcessingQueueState(
+			0,
+			newTimerTaskKey(now.Add(-1*time.Second), 0),
+			newTimerTaskKey(now.Add(100*time.Second), 0),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}, "testDomain2": {}}, true),
+		),
+	}
+
+	var pState []*h.ProcessingQueueState
+	updateProcessingQueueStates := func(states []ProcessingQueueState) error {
+		pState = convertToPersistenceTimerProcessingQueueStates(states)
+		return nil
+	}
+
+	timerQueueProcessBase := s.newTestProcessorBase(processingQueueStates, nil, nil, updateProcessingQueueStates, nil)
+	timerQueueProcessBase.options.MetricScope = metrics.TimerActiveQueueProcessorScope
+	timerQueueProcessBase.options.EnablePersistQueueStates = dynamicconfig.GetBoolPropertyFn(true)
+	processFinished, err := timerQueueProcessBase.updateAckLevel(context.Background())
+	s.NoError(err)
+	s.False(processFinished)
+	s.Equal(len(processingQueueStates), len(pState))
+}
+
+func (s *processorBaseSuite) TestGetReadBackoffDuration() {
+	maxPollRPS := 10
+	now := time.Now()
+	timeSource := clock.NewEventTimeSource().Update(now)
+	s.mockShard.Resource.TimeSource = timeSource
+	processorBase := s.newTestProcessorBase(nil, nil, nil, nil, nil)
+	processorBase.options.MaxPollRPSByLevel = dynamicconfig.GetMapPropertyFn(
+		common.ConvertIntMapToDynamicConfigMapProperty(map[int]int{1: maxPollRPS}),
+	)
+
+	// level without rps limit should never be throttled
+	for i := 0; i != 100; i++ {
+		s.Zero(processorBase.getReadBackoffDuration(0))
+	}
+
+	testDuration := 500 * time.Millisecond
+	numReads := 0
+	currentTime := now
+	for currentTime.Sub(now) < testDuration {
+		backoffDuration := processorBase.getReadBackoffDuration(1)
+		if backoffDuration == 0 {
+			numReads++
+			continue
+		}
+		s.True(backoffDuration <= time.Second/time.Duration(maxPollRPS))
+		currentTime = currentTime.Add(backoffDuration)
+		timeSource.Update(currentTime)
+	}
+
+	// rate limiter allows a burst of maxPollRPS reads
+	maxExpectedReads := int(currentTime.Sub(now).Seconds()*float64(maxPollRPS)) + maxPollRPS
+	s.True(numReads > maxPollRPS)
+	s.True(numReads <= maxExpectedReads, "read %v times, expected at most %v", numReads, maxExpectedReads)
+}
+
+func (s *processorBaseSuite) TestGetReadBackoffDuration_AckReadGap() {
+	taskStates := make(map[int64]t.State)
+	outstandingTasks := make(map[task.Key]task.Task)
+	for taskID := int64(1); taskID <= 3; taskID++ {
+		taskID := taskID
+		taskStates[taskID] = t.TaskStatePending
+		mockTask := task.NewMockTask(s.controller)
+		mockTask.EXPECT().State().DoAndReturn(func() t.State {
+			return taskStates[taskID]
+		}).AnyTimes()
+		outstandingTasks[newTransferTaskKey(taskID)] = mockTask
+	}
+	processingQueueStates := []ProcessingQueueState{
+		newProcessingQueueState(
+			0,
+			newTransferTaskKey(0),
+			newTransferTaskKey(3),
+			newTransferTaskKey(100),
+			NewDomainFilter(nil, true),
+		),
+	}
+	processorBase := s.newTestProcessorBase(processingQueueStates, nil, nil, nil, nil)
+	processorBase.processingQueueCollections = []ProcessingQueueCollection{
+		NewProcessingQueueCollection(0, []ProcessingQueue{
+			newProcessingQueue(processingQueueStates[0], outstandingTasks, s.logger, s.metricsClient),
+		}),
+	}
+	processorBase.options.MaxAckReadGap = dynamicconfig.GetIntPropertyFn(2)
+	processorBase.options.PollBackoffInterval = dynamicconfig.GetDurationPropertyFn(time.Second)
+
+	// reads are paused as 3 tasks are loaded but not acked
+	s.Equal(3, processorBase.getAckReadGap(0))
+	s.NotZero(processorBase.getReadBackoffDuration(0))
+	// other levels are not affected
+	s.Zero(processorBase.getReadBackoffDuration(1))
+
+	// acking tasks doesn't resume reads until the ack level catches up
+	taskStates[1] = t.TaskStateAcked
+	taskStates[2] = t.TaskStateAcked
+	s.NotZero(processorBase.getReadBackoffDuration(0))
+
+	processorBase.processingQueueCollections[0].UpdateAckLevels()
+	s.Equal(1, processorBase.getAckReadGap(0))
+	s.Zero(processorBase.getReadBackoffDuration(0))
+}
+
+func (s *processorBaseSuite) TestPendingTaskCountByDomain() {
+	taskStatesByDomain := map[string][]t.State{
+		"testDomain1": {t.TaskStatePending, t.TaskStateAcked, t.TaskStatePending},
+		"testDomain2": {t.TaskStateNacked},
+		"testDomain3": {t.TaskStateAcked},
+	}
+	processorBase := s.newTestProcessorBase(nil, nil, nil, nil, nil)
+
+	taskID := int64(0)
+	queuesByLevel := make(map[int][]ProcessingQueue)
+	for domainID, taskStates := range taskStatesByDomain {
+		for _, taskState := range taskStates {
+			mockTask := task.NewMockTask(s.controller)
+			mockTask.EXPECT().GetDomainID().Return(domainID).AnyTimes()
+			mockTask.EXPECT().State().Return(taskState).AnyTimes()
+
+			// put each task in a separate queue and collection to verify the aggregation
+			level := int(taskID % 2)
+			queuesByLevel[level] = append(queuesByLevel[level], newProcessingQueue(
+				NewProcessingQueueState(
+					level,
+					newTransferTaskKey(taskID),
+					newTransferTaskKey(taskID+1),
+					NewDomainFilter(nil, true),
+				),
+				map[task.Key]task.Task{newTransferTaskKey(taskID + 1): mockTask},
+				s.logger,
+				s.metricsClient,
+			))
+			taskID++
+		}
+	}
+	for level, queues := range queuesByLevel {
+		processorBase.processingQueueCollections = append(
+			processorBase.processingQueueCollections,
+			NewProcessingQueueCollection(level, queues),
+		)
+	}
+
+	s.Equal(map[string]int{
+		"testDomain1": 2,
+		"testDomain2": 1,
+	}, processorBase.PendingTaskCountByDomain())
+}
+
+func (s *processorBaseSuite) TestTasksInKeyRange() {
+	processorBase := s.newTestProcessorBase(nil, nil, nil, nil, nil)
+	processorBase.options.MetricScope = metrics.TransferActiveQueueProcessorScope
+	s.Empty(processorBase.TasksInKeyRange(newTransferTaskKey(0), newTransferTaskKey(100)))
+
+	mockTasks := make(map[int64]*task.MockTask)
+	newQueue := func(level int, ackLevel int64, maxLevel int64, taskStates map[int64]t.State) ProcessingQueue {
+		outstandingTasks := make(map[task.Key]task.Task)
+		for taskID, taskState := range taskStates {
+			mockTask := task.NewMockTask(s.controller)
+			mockTask.EXPECT().State().Return(taskState).AnyTimes()
+			outstandingTasks[newTransferTaskKey(taskID)] = mockTask
+			mockTasks[taskID] = mockTask
+		}
+		return newProcessingQueue(
+			NewProcessingQueueState(
+				level,
+				newTransferTaskKey(ackLevel),
+				newTransferTaskKey(maxLevel),
+				NewDomainFilter(nil, true),
+			),
+			outstandingTasks,
+			s.logger,
+			s.metricsClient,
+		)
+	}
+	processorBase.processingQueueCollections = []ProcessingQueueCollection{
+		NewProcessingQueueCollection(0, []ProcessingQueue{
+			newQueue(0, 0, 50, map[int64]t.State{
+				5:  t.TaskStatePending,
+				20: t.TaskStateAcked,
+				30: t.TaskStatePending,
+			}),
+			newQueue(0, 50, 100, map[int64]t.State{
+				60: t.TaskStateNacked,
+				90: t.TaskStatePending,
+			}),
+		}),
+		NewProcessingQueueCollection(1, []ProcessingQueue{
+			newQueue(1, 0, 100, map[int64]t.State{
+				10: t.TaskStatePending,
+				40: t.TaskStatePending,
+				70: t.TaskStatePending,
+			}),
+		}),
+	}
+
+	tasks := processorBase.TasksInKeyRange(newTransferTaskKey(10), newTransferTaskKey(70))
+	s.Equal([]task.Task{
+		mockTasks[10],
+		mockTasks[30],
+		mockTasks[40],
+		mockTasks[60],
+	}, tasks)
+
+	s.Empty(processorBase.TasksInKeyRange(newTransferTaskKey(70), newTransferTaskKey(70)))
+	s.Nil(processorBase.TasksInKeyRange(newTimerTaskKey(time.Time{}, 0), newTimerTaskKey(time.Now(), 0)))
+}
+
+func (s *processorBaseSuite) TestNextFireTime() {
+	processorBase := s.newTestProcessorBase(nil, nil, nil, nil, nil)
+	_, ok := processorBase.NextFireTime()
+	s.False(ok)
+
+	now := time.Now()
+	newQueue := func(level int, taskStates map[time.Duration]t.State) ProcessingQueue {
+		outstandingTasks := make(map[task.Key]task.Task)
+		for delay, taskState := range taskStates {
+			mockTask := task.NewMockTask(s.controller)
+			mockTask.EXPECT().State().Return(taskState).AnyTimes()
+			outstandingTasks[newTimerTaskKey(now.Add(delay), int64(delay))] = mockTask
+		}
+		return newProcessingQueue(
+			newProcessingQueueState(
+				level,
+				newTimerTaskKey(now, 0),
+				newTimerTaskKey(now.Add(time.Hour), 0),
+				newTimerTaskKey(now.Add(time.Hour), 0),
+				NewDomainFilter(nil, true),
+			),
+			outstandingTasks,
+			s.logger,
+			s.metricsClient,
+		)
+	}
+	processorBase.processingQueueCollections = []ProcessingQueueCollection{
+		NewProcessingQueueCollection(0, []ProcessingQueue{
+			newQueue(0, map[time.Duration]t.State{
+				time.Second:     t.TaskStateAcked,
+				5 * time.Second: t.TaskStatePending,
+			}),
+		}),
+		NewProcessingQueueCollection(1, []ProcessingQueue{
+			newQueue(1, map[time.Duration]t.State{
+				3 * time.Second:  t.TaskStateNacked,
+				10 * time.Second: t.TaskStatePending,
+			}),
+		}),
+	}
+
+	nextFireTime, ok := processorBase.NextFireTime()
+	s.True(ok)
+	s.True(now.Add(3 * time.Second).Equal(nextFireTime))
+}
+
+func (s *processorBaseSuite) TestUpdateAckLevel_LockHoldLatency() {
+	processingQueueStates := []ProcessingQueueState{
+		NewProcessingQueueState(
+			0,
+			newTransferTaskKey(0),
+			newTransferTaskKey(100),
+			NewDomainFilter(nil, true),
+		),
+	}
+	updateClusterAckLevel := func(task.Key) error {
+		return nil
+	}
+
+	processorBase := s.newTestProcessorBase(processingQueueStates, nil, updateClusterAckLevel, nil, nil)
+	testScope := tally.NewTestScope("", nil)
+	processorBase.metricsScope = metrics.NewClient(testScope, metrics.History).Scope(metrics.TransferActiveQueueProcessorScope)
+	processorBase.options.LockMetricsSampleRate = dynamicconfig.GetFloatPropertyFn(1)
+
+	_, err := processorBase.updateAckLevel(context.Background())
+	s.NoError(err)
+
+	recorded := false
+	for _, timer := range testScope.Snapshot().Timers() {
+		if timer.Name() == "processing_queue_lock_hold_latency" &&
+			timer.Tags()["lockOperation"] == lockOperationUpdateAckLevel {
+			s.Len(timer.Values(), 1)
+			recorded = true
+		}
+	}
+	s.True(recorded)
+}
+
+func (s *processorBaseSuite) TestUpdateAckLevel_DomainDrained() {
+	updateMaxReadLevel := func() task.Key {
+		return newTransferTaskKey(10)
+	}
+	updateClusterAckLevel := func(task.Key) error {
+		return nil
+	}
+	processorBase := s.newTestProcessorBase(nil, updateMaxReadLevel, updateClusterAckLevel, nil, nil)
+
+	var drainedDomains []string
+	processorBase.SetDomainDrainedCallback(func(domainID string) {
+		drainedDomains = append(drainedDomains, domainID)
+	})
+
+	domain1TaskState := t.TaskStatePending
+	mockTask1 := task.NewMockTask(s.controller)
+	mockTask1.EXPECT().GetDomainID().Return("testDomain1").AnyTimes()
+	mockTask1.EXPECT().State().DoAndReturn(func() t.State { return domain1TaskState }).AnyTimes()
+	mockTask2 := task.NewMockTask(s.controller)
+	mockTask2.EXPECT().GetDomainID().Return("testDomain2").AnyTimes()
+	mockTask2.EXPECT().State().Return(t.TaskStatePending).AnyTimes()
+
+	processorBase.processingQueueCollections = []ProcessingQueueCollection{
+		NewProcessingQueueCollection(0, []ProcessingQueue{
+			newProcessingQueue(
+				newProcessingQueueState(
+					0,
+					newTransferTaskKey(0),
+					newTransferTaskKey(10),
+					newTransferTaskKey(100),
+					NewDomainFilter(nil, true),
+				),
+				map[task.Key]task.Task{
+					newTransferTaskKey(1): mockTask2,
+					newTransferTaskKey(5): mockTask1,
+				},
+				s.logger,
+				s.metricsClient,
+			),
+		}),
+	}
+
+	_, err := processorBase.updateAckLevel(context.Background())
+	s.NoError(err)
+	s.Empty(drainedDomains)
+
+	domain1TaskState = t.TaskStateAcked
+	_, err = processorBase.updateAckLevel(context.Background())
+	s.NoError(err)
+	s.Equal([]string{"testDomain1"}, drainedDomains)
+
+	// drained domain should only be reported once
+	_, err = processorBase.updateAckLevel(context.Background())
+	s.NoError(err)
+	s.Equal([]string{"testDomain1"}, drainedDomains)
+}
+
+func (s *processorBaseSuite) TestConcurrentReads_SplitAndUpdateAckLevel() {
+	updateMaxReadLevel := func() task.Key {
+		return newTransferTaskKey(1000)
+	}
+	updateClusterAckLevel := func(task.Key) error {
+		return nil
+	}
+	processorBase := s.newTestProcessorBase(nil, updateMaxReadLevel, updateClusterAckLevel, nil, nil)
+
+	var queues []ProcessingQueue
+	for taskID := int64(0); taskID != 10; taskID++ {
+		mockTask := task.NewMockTask(s.controller)
+		mockTask.EXPECT().GetDomainID().Return("testDomain").AnyTimes()
+		mockTask.EXPECT().GetTaskType().Return(0).AnyTimes()
+		mockTask.EXPECT().State().Return(t.TaskStatePending).AnyTimes()
+		queues = append(queues, newProcessingQueue(
+			newProcessingQueueState(
+				0,
+				newTransferTaskKey(taskID*10),
+				newTransferTaskKey(taskID*10+10),
+				newTransferTaskKey(taskID*10+10),
+				NewDomainFilter(nil, true),
+			),
+			map[task.Key]task.Task{newTransferTaskKey(taskID*10 + 5): mockTask},
+			s.logger,
+			s.metricsClient,
+		))
+	}
+	processorBase.processingQueueCollections = []ProcessingQueueCollection{
+		NewProcessingQueueCollection(0, queues),
+	}
+	mockQueueSplitPolicy := NewMockProcessingQueueSplitPolicy(s.controller)
+	mockQueueSplitPolicy.EXPECT().Evaluate(gomock.Any()).Return(nil).AnyTimes()
+
+	doneCh := make(chan struct{})
+	var readersWG sync.WaitGroup
+	readers := []func(){
+		func() { processorBase.getProcessingQueueStates() },
+		func() { processorBase.PendingTaskCountByDomain() },
+		func() { processorBase.ExportState() },
+		func() { processorBase.SetDomainDrainedCallback(func(string) {}) },
+	}
+	for _, reader := range readers {
+		readersWG.Add(1)
+		go func(reader func()) {
+			defer readersWG.Done()
+			for {
+				select {
+				case <-doneCh:
+					return
+				default:
+					reader()
+				}
+			}
+		}(reader)
+	}
+
+	// the test goroutine acts as the processor pump, which is the only writer
+	for i := 0; i != 100; i++ {
+		processorBase.splitProcessingQueueCollection(context.Background(), mockQueueSplitPolicy, nil)
+		_, err := processorBase.updateAckLevel(context.Background())
+		s.NoError(err)
+	}
+	close(doneCh)
+	readersWG.Wait()
+
+	s.Equal(map[string]int{"testDomain": 10}, processorBase.PendingTaskCountByDomain())
+}
+
+func (s *processorBaseSuite) TestUpdateAckLevel_CaughtUp() {
+	maxReadLevel := newTransferTaskKey(10)
+	updateMaxReadLevel := func() task.Key {
+		return maxReadLevel
+	}
+	updateClusterAckLevel := func(task.Key) error {
+		return nil
+	}
+	processingQueueStates := []ProcessingQueueState{
+		newProcessingQueueState(
+			0,
+			newTransferTaskKey(10),
+			newTransferTaskKey(10),
+			newTransferTaskKey(100),
+			NewDomainFilter(nil, true),
+		),
+	}
+	processorBase := s.newTestProcessorBase(processingQueueStates, updateMaxReadLevel, updateClusterAckLevel, nil, nil)
+	numCaughtUp := 0
+	processorBase.options.OnCaughtUp = func() {
+		numCaughtUp++
+	}
+
+	// the state only changes after caughtUpHysteresis consecutive updates
+	for i := 0; i != caughtUpHysteresis-1; i++ {
+		_, err := processorBase.updateAckLevel(context.Background())
+		s.NoError(err)
+		s.False(processorBase.IsCaughtUp())
+	}
+	for i := 0; i != 3; i++ {
+		_, err := processorBase.updateAckLevel(context.Background())
+		s.NoError(err)
+		s.True(processorBase.IsCaughtUp())
+	}
+	s.Equal(1, numCaughtUp)
+
+	// a single update that falls behind doesn't change the state
+	maxReadLevel = newTransferTaskKey(20)
+	_, err := processorBase.updateAckLevel(context.Background())
+	s.NoError(err)
+	s.True(processorBase.IsCaughtUp())
+	maxReadLevel = newTransferTaskKey(10)
+	_, err = processorBase.updateAckLevel(context.Background())
+	s.NoError(err)
+	s.True(processorBase.IsCaughtUp())
+	s.Equal(1, numCaughtUp)
+
+	maxReadLevel = newTransferTaskKey(20)
+	for i := 0; i != caughtUpHysteresis; i++ {
+		_, err := processorBase.updateAckLevel(context.Background())
+		s.NoError(err)
+	}
+	s.False(processorBase.IsCaughtUp())
+	s.Equal(1, numCaughtUp)
+}
+
+func (s *processorBaseSuite) TestRedispatch_MaxConcurrentPasses() {
+	maxConcurrentRedispatch := 2
+	numPasses := 10
+
+	processorBase := s.newTestProcessorBase(nil, nil, nil, nil, nil)
+	processorBase.options.MaxConcurrentRedispatch = dynamicconfig.GetIntPropertyFn(maxConcurrentRedispatch)
+	processorBase.options.SkipRedispatchWhenBusy = dynamicconfig.GetBoolPropertyFn(false)
+
+	mockRedispatcher := task.NewMockRedispatcher(s.controller)
+	processorBase.redispatcher = mockRedispatcher
+
+	var running, maxRunning int32
+	mockRedispatcher.EXPECT().Redispatch(gomock.Any()).Do(func(_ int) {
+		current := atomic.AddInt32(&running, 1)
+		for {
+			prevMax := atomic.LoadInt32(&maxRunning)
+			if current <= prevMax || atomic.CompareAndSwapInt32(&maxRunning, prevMax, current) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+	}).Times(numPasses)
+
+	var wg sync.WaitGroup
+	wg.Add(numPasses)
+	for i := 0; i != numPasses; i++ {
+		go func() {
+			defer wg.Done()
+			s.True(processorBase.redispatch(context.Background(), 0))
+		}()
+	}
+	wg.Wait()
+
+	s.True(atomic.LoadInt32(&maxRunning) <= int32(maxConcurrentRedispatch))
+}
+
+func (s *processorBaseSuite) TestRedispatch_SkipWhenBusy() {
+	processorBase := s.newTestProcessorBase(nil, nil, nil, nil, nil)
+	processorBase.options.MaxConcurrentRedispatch = dynamicconfig.GetIntPropertyFn(1)
+	processorBase.options.SkipRedispatchWhenBusy = dynamicconfig.GetBoolPropertyFn(true)
+
+	mockRedispatcher := task.NewMockRedispatcher(s.controller)
+	processorBase.redispatcher = mockRedispatcher
+
+	startedCh := make(chan struct{})
+	blockCh := make(chan struct{})
+	mockRedispatcher.EXPECT().Redispatch(gomock.Any()).Do(func(_ int) {
+		close(startedCh)
+		<-blockCh
+	}).Times(1)
+
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		s.True(processorBase.redispatch(context.Background(), 0))
+	}()
+
+	<-startedCh
+	s.False(processorBase.redispatch(context.Background(), 0))
+	close(blockCh)
+	<-doneCh
+}
+
+func (s *processorBaseSuite) TestUpdateAckLevel_StuckAckLevel() {
+	mockTask := task.NewMockTask(s.controller)
+	mockTask.EXPECT().State().Return(t.TaskStatePending).AnyTimes()
+	mockTask.EXPECT().GetDomainID().Return("testDomain1").AnyTimes()
+	queue := newProcessingQueue(
+		NewProcessingQueueState(
+			0,
+			newTransferTaskKey(0),
+			newTransferTaskKey(100),
+			NewDomainFilter(nil, true),
+		),
+		map[task.Key]task.Task{newTransferTaskKey(50): mockTask},
+		s.logger,
+		s.metricsClient,
+	)
+	updateClusterAckLevel := func(task.Key) error {
+		return nil
+	}
+
+	processorBase := s.newTestProcessorBase(nil, nil, updateClusterAckLevel, nil, nil)
+	processorBase.processingQueueCollections = []ProcessingQueueCollection{
+		NewProcessingQueueCollection(0, []ProcessingQueue{queue}),
+	}
+	testScope := tally.NewTestScope("", nil)
+	processorBase.metricsScope = metrics.NewClient(testScope, metrics.History).Scope(metrics.TransferActiveQueueProcessorScope)
+	stuckAckLevelThreshold := 3
+	processorBase.options.StuckAckLevelThreshold = dynamicconfig.GetIntPropertyFn(stuckAckLevelThreshold)
+
+	stuckAckLevelCount := func() int64 {
+		for _, counter := range testScope.Snapshot().Counters() {
+			if counter.Name() == "stuck_ack_level" {
+				return counter.Value()
+			}
+		}
+		return 0
+	}
+
+	// the first update sets the ack level, following updates don't advance it
+	for i := 0; i != stuckAckLevelThreshold; i++ {
+		_, err := processorBase.updateAckLevel(context.Background())
+		s.NoError(err)
+	}
+	s.Zero(stuckAckLevelCount())
+
+	numUpdates := 2
+	for i := 0; i != numUpdates; i++ {
+		_, err := processorBase.updateAckLevel(context.Background())
+		s.NoError(err)
+	}
+	s.Equal(int64(numUpdates), stuckAckLevelCount())
+}
+
+func (s *processorBaseSuite) TestUpdateAckLevel_AckLevelAdvanced() {
+	ackedTask := task.NewMockTask(s.controller)
+	ackedTask.EXPECT().State().Return(t.TaskStateAcked).AnyTimes()
+	ackedTask.EXPECT().GetDomainID().Return("testDomain1").AnyTimes()
+	pendingTask := task.NewMockTask(s.controller)
+	pendingTask.EXPECT().State().Return(t.TaskStatePending).AnyTimes()
+	pendingTask.EXPECT().GetDomainID().Return("testDomain1").AnyTimes()
+	queue := newProcessingQueue(
+		newProcessingQueueState(
+			0,
+			newTransferTaskKey(10),
+			newTransferTaskKey(60),
+			newTransferTaskKey(100),
+			NewDomainFilter(nil, true),
+		),
+		map[task.Key]task.Task{
+			newTransferTaskKey(20): ackedTask,
+			newTransferTaskKey(50): pendingTask,
+		},
+		s.logger,
+		s.metricsClient,
+	)
+	updateClusterAckLevel := func(task.Key) error {
+		return nil
+	}
+
+	processorBase := s.newTestProcessorBase(
+		[]ProcessingQueueState{queue.State()},
+		nil,
+		updateClusterAckLevel,
+		nil,
+		nil,
+	)
+	processorBase.processingQueueCollections = []ProcessingQueueCollection{
+		NewProcessingQueueCollection(0, []ProcessingQueue{queue}),
+	}
+	var advances [][2]task.Key
+	processorBase.options.OnAckLevelAdvanced = func(from, to task.Key) {
+		advances = append(advances, [2]task.Key{from, to})
+	}
+
+	// the ack level advances from the loaded ack level to the acked task
+	_, err := processorBase.updateAckLevel(context.Background())
+	s.NoError(err)
+	s.Equal([][2]task.Key{{newTransferTaskKey(10), newTransferTaskKey(20)}}, advances)
+
+	// the ack level is unchanged while the task is pending
+	_, err = processorBase.updateAckLevel(context.Background())
+	s.NoError(err)
+	s.Len(advances, 1)
+}
+
+func (s *processorBaseSuite) TestUpdateAckLevel_AckLevelRegression() {
+	var persistedAckLevels []task.Key
+	updateClusterAckLevel := func(ackLevel task.Key) error {
+		persistedAckLevels = append(persistedAckLevels, ackLevel)
+		return nil
+	}
+
+	processorBase := s.newTestProcessorBase(
+		[]ProcessingQueueState{
+			NewProcessingQueueState(0, newTransferTaskKey(10), newTransferTaskKey(100), NewDomainFilter(nil, true)),
+		},
+		nil,
+		updateClusterAckLevel,
+		nil,
+		nil,
+	)
+	testScope := tally.NewTestScope("", nil)
+	processorBase.metricsScope = metrics.NewClient(testScope, metrics.History).Scope(metrics.TransferActiveQueueProcessorScope)
+	advanced := false
+	processorBase.options.OnAckLevelAdvanced = func(from, to task.Key) {
+		advanced = true
+	}
+
+	// a queue with an ack level lower than the persisted one shows up
+	processorBase.processingQueueCollections = []ProcessingQueueCollection{
+		NewProcessingQueueCollection(0, []ProcessingQueue{
+			newProcessingQueue(
+				NewProcessingQueueState(0, newTransferTaskKey(5), newTransferTaskKey(100), NewDomainFilter(nil, true)),
+				nil,
+				s.logger,
+				s.metricsClient,
+			),
+		}),
+	}
+	processFinished, err := processorBase.updateAckLevel(context.Background())
+	s.NoError(err)
+	s.False(processFinished)
+	s.Empty(persistedAckLevels)
+	s.False(advanced)
+	s.Equal(newTransferTaskKey(10), processorBase.persistedAckLevel)
+
+	regressionCount := int64(0)
+	for _, counter := range testScope.Snapshot().Counters() {
+		if counter.Name() == "processing_queue_ack_level_regression" {
+			regressionCount = counter.Value()
+		}
+	}
+	s.Equal(int64(1), regressionCount)
+
+	// ack levels not lower than the persisted one are persisted again
+	processorBase.processingQueueCollections = newProcessingQueueCollections(
+		[]ProcessingQueueState{
+			NewProcessingQueueState(0, newTransferTaskKey(10), newTransferTaskKey(100), NewDomainFilter(nil, true)),
+		},
+		s.logger,
+		s.metricsClient,
+	)
+	_, err = processorBase.updateAckLevel(context.Background())
+	s.NoError(err)
+	s.Equal([]task.Key{newTransferTaskKey(10)}, persistedAckLevels)
+}
+
+func (s *processorBaseSuite) TestUpdateAckLevel_ShardOwnershipLost() {
+	updateClusterAckLevel := func(task.Key) error {
+		return &persistence.ShardOwnershipLostError{ShardID: 10, Msg: "range ID changed"}
+	}
+
+	processorBase := s.newTestProcessorBase(
+		[]ProcessingQueueState{
+			NewProcessingQueueState(0, newTransferTaskKey(10), newTransferTaskKey(100), NewDomainFilter(nil, true)),
+		},
+		nil,
+		updateClusterAckLevel,
+		nil,
+		nil,
+	)
+
+	processFinished, err := processorBase.updateAckLevel(context.Background())
+	s.Equal(ErrProcessorShuttingDown, err)
+	s.False(processFinished)
+
+	// other errors are returned as is
+	updateErr := errors.New("some random error")
+	processorBase.updateClusterAckLevel = func(task.Key) error {
+		return updateErr
+	}
+	_, err = processorBase.updateAckLevel(context.Background())
+	s.Equal(updateErr, err)
+}
+
+func (s *processorBaseSuite) TestShouldRedispatch() {
+	processorBase := s.newTestProcessorBase(nil, nil, nil, nil, nil)
+	mockRedispatcher := task.NewMockRedispatcher(s.controller)
+	processorBase.redispatcher = mockRedispatcher
+
+	numIterations := 100
+	mockRedispatcher.EXPECT().Size().Return(0).Times(numIterations)
+	for i := 0; i != numIterations; i++ {
+		s.False(processorBase.shouldRedispatch())
+	}
+
+	mockRedispatcher.EXPECT().Size().Return(10).AnyTimes()
+	for weight, expectedRedispatch := range map[float64]int{
+		-1:   0,
+		0:    0,
+		0.25: 25,
+		0.5:  50,
+		1:    100,
+		2:    100,
+	} {
+		processorBase.redispatchCredit = 0
+		processorBase.options.RedispatchWeight = dynamicconfig.GetFloatPropertyFn(weight)
+
+		numRedispatch := 0
+		for i := 0; i != numIterations; i++ {
+			if processorBase.shouldRedispatch() {
+				numRedispatch++
+			}
+		}
+		s.Equal(expectedRedispatch, numRedispatch, "weight: %v", weight)
+	}
+}
+
+func (s *processorBaseSuite) TestTracing() {
+	mockTask := task.NewMockTask(s.controller)
+	mockTask.EXPECT().State().Return(t.TaskStatePending).AnyTimes()
+	mockTask.EXPECT().GetDomainID().Return("testDomain1").AnyTimes()
+	queue := newProcessingQueue(
+		NewProcessingQueueState(
+			0,
+			newTransferTaskKey(0),
+			newTransferTaskKey(100),
+			NewDomainFilter(nil, true),
+		),
+		map[task.Key]task.Task{newTransferTaskKey(50): mockTask},
+		s.logger,
+		s.metricsClient,
+	)
+	updateClusterAckLevel := func(task.Key) error {
+		return nil
+	}
+
+	processorBase := s.newTestProcessorBase(nil, nil, updateClusterAckLevel, nil, nil)
+	processorBase.processingQueueCollections = []ProcessingQueueCollection{
+		NewProcessingQueueCollection(0, []ProcessingQueue{queue}),
+	}
+	processorBase.options.MetricScope = metrics.TransferActiveQueueProcessorScope
+	mockRedispatcher := task.NewMockRedispatcher(s.controller)
+	processorBase.redispatcher = mockRedispatcher
+	mockRedispatcher.EXPECT().Size().Return(0).AnyTimes()
+	mockRedispatcher.EXPECT().Redispatch(gomock.Any()).Return(&task.RedispatchResult{
+		SubmitStatsByDomainID: map[string]*task.RedispatchSubmitStats{
+			"testDomain1": {Submitted: 1, Rejected: 2},
+			"testDomain2": {Submitted: 3, Rejected: 0},
+		},
+	}).Times(2)
+
+	// no tracer specified and no parent span, spans are noop
+	_, err := processorBase.updateAckLevel(context.Background())
+	s.NoError(err)
+	s.True(processorBase.redispatch(context.Background(), 0))
+
+	// use the tracer of the parent span
+	tracer := mocktracer.New()
+	parentSpan := tracer.StartSpan("parent")
+	ctx := opentracing.ContextWithSpan(context.Background(), parentSpan)
+	_, err = processorBase.updateAckLevel(ctx)
+	s.NoError(err)
+	s.True(processorBase.redispatch(ctx, 10))
+	parentSpan.Finish()
+
+	spans := make(map[string]*mocktracer.MockSpan)
+	for _, span := range tracer.FinishedSpans() {
+		spans[span.OperationName] = span
+	}
+	s.Len(spans, 3)
+	parentSpanID := spans["parent"].SpanContext.SpanID
+	for _, operationName := range []string{"queue.updateAckLevel", "queue.redispatch"} {
+		span, ok := spans[operationName]
+		s.True(ok)
+		s.Equal(parentSpanID, span.ParentID)
+		s.Equal(s.mockShard.GetShardID(), span.Tag("shard.id"))
+		s.Equal("transfer-active", span.Tag("queue.type"))
+	}
+	s.Equal(1, spans["queue.updateAckLevel"].Tag("pending.tasks"))
+	s.Equal(1, spans["queue.updateAckLevel"].Tag("queue.collections"))
+	s.Equal(10, spans["queue.redispatch"].Tag("redispatch.target.size"))
+	s.Equal(4, spans["queue.redispatch"].Tag("redispatch.submitted"))
+	s.Equal(2, spans["queue.redispatch"].Tag("redispatch.rejected"))
+}
+
+func (s *processorBaseSuite) TestTaggedMetrics() {
+	for _, enabled := range []bool{false, true} {
+		mockTask := task.NewMockTask(s.controller)
+		mockTask.EXPECT().State().Return(t.TaskStatePending).AnyTimes()
+		mockTask.EXPECT().GetDomainID().Return("testDomain1").AnyTimes()
+		queue := newProcessingQueue(
+			NewProcessingQueueState(
+				0,
+				newTransferTaskKey(0),
+				newTransferTaskKey(100),
+				NewDomainFilter(nil, true),
+			),
+			map[task.Key]task.Task{newTransferTaskKey(50): mockTask},
+			s.logger,
+			s.metricsClient,
+		)
+		updateClusterAckLevel := func(task.Key) error {
+			return nil
+		}
+
+		processorBase := s.newTestProcessorBase(nil, nil, updateClusterAckLevel, nil, nil)
+		processorBase.processingQueueCollections = []ProcessingQueueCollection{
+			NewProcessingQueueCollection(0, []ProcessingQueue{queue}),
+		}
+		testScope := tally.NewTestScope("", nil)
+		processorBase.metricsScope = metrics.NewClient(testScope, metrics.History).Scope(metrics.TransferActiveQueueProcessorScope)
+		processorBase.options.EnableDomainTaggedMetrics = dynamicconfig.GetBoolPropertyFn(enabled)
+		processorBase.options.EnableLevelTaggedMetrics = dynamicconfig.GetBoolPropertyFn(enabled)
+
+		mockRedispatcher := task.NewMockRedispatcher(s.controller)
+		processorBase.redispatcher = mockRedispatcher
+		mockRedispatcher.EXPECT().Size().Return(0).AnyTimes()
+		mockRedispatcher.EXPECT().Redispatch(gomock.Any()).Return(&task.RedispatchResult{
+			SubmitStatsByDomainID: map[string]*task.RedispatchSubmitStats{
+				"testDomain1": {Submitted: 1, Rejected: 2},
+			},
+		}).Times(1)
+
+		_, err := processorBase.updateAckLevel(context.Background())
+		s.NoError(err)
+		s.True(processorBase.redispatch(context.Background(), 0))
+
+		snapshot := testScope.Snapshot()
+		domainTagged := make(map[string]bool)
+		levelTagged := make(map[string]bool)
+		for _, timer := range snapshot.Timers() {
+			if timer.Tags()["domain"] == "testDomain1" {
+				domainTagged[timer.Name()] = true
+			}
+			if timer.Tags()["queueLevel"] == "0" {
+				levelTagged[timer.Name()] = true
+			}
+		}
+		for _, counter := range snapshot.Counters() {
+			if counter.Tags()["domain"] == "testDomain1" {
+				domainTagged[counter.Name()] = true
+			}
+			if counter.Name() == "processing_queue_redispatch_rejected_counter" {
+				s.Equal(int64(2), counter.Value())
+			}
+		}
+
+		if enabled {
+			s.Equal(map[string]bool{
+				"processing_queue_pending_tasks":                true,
+				"processing_queue_redispatch_submitted_counter": true,
+				"processing_queue_redispatch_rejected_counter":  true,
+			}, domainTagged)
+			s.Equal(map[string]bool{"processing_queue_pending_tasks": true}, levelTagged)
+		} else {
+			s.Empty(domainTagged)
+			s.Empty(levelTagged)
+		}
+	}
+}
+
+func (s *processorBaseSuite) TestSubmitTask_LevelTaggedMetrics() {
+	processorBase := s.newTestProcessorBase(nil, nil, nil, nil, nil)
+	testScope := tally.NewTestScope("", nil)
+	processorBase.metricsScope = metrics.NewClient(testScope, metrics.History).Scope(metrics.TransferActiveQueueProcessorScope)
+
+	submittedTask := task.NewMockTask(s.controller)
+	submittedTask.EXPECT().GetDomainID().Return("testDomain1").AnyTimes()
+	s.mockTaskProcessor.EXPECT().TrySubmit(submittedTask).Return(true, nil).Times(2)
+	rejectedTask := task.NewMockTask(s.controller)
+	rejectedTask.EXPECT().Priority().Return(0).AnyTimes()
+	rejectedTask.EXPECT().GetDomainID().Return("testDomain1").AnyTimes()
+	s.mockTaskProcessor.EXPECT().TrySubmit(rejectedTask).Return(false, nil).Times(1)
+
+	for _, level := range []int{0, 2} {
+		submitted, err := processorBase.submitTask(level, submittedTask)
+		s.NoError(err)
+		s.True(submitted)
+	}
+	submitted, err := processorBase.submitTask(2, rejectedTask)
+	s.NoError(err)
+	s.False(submitted)
+
+	submittedByLevel := make(map[string]int64)
+	for _, counter := range testScope.Snapshot().Counters() {
+		if counter.Name() == "processing_queue_task_submitted_counter" {
+			submittedByLevel[counter.Tags()["queueLevel"]] += counter.Value()
+		}
+	}
+	s.Equal(map[string]int64{"0": 1, "2": 1}, submittedByLevel)
+}
+
+func (s *processorBaseSuite) TestMetricsSnapshot() {
+	processorBase := s.newTestProcessorBase(nil, nil, nil, nil, nil)
+	snapshot := processorBase.MetricsSnapshot()
+	s.Empty(snapshot.AckLevels)
+	s.Empty(snapshot.AckLagByLevel)
+	s.Empty(snapshot.PendingTaskCountByDomain)
+	s.Empty(snapshot.TasksSubmittedByLevel)
+	s.Zero(snapshot.RedispatchQueueSize)
+	s.False(snapshot.CaughtUp)
+
+	pendingTask := task.NewMockTask(s.controller)
+	pendingTask.EXPECT().GetDomainID().Return("testDomain1").AnyTimes()
+	pendingTask.EXPECT().State().Return(t.TaskStatePending).AnyTimes()
+	pendingTask.EXPECT().Priority().Return(0).AnyTimes()
+	ackedTask := task.NewMockTask(s.controller)
+	ackedTask.EXPECT().GetDomainID().Return("testDomain2").AnyTimes()
+	ackedTask.EXPECT().State().Return(t.TaskStateAcked).AnyTimes()
+	processorBase.processingQueueCollections = []ProcessingQueueCollection{
+		NewProcessingQueueCollection(0, []ProcessingQueue{
+			newProcessingQueue(
+				NewProcessingQueueState(0, newTransferTaskKey(0), newTransferTaskKey(10), NewDomainFilter(nil, true)),
+				map[task.Key]task.Task{
+					newTransferTaskKey(5): pendingTask,
+					newTransferTaskKey(6): ackedTask,
+				},
+				s.logger,
+				s.metricsClient,
+			),
+		}),
+		NewProcessingQueueCollection(1, []ProcessingQueue{
+			newProcessingQueue(
+				NewProcessingQueueState(1, newTransferTaskKey(3), newTransferTaskKey(10), NewDomainFilter(nil, true)),
+				nil,
+				s.logger,
+				s.metricsClient,
+			),
+		}),
+	}
+
+	s.mockTaskProcessor.EXPECT().TrySubmit(pendingTask).Return(true, nil).Times(3)
+	for _, level := range []int{0, 0, 1} {
+		submitted, err := processorBase.submitTask(level, pendingTask)
+		s.NoError(err)
+		s.True(submitted)
+	}
+	processorBase.redispatcher.Start()
+	defer processorBase.redispatcher.Stop()
+	processorBase.redispatcher.AddTask(pendingTask)
+	atomic.StoreInt32(&processorBase.caughtUp, 1)
+
+	snapshot = processorBase.MetricsSnapshot()
+	s.Equal(map[int]task.Key{0: newTransferTaskKey(0), 1: newTransferTaskKey(3)}, snapshot.AckLevels)
+	s.Equal(map[int]int{0: 2, 1: 0}, snapshot.AckLagByLevel)
+	s.Equal(map[string]int{"testDomain1": 1}, snapshot.PendingTaskCountByDomain)
+	s.Equal(map[int]int64{0: 2, 1: 1}, snapshot.TasksSubmittedByLevel)
+	s.Equal(1, snapshot.RedispatchQueueSize)
+	s.Equal(RedispatchNotDraining, snapshot.RedispatchDrainTime)
+	s.Zero(snapshot.InFlightTasks)
+	s.True(snapshot.CaughtUp)
+}
+
+func (s *processorBaseSuite) TestExportImportState() {
+	processingQueueStates := []ProcessingQueueState{
+		newProcessingQueueState(
+			0,
+			newTransferTaskKey(0),
+			newTransferTaskKey(50),
+			newTransferTaskKey(100),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, true),
+		),
+		newProcessingQueueState(
+			1,
+			newTransferTaskKey(10),
+			newTransferTaskKey(20),
+			newTransferTaskKey(100),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+		),
+	}
+	exporter := s.newTestProcessorBase(processingQueueStates, nil, nil, nil, nil)
+	exporter.PauseDomain("testDomain2")
+	mockTask := task.NewMockTask(s.controller)
+	mockTask.EXPECT().Priority().Return(0).AnyTimes()
+	mockTask.EXPECT().GetDomainID().Return("testDomain1").AnyTimes()
+	mockTask.EXPECT().GetWorkflowID().Return("testWorkflow").AnyTimes()
+	mockTask.EXPECT().GetRunID().Return("testRun").AnyTimes()
+	mockTask.EXPECT().GetTaskID().Return(int64(15)).AnyTimes()
+	mockTask.EXPECT().GetTaskType().Return(1).AnyTimes()
+	mockTask.EXPECT().GetAttempt().Return(2).AnyTimes()
+	exporter.redispatcher.AddTask(mockTask)
+
+	state := exporter.ExportState()
+	s.Len(state.ProcessingQueueStates, len(processingQueueStates))
+	s.Equal(exporter.DumpRedispatchQueue(), state.RedispatchTasks)
+	s.Equal([]string{"testDomain2"}, state.PausedDomainIDs)
+
+	importer := s.newTestProcessorBase([]ProcessingQueueState{
+		NewProcessingQueueState(
+			0,
+			newTransferTaskKey(0),
+			newTransferTaskKey(1000),
+			NewDomainFilter(nil, true),
+		),
+	}, nil, nil, nil, nil)
+	s.NoError(importer.ImportState(state))
+
+	importedStates := importer.getProcessingQueueStates().GetStateActionResult
+	s.Equal([]string{"testDomain2"}, importedStates.PausedDomainIDs)
+	s.Len(importedStates.States, len(processingQueueStates))
+	for idx, importedState := range importedStates.States {
+		expectedState := processingQueueStates[idx]
+		s.Equal(expectedState.Level(), importedState.Level())
+		s.Equal(expectedState.AckLevel(), importedState.AckLevel())
+		// read level is reset so that unacked tasks, including redispatched ones, are read again
+		s.Equal(expectedState.AckLevel(), importedState.ReadLevel())
+		s.Equal(expectedState.MaxLevel(), importedState.MaxLevel())
+		s.True(expectedState.DomainFilter().Equal(importedState.DomainFilter()))
+	}
+
+	importer.status = common.DaemonStatusStarted
+	s.Equal(errImportStateAfterStart, importer.ImportState(state))
+}
+
+func (s *processorBaseSuite) TestSubmitTask_OutstandingTaskShedding() {
+	outstandingTasks := make(map[task.Key]task.Task)
+	for taskID := int64(1); taskID <= 2; taskID++ {
+		mockTask := task.NewMockTask(s.controller)
+		mockTask.EXPECT().State().Return(t.TaskStatePending).AnyTimes()
+		mockTask.EXPECT().GetDomainID().Return("testDomain1").AnyTimes()
+		outstandingTasks[newTransferTaskKey(taskID)] = mockTask
+	}
+	queue := newProcessingQueue(
+		newProcessingQueueState(
+			0,
+			newTransferTaskKey(0),
+			newTransferTaskKey(10),
+			newTransferTaskKey(100),
+			NewDomainFilter(nil, true),
+		),
+		outstandingTasks,
+		s.logger,
+		s.metricsClient,
+	)
+
+	processorBase := s.newTestProcessorBase(nil, nil, nil, nil, nil)
+	processorBase.processingQueueCollections = []ProcessingQueueCollection{
+		NewProcessingQueueCollection(0, []ProcessingQueue{queue}),
+	}
+	testScope := tally.NewTestScope("", nil)
+	processorBase.metricsScope = metrics.NewClient(testScope, metrics.History).Scope(metrics.TransferActiveQueueProcessorScope)
+	processorBase.options.EnableOutstandingTaskShedding = dynamicconfig.GetBoolPropertyFn(true)
+	processorBase.options.MaxOutstandingTasksPerDomain = func(domainID string) int {
+		if domainID == "testDomain1" {
+			return 3
+		}
+		return 0
+	}
+	processorBase.refreshOutstandingTaskCount()
+
+	newTask := func(domainID string) *task.MockTask {
+		mockTask := task.NewMockTask(s.controller)
+		mockTask.EXPECT().Priority().Return(0).AnyTimes()
+		mockTask.EXPECT().GetDomainID().Return(domainID).AnyTimes()
+		return mockTask
+	}
+	// testDomain1 has 2 outstanding tasks, only one more task can be submitted
+	submittedTask := newTask("testDomain1")
+	s.mockTaskProcessor.EXPECT().TrySubmit(submittedTask).Return(true, nil).Times(1)
+	shedTask := newTask("testDomain1")
+	s.mockTaskProcessor.EXPECT().TrySubmit(shedTask).Times(0)
+	otherDomainTasks := []*task.MockTask{newTask("testDomain2"), newTask("testDomain2")}
+	for _, otherDomainTask := range otherDomainTasks {
+		s.mockTaskProcessor.EXPECT().TrySubmit(otherDomainTask).Return(true, nil).Times(1)
+	}
+
+	for _, mockTask := range append([]*task.MockTask{submittedTask, shedTask}, otherDomainTasks...) {
+		submitted, err := processorBase.submitTask(0, mockTask)
+		s.NoError(err)
+		s.True(submitted)
+	}
+	redispatchTasks := processorBase.redispatcher.Snapshot()
+	s.Len(redispatchTasks, 1)
+	s.True(redispatchTasks[0] == shedTask)
+
+	var numShed int64
+	for _, counter := range testScope.Snapshot().Counters() {
+		if counter.Name() == "processing_queue_domain_shed_counter" {
+			s.Equal("testDomain1", counter.Tags()["domain"])
+			numShed += counter.Value()
+		}
+	}
+	s.Equal(int64(1), numShed)
+
+	processorBase.options.EnableOutstandingTaskShedding = dynamicconfig.GetBoolPropertyFn(false)
+	processorBase.refreshOutstandingTaskCount()
+	s.mockTaskProcessor.EXPECT().TrySubmit(shedTask).Return(true, nil).Times(1)
+	submitted, err := processorBase.submitTask(0, shedTask)
 	s.NoError(err)
-	s.False(processFinished)
-	s.Equal(len(processingQueueStates), len(pState))
+	s.True(submitted)
+}
+
+func (s *processorBaseSuite) TestRedispatch_OutstandingTaskShedding() {
+	newTask := func(taskState *t.State) *task.MockTask {
+		mockTask := task.NewMockTask(s.controller)
+		mockTask.EXPECT().Priority().Return(0).AnyTimes()
+		mockTask.EXPECT().GetDomainID().Return("testDomain1").AnyTimes()
+		mockTask.EXPECT().State().DoAndReturn(func() t.State { return *taskState }).AnyTimes()
+		return mockTask
+	}
+	outstandingTasks := make(map[task.Key]task.Task)
+	inFlightTaskStates := make([]t.State, 2)
+	for idx := range inFlightTaskStates {
+		inFlightTaskStates[idx] = t.TaskStatePending
+		outstandingTasks[newTransferTaskKey(int64(idx+1))] = newTask(&inFlightTaskStates[idx])
+	}
+	queue := newProcessingQueue(
+		newProcessingQueueState(
+			0,
+			newTransferTaskKey(0),
+			newTransferTaskKey(10),
+			newTransferTaskKey(100),
+			NewDomainFilter(nil, true),
+		),
+		outstandingTasks,
+		s.logger,
+		s.metricsClient,
+	)
+	processorBase := s.newTestProcessorBase(nil, nil, nil, nil, nil)
+	processorBase.processingQueueCollections = []ProcessingQueueCollection{
+		NewProcessingQueueCollection(0, []ProcessingQueue{queue}),
+	}
+	processorBase.options.EnableOutstandingTaskShedding = dynamicconfig.GetBoolPropertyFn(true)
+	processorBase.options.MaxOutstandingTasksPerDomain = func(domainID string) int {
+		return 2
+	}
+	processorBase.redispatcher.Start()
+	defer processorBase.redispatcher.Stop()
+
+	// testDomain1 already has 2 in-flight tasks, the newly read task is shed
+	shedTaskState := t.TaskStatePending
+	shedTask := newTask(&shedTaskState)
+	numSubmitted := 0
+	s.mockTaskProcessor.EXPECT().TrySubmit(shedTask).DoAndReturn(func(_ task.Task) (bool, error) {
+		numSubmitted++
+		return true, nil
+	}).MaxTimes(1)
+	processorBase.refreshOutstandingTaskCount()
+	submitted, err := processorBase.submitTask(0, shedTask)
+	s.NoError(err)
+	s.True(submitted)
+	outstandingTasks[newTransferTaskKey(3)] = shedTask
+
+	// the redispatcher holds the shed task while the domain is still over its limit
+	processorBase.refreshOutstandingTaskCount()
+	s.True(processorBase.redispatch(context.Background(), 0))
+	s.Equal(0, numSubmitted)
+	s.Equal(1, processorBase.redispatcher.Size())
+
+	// the shed task is submitted once an in-flight task of the domain is acked
+	inFlightTaskStates[0] = t.TaskStateAcked
+	processorBase.refreshOutstandingTaskCount()
+	s.True(processorBase.redispatch(context.Background(), 0))
+	s.Equal(1, numSubmitted)
+	s.Equal(0, processorBase.redispatcher.Size())
+}
+
+func (s *processorBaseSuite) TestSubmitTask_InFlightBudget() {
+	processorBase := s.newTestProcessorBase(nil, nil, nil, nil, nil)
+	processorBase.options.MaxInFlightTasks = dynamicconfig.GetIntPropertyFn(2)
+	processorBase.redispatcher.Start()
+	defer processorBase.redispatcher.Stop()
+
+	var tasks []*task.MockTask
+	for taskID := int64(1); taskID <= 4; taskID++ {
+		mockTask := task.NewMockTask(s.controller)
+		mockTask.EXPECT().Priority().Return(0).AnyTimes()
+		mockTask.EXPECT().GetDomainID().Return("testDomain").AnyTimes()
+		mockTask.EXPECT().GetTaskID().Return(taskID).AnyTimes()
+		tasks = append(tasks, mockTask)
+	}
+	numSubmitted := 0
+	for _, mockTask := range tasks {
+		s.mockTaskProcessor.EXPECT().TrySubmit(mockTask).DoAndReturn(func(_ task.Task) (bool, error) {
+			numSubmitted++
+			return true, nil
+		}).MaxTimes(1)
+	}
+
+	// only the first two tasks are submitted before any completion
+	for idx, mockTask := range tasks {
+		submitted, err := processorBase.submitTask(0, mockTask)
+		s.NoError(err)
+		s.Equal(idx < 2, submitted)
+	}
+	s.Equal(2, numSubmitted)
+	s.Equal(2, processorBase.numInFlightTasks())
+	s.Equal(2, processorBase.redispatcher.Size())
+
+	// redispatching is also deferred while the budget is full
+	processorBase.redispatcher.Redispatch(0)
+	s.Equal(2, numSubmitted)
+	s.Equal(2, processorBase.redispatcher.Size())
+
+	// acking a task releases its slot, nacking a task that can't be resubmitted also releases
+	// its slot, and acking a task that is not in-flight has no effect
+	processorBase.CompleteQueueTask(1)
+	processorBase.CompleteQueueTask(3)
+	s.Equal(1, processorBase.numInFlightTasks())
+	processorBase.redispatchNackedTask(defaultProcessingQueueLevel, tasks[1])
+	s.Equal(0, processorBase.numInFlightTasks())
+	s.Equal(3, processorBase.redispatcher.Size())
+
+	processorBase.redispatcher.Redispatch(0)
+	s.Equal(4, numSubmitted)
+	s.Equal(2, processorBase.numInFlightTasks())
+	s.Equal(1, processorBase.redispatcher.Size())
+	s.True(processorBase.redispatcher.Snapshot()[0] == tasks[1])
+}
+
+func (s *processorBaseSuite) TestSubmitTask_OrderedProcessing() {
+	processorBase := s.newTestProcessorBase(nil, nil, nil, nil, nil)
+	processorBase.options.EnableOrderedProcessingByDomainID = dynamicconfig.GetBoolPropertyFnFilteredByDomain(true)
+	processorBase.redispatcher.Start()
+	defer processorBase.redispatcher.Stop()
+
+	// tasks 1 and 2 belong to the same workflow, task 3 belongs to another workflow
+	workflowIDs := []string{"testWorkflow1", "testWorkflow1", "testWorkflow2"}
+	var tasks []*task.MockTask
+	for idx, workflowID := range workflowIDs {
+		mockTask := task.NewMockTask(s.controller)
+		mockTask.EXPECT().Priority().Return(0).AnyTimes()
+		mockTask.EXPECT().GetDomainID().Return("testDomain").AnyTimes()
+		mockTask.EXPECT().GetWorkflowID().Return(workflowID).AnyTimes()
+		mockTask.EXPECT().GetRunID().Return("testRunID").AnyTimes()
+		mockTask.EXPECT().GetTaskID().Return(int64(idx + 1)).AnyTimes()
+		tasks = append(tasks, mockTask)
+	}
+	var submittedTaskIDs []int64
+	s.mockTaskProcessor.EXPECT().TrySubmit(gomock.Any()).DoAndReturn(func(submittedTask task.Task) (bool, error) {
+		submittedTaskIDs = append(submittedTaskIDs, submittedTask.GetTaskID())
+		return true, nil
+	}).AnyTimes()
+
+	// task 2 waits for task 1 in the redispatcher, while task 3 of another workflow is submitted
+	for _, mockTask := range tasks {
+		submitted, err := processorBase.submitTask(0, mockTask)
+		s.NoError(err)
+		s.True(submitted)
+	}
+	s.Equal([]int64{1, 3}, submittedTaskIDs)
+	s.Equal(1, processorBase.redispatcher.Size())
+
+	processorBase.redispatcher.Redispatch(0)
+	s.Equal([]int64{1, 3}, submittedTaskIDs)
+	s.Equal(1, processorBase.redispatcher.Size())
+
+	// completing the task of the other workflow doesn't release the gate
+	processorBase.CompleteQueueTask(3)
+	processorBase.redispatcher.Redispatch(0)
+	s.Equal([]int64{1, 3}, submittedTaskIDs)
+
+	// task 2 is submitted once task 1 completes
+	processorBase.CompleteQueueTask(1)
+	processorBase.redispatcher.Redispatch(0)
+	s.Equal([]int64{1, 3, 2}, submittedTaskIDs)
+	s.Zero(processorBase.redispatcher.Size())
+
+	// the gate is also released when the in-flight task is nacked
+	processorBase.redispatchNackedTask(defaultProcessingQueueLevel, tasks[1])
+	submitted, err := processorBase.trySubmitTask(tasks[0])
+	s.NoError(err)
+	s.True(submitted)
+	s.Equal([]int64{1, 3, 2, 1}, submittedTaskIDs)
+}
+
+func (s *processorBaseSuite) TestEstimateRedispatchDrainTime() {
+	now := time.Now()
+	timeSource := clock.NewEventTimeSource().Update(now)
+	s.mockShard.Resource.TimeSource = timeSource
+	processorBase := s.newTestProcessorBase(nil, nil, nil, nil, nil)
+
+	s.Zero(processorBase.EstimateRedispatchDrainTime())
+
+	for i := 0; i != 50; i++ {
+		mockTask := task.NewMockTask(s.controller)
+		mockTask.EXPECT().Priority().Return(0).AnyTimes()
+		mockTask.EXPECT().GetDomainID().Return("some random domainID").AnyTimes()
+		processorBase.redispatcher.AddTask(mockTask)
+	}
+	s.Equal(RedispatchNotDraining, processorBase.EstimateRedispatchDrainTime())
+
+	// 10 tasks are submitted every second
+	processorBase.recordRedispatchSubmits(0)
+	for i := 1; i <= 3; i++ {
+		timeSource.Update(now.Add(time.Duration(i) * time.Second))
+		processorBase.recordRedispatchSubmits(10)
+	}
+	s.InDelta(5*time.Second, processorBase.EstimateRedispatchDrainTime(), float64(time.Millisecond))
+
+	// the estimate grows as the submit rate drops
+	timeSource.Update(now.Add(5 * time.Second))
+	processorBase.recordRedispatchSubmits(0)
+	s.InDelta(50.0/7*float64(time.Second), processorBase.EstimateRedispatchDrainTime(), float64(time.Millisecond))
+}
+
+func (s *processorBaseSuite) TestRecordRedispatchQueueSize() {
+	now := time.Now()
+	timeSource := clock.NewEventTimeSource().Update(now)
+	s.mockShard.Resource.TimeSource = timeSource
+	processorBase := s.newTestProcessorBase(nil, nil, nil, nil, nil)
+
+	// the first sample only starts the measurement
+	_, ok := processorBase.recordRedispatchQueueSize(10)
+	s.False(ok)
+
+	// 30 tasks added and 10 tasks drained in 2 seconds
+	timeSource.Update(now.Add(2 * time.Second))
+	rate, ok := processorBase.recordRedispatchQueueSize(30)
+	s.True(ok)
+	s.InDelta(10, rate, 1e-9)
+
+	// samples taken at the same time are skipped
+	_, ok = processorBase.recordRedispatchQueueSize(100)
+	s.False(ok)
+
+	// 5 tasks added and 25 tasks drained in 4 seconds
+	timeSource.Update(now.Add(6 * time.Second))
+	rate, ok = processorBase.recordRedispatchQueueSize(10)
+	s.True(ok)
+	s.InDelta(-5, rate, 1e-9)
+
+	// the queue size doesn't change
+	timeSource.Update(now.Add(7 * time.Second))
+	rate, ok = processorBase.recordRedispatchQueueSize(10)
+	s.True(ok)
+	s.Zero(rate)
+}
+
+func (s *processorBaseSuite) TestPumpIterationMetrics() {
+	now := time.Now()
+	timeSource := clock.NewEventTimeSource().Update(now)
+	s.mockShard.Resource.TimeSource = timeSource
+	processorBase := s.newTestProcessorBase(nil, nil, nil, nil, nil)
+	testScope := tally.NewTestScope("", nil)
+	processorBase.metricsScope = metrics.NewClient(testScope, metrics.History).Scope(metrics.TransferActiveQueueProcessorScope)
+
+	getIterationMetrics := func() (map[string]int64, map[string][]time.Duration) {
+		counts := make(map[string]int64)
+		for _, counter := range testScope.Snapshot().Counters() {
+			if counter.Name() == "processing_queue_pump_iteration" {
+				counts[counter.Tags()["pumpIteration"]] += counter.Value()
+			}
+		}
+		latencies := make(map[string][]time.Duration)
+		for _, timer := range testScope.Snapshot().Timers() {
+			if timer.Name() == "processing_queue_pump_iteration_latency" {
+				latencies[timer.Tags()["pumpIteration"]] = append(latencies[timer.Tags()["pumpIteration"]], timer.Values()...)
+			}
+		}
+		return counts, latencies
+	}
+
+	// simulate pump iterations, the type of an iteration is decided after it starts
+	simulateIteration := func(iterationType string, duration time.Duration) {
+		iteration := processorBase.startPumpIteration(pumpIterationIdle)
+		now = now.Add(duration)
+		timeSource.Update(now)
+		iteration.iterationType = iterationType
+		processorBase.finishPumpIteration(iteration)
+	}
+	simulateIteration(pumpIterationRead, 10*time.Millisecond)
+	simulateIteration(pumpIterationRead, 20*time.Millisecond)
+	simulateIteration(pumpIterationRedispatch, 5*time.Millisecond)
+	simulateIteration(pumpIterationIdle, 0)
+
+	counts, latencies := getIterationMetrics()
+	s.Equal(map[string]int64{
+		pumpIterationRead:       2,
+		pumpIterationRedispatch: 1,
+		pumpIterationIdle:       1,
+	}, counts)
+	s.ElementsMatch([]time.Duration{10 * time.Millisecond, 20 * time.Millisecond}, latencies[pumpIterationRead])
+	s.Equal([]time.Duration{5 * time.Millisecond}, latencies[pumpIterationRedispatch])
+
+	// counters keep incrementing across iterations
+	simulateIteration(pumpIterationRead, time.Millisecond)
+	simulateIteration(pumpIterationSplit, time.Millisecond)
+	counts, _ = getIterationMetrics()
+	s.Equal(int64(3), counts[pumpIterationRead])
+	s.Equal(int64(1), counts[pumpIterationSplit])
+}
+
+func (s *processorBaseSuite) TestWarmup() {
+	now := time.Now()
+	timeSource := clock.NewEventTimeSource().Update(now)
+	s.mockShard.Resource.TimeSource = timeSource
+	warmupDuration := 10 * time.Second
+	options := newTransferQueueProcessorOptions(s.mockShard.GetConfig(), true, false)
+	options.MaxPollRPS = dynamicconfig.GetIntPropertyFn(100)
+	options.WarmupDuration = dynamicconfig.GetDurationPropertyFn(warmupDuration)
+	processorBase := newProcessorBase(
+		s.mockShard,
+		nil,
+		s.mockTaskProcessor,
+		options,
+		nil,
+		nil,
+		nil,
+		nil,
+		s.logger,
+		s.metricsClient,
+	)
+
+	// the effective read rate increases over the warmup window
+	var readRPS []float64
+	for elapsed := time.Duration(0); elapsed < warmupDuration; elapsed += 2 * time.Second {
+		timeSource.Update(now.Add(elapsed))
+		rps, ok := processorBase.getWarmupReadRPS()
+		s.True(ok)
+		readRPS = append(readRPS, rps)
+	}
+	s.InDelta(100*warmupInitialRateFactor, readRPS[0], 0.001)
+	for i := 1; i != len(readRPS); i++ {
+		s.True(readRPS[i] > readRPS[i-1])
+		s.True(readRPS[i] < 100)
+	}
+	s.NoError(processorBase.waitReadRateLimit(context.Background()))
+	s.InDelta(readRPS[len(readRPS)-1], float64(processorBase.warmupRateLimiter.Limit()), 0.001)
+
+	// redispatch passes are scaled down during warmup as well
+	numTasks := 1000
+	numSubmitted := 0
+	s.mockTaskProcessor.EXPECT().TrySubmit(gomock.Any()).DoAndReturn(func(_ interface{}) (bool, error) {
+		numSubmitted++
+		return true, nil
+	}).AnyTimes()
+	for i := 0; i != numTasks; i++ {
+		mockTask := task.NewMockTask(s.controller)
+		mockTask.EXPECT().Priority().Return(0).AnyTimes()
+		mockTask.EXPECT().GetDomainID().Return("testDomain").AnyTimes()
+		processorBase.redispatcher.AddTask(mockTask)
+	}
+	processorBase.redispatcher.Start()
+	defer processorBase.redispatcher.Stop()
+
+	timeSource.Update(now)
+	processorBase.redispatcher.Redispatch(0)
+	submittedAtStart := numSubmitted
+	s.True(submittedAtStart > 0)
+	s.True(submittedAtStart < numTasks/5)
+
+	timeSource.Update(now.Add(warmupDuration / 2))
+	processorBase.redispatcher.Redispatch(0)
+	submittedAtHalfway := numSubmitted - submittedAtStart
+	s.True(submittedAtHalfway > submittedAtStart)
+
+	// warmup is done
+	timeSource.Update(now.Add(warmupDuration))
+	_, ok := processorBase.getWarmupReadRPS()
+	s.False(ok)
+	s.Equal(1.0, processorBase.getWarmupRateFactor())
+	processorBase.redispatcher.Redispatch(0)
+	s.Equal(numTasks, numSubmitted)
+	s.Zero(processorBase.redispatcher.Size())
+}
+
+func (s *processorBaseSuite) TestRedispatch_LevelOrder() {
+	processorBase := s.newTestProcessorBase(nil, nil, nil, nil, nil)
+	processorBase.redispatcher.Start()
+	defer processorBase.redispatcher.Stop()
+
+	tasksByLevel := make(map[task.Task]int)
+	for _, level := range []int{0, 2, 0, 1, 0, 2, 0} {
+		mockTask := task.NewMockTask(s.controller)
+		mockTask.EXPECT().Priority().Return(0).AnyTimes()
+		mockTask.EXPECT().GetDomainID().Return(fmt.Sprintf("testDomain%v", level)).AnyTimes()
+		mockTask.EXPECT().GetTaskID().Return(int64(len(tasksByLevel))).AnyTimes()
+		tasksByLevel[mockTask] = level
+		s.NoError(processorBase.deferTask(level, mockTask))
+	}
+	s.Equal(len(tasksByLevel), processorBase.redispatcher.Size())
+
+	snapshotLevels := make([]int, 0, len(tasksByLevel))
+	for _, task := range processorBase.redispatcher.Snapshot() {
+		snapshotLevels = append(snapshotLevels, tasksByLevel[task])
+	}
+	s.Equal([]int{2, 2, 1, 0, 0, 0, 0}, snapshotLevels)
+
+	// the task processor only has room for 3 tasks, tasks of higher levels are submitted first
+	var submittedLevels []int
+	s.mockTaskProcessor.EXPECT().TrySubmit(gomock.Any()).DoAndReturn(func(task task.Task) (bool, error) {
+		if len(submittedLevels) == 3 {
+			return false, nil
+		}
+		submittedLevels = append(submittedLevels, tasksByLevel[task])
+		return true, nil
+	}).AnyTimes()
+	result := processorBase.redispatcher.Redispatch(0)
+	s.Equal([]int{2, 2, 1}, submittedLevels)
+	s.Equal(4, processorBase.redispatcher.Size())
+	s.Equal(2, result.SubmitStatsByDomainID["testDomain2"].Submitted)
+	s.Equal(1, result.SubmitStatsByDomainID["testDomain1"].Submitted)
+	s.Equal(1, result.SubmitStatsByDomainID["testDomain0"].Rejected)
+
+	// nacked tasks go back to the redispatch queue of their level
+	var nackedTask task.Task
+	for task, level := range tasksByLevel {
+		if level == 2 {
+			nackedTask = task
+			break
+		}
+	}
+	processorBase.redispatchNackedTask(2, nackedTask)
+	s.True(processorBase.redispatcher.Snapshot()[0] == nackedTask)
+}
+
+func (s *processorBaseSuite) TestBoostDomain() {
+	now := time.Now()
+	timeSource := clock.NewEventTimeSource().Update(now)
+	s.mockShard.Resource.TimeSource = timeSource
+	processorBase := s.newTestProcessorBase(nil, nil, nil, nil, nil)
+	processorBase.redispatcher.Start()
+	defer processorBase.redispatcher.Stop()
+	processorBase.processingQueueCollections = []ProcessingQueueCollection{
+		NewProcessingQueueCollection(0, []ProcessingQueue{
+			newProcessingQueue(
+				NewProcessingQueueState(0, newTransferTaskKey(0), newTransferTaskKey(10), NewDomainFilter(map[string]struct{}{"boostedDomain": {}}, true)),
+				nil,
+				s.logger,
+				s.metricsClient,
+			),
+		}),
+		NewProcessingQueueCollection(1, []ProcessingQueue{
+			newProcessingQueue(
+				NewProcessingQueueState(1, newTransferTaskKey(0), newTransferTaskKey(10), NewDomainFilter(map[string]struct{}{"boostedDomain": {}}, false)),
+				nil,
+				s.logger,
+				s.metricsClient,
+			),
+		}),
+	}
+
+	newMockTask := func(domainID string, taskID int64) *task.MockTask {
+		mockTask := task.NewMockTask(s.controller)
+		mockTask.EXPECT().Priority().Return(0).AnyTimes()
+		mockTask.EXPECT().GetDomainID().Return(domainID).AnyTimes()
+		mockTask.EXPECT().GetTaskID().Return(taskID).AnyTimes()
+		return mockTask
+	}
+	var submittedTaskIDs []int64
+	s.mockTaskProcessor.EXPECT().TrySubmit(gomock.Any()).DoAndReturn(func(task task.Task) (bool, error) {
+		submittedTaskIDs = append(submittedTaskIDs, task.GetTaskID())
+		return true, nil
+	}).AnyTimes()
+
+	processorBase.BoostDomain("boostedDomain", now.Add(time.Minute))
+	s.Equal(map[string]time.Time{"boostedDomain": now.Add(time.Minute)}, processorBase.MetricsSnapshot().BoostedDomains)
+	s.False(processorBase.isLevelBoosted(0))
+	s.True(processorBase.isLevelBoosted(1))
+
+	// tasks of the boosted domain are redispatched first
+	s.NoError(processorBase.deferTask(0, newMockTask("testDomain", 1)))
+	s.NoError(processorBase.deferTask(0, newMockTask("boostedDomain", 2)))
+	s.True(processorBase.redispatch(context.Background(), 0))
+	s.Equal([]int64{2, 1}, submittedTaskIDs)
+	s.Zero(processorBase.redispatcher.Size())
+
+	// the boost expires after the deadline
+	timeSource.Update(now.Add(time.Minute))
+	s.Empty(processorBase.MetricsSnapshot().BoostedDomains)
+	s.False(processorBase.isLevelBoosted(1))
+
+	submittedTaskIDs = nil
+	s.NoError(processorBase.deferTask(0, newMockTask("testDomain", 3)))
+	s.NoError(processorBase.deferTask(0, newMockTask("boostedDomain", 4)))
+	s.True(processorBase.redispatch(context.Background(), 0))
+	s.Equal([]int64{3, 4}, submittedTaskIDs)
+
+	// a deadline in the past removes the boost right away
+	processorBase.BoostDomain("boostedDomain", now.Add(2*time.Minute))
+	s.True(processorBase.isDomainBoosted("boostedDomain"))
+	processorBase.BoostDomain("boostedDomain", now)
+	s.False(processorBase.isDomainBoosted("boostedDomain"))
+}
+
+func (s *processorBaseSuite) TestDeferTask_RedispatchQueueFull() {
+	processorBase := s.newTestProcessorBase(nil, nil, nil, nil, nil)
+	processorBase.redispatcher.Start()
+	defer processorBase.redispatcher.Stop()
+	processorBase.options.MaxRedispatchQueueSizePerDomain = func(domainID string) int {
+		if domainID == "cappedDomain" {
+			return 2
+		}
+		return 0
+	}
+
+	newMockTask := func(domainID string) *task.MockTask {
+		mockTask := task.NewMockTask(s.controller)
+		mockTask.EXPECT().Priority().Return(0).AnyTimes()
+		mockTask.EXPECT().GetDomainID().Return(domainID).AnyTimes()
+		return mockTask
+	}
+
+	s.NoError(processorBase.deferTask(0, newMockTask("cappedDomain")))
+	s.NoError(processorBase.deferTask(0, newMockTask("cappedDomain")))
+	s.Equal(2, processorBase.redispatcher.SizeByDomainID("cappedDomain"))
+
+	// the capped domain has reached its limit and its tasks are parked, while other domains can still defer tasks
+	parkedTasks := []*task.MockTask{newMockTask("cappedDomain"), newMockTask("cappedDomain"), newMockTask("cappedDomain")}
+	s.NoError(processorBase.deferTask(0, parkedTasks[0]))
+	s.NoError(processorBase.deferTask(1, parkedTasks[1]))
+	s.NoError(processorBase.deferTask(0, parkedTasks[2]))
+	for i := 0; i != 3; i++ {
+		s.NoError(processorBase.deferTask(0, newMockTask("testDomain")))
+	}
+	s.Equal(2, processorBase.redispatcher.SizeByDomainID("cappedDomain"))
+	s.Equal(3, processorBase.redispatcher.SizeByDomainID("testDomain"))
+	s.Equal(5, processorBase.redispatcher.Size())
+	s.Len(processorBase.parkedTasksByDomain["cappedDomain"], 3)
+
+	// parked tasks are not released while the capped domain is still at its limit
+	processorBase.releaseParkedTasks()
+	s.Len(processorBase.parkedTasksByDomain["cappedDomain"], 3)
+
+	// parked tasks are released in order once tasks of the capped domain are redispatched
+	s.mockTaskProcessor.EXPECT().TrySubmit(gomock.Any()).Return(true, nil).Times(5)
+	s.True(processorBase.redispatch(context.Background(), 0))
+	s.Zero(processorBase.redispatcher.SizeByDomainID("cappedDomain"))
+	processorBase.releaseParkedTasks()
+	s.Equal(2, processorBase.redispatcher.SizeByDomainID("cappedDomain"))
+	s.Len(processorBase.parkedTasksByDomain["cappedDomain"], 1)
+	s.True(processorBase.parkedTasksByDomain["cappedDomain"][0].task == parkedTasks[2])
+}
+
+func (s *processorBaseSuite) TestRedispatchNackedTask_RedispatchDisabled() {
+	processorBase := s.newTestProcessorBase(nil, nil, nil, nil, nil)
+	processorBase.options.DisableRedispatch = dynamicconfig.GetBoolPropertyFn(true)
+
+	for taskID := int64(1); taskID <= 2; taskID++ {
+		mockTask := task.NewMockTask(s.controller)
+		mockTask.EXPECT().GetTaskID().Return(taskID).AnyTimes()
+		processorBase.redispatchNackedTask(0, mockTask)
+	}
+	s.Zero(processorBase.redispatcher.Size())
+
+	// a single reset is requested so that the dropped tasks are read again from the ack level
+	select {
+	case notification := <-processorBase.actionNotifyCh:
+		s.Equal(ActionTypeReset, notification.action.ActionType)
+	case <-time.After(time.Second):
+		s.Fail("reset should be requested for dropped tasks")
+	}
+	select {
+	case <-processorBase.actionNotifyCh:
+		s.Fail("only one reset should be requested")
+	default:
+	}
+}
+
+func (s *processorBaseSuite) TestRedispatchPolicyByCategory() {
+	config := s.mockShard.GetConfig()
+	droppedDomainID := "droppedDomain"
+	redispatchPolicies := newRedispatchPolicies(config)
+	redispatchPolicies[task.CategoryTimer].ShouldDrop = func(task task.Task) bool {
+		return task.GetDomainID() == droppedDomainID
+	}
+	newTestProcessorBase := func(options *queueProcessorOptions) *processorBase {
+		options.RedispatchPolicies = redispatchPolicies
+		return newProcessorBase(
+			s.mockShard,
+			nil,
+			s.mockTaskProcessor,
+			options,
+			nil,
+			nil,
+			nil,
+			nil,
+			s.logger,
+			s.metricsClient,
+		)
+	}
+	transferProcessorBase := newTestProcessorBase(newTransferQueueProcessorOptions(config, true, false))
+	timerProcessorBase := newTestProcessorBase(newTimerQueueProcessorOptions(config, true, false))
+
+	transferPolicy := transferProcessorBase.getRedispatchPolicy()
+	s.Equal(config.QueueProcessorRedispatchRequeueMaxDelay(), transferPolicy.RequeueMaxDelay())
+	s.Equal(config.QueueProcessorRedispatchMaxBatchSize(), transferPolicy.MaxBatchSize())
+	s.Nil(transferPolicy.ShouldDrop)
+	timerPolicy := timerProcessorBase.getRedispatchPolicy()
+	s.Equal(config.TimerProcessorRedispatchRequeueMaxDelay(), timerPolicy.RequeueMaxDelay())
+	s.Equal(config.TimerProcessorRedispatchMaxBatchSize(), timerPolicy.MaxBatchSize())
+	s.Equal(config.QueueProcessorRedispatchMinBatchSize(), timerPolicy.MinBatchSize())
+	s.NotEqual(transferPolicy.RequeueMaxDelay(), timerPolicy.RequeueMaxDelay())
+
+	// the drop rule of the timer policy doesn't apply to transfer tasks
+	transferTask := task.NewMockTask(s.controller)
+	transferTask.EXPECT().Priority().Return(0).AnyTimes()
+	transferTask.EXPECT().GetDomainID().Return(droppedDomainID).AnyTimes()
+	s.mockTaskProcessor.EXPECT().TrySubmit(transferTask).Return(true, nil).Times(1)
+	transferProcessorBase.redispatcher.Start()
+	defer transferProcessorBase.redispatcher.Stop()
+	s.NoError(transferProcessorBase.deferTask(0, transferTask))
+	transferProcessorBase.redispatcher.Redispatch(0)
+	s.Zero(transferProcessorBase.redispatcher.Size())
+
+	timerTask := task.NewMockTask(s.controller)
+	timerTask.EXPECT().Priority().Return(0).AnyTimes()
+	timerTask.EXPECT().GetDomainID().Return(droppedDomainID).AnyTimes()
+	timerTask.EXPECT().Ack().Times(1)
+	timerProcessorBase.redispatcher.Start()
+	defer timerProcessorBase.redispatcher.Stop()
+	s.NoError(timerProcessorBase.deferTask(0, timerTask))
+	timerProcessorBase.redispatcher.Redispatch(0)
+	s.Zero(timerProcessorBase.redispatcher.Size())
+}
+
+func (s *processorBaseSuite) TestRedispatchDecisionLog() {
+	logSize := 3
+	options := newTransferQueueProcessorOptions(s.mockShard.GetConfig(), true, false)
+	options.RedispatchDecisionLogSize = dynamicconfig.GetIntPropertyFn(logSize)
+	processorBase := newProcessorBase(
+		s.mockShard,
+		nil,
+		s.mockTaskProcessor,
+		options,
+		nil,
+		nil,
+		nil,
+		nil,
+		s.logger,
+		s.metricsClient,
+	)
+	processorBase.redispatcher.Start()
+	defer processorBase.redispatcher.Stop()
+	s.Empty(processorBase.MetricsSnapshot().RecentRedispatchDecisions)
+
+	numTasks := 5
+	for taskID := int64(1); taskID <= int64(numTasks); taskID++ {
+		mockTask := task.NewMockTask(s.controller)
+		mockTask.EXPECT().Priority().Return(0).AnyTimes()
+		mockTask.EXPECT().GetDomainID().Return("testDomain").AnyTimes()
+		mockTask.EXPECT().GetTaskID().Return(taskID).AnyTimes()
+		mockTask.EXPECT().GetTaskCategory().Return(task.CategoryTransfer).AnyTimes()
+		mockTask.EXPECT().GetAttempt().Return(int(taskID)).AnyTimes()
+		s.NoError(processorBase.deferTask(0, mockTask))
+	}
+
+	// the last task is rejected by the task processor and requeued
+	numSubmitted := 0
+	s.mockTaskProcessor.EXPECT().TrySubmit(gomock.Any()).DoAndReturn(func(task task.Task) (bool, error) {
+		if numSubmitted == numTasks-1 {
+			return false, nil
+		}
+		numSubmitted++
+		return true, nil
+	}).Times(numTasks)
+	processorBase.redispatcher.Redispatch(0)
+
+	// only the last logSize decisions are retained, from the oldest to the newest
+	decisions := processorBase.MetricsSnapshot().RecentRedispatchDecisions
+	s.Len(decisions, logSize)
+	expectedActions := []task.SubmitAction{task.SubmitActionSubmitted, task.SubmitActionSubmitted, task.SubmitActionRequeue}
+	for idx, decision := range decisions {
+		taskID := int64(numTasks - logSize + idx + 1)
+		s.Equal(newTransferTaskKey(taskID), decision.Key)
+		s.Equal("testDomain", decision.DomainID)
+		s.Equal(expectedActions[idx], decision.Action)
+		s.Equal(int(taskID), decision.Attempt)
+	}
+}
+
+func (s *processorBaseSuite) TestTaskEventSink() {
+	sink := &testTaskEventSink{eventCh: make(chan TaskEvent, 10)}
+	options := newTransferQueueProcessorOptions(s.mockShard.GetConfig(), true, false)
+	options.TaskEventSink = sink
+	processorBase := newProcessorBase(
+		s.mockShard,
+		nil,
+		s.mockTaskProcessor,
+		options,
+		nil,
+		nil,
+		nil,
+		nil,
+		s.logger,
+		s.metricsClient,
+	)
+	processorBase.startTaskEventPump()
+	processorBase.redispatcher.Start()
+	defer func() {
+		close(processorBase.shutdownCh)
+		processorBase.redispatcher.Stop()
+		processorBase.shutdownWG.Wait()
+	}()
+
+	var tasks []*task.MockTask
+	for taskID := int64(1); taskID <= 3; taskID++ {
+		mockTask := task.NewMockTask(s.controller)
+		mockTask.EXPECT().Priority().Return(0).AnyTimes()
+		mockTask.EXPECT().GetDomainID().Return("testDomain").AnyTimes()
+		mockTask.EXPECT().GetWorkflowID().Return("testWorkflowID").AnyTimes()
+		mockTask.EXPECT().GetRunID().Return("testRunID").AnyTimes()
+		mockTask.EXPECT().GetTaskType().Return(persistence.TransferTaskTypeActivityTask).AnyTimes()
+		mockTask.EXPECT().GetTaskID().Return(taskID).AnyTimes()
+		mockTask.EXPECT().GetTaskCategory().Return(task.CategoryTransfer).AnyTimes()
+		tasks = append(tasks, mockTask)
+	}
+	s.mockTaskProcessor.EXPECT().TrySubmit(tasks[0]).Return(true, nil).Times(1)
+	s.mockTaskProcessor.EXPECT().TrySubmit(tasks[1]).Return(true, nil).Times(1)
+	gomock.InOrder(
+		s.mockTaskProcessor.EXPECT().TrySubmit(tasks[2]).Return(false, nil).Times(1),
+		s.mockTaskProcessor.EXPECT().TrySubmit(tasks[2]).Return(true, nil).Times(1),
+	)
+
+	for _, mockTask := range tasks {
+		_, err := processorBase.submitTask(0, mockTask)
+		s.NoError(err)
+	}
+	// the rejected task is only audited once it's submitted by the redispatcher
+	processorBase.redispatcher.Redispatch(0)
+
+	var events []TaskEvent
+	for len(events) != len(tasks) {
+		select {
+		case event := <-sink.eventCh:
+			events = append(events, event)
+		case <-time.After(5 * time.Second):
+			s.FailNow("task events are not delivered", "received %v events", len(events))
+		}
+	}
+	for idx, event := range events {
+		s.Equal(TaskEvent{
+			DomainID:     "testDomain",
+			WorkflowID:   "testWorkflowID",
+			RunID:        "testRunID",
+			TaskType:     persistence.TransferTaskTypeActivityTask,
+			Key:          newTransferTaskKey(int64(idx + 1)),
+			Redispatched: idx == 2,
+		}, event)
+	}
+	s.Empty(sink.eventCh)
+}
+
+func (s *processorBaseSuite) TestPauseDomain() {
+	processorBase := s.newTestProcessorBase(nil, nil, nil, nil, nil)
+
+	pausedTask := task.NewMockTask(s.controller)
+	pausedTask.EXPECT().Priority().Return(0).AnyTimes()
+	pausedTask.EXPECT().GetDomainID().Return("testDomain1").AnyTimes()
+	activeTask := task.NewMockTask(s.controller)
+	activeTask.EXPECT().Priority().Return(0).AnyTimes()
+	activeTask.EXPECT().GetDomainID().Return("testDomain2").AnyTimes()
+	s.mockTaskProcessor.EXPECT().TrySubmit(activeTask).Return(true, nil).Times(1)
+
+	processorBase.PauseDomain("testDomain1")
+	s.Equal([]string{"testDomain1"}, processorBase.getProcessingQueueStates().GetStateActionResult.PausedDomainIDs)
+
+	submitted, err := processorBase.submitTask(0, pausedTask)
+	s.NoError(err)
+	s.True(submitted)
+	submitted, err = processorBase.submitTask(0, activeTask)
+	s.NoError(err)
+	s.True(submitted)
+	s.Equal(1, processorBase.redispatcher.Size())
+
+	processorBase.redispatcher.Start()
+	defer processorBase.redispatcher.Stop()
+
+	processorBase.redispatcher.Redispatch(0)
+	s.Equal(1, processorBase.redispatcher.Size())
+
+	s.mockTaskProcessor.EXPECT().TrySubmit(pausedTask).Return(true, nil).Times(1)
+	processorBase.ResumeDomain("testDomain1")
+	s.Empty(processorBase.getProcessingQueueStates().GetStateActionResult.PausedDomainIDs)
+
+	processorBase.redispatcher.Redispatch(0)
+	s.Equal(0, processorBase.redispatcher.Size())
+}
+
+func (s *processorBaseSuite) TestReadOnly() {
+	processingQueueStates := []ProcessingQueueState{
+		newProcessingQueueState(
+			0,
+			newTransferTaskKey(0),
+			newTransferTaskKey(10),
+			newTransferTaskKey(100),
+			NewDomainFilter(nil, true),
+		),
+	}
+	updateClusterAckLevelCalled := false
+	updateClusterAckLevel := func(ackLevel task.Key) error {
+		updateClusterAckLevelCalled = true
+		return nil
+	}
+	options := newTransferQueueProcessorOptions(s.mockShard.GetConfig(), true, false)
+	options.ReadOnly = true
+	processorBase := newProcessorBase(
+		s.mockShard,
+		processingQueueStates,
+		s.mockTaskProcessor,
+		options,
+		nil,
+		updateClusterAckLevel,
+		nil,
+		nil,
+		s.logger,
+		s.metricsClient,
+	)
+
+	mockTask := task.NewMockTask(s.controller)
+	mockTask.EXPECT().Priority().Return(0).AnyTimes()
+	mockTask.EXPECT().GetDomainID().Return("testDomain1").AnyTimes()
+	s.mockTaskProcessor.EXPECT().TrySubmit(gomock.Any()).Times(0)
+
+	submitted, err := processorBase.submitTask(0, mockTask)
+	s.NoError(err)
+	s.True(submitted)
+	s.Equal(1, processorBase.redispatcher.Size())
+
+	processorBase.redispatcher.Start()
+	defer processorBase.redispatcher.Stop()
+
+	s.True(processorBase.redispatch(context.Background(), 0))
+	s.Equal(1, processorBase.redispatcher.Size())
+
+	_, err = processorBase.updateAckLevel(context.Background())
+	s.NoError(err)
+	s.True(updateClusterAckLevelCalled)
+
+	s.mockTaskProcessor.EXPECT().TrySubmit(mockTask).Return(true, nil).Times(1)
+	processorBase.SetReadOnly(false)
+	s.True(processorBase.redispatch(context.Background(), 0))
+	s.Equal(0, processorBase.redispatcher.Size())
+}
+
+func (s *processorBaseSuite) TestDumpRedispatchQueue() {
+	processorBase := s.newTestProcessorBase(nil, nil, nil, nil, nil)
+
+	expectedTaskInfos := []RedispatchTaskInfo{
+		{
+			DomainID:   "testDomain1",
+			WorkflowID: "testWorkflow1",
+			RunID:      "testRun1",
+			TaskID:     1,
+			TaskType:   2,
+			Attempt:    3,
+		},
+		{
+			DomainID:   "testDomain2",
+			WorkflowID: "testWorkflow2",
+			RunID:      "testRun2",
+			TaskID:     4,
+			TaskType:   5,
+			Attempt:    6,
+		},
+	}
+	for idx := len(expectedTaskInfos) - 1; idx >= 0; idx-- {
+		taskInfo := expectedTaskInfos[idx]
+		mockTask := task.NewMockTask(s.controller)
+		mockTask.EXPECT().Priority().Return(idx).AnyTimes()
+		mockTask.EXPECT().GetDomainID().Return(taskInfo.DomainID).AnyTimes()
+		mockTask.EXPECT().GetWorkflowID().Return(taskInfo.WorkflowID).AnyTimes()
+		mockTask.EXPECT().GetRunID().Return(taskInfo.RunID).AnyTimes()
+		mockTask.EXPECT().GetTaskID().Return(taskInfo.TaskID).AnyTimes()
+		mockTask.EXPECT().GetTaskType().Return(taskInfo.TaskType).AnyTimes()
+		mockTask.EXPECT().GetAttempt().Return(taskInfo.Attempt).AnyTimes()
+		processorBase.redispatcher.AddTask(mockTask)
+	}
+
+	s.Equal(expectedTaskInfos, processorBase.DumpRedispatchQueue())
+	s.Equal(len(expectedTaskInfos), processorBase.redispatcher.Size())
+}
+
+func (s *processorBaseSuite) TestRedispatchTaskKeys() {
+	processorBase := s.newTestProcessorBase(nil, nil, nil, nil, nil)
+	s.Empty(processorBase.RedispatchTaskKeys())
+
+	now := time.Now()
+	expectedTaskKeys := []task.Key{
+		newTransferTaskKey(1),
+		newTimerTaskKey(now, 2),
+		newTransferTaskKey(3),
+	}
+	for idx := len(expectedTaskKeys) - 1; idx >= 0; idx-- {
+		mockTask := task.NewMockTask(s.controller)
+		mockTask.EXPECT().Priority().Return(idx).AnyTimes()
+		mockTask.EXPECT().GetDomainID().Return("some random domainID").AnyTimes()
+		switch taskKey := expectedTaskKeys[idx].(type) {
+		case transferTaskKey:
+			mockTask.EXPECT().GetTaskCategory().Return(task.CategoryTransfer).AnyTimes()
+			mockTask.EXPECT().GetTaskID().Return(taskKey.taskID).AnyTimes()
+		case timerTaskKey:
+			mockTask.EXPECT().GetTaskCategory().Return(task.CategoryTimer).AnyTimes()
+			mockTask.EXPECT().GetVisibilityTimestamp().Return(taskKey.visibilityTimestamp).AnyTimes()
+			mockTask.EXPECT().GetTaskID().Return(taskKey.taskID).AnyTimes()
+		}
+		processorBase.redispatcher.AddTask(mockTask)
+	}
+
+	s.Equal(expectedTaskKeys, processorBase.RedispatchTaskKeys())
+	s.Equal(len(expectedTaskKeys), processorBase.redispatcher.Size())
 }
 
 func (s *processorBaseSuite) newTestProcessorBase(
@@ -347,3 +3693,67 @@ func (s *processorBaseSuite) newTestProcessorBase(
 		s.metricsClient,
 	)
 }
+
+// testTaskEventSink forwards task events to eventCh
+type testTaskEventSink struct {
+	eventCh chan TaskEvent
+}
+
+func (s *testTaskEventSink) OnTaskSubmitted(
+	event TaskEvent,
+) {
+	s.eventCh <- event
+}
+
+// testSplitApprover records proposed states and vetoes all of them if veto is true
+type testSplitApprover struct {
+	veto           bool
+	proposedStates [][]ProcessingQueueState
+}
+
+func (a *testSplitApprover) ApproveSplit(
+	queue ProcessingQueue,
+	proposedStates []ProcessingQueueState,
+) []ProcessingQueueState {
+	a.proposedStates = append(a.proposedStates, proposedStates)
+	if a.veto {
+		return nil
+	}
+	return proposedStates
+}
+
+// blockingSplitPolicy blocks each evaluation until the context is cancelled
+type blockingSplitPolicy struct {
+	evaluatedCh chan struct{}
+}
+
+func (p *blockingSplitPolicy) Evaluate(
+	queue ProcessingQueue,
+) []ProcessingQueueState {
+	return nil
+}
+
+func (p *blockingSplitPolicy) EvaluateWithContext(
+	ctx context.Context,
+	queue ProcessingQueue,
+) []ProcessingQueueState {
+	p.evaluatedCh <- struct{}{}
+	<-ctx.Done()
+	return nil
+}
+
+// testTaskKey is a task key type other than transfer and timer task keys
+type testTaskKey struct {
+	version int64
+	id      int64
+}
+
+func (k testTaskKey) Less(
+	key task.Key,
+) bool {
+	testKey := key.(testTaskKey)
+	if k.version == testKey.version {
+		return k.id < testKey.id
+	}
+	return k.version < testKey.version
+}
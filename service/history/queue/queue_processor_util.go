@@ -21,17 +21,96 @@
 package queue
 
 import (
+	"fmt"
+	"sort"
 	"time"
 
 	h "github.com/uber/cadence/.gen/go/history"
 	"github.com/uber/cadence/common"
+	"github.com/uber/cadence/service/history/config"
+	"github.com/uber/cadence/service/history/task"
 )
 
+type (
+	// StateSpec is a compact description of a processing queue state.
+	// MinID and MaxID are the ack level and max level of a transfer queue,
+	// MinTime and MaxTime are the ack level and max level of a timer queue.
+	// The queue matches Domains, or all domains except Domains if Reverse is true
+	StateSpec struct {
+		Level   int
+		MinID   int64
+		MaxID   int64
+		MinTime time.Time
+		MaxTime time.Time
+		Domains []string
+		Reverse bool
+	}
+
+	// ProcessingQueueStatesDelta records the changes from one list of processing queue states
+	// to another, see DiffProcessingQueueStates. It's usually much smaller than the full list when
+	// only a few states changed, and a sequence of deltas can be replayed over a base list of states
+	// with ApplyProcessingQueueStatesDeltas to reconstruct the latest list
+	ProcessingQueueStatesDelta struct {
+		Added    []ProcessingQueueState
+		Removed  []ProcessingQueueState
+		Modified []ProcessingQueueState
+	}
+)
+
+// ProcessingQueueStatesFromSpec creates processing queue states from specs,
+// task keys are created based on the given task category
+func ProcessingQueueStatesFromSpec(
+	category task.Category,
+	specs []StateSpec,
+) ([]ProcessingQueueState, error) {
+	states := make([]ProcessingQueueState, 0, len(specs))
+	for _, spec := range specs {
+		var ackLevel, maxLevel task.Key
+		switch category {
+		case task.CategoryTransfer:
+			ackLevel = newTransferTaskKey(spec.MinID)
+			maxLevel = newTransferTaskKey(spec.MaxID)
+		case task.CategoryTimer:
+			ackLevel = newTimerTaskKey(spec.MinTime, 0)
+			maxLevel = newTimerTaskKey(spec.MaxTime, 0)
+		default:
+			return nil, fmt.Errorf("unsupported task category for processing queue states: %v", category)
+		}
+
+		states = append(states, NewProcessingQueueState(
+			spec.Level,
+			ackLevel,
+			maxLevel,
+			NewDomainFilter(covertToDomainIDSet(spec.Domains), spec.Reverse),
+		))
+	}
+
+	return states, nil
+}
+
+// newRedispatchPolicies creates the redispatch policy for each task category. Timer tasks are
+// time sensitive and have their own requeue delays and batch size, transfer tasks use the
+// redispatch options shared by all queue processors
+func newRedispatchPolicies(
+	config *config.Config,
+) map[task.Category]*RedispatchPolicy {
+	return map[task.Category]*RedispatchPolicy{
+		task.CategoryTransfer: {},
+		task.CategoryTimer: {
+			RequeueDelay:    config.TimerProcessorRedispatchRequeueDelay,
+			RequeueMaxDelay: config.TimerProcessorRedispatchRequeueMaxDelay,
+			MaxBatchSize:    config.TimerProcessorRedispatchMaxBatchSize,
+		},
+	}
+}
+
 func convertToPersistenceTransferProcessingQueueStates(
 	states []ProcessingQueueState,
 ) []*h.ProcessingQueueState {
 	pStates := make([]*h.ProcessingQueueState, 0, len(states))
 	for _, state := range states {
+		// TODO: persist task type filter and priority, currently they will be
+		// reset to match all task types and no priority after shard reload
 		pStates = append(pStates, &h.ProcessingQueueState{
 			Level:        common.Int32Ptr(int32(state.Level())),
 			AckLevel:     common.Int64Ptr(state.AckLevel().(transferTaskKey).taskID),
@@ -94,13 +173,8 @@ func convertFromPersistenceTimerProcessingQueueStates(
 func convertToPersistenceDomainFilter(
 	domainFilter DomainFilter,
 ) *h.DomainFilter {
-	domainIDs := make([]string, 0, len(domainFilter.DomainIDs))
-	for domainID := range domainFilter.DomainIDs {
-		domainIDs = append(domainIDs, domainID)
-	}
-
 	return &h.DomainFilter{
-		DomainIDs:    domainIDs,
+		DomainIDs:    domainFilter.Domains(),
 		ReverseMatch: common.BoolPtr(domainFilter.ReverseMatch),
 	}
 }
@@ -138,3 +212,117 @@ func validateProcessingQueueStates(
 		return false
 	}
 }
+
+// DiffProcessingQueueStates compares two lists of processing queue states and returns
+// states that are only in after (added), only in before (removed), and states from after
+// that have the same level and ack level as a state in before but are otherwise different (modified).
+// All returned lists are ordered by level and then ack level.
+func DiffProcessingQueueStates(
+	before []ProcessingQueueState,
+	after []ProcessingQueueState,
+) (added []ProcessingQueueState, removed []ProcessingQueueState, modified []ProcessingQueueState) {
+	matched := make([]bool, len(before))
+	for _, afterState := range after {
+		found := false
+		for idx, beforeState := range before {
+			if matched[idx] ||
+				beforeState.Level() != afterState.Level() ||
+				!taskKeyEquals(beforeState.AckLevel(), afterState.AckLevel()) {
+				continue
+			}
+
+			matched[idx] = true
+			found = true
+			if !processingQueueStateEquals(beforeState, afterState) {
+				modified = append(modified, afterState)
+			}
+			break
+		}
+
+		if !found {
+			added = append(added, afterState)
+		}
+	}
+
+	for idx, beforeState := range before {
+		if !matched[idx] {
+			removed = append(removed, beforeState)
+		}
+	}
+
+	sortProcessingQueueStates(added)
+	sortProcessingQueueStates(removed)
+	sortProcessingQueueStates(modified)
+	return added, removed, modified
+}
+
+// NewProcessingQueueStatesDelta creates a delta that turns before into after
+func NewProcessingQueueStatesDelta(
+	before []ProcessingQueueState,
+	after []ProcessingQueueState,
+) *ProcessingQueueStatesDelta {
+	added, removed, modified := DiffProcessingQueueStates(before, after)
+	return &ProcessingQueueStatesDelta{
+		Added:    added,
+		Removed:  removed,
+		Modified: modified,
+	}
+}
+
+// IsEmpty returns true if the delta contains no change
+func (d *ProcessingQueueStatesDelta) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Modified) == 0
+}
+
+// ApplyProcessingQueueStatesDeltas replays deltas in order over the base states and returns
+// the resulting states ordered by level and then ack level. Base is not modified. An error is
+// returned if a removed or modified state doesn't match any state when its delta is applied,
+// which means the deltas were not recorded against base
+func ApplyProcessingQueueStatesDeltas(
+	base []ProcessingQueueState,
+	deltas ...*ProcessingQueueStatesDelta,
+) ([]ProcessingQueueState, error) {
+	states := make([]ProcessingQueueState, len(base))
+	copy(states, base)
+
+	findState := func(target ProcessingQueueState) int {
+		for idx, state := range states {
+			if state.Level() == target.Level() && taskKeyEquals(state.AckLevel(), target.AckLevel()) {
+				return idx
+			}
+		}
+		return -1
+	}
+
+	for deltaIdx, delta := range deltas {
+		for _, removedState := range delta.Removed {
+			idx := findState(removedState)
+			if idx == -1 || !processingQueueStateEquals(states[idx], removedState) {
+				return nil, fmt.Errorf("delta %v removes processing queue state %v not found in states", deltaIdx, removedState)
+			}
+			states = append(states[:idx], states[idx+1:]...)
+		}
+		for _, modifiedState := range delta.Modified {
+			idx := findState(modifiedState)
+			if idx == -1 {
+				return nil, fmt.Errorf("delta %v modifies processing queue state %v not found in states", deltaIdx, modifiedState)
+			}
+			states[idx] = modifiedState
+		}
+		states = append(states, delta.Added...)
+	}
+
+	sortProcessingQueueStates(states)
+	return states, nil
+}
+
+func sortProcessingQueueStates(
+	states []ProcessingQueueState,
+) {
+	sort.SliceStable(states, func(i, j int) bool {
+		if states[i].Level() == states[j].Level() {
+			return states[i].AckLevel().Less(states[j].AckLevel())
+		}
+		return states[i].Level() < states[j].Level()
+	})
+}
@@ -29,6 +29,7 @@ import (
 
 	h "github.com/uber/cadence/.gen/go/history"
 	"github.com/uber/cadence/common"
+	"github.com/uber/cadence/service/history/task"
 )
 
 type (
@@ -159,6 +160,228 @@ func (s *queueProcessorUtilSuite) TestConvertFromPersistenceTimerProcessingQueue
 	}
 }
 
+func (s *queueProcessorUtilSuite) TestProcessingQueueStatesFromSpec_Transfer() {
+	states, err := ProcessingQueueStatesFromSpec(task.CategoryTransfer, []StateSpec{
+		{Level: 0, MinID: 10, MaxID: 100, Domains: []string{"domain 1"}, Reverse: true},
+		{Level: 1, MinID: 20, MaxID: 50, Domains: []string{"domain 1", "domain 2"}},
+	})
+	s.NoError(err)
+	s.Equal([]ProcessingQueueState{
+		NewProcessingQueueState(
+			0,
+			newTransferTaskKey(10),
+			newTransferTaskKey(100),
+			NewDomainFilter(map[string]struct{}{"domain 1": {}}, true),
+		),
+		NewProcessingQueueState(
+			1,
+			newTransferTaskKey(20),
+			newTransferTaskKey(50),
+			NewDomainFilter(map[string]struct{}{"domain 1": {}, "domain 2": {}}, false),
+		),
+	}, states)
+}
+
+func (s *queueProcessorUtilSuite) TestProcessingQueueStatesFromSpec_Timer() {
+	now := time.Now()
+	states, err := ProcessingQueueStatesFromSpec(task.CategoryTimer, []StateSpec{
+		{Level: 0, MinTime: now, MaxTime: now.Add(time.Hour), Reverse: true},
+		{Level: 2, MinTime: now.Add(-time.Minute), MaxTime: now.Add(time.Minute), Domains: []string{"domain 1"}},
+	})
+	s.NoError(err)
+	s.Equal([]ProcessingQueueState{
+		NewProcessingQueueState(
+			0,
+			newTimerTaskKey(now, 0),
+			newTimerTaskKey(now.Add(time.Hour), 0),
+			NewDomainFilter(nil, true),
+		),
+		NewProcessingQueueState(
+			2,
+			newTimerTaskKey(now.Add(-time.Minute), 0),
+			newTimerTaskKey(now.Add(time.Minute), 0),
+			NewDomainFilter(map[string]struct{}{"domain 1": {}}, false),
+		),
+	}, states)
+}
+
+func (s *queueProcessorUtilSuite) TestProcessingQueueStatesFromSpec_UnsupportedCategory() {
+	_, err := ProcessingQueueStatesFromSpec(task.CategoryReplication, []StateSpec{{Level: 0}})
+	s.Error(err)
+}
+
+func (s *queueProcessorUtilSuite) TestDiffProcessingQueueStates() {
+	before := []ProcessingQueueState{
+		NewProcessingQueueState(
+			0,
+			newTransferTaskKey(0),
+			newTransferTaskKey(100),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, true),
+		),
+		NewProcessingQueueState(
+			1,
+			newTransferTaskKey(0),
+			newTransferTaskKey(100),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+		),
+		NewProcessingQueueState(
+			0,
+			newTransferTaskKey(100),
+			newTransferTaskKey(1000),
+			NewDomainFilter(map[string]struct{}{}, true),
+		),
+	}
+	after := []ProcessingQueueState{
+		NewProcessingQueueState(
+			2,
+			newTransferTaskKey(100),
+			newTransferTaskKey(1000),
+			NewDomainFilter(map[string]struct{}{"testDomain3": {}}, false),
+		),
+		NewProcessingQueueState(
+			0,
+			newTransferTaskKey(100),
+			newTransferTaskKey(1000),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}, "testDomain2": {}, "testDomain3": {}}, false),
+		),
+		NewProcessingQueueState(
+			0,
+			newTransferTaskKey(0),
+			newTransferTaskKey(100),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, true),
+		),
+		NewProcessingQueueState(
+			1,
+			newTransferTaskKey(100),
+			newTransferTaskKey(1000),
+			NewDomainFilter(map[string]struct{}{"testDomain2": {}}, false),
+		),
+		NewProcessingQueueState(
+			2,
+			newTransferTaskKey(0),
+			newTransferTaskKey(100),
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+		),
+	}
+
+	added, removed, modified := DiffProcessingQueueStates(before, after)
+	s.Equal([]ProcessingQueueState{after[3], after[4], after[0]}, added)
+	s.Equal([]ProcessingQueueState{before[1]}, removed)
+	s.Equal([]ProcessingQueueState{after[1]}, modified)
+
+	added, removed, modified = DiffProcessingQueueStates(before, before)
+	s.Empty(added)
+	s.Empty(removed)
+	s.Empty(modified)
+}
+
+func (s *queueProcessorUtilSuite) TestApplyProcessingQueueStatesDeltas() {
+	topologies := [][]ProcessingQueueState{
+		{
+			NewProcessingQueueState(
+				0,
+				newTransferTaskKey(0),
+				newTransferTaskKey(1000),
+				NewDomainFilter(map[string]struct{}{}, true),
+			),
+		},
+		// split testDomain1 to level 1
+		{
+			NewProcessingQueueState(
+				0,
+				newTransferTaskKey(0),
+				newTransferTaskKey(1000),
+				NewDomainFilter(map[string]struct{}{"testDomain1": {}}, true),
+			),
+			NewProcessingQueueState(
+				1,
+				newTransferTaskKey(0),
+				newTransferTaskKey(1000),
+				NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+			),
+		},
+		// ack level of level 1 moved forward and a new state is added to level 0
+		{
+			NewProcessingQueueState(
+				0,
+				newTransferTaskKey(0),
+				newTransferTaskKey(1000),
+				NewDomainFilter(map[string]struct{}{"testDomain1": {}}, true),
+			),
+			NewProcessingQueueState(
+				1,
+				newTransferTaskKey(500),
+				newTransferTaskKey(1000),
+				NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+			),
+			NewProcessingQueueState(
+				0,
+				newTransferTaskKey(1000),
+				newTransferTaskKey(2000),
+				NewDomainFilter(map[string]struct{}{}, true),
+			),
+		},
+		// testDomain2 is split to level 1 as well
+		{
+			NewProcessingQueueState(
+				0,
+				newTransferTaskKey(0),
+				newTransferTaskKey(1000),
+				NewDomainFilter(map[string]struct{}{"testDomain1": {}}, true),
+			),
+			NewProcessingQueueState(
+				1,
+				newTransferTaskKey(500),
+				newTransferTaskKey(1000),
+				NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+			),
+			NewProcessingQueueState(
+				0,
+				newTransferTaskKey(1000),
+				newTransferTaskKey(2000),
+				NewDomainFilter(map[string]struct{}{"testDomain2": {}}, true),
+			),
+			NewProcessingQueueState(
+				1,
+				newTransferTaskKey(1000),
+				newTransferTaskKey(2000),
+				NewDomainFilter(map[string]struct{}{"testDomain2": {}}, false),
+			),
+		},
+		// level 1 is merged back to level 0
+		{
+			NewProcessingQueueState(
+				0,
+				newTransferTaskKey(500),
+				newTransferTaskKey(2000),
+				NewDomainFilter(map[string]struct{}{}, true),
+			),
+		},
+	}
+
+	var deltas []*ProcessingQueueStatesDelta
+	for i := 1; i != len(topologies); i++ {
+		delta := NewProcessingQueueStatesDelta(topologies[i-1], topologies[i])
+		s.False(delta.IsEmpty())
+		deltas = append(deltas, delta)
+	}
+	s.True(NewProcessingQueueStatesDelta(topologies[1], topologies[1]).IsEmpty())
+
+	for i := 0; i != len(topologies); i++ {
+		states, err := ApplyProcessingQueueStatesDeltas(topologies[0], deltas[:i]...)
+		s.NoError(err)
+
+		expectedStates := make([]ProcessingQueueState, len(topologies[i]))
+		copy(expectedStates, topologies[i])
+		sortProcessingQueueStates(expectedStates)
+		s.Equal(expectedStates, states)
+	}
+
+	// deltas recorded against a different base can't be applied
+	_, err := ApplyProcessingQueueStatesDeltas(topologies[0], deltas[1:]...)
+	s.Error(err)
+}
+
 func (s *queueProcessorUtilSuite) assertProcessingQueueStateEqual(
 	state ProcessingQueueState,
 	pState *h.ProcessingQueueState,
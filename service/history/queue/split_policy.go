@@ -21,9 +21,12 @@
 package queue
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
+	"time"
 
+	"github.com/uber/cadence/common"
 	"github.com/uber/cadence/common/log"
 	"github.com/uber/cadence/common/log/tag"
 	"github.com/uber/cadence/common/metrics"
@@ -32,20 +35,48 @@ import (
 	"github.com/uber/cadence/service/history/task"
 )
 
+const (
+	// SplitSkipReasonNone means the policy split the queue
+	SplitSkipReasonNone SplitSkipReason = ""
+	// SplitSkipReasonNoAction is the reason for policies that don't report why a queue is not split
+	SplitSkipReasonNoAction SplitSkipReason = "no_action"
+	// SplitSkipReasonMaxLevelReached means the queue is already at the max split level
+	SplitSkipReasonMaxLevelReached SplitSkipReason = "max_level_reached"
+	// SplitSkipReasonInvalidConfig means the policy config can't be parsed
+	SplitSkipReasonInvalidConfig SplitSkipReason = "invalid_config"
+	// SplitSkipReasonNoThreshold means no threshold is specified for the queue level
+	SplitSkipReasonNoThreshold SplitSkipReason = "no_threshold"
+	// SplitSkipReasonThresholdNotMet means no domain in the queue exceeds the threshold
+	SplitSkipReasonThresholdNotMet SplitSkipReason = "threshold_not_met"
+	// SplitSkipReasonDomainNotEnabled means domains exceeding the threshold don't have the policy enabled
+	SplitSkipReasonDomainNotEnabled SplitSkipReason = "domain_not_enabled"
+	// SplitSkipReasonDisabled means the policy is disabled
+	SplitSkipReasonDisabled SplitSkipReason = "disabled"
+	// SplitSkipReasonNoPendingTask means the queue has no outstanding task
+	SplitSkipReasonNoPendingTask SplitSkipReason = "no_pending_task"
+	// SplitSkipReasonNotSelected means no domain is selected by the random split policy
+	SplitSkipReasonNotSelected SplitSkipReason = "not_selected"
+)
+
 const (
 	policyTypePendingTask int = iota + 1
 	policyTypeStuckTask
 	policyTypeSelectedDomain
 	policyTypeRandom
+	policyTypeTaskType
+	policyTypePinnedDomain
 )
 
 type (
 	lookAheadFunc func(task.Key, string) task.Key
 
+	// SplitSkipReason describes why a split policy doesn't split a processing queue
+	SplitSkipReason string
+
 	pendingTaskSplitPolicy struct {
-		pendingTaskThreshold map[int]int // queue level -> threshold
+		pendingTaskThreshold dynamicconfig.MapPropertyFn // queue level -> threshold
 		enabledByDomainID    dynamicconfig.BoolPropertyFnWithDomainIDFilter
-		maxNewQueueLevel     int
+		maxNewQueueLevel     dynamicconfig.IntPropertyFn
 		lookAheadFunc        lookAheadFunc
 
 		logger       log.Logger
@@ -53,9 +84,9 @@ type (
 	}
 
 	stuckTaskSplitPolicy struct {
-		attemptThreshold  map[int]int // queue level -> threshold
+		attemptThreshold  dynamicconfig.MapPropertyFn // queue level -> threshold
 		enabledByDomainID dynamicconfig.BoolPropertyFnWithDomainIDFilter
-		maxNewQueueLevel  int
+		maxNewQueueLevel  dynamicconfig.IntPropertyFn
 
 		logger       log.Logger
 		metricsScope metrics.Scope
@@ -70,27 +101,89 @@ type (
 	}
 
 	randomSplitPolicy struct {
-		splitProbability  float64
+		splitProbability  dynamicconfig.FloatPropertyFn
 		enabledByDomainID dynamicconfig.BoolPropertyFnWithDomainIDFilter
-		maxNewQueueLevel  int
+		maxNewQueueLevel  dynamicconfig.IntPropertyFn
 		lookAheadFunc     lookAheadFunc
 
 		logger       log.Logger
 		metricsScope metrics.Scope
 	}
 
+	taskTypeSplitPolicy struct {
+		taskTypes     map[int]struct{}
+		newQueueLevel int
+
+		logger       log.Logger
+		metricsScope metrics.Scope
+	}
+
+	pinnedDomainSplitPolicy struct {
+		pinnedDomainLevels map[string]int
+
+		logger       log.Logger
+		metricsScope metrics.Scope
+	}
+
 	aggregatedSplitPolicy struct {
 		policies []ProcessingQueueSplitPolicy
 	}
+
+	// timedSplitPolicy wraps a split policy and records the number of
+	// evaluations and the total time spent evaluating the policy
+	timedSplitPolicy struct {
+		ProcessingQueueSplitPolicy
+
+		name         string
+		numEvaluated int
+		duration     time.Duration
+		skipReasons  []splitSkipRecord
+	}
+
+	// contextSplitPolicy binds a context to a split policy so that the context
+	// can be passed through ProcessingQueue.Split, which only takes the policy
+	contextSplitPolicy struct {
+		ctx    context.Context
+		policy ProcessingQueueSplitPolicy
+	}
+
+	// approvedSplitPolicy consults a SplitApprover before returning the states proposed by
+	// the wrapped policy, and records the number of proposed splits vetoed by the approver
+	approvedSplitPolicy struct {
+		policy   ProcessingQueueSplitPolicy
+		approver SplitApprover
+
+		numVetoed int
+	}
+
+	// defaultSplitApprover approves all proposed splits
+	defaultSplitApprover struct{}
+
+	// cooldownSplitApprover vetoes proposed splits which move a domain split or merged within
+	// the cooldown to another level, and records the time domains are moved by approved splits
+	cooldownSplitApprover struct {
+		approver         SplitApprover
+		domainSplitTimes map[string]time.Time
+		now              time.Time
+		cooldown         time.Duration
+		metricsScope     metrics.Scope
+	}
+
+	// splitSkipRecord records a processing queue not split by a policy
+	splitSkipRecord struct {
+		level  int
+		reason SplitSkipReason
+	}
 )
 
 // NewPendingTaskSplitPolicy creates a new processing queue split policy
-// based on the number of pending tasks
+// based on the number of pending tasks. Thresholds and max queue level are
+// read from the given dynamic config properties on every evaluation
 func NewPendingTaskSplitPolicy(
-	pendingTaskThreshold map[int]int,
+	pendingTaskThreshold dynamicconfig.MapPropertyFn,
 	enabledByDomainID dynamicconfig.BoolPropertyFnWithDomainIDFilter,
 	lookAheadFunc lookAheadFunc,
-	maxNewQueueLevel int,
+	maxNewQueueLevel dynamicconfig.IntPropertyFn,
 	logger log.Logger,
 	metricsScope metrics.Scope,
 ) ProcessingQueueSplitPolicy {
@@ -105,11 +198,12 @@ func NewPendingTaskSplitPolicy(
 }
 
 // NewStuckTaskSplitPolicy creates a new processing queue split policy
-// based on the number of task attempts tasks
+// based on the number of task attempts tasks. Thresholds and max queue level
+// are read from the given dynamic config properties on every evaluation
 func NewStuckTaskSplitPolicy(
-	attemptThreshold map[int]int,
+	attemptThreshold dynamicconfig.MapPropertyFn,
 	enabledByDomainID dynamicconfig.BoolPropertyFnWithDomainIDFilter,
-	maxNewQueueLevel int,
+	maxNewQueueLevel dynamicconfig.IntPropertyFn,
 	logger log.Logger,
 	metricsScope metrics.Scope,
 ) ProcessingQueueSplitPolicy {
@@ -138,12 +232,26 @@ func NewSelectedDomainSplitPolicy(
 	}
 }
 
+// newPinnedDomainSplitPolicy creates a split policy that moves pinned domains
+// in a processing queue to the levels they are pinned to
+func newPinnedDomainSplitPolicy(
+	pinnedDomainLevels map[string]int,
+	logger log.Logger,
+	metricsScope metrics.Scope,
+) ProcessingQueueSplitPolicy {
+	return &pinnedDomainSplitPolicy{
+		pinnedDomainLevels: pinnedDomainLevels,
+		logger:             logger,
+		metricsScope:       metricsScope,
+	}
+}
+
 // NewRandomSplitPolicy creates a split policy that will randomly split one
 // or more domains into a new processing queue
 func NewRandomSplitPolicy(
-	splitProbability float64,
+	splitProbability dynamicconfig.FloatPropertyFn,
 	enabledByDomainID dynamicconfig.BoolPropertyFnWithDomainIDFilter,
-	maxNewQueueLevel int,
+	maxNewQueueLevel dynamicconfig.IntPropertyFn,
 	lookAheadFunc lookAheadFunc,
 	logger log.Logger,
 	metricsScope metrics.Scope,
@@ -158,6 +266,22 @@ func NewRandomSplitPolicy(
 	}
 }
 
+// NewTaskTypeSplitPolicy creates a new processing queue split policy
+// that splits out specific task types to a new processing queue level
+func NewTaskTypeSplitPolicy(
+	taskTypes map[int]struct{},
+	newQueueLevel int,
+	logger log.Logger,
+	metricsScope metrics.Scope,
+) ProcessingQueueSplitPolicy {
+	return &taskTypeSplitPolicy{
+		taskTypes:     taskTypes,
+		newQueueLevel: newQueueLevel,
+		logger:        logger,
+		metricsScope:  metricsScope,
+	}
+}
+
 // NewAggregatedSplitPolicy creates a new processing queue split policy
 // that which combines other policies. Policies are evaluated in the order
 // they passed in, and if one policy returns an non-empty result, that result
@@ -173,17 +297,30 @@ func NewAggregatedSplitPolicy(
 func (p *pendingTaskSplitPolicy) Evaluate(
 	queue ProcessingQueue,
 ) []ProcessingQueueState {
+	newStates, _ := p.EvaluateWithReason(queue)
+	return newStates
+}
+
+func (p *pendingTaskSplitPolicy) EvaluateWithReason(
+	queue ProcessingQueue,
+) ([]ProcessingQueueState, SplitSkipReason) {
 	queueImpl := queue.(*processingQueueImpl)
 
-	if queueImpl.state.level == p.maxNewQueueLevel {
+	if queueImpl.state.level >= p.maxNewQueueLevel() {
 		// already reaches max level, skip splitting
-		return nil
+		return nil, SplitSkipReasonMaxLevelReached
+	}
+
+	pendingTaskThreshold, err := common.ConvertDynamicConfigMapPropertyToIntMap(p.pendingTaskThreshold())
+	if err != nil {
+		p.logger.Error("Failed to convert pending task threshold", tag.Error(err))
+		return nil, SplitSkipReasonInvalidConfig
 	}
 
-	threshold, ok := p.pendingTaskThreshold[queueImpl.state.level]
+	threshold, ok := pendingTaskThreshold[queueImpl.state.level]
 	if !ok {
 		// no threshold specified for the level, skip splitting
-		return nil
+		return nil, SplitSkipReasonNoThreshold
 	}
 
 	pendingTasksPerDomain := make(map[string]int) // domainID -> # of pending tasks
@@ -194,14 +331,20 @@ func (p *pendingTaskSplitPolicy) Evaluate(
 	}
 
 	domainToSplit := make(map[string]struct{})
+	skipReason := SplitSkipReasonThresholdNotMet
 	for domainID, pendingTasks := range pendingTasksPerDomain {
-		if pendingTasks > threshold && p.enabledByDomainID(domainID) {
-			domainToSplit[domainID] = struct{}{}
+		if pendingTasks <= threshold {
+			continue
+		}
+		if !p.enabledByDomainID(domainID) {
+			skipReason = SplitSkipReasonDomainNotEnabled
+			continue
 		}
+		domainToSplit[domainID] = struct{}{}
 	}
 
 	if len(domainToSplit) == 0 {
-		return nil
+		return nil, skipReason
 	}
 
 	newQueueLevel := queueImpl.state.level + 1 // split stuck tasks to current level + 1
@@ -218,36 +361,55 @@ func (p *pendingTaskSplitPolicy) Evaluate(
 		domainToSplit,
 		newQueueLevel,
 		p.lookAheadFunc,
-	)
+	), SplitSkipReasonNone
 }
 
 func (p *stuckTaskSplitPolicy) Evaluate(
 	queue ProcessingQueue,
 ) []ProcessingQueueState {
+	newStates, _ := p.EvaluateWithReason(queue)
+	return newStates
+}
+
+func (p *stuckTaskSplitPolicy) EvaluateWithReason(
+	queue ProcessingQueue,
+) ([]ProcessingQueueState, SplitSkipReason) {
 	queueImpl := queue.(*processingQueueImpl)
 
-	if queueImpl.state.level == p.maxNewQueueLevel {
+	if queueImpl.state.level >= p.maxNewQueueLevel() {
 		// already reaches max level, skip splitting
-		return nil
+		return nil, SplitSkipReasonMaxLevelReached
 	}
 
-	threshold, ok := p.attemptThreshold[queueImpl.state.level]
+	attemptThreshold, err := common.ConvertDynamicConfigMapPropertyToIntMap(p.attemptThreshold())
+	if err != nil {
+		p.logger.Error("Failed to convert stuck task threshold", tag.Error(err))
+		return nil, SplitSkipReasonInvalidConfig
+	}
+
+	threshold, ok := attemptThreshold[queueImpl.state.level]
 	if !ok {
 		// no threshold specified for the level, skip splitting
-		return nil
+		return nil, SplitSkipReasonNoThreshold
 	}
 
 	domainToSplit := make(map[string]struct{})
+	skipReason := SplitSkipReasonThresholdNotMet
 	for _, task := range queueImpl.outstandingTasks {
 		domainID := task.GetDomainID()
 		attempt := task.GetAttempt()
-		if attempt > threshold && p.enabledByDomainID(domainID) {
-			domainToSplit[domainID] = struct{}{}
+		if attempt <= threshold {
+			continue
 		}
+		if !p.enabledByDomainID(domainID) {
+			skipReason = SplitSkipReasonDomainNotEnabled
+			continue
+		}
+		domainToSplit[domainID] = struct{}{}
 	}
 
 	if len(domainToSplit) == 0 {
-		return nil
+		return nil, skipReason
 	}
 
 	newQueueLevel := queueImpl.state.level + 1 // split stuck tasks to current level + 1
@@ -264,7 +426,7 @@ func (p *stuckTaskSplitPolicy) Evaluate(
 		domainToSplit,
 		newQueueLevel,
 		nil, // no need to look ahead
-	)
+	), SplitSkipReasonNone
 }
 
 func (p *selectedDomainSplitPolicy) Evaluate(
@@ -294,32 +456,110 @@ func (p *selectedDomainSplitPolicy) Evaluate(
 	p.metricsScope.IncCounter(metrics.ProcessingQueueSelectedDomainSplitCounter)
 
 	return []ProcessingQueueState{
-		newProcessingQueueState(
+		newProcessingQueueStateWithTaskTypeFilter(
 			currentQueueState.Level(),
 			currentQueueState.AckLevel(),
 			currentQueueState.ReadLevel(),
 			currentQueueState.MaxLevel(),
 			currentDomainFilter.Exclude(p.domainIDs),
-		),
-		newProcessingQueueState(
+			currentQueueState.TaskTypeFilter().copy(),
+		).withPriority(currentQueueState.Priority()),
+		newProcessingQueueStateWithTaskTypeFilter(
 			p.newQueueLevel,
 			currentQueueState.AckLevel(),
 			currentQueueState.ReadLevel(),
 			currentQueueState.MaxLevel(),
 			// make a copy here so that it won't be accidentally modified when p.domainID is changed
 			NewDomainFilter(p.domainIDs, false).copy(),
+			currentQueueState.TaskTypeFilter().copy(),
 		),
 	}
 }
 
+// Evaluate moves pinned domains matched by the queue but pinned to another level. If these
+// domains are pinned to different levels, only those pinned to the lowest level are moved
+// and the rest are moved when the resulting queue is evaluated again
+func (p *pinnedDomainSplitPolicy) Evaluate(
+	queue ProcessingQueue,
+) []ProcessingQueueState {
+	currentQueueState := queue.State()
+	currentDomainFilter := currentQueueState.DomainFilter()
+
+	newQueueLevel := -1
+	for domainID, level := range p.pinnedDomainLevels {
+		if level == currentQueueState.Level() || !currentDomainFilter.Filter(domainID) {
+			continue
+		}
+		if newQueueLevel == -1 || level < newQueueLevel {
+			newQueueLevel = level
+		}
+	}
+	if newQueueLevel == -1 {
+		// no pinned domain to move
+		return nil
+	}
+
+	domainIDs := make(map[string]struct{})
+	for domainID, level := range p.pinnedDomainLevels {
+		if level == newQueueLevel && currentDomainFilter.Filter(domainID) {
+			domainIDs[domainID] = struct{}{}
+		}
+	}
+
+	p.logger.Info("Split processing queue",
+		tag.QueueLevel(newQueueLevel),
+		tag.PreviousQueueLevel(currentQueueState.Level()),
+		tag.WorkflowDomainIDs(domainIDs),
+		tag.QueueSplitPolicyType(policyTypePinnedDomain),
+	)
+	p.metricsScope.IncCounter(metrics.ProcessingQueuePinnedDomainSplitCounter)
+
+	newQueueStates := []ProcessingQueueState{
+		newProcessingQueueStateWithTaskTypeFilter(
+			newQueueLevel,
+			currentQueueState.AckLevel(),
+			currentQueueState.ReadLevel(),
+			currentQueueState.MaxLevel(),
+			NewDomainFilter(domainIDs, false),
+			currentQueueState.TaskTypeFilter().copy(),
+		),
+	}
+
+	remainingDomainFilter := currentDomainFilter.Exclude(domainIDs)
+	if remainingDomainFilter.ReverseMatch || len(remainingDomainFilter.DomainIDs) != 0 {
+		// this means the remaining domain filter still matches at least one domain
+		newQueueStates = append(newQueueStates, newProcessingQueueStateWithTaskTypeFilter(
+			currentQueueState.Level(),
+			currentQueueState.AckLevel(),
+			currentQueueState.ReadLevel(),
+			currentQueueState.MaxLevel(),
+			remainingDomainFilter,
+			currentQueueState.TaskTypeFilter().copy(),
+		).withPriority(currentQueueState.Priority()))
+	}
+	return newQueueStates
+}
+
 func (p *randomSplitPolicy) Evaluate(
 	queue ProcessingQueue,
 ) []ProcessingQueueState {
+	newStates, _ := p.EvaluateWithReason(queue)
+	return newStates
+}
+
+func (p *randomSplitPolicy) EvaluateWithReason(
+	queue ProcessingQueue,
+) ([]ProcessingQueueState, SplitSkipReason) {
 	queueImpl := queue.(*processingQueueImpl)
 
-	if queueImpl.state.level == p.maxNewQueueLevel {
+	if queueImpl.state.level >= p.maxNewQueueLevel() {
 		// already reaches max level, skip splitting
-		return nil
+		return nil, SplitSkipReasonMaxLevelReached
+	}
+
+	splitProbability := p.splitProbability()
+	if splitProbability == float64(0) {
+		return nil, SplitSkipReasonDisabled
 	}
 
 	domainIDs := make(map[string]struct{})
@@ -328,7 +568,7 @@ func (p *randomSplitPolicy) Evaluate(
 	}
 
 	if len(domainIDs) == 0 {
-		return nil
+		return nil, SplitSkipReasonNoPendingTask
 	}
 
 	domainToSplit := make(map[string]struct{})
@@ -336,7 +576,7 @@ func (p *randomSplitPolicy) Evaluate(
 		if !p.enabledByDomainID(domainID) {
 			continue
 		}
-		if !shouldSplit(p.splitProbability) {
+		if !shouldSplit(splitProbability) {
 			continue
 		}
 
@@ -344,7 +584,7 @@ func (p *randomSplitPolicy) Evaluate(
 	}
 
 	if len(domainToSplit) == 0 {
-		return nil
+		return nil, SplitSkipReasonNotSelected
 	}
 
 	newQueueLevel := queueImpl.state.level + 1 // split stuck tasks to current level + 1
@@ -361,14 +601,83 @@ func (p *randomSplitPolicy) Evaluate(
 		domainToSplit,
 		newQueueLevel,
 		p.lookAheadFunc,
+	), SplitSkipReasonNone
+}
+
+func (p *taskTypeSplitPolicy) Evaluate(
+	queue ProcessingQueue,
+) []ProcessingQueueState {
+	currentQueueState := queue.State()
+	if currentQueueState.Level() == p.newQueueLevel {
+		// task types are already in their own level
+		return nil
+	}
+
+	currentTaskTypeFilter := currentQueueState.TaskTypeFilter()
+	taskTypeToSplit := make(map[int]struct{})
+	for taskType := range p.taskTypes {
+		if currentTaskTypeFilter.Filter(taskType) {
+			taskTypeToSplit[taskType] = struct{}{}
+		}
+	}
+
+	if len(taskTypeToSplit) == 0 {
+		// no split needed
+		return nil
+	}
+
+	p.logger.Info("Split processing queue",
+		tag.QueueLevel(p.newQueueLevel),
+		tag.PreviousQueueLevel(currentQueueState.Level()),
+		tag.Value(taskTypeToSplit),
+		tag.QueueSplitPolicyType(policyTypeTaskType),
 	)
+	p.metricsScope.IncCounter(metrics.ProcessingQueueTaskTypeSplitCounter)
+
+	newQueueStates := []ProcessingQueueState{
+		newProcessingQueueStateWithTaskTypeFilter(
+			p.newQueueLevel,
+			currentQueueState.AckLevel(),
+			currentQueueState.ReadLevel(),
+			currentQueueState.MaxLevel(),
+			currentQueueState.DomainFilter().copy(),
+			NewTaskTypeFilter(taskTypeToSplit, false),
+		),
+	}
+
+	excludedTaskTypeFilter := currentTaskTypeFilter.Exclude(taskTypeToSplit)
+	if excludedTaskTypeFilter.ReverseMatch || len(excludedTaskTypeFilter.TaskTypes) != 0 {
+		// this means the new task type filter still matches at least one task type
+		newQueueStates = append(newQueueStates, newProcessingQueueStateWithTaskTypeFilter(
+			currentQueueState.Level(),
+			currentQueueState.AckLevel(),
+			currentQueueState.ReadLevel(),
+			currentQueueState.MaxLevel(),
+			currentQueueState.DomainFilter().copy(),
+			excludedTaskTypeFilter,
+		).withPriority(currentQueueState.Priority()))
+	}
+
+	return newQueueStates
 }
 
 func (p *aggregatedSplitPolicy) Evaluate(
 	queue ProcessingQueue,
+) []ProcessingQueueState {
+	return p.EvaluateWithContext(context.Background(), queue)
+}
+
+// EvaluateWithContext evaluates the policies in order and stops
+// without splitting the queue once the context is cancelled
+func (p *aggregatedSplitPolicy) EvaluateWithContext(
+	ctx context.Context,
+	queue ProcessingQueue,
 ) []ProcessingQueueState {
 	for _, policy := range p.policies {
-		newStates := policy.Evaluate(queue)
+		if ctx.Err() != nil {
+			return nil
+		}
+		newStates := evaluateSplitPolicyWithContext(ctx, policy, queue)
 		if len(newStates) != 0 {
 			return newStates
 		}
@@ -376,6 +685,245 @@ func (p *aggregatedSplitPolicy) Evaluate(
 	return nil
 }
 
+func (p *timedSplitPolicy) Evaluate(
+	queue ProcessingQueue,
+) []ProcessingQueueState {
+	return p.EvaluateWithContext(context.Background(), queue)
+}
+
+func (p *timedSplitPolicy) EvaluateWithContext(
+	ctx context.Context,
+	queue ProcessingQueue,
+) []ProcessingQueueState {
+	startTime := time.Now()
+	var newStates []ProcessingQueueState
+	var reason SplitSkipReason
+	if policyWithContext, ok := p.ProcessingQueueSplitPolicy.(ProcessingQueueSplitPolicyWithContext); ok {
+		newStates = policyWithContext.EvaluateWithContext(ctx, queue)
+		if len(newStates) == 0 {
+			reason = SplitSkipReasonNoAction
+		}
+	} else {
+		newStates, reason = evaluateSplitPolicyWithReason(p.ProcessingQueueSplitPolicy, queue)
+	}
+	p.numEvaluated++
+	p.duration += time.Since(startTime)
+
+	if len(newStates) == 0 {
+		p.skipReasons = append(p.skipReasons, splitSkipRecord{
+			level:  queue.State().Level(),
+			reason: reason,
+		})
+	}
+	return newStates
+}
+
+func (p *timedSplitPolicy) reset() {
+	p.numEvaluated = 0
+	p.duration = 0
+	p.skipReasons = nil
+}
+
+// newContextSplitPolicy returns a policy which evaluates the given policy with ctx,
+// queues are not split once ctx is cancelled
+func newContextSplitPolicy(
+	ctx context.Context,
+	policy ProcessingQueueSplitPolicy,
+) ProcessingQueueSplitPolicy {
+	return &contextSplitPolicy{
+		ctx:    ctx,
+		policy: policy,
+	}
+}
+
+func (p *contextSplitPolicy) Evaluate(
+	queue ProcessingQueue,
+) []ProcessingQueueState {
+	if p.ctx.Err() != nil {
+		return nil
+	}
+	return evaluateSplitPolicyWithContext(p.ctx, p.policy, queue)
+}
+
+// newApprovedSplitPolicy returns a policy which applies the states proposed by
+// the given policy only when they are approved by the approver
+func newApprovedSplitPolicy(
+	policy ProcessingQueueSplitPolicy,
+	approver SplitApprover,
+) *approvedSplitPolicy {
+	return &approvedSplitPolicy{
+		policy:   policy,
+		approver: approver,
+	}
+}
+
+func (p *approvedSplitPolicy) Evaluate(
+	queue ProcessingQueue,
+) []ProcessingQueueState {
+	return p.EvaluateWithContext(context.Background(), queue)
+}
+
+func (p *approvedSplitPolicy) EvaluateWithContext(
+	ctx context.Context,
+	queue ProcessingQueue,
+) []ProcessingQueueState {
+	proposedStates := evaluateSplitPolicyWithContext(ctx, p.policy, queue)
+	if len(proposedStates) == 0 {
+		return nil
+	}
+
+	approvedStates := p.approver.ApproveSplit(queue, proposedStates)
+	if len(approvedStates) == 0 {
+		p.numVetoed++
+		return nil
+	}
+	return approvedStates
+}
+
+func (a defaultSplitApprover) ApproveSplit(
+	_ ProcessingQueue,
+	proposedStates []ProcessingQueueState,
+) []ProcessingQueueState {
+	return proposedStates
+}
+
+// newCooldownSplitApprover returns an approver which vetoes proposed splits of domains
+// split or merged within the cooldown before consulting the given approver.
+// domainSplitTimes is updated with now for domains moved by approved splits
+func newCooldownSplitApprover(
+	approver SplitApprover,
+	domainSplitTimes map[string]time.Time,
+	now time.Time,
+	cooldown time.Duration,
+	metricsScope metrics.Scope,
+) SplitApprover {
+	return &cooldownSplitApprover{
+		approver:         approver,
+		domainSplitTimes: domainSplitTimes,
+		now:              now,
+		cooldown:         cooldown,
+		metricsScope:     metricsScope,
+	}
+}
+
+func (a *cooldownSplitApprover) ApproveSplit(
+	queue ProcessingQueue,
+	proposedStates []ProcessingQueueState,
+) []ProcessingQueueState {
+	for _, domainID := range getMovedDomainIDs(queue, proposedStates) {
+		if splitTime, ok := a.domainSplitTimes[domainID]; ok && a.now.Sub(splitTime) < a.cooldown {
+			a.metricsScope.IncCounter(metrics.ProcessingQueueSplitCooldownCounter)
+			return nil
+		}
+	}
+
+	approvedStates := a.approver.ApproveSplit(queue, proposedStates)
+	for _, domainID := range getMovedDomainIDs(queue, approvedStates) {
+		a.domainSplitTimes[domainID] = a.now
+	}
+	return approvedStates
+}
+
+// getMovedDomainIDs returns the domains moved out of the level of the queue by the states,
+// domains in states matching all but some domains are not included
+func getMovedDomainIDs(
+	queue ProcessingQueue,
+	states []ProcessingQueueState,
+) []string {
+	var domainIDs []string
+	for _, state := range states {
+		if state.Level() != queue.State().Level() {
+			domainIDs = append(domainIDs, getFilteredDomainIDs(state.DomainFilter())...)
+		}
+	}
+	return domainIDs
+}
+
+// evaluateSplitPolicyWithContext evaluates the policy with ctx if it implements
+// ProcessingQueueSplitPolicyWithContext, otherwise ctx is ignored
+func evaluateSplitPolicyWithContext(
+	ctx context.Context,
+	policy ProcessingQueueSplitPolicy,
+	queue ProcessingQueue,
+) []ProcessingQueueState {
+	if policyWithContext, ok := policy.(ProcessingQueueSplitPolicyWithContext); ok {
+		return policyWithContext.EvaluateWithContext(ctx, queue)
+	}
+	return policy.Evaluate(queue)
+}
+
+// evaluateSplitPolicyWithReason evaluates the policy and returns the reason if the queue is not split,
+// SplitSkipReasonNoAction is returned for policies not implementing ProcessingQueueSplitPolicyWithReason
+func evaluateSplitPolicyWithReason(
+	policy ProcessingQueueSplitPolicy,
+	queue ProcessingQueue,
+) ([]ProcessingQueueState, SplitSkipReason) {
+	if policyWithReason, ok := policy.(ProcessingQueueSplitPolicyWithReason); ok {
+		newStates, reason := policyWithReason.EvaluateWithReason(queue)
+		if len(newStates) == 0 && reason == SplitSkipReasonNone {
+			reason = SplitSkipReasonNoAction
+		}
+		return newStates, reason
+	}
+
+	newStates := policy.Evaluate(queue)
+	if len(newStates) == 0 {
+		return nil, SplitSkipReasonNoAction
+	}
+	return newStates, SplitSkipReasonNone
+}
+
+// newTimedSplitPolicy wraps the given policy so that its evaluation time can be measured.
+// For aggregated split policy, each of the underlying policies is wrapped and measured separately.
+// Returns the wrapped policy and the list of policies being measured.
+func newTimedSplitPolicy(
+	policy ProcessingQueueSplitPolicy,
+) (ProcessingQueueSplitPolicy, []*timedSplitPolicy) {
+	aggregatedPolicy, ok := policy.(*aggregatedSplitPolicy)
+	if !ok {
+		timedPolicy := &timedSplitPolicy{
+			ProcessingQueueSplitPolicy: policy,
+			name:                       splitPolicyName(policy),
+		}
+		return timedPolicy, []*timedSplitPolicy{timedPolicy}
+	}
+
+	timedPolicies := make([]*timedSplitPolicy, 0, len(aggregatedPolicy.policies))
+	policies := make([]ProcessingQueueSplitPolicy, 0, len(aggregatedPolicy.policies))
+	for _, policy := range aggregatedPolicy.policies {
+		timedPolicy := &timedSplitPolicy{
+			ProcessingQueueSplitPolicy: policy,
+			name:                       splitPolicyName(policy),
+		}
+		timedPolicies = append(timedPolicies, timedPolicy)
+		policies = append(policies, timedPolicy)
+	}
+	return NewAggregatedSplitPolicy(policies...), timedPolicies
+}
+
+func splitPolicyName(
+	policy ProcessingQueueSplitPolicy,
+) string {
+	switch policy.(type) {
+	case *pendingTaskSplitPolicy:
+		return "pendingTask"
+	case *stuckTaskSplitPolicy:
+		return "stuckTask"
+	case *selectedDomainSplitPolicy:
+		return "selectedDomain"
+	case *randomSplitPolicy:
+		return "random"
+	case *taskTypeSplitPolicy:
+		return "taskType"
+	case *pinnedDomainSplitPolicy:
+		return "pinnedDomain"
+	case *aggregatedSplitPolicy:
+		return "aggregated"
+	default:
+		return fmt.Sprintf("%T", policy)
+	}
+}
+
 // splitQueueHelper assumes domainToSplit is not empty
 func splitQueueHelper(
 	queueImpl *processingQueueImpl,
@@ -393,38 +941,7 @@ func splitQueueHelper(
 		newMaxLevel = queueImpl.state.maxLevel
 	}
 
-	newQueueStates := []ProcessingQueueState{
-		newProcessingQueueState(
-			newQueueLevel,
-			queueImpl.state.ackLevel,
-			queueImpl.state.readLevel,
-			newMaxLevel,
-			NewDomainFilter(domainToSplit, false),
-		),
-	}
-
-	excludedDomainFilter := queueImpl.state.domainFilter.Exclude(domainToSplit)
-	if excludedDomainFilter.ReverseMatch || len(excludedDomainFilter.DomainIDs) != 0 {
-		// this means the new domain filter still matches at least one domain
-		newQueueStates = append(newQueueStates, newProcessingQueueState(
-			queueImpl.state.level,
-			queueImpl.state.ackLevel,
-			queueImpl.state.readLevel,
-			newMaxLevel,
-			excludedDomainFilter,
-		))
-	}
-
-	if !taskKeyEquals(newMaxLevel, queueImpl.state.maxLevel) {
-		newQueueStates = append(newQueueStates, newProcessingQueueState(
-			queueImpl.state.level,
-			newMaxLevel,
-			newMaxLevel,
-			queueImpl.state.maxLevel,
-			queueImpl.state.domainFilter.copy(),
-		))
-	}
-
+	newQueueStates := queueImpl.SplitAt(newMaxLevel, domainToSplit, newQueueLevel)
 	for _, state := range newQueueStates {
 		if state.ReadLevel().Less(state.AckLevel()) || state.MaxLevel().Less(state.ReadLevel()) {
 			panic(fmt.Sprintf("invalid processing queue split result: %v, state before split: %v, newMaxLevel: %v", state, queueImpl.state, newMaxLevel))
@@ -21,6 +21,7 @@
 package queue
 
 import (
+	"context"
 	"sort"
 	"testing"
 
@@ -29,9 +30,11 @@ import (
 	"github.com/stretchr/testify/suite"
 	"github.com/uber-go/tally"
 
+	"github.com/uber/cadence/common"
 	"github.com/uber/cadence/common/log"
 	"github.com/uber/cadence/common/log/loggerimpl"
 	"github.com/uber/cadence/common/metrics"
+	"github.com/uber/cadence/common/persistence"
 	"github.com/uber/cadence/common/service/dynamicconfig"
 	t "github.com/uber/cadence/common/task"
 	"github.com/uber/cadence/service/history/task"
@@ -81,10 +84,10 @@ func (s *splitPolicySuite) TestPendingTaskSplitPolicy() {
 		return testKey{ID: currentID + lookAheadTasks}
 	}
 	pendingTaskSplitPolicy := NewPendingTaskSplitPolicy(
-		pendingTaskThreshold,
+		dynamicconfig.GetMapPropertyFn(common.ConvertIntMapToDynamicConfigMapProperty(pendingTaskThreshold)),
 		dynamicconfig.GetBoolPropertyFnFilteredByDomain(true),
 		lookAheadFunc,
-		maxNewQueueLevel,
+		dynamicconfig.GetIntPropertyFn(maxNewQueueLevel),
 		s.logger,
 		s.metricsScope,
 	)
@@ -280,6 +283,202 @@ func (s *splitPolicySuite) TestPendingTaskSplitPolicy() {
 	}
 }
 
+func (s *splitPolicySuite) TestPendingTaskSplitPolicy_ThresholdChanged() {
+	pendingTaskThreshold := map[int]int{0: 100}
+	pendingTaskSplitPolicy := NewPendingTaskSplitPolicy(
+		func(...dynamicconfig.FilterOption) map[string]interface{} {
+			return common.ConvertIntMapToDynamicConfigMapProperty(pendingTaskThreshold)
+		},
+		dynamicconfig.GetBoolPropertyFnFilteredByDomain(true),
+		nil,
+		dynamicconfig.GetIntPropertyFn(3),
+		s.logger,
+		s.metricsScope,
+	)
+
+	numPendingTasks := 50
+	outstandingTasks := make(map[task.Key]task.Task)
+	for i := 0; i != numPendingTasks; i++ {
+		mockTask := task.NewMockTask(s.controller)
+		mockTask.EXPECT().GetDomainID().Return("testDomain1").AnyTimes()
+		mockTask.EXPECT().State().Return(t.TaskStatePending).AnyTimes()
+		outstandingTasks[task.NewMockKey(s.controller)] = mockTask
+	}
+	queue := newProcessingQueue(
+		newProcessingQueueState(
+			0,
+			testKey{ID: 0},
+			testKey{ID: 100},
+			testKey{ID: 100},
+			NewDomainFilter(nil, true),
+		),
+		outstandingTasks,
+		nil,
+		nil,
+	)
+
+	s.Empty(pendingTaskSplitPolicy.Evaluate(queue))
+
+	// lower the threshold, the new value should be used by the next evaluation
+	pendingTaskThreshold = map[int]int{0: numPendingTasks - 1}
+	s.assertQueueStatesEqual([]ProcessingQueueState{
+		newProcessingQueueState(
+			1,
+			testKey{ID: 0},
+			testKey{ID: 100},
+			testKey{ID: 100},
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+		),
+		newProcessingQueueState(
+			0,
+			testKey{ID: 0},
+			testKey{ID: 100},
+			testKey{ID: 100},
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, true),
+		),
+	}, pendingTaskSplitPolicy.Evaluate(queue))
+}
+
+func (s *splitPolicySuite) TestSplitPolicy_MaxLevelLowered() {
+	maxNewQueueLevel := 3
+	maxNewQueueLevelFn := func(...dynamicconfig.FilterOption) int {
+		return maxNewQueueLevel
+	}
+	policies := []ProcessingQueueSplitPolicyWithReason{
+		NewPendingTaskSplitPolicy(
+			dynamicconfig.GetMapPropertyFn(common.ConvertIntMapToDynamicConfigMapProperty(map[int]int{2: 1})),
+			dynamicconfig.GetBoolPropertyFnFilteredByDomain(true),
+			nil,
+			maxNewQueueLevelFn,
+			s.logger,
+			s.metricsScope,
+		).(ProcessingQueueSplitPolicyWithReason),
+		NewStuckTaskSplitPolicy(
+			dynamicconfig.GetMapPropertyFn(common.ConvertIntMapToDynamicConfigMapProperty(map[int]int{2: 1})),
+			dynamicconfig.GetBoolPropertyFnFilteredByDomain(true),
+			maxNewQueueLevelFn,
+			s.logger,
+			s.metricsScope,
+		).(ProcessingQueueSplitPolicyWithReason),
+		NewRandomSplitPolicy(
+			dynamicconfig.GetFloatPropertyFn(1),
+			dynamicconfig.GetBoolPropertyFnFilteredByDomain(true),
+			maxNewQueueLevelFn,
+			nil,
+			s.logger,
+			s.metricsScope,
+		).(ProcessingQueueSplitPolicyWithReason),
+	}
+
+	outstandingTasks := make(map[task.Key]task.Task)
+	for i := 0; i != 5; i++ {
+		mockTask := task.NewMockTask(s.controller)
+		mockTask.EXPECT().GetDomainID().Return("testDomain1").AnyTimes()
+		mockTask.EXPECT().State().Return(t.TaskStatePending).AnyTimes()
+		mockTask.EXPECT().GetAttempt().Return(10).AnyTimes()
+		outstandingTasks[testKey{ID: i + 1}] = mockTask
+	}
+	queue := newProcessingQueue(
+		newProcessingQueueState(
+			2,
+			testKey{ID: 0},
+			testKey{ID: 100},
+			testKey{ID: 100},
+			NewDomainFilter(nil, true),
+		),
+		outstandingTasks,
+		nil,
+		nil,
+	)
+
+	for _, policy := range policies {
+		newStates, reason := policy.EvaluateWithReason(queue)
+		s.NotEmpty(newStates)
+		s.Equal(SplitSkipReasonNone, reason)
+	}
+
+	// lower the max level below the level of the queue, it should no longer be split
+	maxNewQueueLevel = 1
+	for _, policy := range policies {
+		newStates, reason := policy.EvaluateWithReason(queue)
+		s.Empty(newStates)
+		s.Equal(SplitSkipReasonMaxLevelReached, reason)
+	}
+}
+
+func (s *splitPolicySuite) TestPendingTaskSplitPolicy_SkipReason() {
+	pendingTaskThreshold := map[string]interface{}{"0": 10}
+	pendingTaskSplitPolicy := NewPendingTaskSplitPolicy(
+		func(...dynamicconfig.FilterOption) map[string]interface{} {
+			return pendingTaskThreshold
+		},
+		func(domainID string) bool {
+			return domainID == "enabledDomain"
+		},
+		nil,
+		dynamicconfig.GetIntPropertyFn(3),
+		s.logger,
+		s.metricsScope,
+	).(ProcessingQueueSplitPolicyWithReason)
+
+	newQueue := func(level int, domainID string, numPendingTasks int) ProcessingQueue {
+		outstandingTasks := make(map[task.Key]task.Task)
+		for i := 0; i != numPendingTasks; i++ {
+			mockTask := task.NewMockTask(s.controller)
+			mockTask.EXPECT().GetDomainID().Return(domainID).AnyTimes()
+			mockTask.EXPECT().State().Return(t.TaskStatePending).AnyTimes()
+			outstandingTasks[testKey{ID: i + 1}] = mockTask
+		}
+		return newProcessingQueue(
+			newProcessingQueueState(
+				level,
+				testKey{ID: 0},
+				testKey{ID: 100},
+				testKey{ID: 100},
+				NewDomainFilter(nil, true),
+			),
+			outstandingTasks,
+			nil,
+			nil,
+		)
+	}
+
+	newStates, reason := pendingTaskSplitPolicy.EvaluateWithReason(newQueue(3, "enabledDomain", 20))
+	s.Empty(newStates)
+	s.Equal(SplitSkipReasonMaxLevelReached, reason)
+
+	newStates, reason = pendingTaskSplitPolicy.EvaluateWithReason(newQueue(1, "enabledDomain", 20))
+	s.Empty(newStates)
+	s.Equal(SplitSkipReasonNoThreshold, reason)
+
+	newStates, reason = pendingTaskSplitPolicy.EvaluateWithReason(newQueue(0, "enabledDomain", 5))
+	s.Empty(newStates)
+	s.Equal(SplitSkipReasonThresholdNotMet, reason)
+
+	newStates, reason = pendingTaskSplitPolicy.EvaluateWithReason(newQueue(0, "disabledDomain", 20))
+	s.Empty(newStates)
+	s.Equal(SplitSkipReasonDomainNotEnabled, reason)
+
+	newStates, reason = pendingTaskSplitPolicy.EvaluateWithReason(newQueue(0, "enabledDomain", 20))
+	s.NotEmpty(newStates)
+	s.Equal(SplitSkipReasonNone, reason)
+
+	pendingTaskThreshold = map[string]interface{}{"invalidLevel": 10}
+	newStates, reason = pendingTaskSplitPolicy.EvaluateWithReason(newQueue(0, "enabledDomain", 20))
+	s.Empty(newStates)
+	s.Equal(SplitSkipReasonInvalidConfig, reason)
+}
+
+func (s *splitPolicySuite) TestEvaluateSplitPolicyWithReason_DefaultReason() {
+	mockProcessingQueue := NewMockProcessingQueue(s.controller)
+	mockSplitPolicy := NewMockProcessingQueueSplitPolicy(s.controller)
+	mockSplitPolicy.EXPECT().Evaluate(mockProcessingQueue).Return(nil).Times(1)
+
+	newStates, reason := evaluateSplitPolicyWithReason(mockSplitPolicy, mockProcessingQueue)
+	s.Empty(newStates)
+	s.Equal(SplitSkipReasonNoAction, reason)
+}
+
 func (s *splitPolicySuite) TestStuckTaskSplitPolicy() {
 	maxNewQueueLevel := 3
 	attemptThreshold := map[int]int{
@@ -290,9 +489,9 @@ func (s *splitPolicySuite) TestStuckTaskSplitPolicy() {
 	}
 
 	stuckTaskSplitPolicy := NewStuckTaskSplitPolicy(
-		attemptThreshold,
+		dynamicconfig.GetMapPropertyFn(common.ConvertIntMapToDynamicConfigMapProperty(attemptThreshold)),
 		dynamicconfig.GetBoolPropertyFnFilteredByDomain(true),
-		maxNewQueueLevel,
+		dynamicconfig.GetIntPropertyFn(maxNewQueueLevel),
 		s.logger,
 		s.metricsScope,
 	)
@@ -555,6 +754,215 @@ func (s *splitPolicySuite) TestSelectedDomainSplitPolicy() {
 	}
 }
 
+func (s *splitPolicySuite) TestPinnedDomainSplitPolicy() {
+	pinnedDomainLevels := map[string]int{
+		"testDomain1": 2,
+		"testDomain2": 3,
+		"testDomain3": 2,
+		"testDomain4": 0,
+	}
+
+	testCases := []struct {
+		currentState      ProcessingQueueState
+		expectedNewStates []ProcessingQueueState
+	}{
+		{
+			// pinned domains are already at their level
+			currentState: newProcessingQueueState(
+				2,
+				testKey{ID: 0},
+				testKey{ID: 5},
+				testKey{ID: 10},
+				NewDomainFilter(map[string]struct{}{"testDomain1": {}, "testDomain3": {}}, false),
+			),
+			expectedNewStates: nil,
+		},
+		{
+			// only domains pinned to the lowest level are moved
+			currentState: newProcessingQueueState(
+				0,
+				testKey{ID: 0},
+				testKey{ID: 5},
+				testKey{ID: 10},
+				NewDomainFilter(map[string]struct{}{"testDomain3": {}}, true),
+			),
+			expectedNewStates: []ProcessingQueueState{
+				newProcessingQueueState(
+					2,
+					testKey{ID: 0},
+					testKey{ID: 5},
+					testKey{ID: 10},
+					NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+				),
+				newProcessingQueueState(
+					0,
+					testKey{ID: 0},
+					testKey{ID: 5},
+					testKey{ID: 10},
+					NewDomainFilter(map[string]struct{}{"testDomain1": {}, "testDomain3": {}}, true),
+				),
+			},
+		},
+		{
+			// the queue only contains pinned domains
+			currentState: newProcessingQueueState(
+				1,
+				testKey{ID: 0},
+				testKey{ID: 5},
+				testKey{ID: 10},
+				NewDomainFilter(map[string]struct{}{"testDomain2": {}}, false),
+			),
+			expectedNewStates: []ProcessingQueueState{
+				newProcessingQueueState(
+					3,
+					testKey{ID: 0},
+					testKey{ID: 5},
+					testKey{ID: 10},
+					NewDomainFilter(map[string]struct{}{"testDomain2": {}}, false),
+				),
+			},
+		},
+	}
+
+	splitPolicy := newPinnedDomainSplitPolicy(pinnedDomainLevels, s.logger, s.metricsScope)
+	for _, tc := range testCases {
+		queue := NewProcessingQueue(tc.currentState, nil, nil)
+		s.assertQueueStatesEqual(tc.expectedNewStates, splitPolicy.Evaluate(queue))
+	}
+}
+
+func (s *splitPolicySuite) TestTaskTypeSplitPolicy() {
+	newQueueLevel := 123
+	taskTypeToSplit := map[int]struct{}{persistence.TransferTaskTypeCloseExecution: {}}
+
+	testCases := []struct {
+		currentState      ProcessingQueueState
+		expectedNewStates []ProcessingQueueState
+	}{
+		{
+			// task type already split out
+			currentState: newProcessingQueueStateWithTaskTypeFilter(
+				0,
+				testKey{ID: 0},
+				testKey{ID: 5},
+				testKey{ID: 10},
+				NewDomainFilter(nil, true),
+				NewTaskTypeFilter(taskTypeToSplit, true),
+			),
+			expectedNewStates: nil,
+		},
+		{
+			// queue is already in the new level
+			currentState: newProcessingQueueState(
+				newQueueLevel,
+				testKey{ID: 0},
+				testKey{ID: 5},
+				testKey{ID: 10},
+				NewDomainFilter(nil, true),
+			),
+			expectedNewStates: nil,
+		},
+		{
+			currentState: newProcessingQueueState(
+				0,
+				testKey{ID: 0},
+				testKey{ID: 5},
+				testKey{ID: 10},
+				NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+			),
+			expectedNewStates: []ProcessingQueueState{
+				newProcessingQueueStateWithTaskTypeFilter(
+					0,
+					testKey{ID: 0},
+					testKey{ID: 5},
+					testKey{ID: 10},
+					NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+					NewTaskTypeFilter(taskTypeToSplit, true),
+				),
+				newProcessingQueueStateWithTaskTypeFilter(
+					newQueueLevel,
+					testKey{ID: 0},
+					testKey{ID: 5},
+					testKey{ID: 10},
+					NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+					NewTaskTypeFilter(taskTypeToSplit, false),
+				),
+			},
+		},
+		{
+			// all task types in the queue are split out
+			currentState: newProcessingQueueStateWithTaskTypeFilter(
+				0,
+				testKey{ID: 0},
+				testKey{ID: 5},
+				testKey{ID: 10},
+				NewDomainFilter(nil, true),
+				NewTaskTypeFilter(taskTypeToSplit, false),
+			),
+			expectedNewStates: []ProcessingQueueState{
+				newProcessingQueueStateWithTaskTypeFilter(
+					newQueueLevel,
+					testKey{ID: 0},
+					testKey{ID: 5},
+					testKey{ID: 10},
+					NewDomainFilter(nil, true),
+					NewTaskTypeFilter(taskTypeToSplit, false),
+				),
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		queue := NewProcessingQueue(tc.currentState, nil, nil)
+		splitPolicy := NewTaskTypeSplitPolicy(taskTypeToSplit, newQueueLevel, s.logger, s.metricsScope)
+
+		s.assertQueueStatesEqual(tc.expectedNewStates, splitPolicy.Evaluate(queue))
+	}
+}
+
+func (s *splitPolicySuite) TestTaskTypeSplitPolicy_SplitTasks() {
+	newQueueLevel := 123
+	taskTypeToSplit := map[int]struct{}{persistence.TransferTaskTypeCloseExecution: {}}
+
+	outstandingTasks := make(map[task.Key]task.Task)
+	for i := 0; i != 4; i++ {
+		taskType := persistence.TransferTaskTypeActivityTask
+		if i%2 == 0 {
+			taskType = persistence.TransferTaskTypeCloseExecution
+		}
+		mockTask := task.NewMockTask(s.controller)
+		mockTask.EXPECT().GetDomainID().Return("testDomain").AnyTimes()
+		mockTask.EXPECT().GetTaskType().Return(taskType).AnyTimes()
+		outstandingTasks[testKey{ID: i + 1}] = mockTask
+	}
+
+	queue := newProcessingQueue(
+		newProcessingQueueState(
+			0,
+			testKey{ID: 0},
+			testKey{ID: 4},
+			testKey{ID: 10},
+			NewDomainFilter(nil, true),
+		),
+		outstandingTasks,
+		s.logger,
+		metrics.NewClient(tally.NoopScope, metrics.History),
+	)
+
+	splitPolicy := NewTaskTypeSplitPolicy(taskTypeToSplit, newQueueLevel, s.logger, s.metricsScope)
+	newQueues := queue.Split(splitPolicy)
+	s.Len(newQueues, 2)
+
+	for _, newQueue := range newQueues {
+		queueImpl := newQueue.(*processingQueueImpl)
+		s.Len(queueImpl.outstandingTasks, 2)
+		for _, task := range queueImpl.outstandingTasks {
+			_, isSplitType := taskTypeToSplit[task.GetTaskType()]
+			s.Equal(newQueue.State().Level() == newQueueLevel, isSplitType)
+		}
+	}
+}
+
 func (s *splitPolicySuite) TestRandomSplitPolicy() {
 	maxNewQueueLevel := 3
 	lookAheadFunc := func(key task.Key, _ string) task.Key {
@@ -648,9 +1056,9 @@ func (s *splitPolicySuite) TestRandomSplitPolicy() {
 			nil,
 		)
 		splitPolicy := NewRandomSplitPolicy(
-			tc.splitProbability,
+			dynamicconfig.GetFloatPropertyFn(tc.splitProbability),
 			dynamicconfig.GetBoolPropertyFnFilteredByDomain(true),
-			maxNewQueueLevel,
+			dynamicconfig.GetIntPropertyFn(maxNewQueueLevel),
 			lookAheadFunc,
 			s.logger,
 			s.metricsScope,
@@ -689,6 +1097,24 @@ func (s *splitPolicySuite) TestAggregatedSplitPolicy() {
 	s.Equal(expectedNewStates, aggregatedSplitPolicy.Evaluate(mockProcessingQueue))
 }
 
+func (s *splitPolicySuite) TestAggregatedSplitPolicy_Cancelled() {
+	mockProcessingQueue := NewMockProcessingQueue(s.controller)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	firstPolicy := NewMockProcessingQueueSplitPolicy(s.controller)
+	firstPolicy.EXPECT().Evaluate(mockProcessingQueue).DoAndReturn(func(_ ProcessingQueue) []ProcessingQueueState {
+		cancel()
+		return nil
+	}).Times(1)
+	secondPolicy := NewMockProcessingQueueSplitPolicy(s.controller)
+	secondPolicy.EXPECT().Evaluate(gomock.Any()).Times(0)
+
+	splitPolicy := newContextSplitPolicy(ctx, NewAggregatedSplitPolicy(firstPolicy, secondPolicy))
+	s.Nil(splitPolicy.Evaluate(mockProcessingQueue))
+	// policies are not evaluated at all once the context is cancelled
+	s.Nil(splitPolicy.Evaluate(mockProcessingQueue))
+}
+
 func (s *splitPolicySuite) assertQueueStatesEqual(
 	expected []ProcessingQueueState,
 	actual []ProcessingQueueState,
@@ -0,0 +1,120 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package queue
+
+// NewTaskTypeFilter creates a new task type filter
+func NewTaskTypeFilter(
+	taskTypes map[int]struct{},
+	reverseMatch bool,
+) TaskTypeFilter {
+	if taskTypes == nil {
+		taskTypes = make(map[int]struct{})
+	}
+
+	return TaskTypeFilter{
+		TaskTypes:    taskTypes,
+		ReverseMatch: reverseMatch,
+	}
+}
+
+// Filter returns true if taskType is in the task type set specified by the filter
+func (f TaskTypeFilter) Filter(taskType int) bool {
+	_, ok := f.TaskTypes[taskType]
+	if f.ReverseMatch {
+		ok = !ok
+	}
+	return ok
+}
+
+// Include adds more task types to the task type set specified by the filter
+func (f TaskTypeFilter) Include(taskTypes map[int]struct{}) TaskTypeFilter {
+	filter := f.copy()
+	for taskType := range taskTypes {
+		if !filter.ReverseMatch {
+			filter.TaskTypes[taskType] = struct{}{}
+		} else {
+			delete(filter.TaskTypes, taskType)
+		}
+	}
+	return filter
+}
+
+// Exclude removes task types from the task type set specified by the filter
+func (f TaskTypeFilter) Exclude(taskTypes map[int]struct{}) TaskTypeFilter {
+	filter := f.copy()
+	for taskType := range taskTypes {
+		if !filter.ReverseMatch {
+			delete(filter.TaskTypes, taskType)
+		} else {
+			filter.TaskTypes[taskType] = struct{}{}
+		}
+	}
+	return filter
+}
+
+// Merge merges the task type sets specified by two task type filters
+func (f TaskTypeFilter) Merge(f2 TaskTypeFilter) TaskTypeFilter {
+	// case 1: ReverseMatch field is false for both filters
+	if !f.ReverseMatch && !f2.ReverseMatch {
+		// union the taskTypes field
+		return f.Include(f2.TaskTypes)
+	}
+
+	// case 2: ReverseMatch field is true for both filters
+	if f.ReverseMatch && f2.ReverseMatch {
+		// intersect the taskTypes field
+		filter := NewTaskTypeFilter(nil, true)
+		for taskType := range f.TaskTypes {
+			if _, ok := f2.TaskTypes[taskType]; ok {
+				filter.TaskTypes[taskType] = struct{}{}
+			}
+		}
+		return filter
+	}
+
+	// case 3, 4: one of the filters has ReverseMatch equals true
+	if f.ReverseMatch {
+		return f.Include(f2.TaskTypes)
+	}
+	return f2.Include(f.TaskTypes)
+}
+
+// Equal returns true if the two filters specify the same task type set
+func (f TaskTypeFilter) Equal(f2 TaskTypeFilter) bool {
+	if f.ReverseMatch != f2.ReverseMatch || len(f.TaskTypes) != len(f2.TaskTypes) {
+		return false
+	}
+
+	for taskType := range f.TaskTypes {
+		if _, ok := f2.TaskTypes[taskType]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (f TaskTypeFilter) copy() TaskTypeFilter {
+	taskTypes := make(map[int]struct{})
+	for taskType := range f.TaskTypes {
+		taskTypes[taskType] = struct{}{}
+	}
+	return NewTaskTypeFilter(taskTypes, f.ReverseMatch)
+}
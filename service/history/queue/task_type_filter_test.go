@@ -0,0 +1,157 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package queue
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type (
+	taskTypeFilterSuite struct {
+		suite.Suite
+		*require.Assertions
+	}
+)
+
+func TestTaskTypeFilterSuite(t *testing.T) {
+	s := new(taskTypeFilterSuite)
+	suite.Run(t, s)
+}
+
+func (s *taskTypeFilterSuite) SetupTest() {
+	s.Assertions = require.New(s.T())
+}
+
+func (s *taskTypeFilterSuite) TestTaskTypeFilter_Filter() {
+	testCases := []struct {
+		taskTypes      map[int]struct{}
+		reverseMatch   bool
+		testTaskTypes  []int
+		expectedResult []bool
+	}{
+		{
+			taskTypes:      nil,
+			reverseMatch:   false,
+			testTaskTypes:  []int{1},
+			expectedResult: []bool{false},
+		},
+		{
+			taskTypes:      map[int]struct{}{1: {}, 2: {}},
+			reverseMatch:   false,
+			testTaskTypes:  []int{1, 3},
+			expectedResult: []bool{true, false},
+		},
+		{
+			taskTypes:      nil,
+			reverseMatch:   true,
+			testTaskTypes:  []int{1},
+			expectedResult: []bool{true},
+		},
+		{
+			taskTypes:      map[int]struct{}{1: {}, 2: {}},
+			reverseMatch:   true,
+			testTaskTypes:  []int{1, 3},
+			expectedResult: []bool{false, true},
+		},
+	}
+
+	for _, tc := range testCases {
+		filter := NewTaskTypeFilter(tc.taskTypes, tc.reverseMatch)
+		for i, testTaskType := range tc.testTaskTypes {
+			result := filter.Filter(testTaskType)
+			s.Equal(tc.expectedResult[i], result)
+		}
+	}
+}
+
+func (s *taskTypeFilterSuite) TestTaskTypeFilter_Exclude() {
+	testCases := []struct {
+		taskTypes         map[int]struct{}
+		reverseMatch      bool
+		newTaskTypes      map[int]struct{}
+		expectedTaskTypes map[int]struct{}
+	}{
+		{
+			taskTypes:         map[int]struct{}{1: {}, 2: {}},
+			reverseMatch:      false,
+			newTaskTypes:      map[int]struct{}{2: {}, 3: {}},
+			expectedTaskTypes: map[int]struct{}{1: {}},
+		},
+		{
+			taskTypes:         map[int]struct{}{1: {}, 2: {}},
+			reverseMatch:      true,
+			newTaskTypes:      map[int]struct{}{2: {}, 3: {}},
+			expectedTaskTypes: map[int]struct{}{1: {}, 2: {}, 3: {}},
+		},
+	}
+
+	for _, tc := range testCases {
+		baseFilter := NewTaskTypeFilter(tc.taskTypes, tc.reverseMatch)
+		newFilter := baseFilter.Exclude(tc.newTaskTypes)
+
+		// check if the base filter got modified
+		s.Equal(tc.taskTypes, baseFilter.TaskTypes)
+		s.Equal(tc.reverseMatch, baseFilter.ReverseMatch)
+
+		s.Equal(tc.expectedTaskTypes, newFilter.TaskTypes)
+		s.Equal(tc.reverseMatch, newFilter.ReverseMatch)
+	}
+}
+
+func (s *taskTypeFilterSuite) TestTaskTypeFilter_Merge() {
+	testCases := []struct {
+		taskTypes            []map[int]struct{}
+		reverseMatch         []bool
+		expectedTaskTypes    map[int]struct{}
+		expectedReverseMatch bool
+	}{
+		{
+			taskTypes:            []map[int]struct{}{{1: {}, 2: {}}, {2: {}, 3: {}}},
+			reverseMatch:         []bool{false, false},
+			expectedTaskTypes:    map[int]struct{}{1: {}, 2: {}, 3: {}},
+			expectedReverseMatch: false,
+		},
+		{
+			taskTypes:            []map[int]struct{}{{1: {}, 2: {}}, {2: {}, 3: {}}},
+			reverseMatch:         []bool{true, true},
+			expectedTaskTypes:    map[int]struct{}{2: {}},
+			expectedReverseMatch: true,
+		},
+		{
+			taskTypes:            []map[int]struct{}{{1: {}, 2: {}}, {2: {}, 3: {}}},
+			reverseMatch:         []bool{true, false},
+			expectedTaskTypes:    map[int]struct{}{1: {}},
+			expectedReverseMatch: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		mergedFilter := NewTaskTypeFilter(tc.taskTypes[0], tc.reverseMatch[0]).Merge(
+			NewTaskTypeFilter(tc.taskTypes[1], tc.reverseMatch[1]),
+		)
+
+		s.Equal(tc.expectedTaskTypes, mergedFilter.TaskTypes)
+		s.Equal(tc.expectedReverseMatch, mergedFilter.ReverseMatch)
+	}
+}
@@ -464,6 +464,10 @@ func newTimerQueueActiveProcessor(
 		return nil
 	}
 
+	options.LoadProcessingQueueStates = func() []ProcessingQueueState {
+		return loadTimerProcessingQueueStates(clusterName, shard, options, logger)
+	}
+
 	return newTimerQueueProcessorBase(
 		clusterName,
 		shard,
@@ -521,6 +525,10 @@ func newTimerQueueStandbyProcessor(
 		return nil
 	}
 
+	options.LoadProcessingQueueStates = func() []ProcessingQueueState {
+		return loadTimerProcessingQueueStates(clusterName, shard, options, logger)
+	}
+
 	remoteTimerGate := NewRemoteTimerGate()
 	remoteTimerGate.SetCurrentTime(shard.GetCurrentTime(clusterName))
 
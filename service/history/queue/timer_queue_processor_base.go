@@ -63,7 +63,8 @@ type (
 	timerQueueProcessorBase struct {
 		*processorBase
 
-		taskInitializer task.Initializer
+		// taskInitializer creates tasks for the processing queue collection at the given level
+		taskInitializer func(level int, taskInfo task.Info) task.Task
 
 		clusterName string
 
@@ -118,7 +119,7 @@ func newTimerQueueProcessorBase(
 	return &timerQueueProcessorBase{
 		processorBase: processorBase,
 
-		taskInitializer: func(taskInfo task.Info) task.Task {
+		taskInitializer: func(level int, taskInfo task.Info) task.Task {
 			return task.NewTimerTask(
 				shard,
 				taskInfo,
@@ -127,10 +128,12 @@ func newTimerQueueProcessorBase(
 				taskFilter,
 				taskExecutor,
 				taskProcessor,
-				processorBase.redispatcher.AddTask,
+				func(nackedTask task.Task) {
+					processorBase.redispatchNackedTask(level, nackedTask)
+				},
 				shard.GetTimeSource(),
 				shard.GetConfig().TimerTaskMaxRetryCount,
-				nil,
+				processorBase,
 			)
 		},
 
@@ -160,8 +163,10 @@ func (t *timerQueueProcessorBase) Start() {
 		t.upsertPollTime(queueCollections.Level(), time.Time{})
 	}
 
-	t.shutdownWG.Add(1)
+	t.shutdownWG.Add(2)
 	go t.processorPump()
+	go t.compactorPump()
+	t.startTaskEventPump()
 }
 
 func (t *timerQueueProcessorBase) Stop() {
@@ -208,9 +213,11 @@ processorPumpLoop:
 		case <-t.shutdownCh:
 			break processorPumpLoop
 		case <-t.timerGate.FireChan():
+			iteration := t.startPumpIteration(pumpIterationIdle)
 			maxRedispatchQueueSize := t.options.MaxRedispatchQueueSize()
 			if t.redispatcher.Size() > maxRedispatchQueueSize {
-				t.redispatcher.Redispatch(maxRedispatchQueueSize)
+				iteration.iterationType = pumpIterationRedispatch
+				t.redispatch(context.Background(), maxRedispatchQueueSize)
 				if t.redispatcher.Size() > maxRedispatchQueueSize {
 					// if redispatcher still has a large number of tasks
 					// this only happens when system is under very high load
@@ -223,6 +230,15 @@ processorPumpLoop:
 					))
 				}
 				t.timerGate.Update(time.Time{})
+				t.finishPumpIteration(iteration)
+				continue processorPumpLoop
+			}
+
+			if t.shouldRedispatch() {
+				iteration.iterationType = pumpIterationRedispatch
+				t.redispatch(context.Background(), 0)
+				t.timerGate.Update(time.Time{})
+				t.finishPumpIteration(iteration)
 				continue processorPumpLoop
 			}
 
@@ -239,10 +255,16 @@ processorPumpLoop:
 			}
 			t.pollTimeLock.Unlock()
 
+			if len(levels) != 0 {
+				iteration.iterationType = pumpIterationRead
+			}
 			t.processQueueCollections(levels)
+			t.finishPumpIteration(iteration)
 		case <-updateAckTimer.C:
-			processFinished, err := t.updateAckLevel()
-			if err == shard.ErrShardClosed || (err == nil && processFinished) {
+			iteration := t.startPumpIteration(pumpIterationUpdateAckLevel)
+			processFinished, err := t.updateAckLevel(context.Background())
+			if err == shard.ErrShardClosed || err == errQueueShutdownTimeout || err == ErrProcessorShuttingDown || (err == nil && processFinished) {
+				t.finishPumpIteration(iteration)
 				go t.Stop()
 				break processorPumpLoop
 			}
@@ -250,7 +272,9 @@ processorPumpLoop:
 				t.options.UpdateAckInterval(),
 				t.options.UpdateAckIntervalJitterCoefficient(),
 			))
+			t.finishPumpIteration(iteration)
 		case <-t.newTimerCh:
+			iteration := t.startPumpIteration(pumpIterationIdle)
 			t.newTimeLock.Lock()
 			newTime := t.newTime
 			t.newTime = time.Time{}
@@ -264,14 +288,27 @@ processorPumpLoop:
 			for _, queueCollection := range t.processingQueueCollections {
 				t.upsertPollTime(queueCollection.Level(), newTime)
 			}
+			t.finishPumpIteration(iteration)
 		case <-splitQueueTimer.C:
+			iteration := t.startPumpIteration(pumpIterationSplit)
 			t.splitQueue()
 			splitQueueTimer.Reset(backoff.JitDuration(
 				t.options.SplitQueueInterval(),
 				t.options.SplitQueueIntervalJitterCoefficient(),
 			))
+			t.finishPumpIteration(iteration)
+		case <-t.compactNotifyCh:
+			iteration := t.startPumpIteration(pumpIterationCompact)
+			t.pruneProcessingQueueCollections()
+			t.finishPumpIteration(iteration)
+		case <-t.drainNotifyCh:
+			iteration := t.startPumpIteration(pumpIterationIdle)
+			t.pollAllLevelsNow()
+			t.finishPumpIteration(iteration)
 		case notification := <-t.actionNotifyCh:
+			iteration := t.startPumpIteration(pumpIterationAction)
 			t.handleActionNotification(notification)
+			t.finishPumpIteration(iteration)
 		}
 	}
 }
@@ -299,25 +336,43 @@ func (t *timerQueueProcessorBase) processQueueCollections(levels map[int]struct{
 		var nextPageToken []byte
 		readLevel := activeQueue.State().ReadLevel()
 		maxReadLevel := minTaskKey(activeQueue.State().MaxLevel(), t.updateMaxReadLevel())
+		maxReadLevel, readCapped := t.capReadTimeRange(activeQueue.State().AckLevel(), maxReadLevel)
 		domainFilter := activeQueue.State().DomainFilter()
+		taskTypeFilter := activeQueue.State().TaskTypeFilter()
 
 		if progress, ok := t.processingQueueReadProgress[level]; ok {
 			if progress.currentQueue == activeQueue {
 				readLevel = progress.readLevel
 				maxReadLevel = progress.maxReadLevel
 				nextPageToken = progress.nextPageToken
+				readCapped = false
 			}
 			delete(t.processingQueueReadProgress, level)
 		}
 
+		if !readLevel.Less(maxReadLevel) && readCapped {
+			// all tasks within the max read time range have been read,
+			// check back later after the ack level moves forward
+			t.setupReadBackoffTimer(level, backoff.JitDuration(
+				t.options.PollBackoffInterval(),
+				t.options.PollBackoffIntervalJitterCoefficient(),
+			))
+			continue
+		}
+
 		if !readLevel.Less(maxReadLevel) {
 			// notify timer gate about the min time
 			t.upsertPollTime(level, readLevel.(timerTaskKey).visibilityTimestamp)
 			continue
 		}
 
+		if backoffDuration := t.getReadBackoffDuration(level); backoffDuration > 0 {
+			t.setupReadBackoffTimer(level, backoffDuration)
+			continue
+		}
+
 		ctx, cancel := context.WithTimeout(context.Background(), loadQueueTaskThrottleRetryDelay)
-		if err := t.rateLimiter.Wait(ctx); err != nil {
+		if err := t.waitReadRateLimit(ctx); err != nil {
 			cancel()
 			if level == defaultProcessingQueueLevel {
 				t.upsertPollTime(level, time.Time{})
@@ -335,26 +390,54 @@ func (t *timerQueueProcessorBase) processQueueCollections(levels map[int]struct{
 			continue
 		}
 
+		t.releaseParkedTasks()
+		t.refreshOutstandingTaskCount()
 		tasks := make(map[task.Key]task.Task)
 		taskChFull := false
+		var droppedReadLevel task.Key
+		lastTaskKey := activeQueue.State().ReadLevel()
 		for _, taskInfo := range timerTaskInfos {
-			if !domainFilter.Filter(taskInfo.GetDomainID()) {
+			taskKey := newTimerTaskKey(taskInfo.GetVisibilityTimestamp(), taskInfo.GetTaskID())
+			if !lastTaskKey.Less(taskKey) {
+				// tasks are read by visibility timestamp only, skip tasks with the same visibility
+				// timestamp as the read level that have already been read by the previous poll
+				continue
+			}
+			prevTaskKey := lastTaskKey
+			lastTaskKey = taskKey
+
+			if !domainFilter.Filter(taskInfo.GetDomainID()) || !taskTypeFilter.Filter(taskInfo.GetTaskType()) {
 				continue
 			}
 
-			task := t.taskInitializer(taskInfo)
-			tasks[newTimerTaskKey(taskInfo.GetVisibilityTimestamp(), taskInfo.GetTaskID())] = task
-			submitted, err := t.submitTask(task)
+			task := t.taskInitializer(level, taskInfo)
+			if !t.verifyTaskCategory(task) {
+				continue
+			}
+			assignQueuePriority(task, activeQueue.State())
+			submitted, err := t.submitTask(level, task)
+			if err == errTaskDropped {
+				// redispatch is disabled, stop right before the dropped
+				// task so that it's read again by the next poll
+				droppedReadLevel = prevTaskKey
+				break
+			}
 			if err != nil {
-				// only err here is due to the fact that processor has been shutdown
+				// only other err here is due to the fact that processor has been shutdown
 				// return instead of continue
 				return
 			}
+			tasks[taskKey] = task
 			taskChFull = taskChFull || !submitted
 		}
 
 		var newReadLevel task.Key
-		if len(nextPageToken) == 0 {
+		if droppedReadLevel != nil {
+			// the read progress is discarded as the next poll starts from the dropped task,
+			// back off instead of reading the dropped task again right away
+			t.setupBackoffTimer(level)
+			newReadLevel = droppedReadLevel
+		} else if len(nextPageToken) == 0 {
 			newReadLevel = maxReadLevel
 			if lookAheadTask != nil {
 				// lookAheadTask may exist only when nextPageToken is empty
@@ -383,7 +466,9 @@ func (t *timerQueueProcessorBase) processQueueCollections(levels map[int]struct{
 			}
 			newReadLevel = newTimerTaskKey(timerTaskInfos[len(timerTaskInfos)-1].GetVisibilityTimestamp(), 0)
 		}
+		unlock := t.lockQueueCollections(lockOperationAddTasks)
 		queueCollection.AddTasks(tasks, newReadLevel)
+		unlock()
 	}
 }
 
@@ -399,7 +484,10 @@ func (t *timerQueueProcessorBase) splitQueue() {
 		},
 	)
 
-	t.splitProcessingQueueCollection(splitPolicy, t.upsertPollTime)
+	ctx, cancel := t.newShutdownContext()
+	defer cancel()
+
+	t.splitProcessingQueueCollection(ctx, splitPolicy, t.upsertPollTime)
 }
 
 func (t *timerQueueProcessorBase) handleActionNotification(notification actionNotification) {
@@ -407,10 +495,37 @@ func (t *timerQueueProcessorBase) handleActionNotification(notification actionNo
 		switch notification.action.ActionType {
 		case ActionTypeReset:
 			t.upsertPollTime(defaultProcessingQueueLevel, time.Time{})
+		case ActionTypeCollapseLevel, ActionTypeReconcile, ActionTypeSplit:
+			for _, queueCollection := range t.processingQueueCollections {
+				t.upsertPollTime(queueCollection.Level(), time.Time{})
+			}
 		}
 	})
 }
 
+// capReadTimeRange caps maxReadLevel to ackLevel + MaxReadTimeRange, so that a single read
+// won't load too many tasks when the ack level is far behind. It returns the capped max read
+// level and whether it's smaller than the given one. The range is not capped while the processor is draining
+func (t *timerQueueProcessorBase) capReadTimeRange(
+	ackLevel task.Key,
+	maxReadLevel task.Key,
+) (task.Key, bool) {
+	if t.options.MaxReadTimeRange == nil || t.isDraining() {
+		return maxReadLevel, false
+	}
+
+	maxReadTimeRange := t.options.MaxReadTimeRange()
+	if maxReadTimeRange <= 0 {
+		return maxReadLevel, false
+	}
+
+	upperBound := newTimerTaskKey(ackLevel.(timerTaskKey).visibilityTimestamp.Add(maxReadTimeRange), 0)
+	if upperBound.Less(maxReadLevel) {
+		return upperBound, true
+	}
+	return maxReadLevel, false
+}
+
 func (t *timerQueueProcessorBase) readAndFilterTasks(
 	readLevel task.Key,
 	maxReadLevel task.Key,
@@ -565,6 +680,22 @@ func (t *timerQueueProcessorBase) upsertPollTime(level int, newPollTime time.Tim
 	}
 }
 
+// pollAllLevelsNow polls all processing queue collections immediately, existing backoff timers are cancelled
+func (t *timerQueueProcessorBase) pollAllLevelsNow() {
+	t.pollTimeLock.Lock()
+	defer t.pollTimeLock.Unlock()
+
+	for _, queueCollection := range t.processingQueueCollections {
+		level := queueCollection.Level()
+		if backoffTimer, ok := t.backoffTimer[level]; ok {
+			backoffTimer.Stop()
+			delete(t.backoffTimer, level)
+		}
+		t.nextPollTime[level] = time.Time{}
+	}
+	t.timerGate.Update(time.Time{})
+}
+
 // setupBackoffTimer will trigger a poll for the specified processing queue collection
 // after a certain period of (real) time. This means for standby timer, even if the cluster time
 // has not been updated, the poll will still be triggered when the timer fired. Use this function
@@ -581,10 +712,27 @@ func (t *timerQueueProcessorBase) setupBackoffTimer(level int) {
 
 	t.metricsScope.IncCounter(metrics.ProcessingQueueThrottledCounter)
 	t.logger.Info("Throttled processing queue", tag.QueueLevel(level))
-	backoffDuration := backoff.JitDuration(
+	t.setupBackoffTimerLocked(level, backoff.JitDuration(
 		t.options.PollBackoffInterval(),
 		t.options.PollBackoffIntervalJitterCoefficient(),
-	)
+	))
+}
+
+// setupReadBackoffTimer is similar to setupBackoffTimer, but is used when reads for
+// the processing queue collection are throttled by the per level read rate limiter
+func (t *timerQueueProcessorBase) setupReadBackoffTimer(level int, backoffDuration time.Duration) {
+	t.pollTimeLock.Lock()
+	defer t.pollTimeLock.Unlock()
+
+	if _, ok := t.backoffTimer[level]; ok {
+		// honor existing backoff timer
+		return
+	}
+
+	t.setupBackoffTimerLocked(level, backoffDuration)
+}
+
+func (t *timerQueueProcessorBase) setupBackoffTimerLocked(level int, backoffDuration time.Duration) {
 	t.backoffTimer[level] = time.AfterFunc(backoffDuration, func() {
 		select {
 		case <-t.shutdownCh:
@@ -611,6 +759,8 @@ func newTimerTaskKey(
 	}
 }
 
+// Less orders timer task keys by visibility timestamp and breaks ties on
+// taskID, so the minimum of keys sharing a timestamp is always the lowest taskID.
 func (k timerTaskKey) Less(
 	key task.Key,
 ) bool {
@@ -621,6 +771,12 @@ func (k timerTaskKey) Less(
 	return k.visibilityTimestamp.Before(timerKey.visibilityTimestamp)
 }
 
+// normalizeAckLevel resets taskID of the ack level, as timer ack levels are persisted
+// and read by visibility timestamp only
+func (k timerTaskKey) normalizeAckLevel() task.Key {
+	return newTimerTaskKey(k.visibilityTimestamp, 0)
+}
+
 func (k timerTaskKey) String() string {
 	return fmt.Sprintf("{visibilityTimestamp: %v, taskID: %v}", k.visibilityTimestamp, k.taskID)
 }
@@ -639,10 +795,41 @@ func newTimerQueueProcessorOptions(
 		UpdateAckIntervalJitterCoefficient:   config.TimerProcessorUpdateAckIntervalJitterCoefficient,
 		RedispatchIntervalJitterCoefficient:  config.TaskRedispatchIntervalJitterCoefficient,
 		MaxRedispatchQueueSize:               config.TimerProcessorMaxRedispatchQueueSize,
+		RedispatchBatchSizeByDomainID:        config.QueueProcessorRedispatchBatchSizeByDomainID,
 		SplitQueueInterval:                   config.TimerProcessorSplitQueueInterval,
 		SplitQueueIntervalJitterCoefficient:  config.TimerProcessorSplitQueueIntervalJitterCoefficient,
 		PollBackoffInterval:                  config.QueueProcessorPollBackoffInterval,
 		PollBackoffIntervalJitterCoefficient: config.QueueProcessorPollBackoffIntervalJitterCoefficient,
+		ShutdownTimeout:                      config.QueueProcessorShutdownTimeout,
+		MinPollInterval:                      config.QueueProcessorMinPollInterval,
+		LockMetricsSampleRate:                config.QueueProcessorLockMetricsSampleRate,
+		MaxConcurrentRedispatch:              config.QueueProcessorMaxConcurrentRedispatch,
+		SkipRedispatchWhenBusy:               config.QueueProcessorSkipRedispatchWhenBusy,
+		EnableBackgroundCompaction:           config.QueueProcessorEnableBackgroundCompaction,
+		BackgroundCompactionInterval:         config.QueueProcessorBackgroundCompactionInterval,
+		EnableDomainTaggedMetrics:            config.QueueProcessorEnableDomainTaggedMetrics,
+		EnableLevelTaggedMetrics:             config.QueueProcessorEnableLevelTaggedMetrics,
+		StuckAckLevelThreshold:               config.QueueProcessorStuckAckLevelThreshold,
+		RedispatchWeight:                     config.QueueProcessorRedispatchWeight,
+		EnableOutstandingTaskShedding:        config.QueueProcessorEnableOutstandingTaskShedding,
+		MaxOutstandingTasksPerDomain:         config.QueueProcessorMaxOutstandingTasksPerDomain,
+		RedispatchMinBatchSize:               config.QueueProcessorRedispatchMinBatchSize,
+		RedispatchMaxBatchSize:               config.QueueProcessorRedispatchMaxBatchSize,
+		MaxInFlightTasks:                     config.QueueProcessorMaxInFlightTasks,
+		MaxAckReadGap:                        config.QueueProcessorMaxAckReadGap,
+		PinnedDomainLevels:                   config.QueueProcessorPinnedDomainLevels,
+		RedispatchRequeueDelay:               config.QueueProcessorRedispatchRequeueDelay,
+		RedispatchRequeueMaxDelay:            config.QueueProcessorRedispatchRequeueMaxDelay,
+		NewTaskLevelStrategy:                 config.QueueProcessorNewTaskLevelStrategy,
+		DisableRedispatch:                    config.QueueProcessorDisableRedispatch,
+		WarmupDuration:                       config.QueueProcessorWarmupDuration,
+		EnableTaskSubmitAge:                  config.QueueProcessorEnableTaskSubmitAge,
+		SplitCooldown:                        config.QueueProcessorSplitCooldown,
+		EnableOrderedProcessingByDomainID:    config.QueueProcessorEnableOrderedProcessingByDomainID,
+		RedispatchDecisionLogSize:            config.QueueProcessorRedispatchDecisionLogSize,
+		MaxRedispatchQueueSizePerDomain:      config.QueueProcessorMaxRedispatchQueueSizePerDomain,
+		MaxReadTimeRange:                     config.TimerProcessorMaxReadTimeRange,
+		RedispatchPolicies:                   newRedispatchPolicies(config),
 	}
 
 	if isFailover {
@@ -662,6 +849,7 @@ func newTimerQueueProcessorOptions(
 		options.EnableStuckTaskSplitByDomainID = config.QueueProcessorEnableStuckTaskSplitByDomainID
 		options.StuckTaskSplitThreshold = config.QueueProcessorStuckTaskSplitThreshold
 		options.SplitLookAheadDurationByDomainID = config.QueueProcessorSplitLookAheadDurationByDomainID
+		options.MaxPollRPSByLevel = config.QueueProcessorMaxPollRPSByLevel
 
 		options.EnablePersistQueueStates = config.QueueProcessorEnablePersistQueueStates
 		options.EnableLoadQueueStates = config.QueueProcessorEnableLoadQueueStates
@@ -37,6 +37,7 @@ import (
 	"github.com/uber/cadence/common/log/loggerimpl"
 	"github.com/uber/cadence/common/metrics"
 	"github.com/uber/cadence/common/persistence"
+	"github.com/uber/cadence/common/service/dynamicconfig"
 	"github.com/uber/cadence/service/history/config"
 	"github.com/uber/cadence/service/history/constants"
 	"github.com/uber/cadence/service/history/shard"
@@ -862,6 +863,150 @@ func (s *timerQueueProcessorBaseSuite) TestProcessBatch_NoNextPage_NoLookAhead()
 	}
 }
 
+func (s *timerQueueProcessorBaseSuite) TestProcessQueueCollections_RedispatchDisabled() {
+	mockClusterMetadata := s.mockShard.Resource.ClusterMetadata
+	mockClusterMetadata.EXPECT().GetCurrentClusterName().Return(s.clusterName).AnyTimes()
+
+	now := time.Now()
+	queueLevel := 0
+	ackLevel := newTimerTaskKey(now.Add(-5*time.Second), 0)
+	shardMaxReadLevel := newTimerTaskKey(now.Add(1*time.Second), 0)
+	maxLevel := newTimerTaskKey(now.Add(10*time.Second), 0)
+	processingQueueStates := []ProcessingQueueState{
+		NewProcessingQueueState(
+			queueLevel,
+			ackLevel,
+			maxLevel,
+			NewDomainFilter(nil, true),
+		),
+	}
+	updateMaxReadLevel := func() task.Key {
+		return shardMaxReadLevel
+	}
+
+	// both tasks have the same visibility timestamp
+	visibilityTimestamp := now.Add(-3 * time.Second)
+	timerTasks := []*persistence.TimerTaskInfo{
+		{
+			DomainID:            "some random domain ID",
+			WorkflowID:          "some random workflow ID",
+			RunID:               uuid.New(),
+			VisibilityTimestamp: visibilityTimestamp,
+			TaskID:              int64(59),
+			TaskType:            1,
+			TimeoutType:         2,
+			EventID:             int64(28),
+		},
+		{
+			DomainID:            "some random domain ID",
+			WorkflowID:          "some random workflow ID",
+			RunID:               uuid.New(),
+			VisibilityTimestamp: visibilityTimestamp,
+			TaskID:              int64(60),
+			TaskType:            1,
+			TimeoutType:         2,
+			EventID:             int64(29),
+		},
+	}
+	mockExecutionMgr := s.mockShard.Resource.ExecutionMgr
+	for _, minTimestamp := range []time.Time{ackLevel.(timerTaskKey).visibilityTimestamp, visibilityTimestamp} {
+		mockExecutionMgr.On("GetTimerIndexTasks", mock.Anything, &persistence.GetTimerIndexTasksRequest{
+			MinTimestamp: minTimestamp,
+			MaxTimestamp: shardMaxReadLevel.(timerTaskKey).visibilityTimestamp,
+			BatchSize:    s.mockShard.GetConfig().TimerTaskBatchSize(),
+		}).Return(&persistence.GetTimerIndexTasksResponse{Timers: timerTasks}, nil).Once()
+	}
+	mockExecutionMgr.On("GetTimerIndexTasks", mock.Anything, &persistence.GetTimerIndexTasksRequest{
+		MinTimestamp: shardMaxReadLevel.(timerTaskKey).visibilityTimestamp,
+		MaxTimestamp: maximumTimerTaskKey.(timerTaskKey).visibilityTimestamp,
+		BatchSize:    1,
+	}).Return(&persistence.GetTimerIndexTasksResponse{}, nil).Times(2)
+
+	// the second task is rejected and dropped by the first poll
+	var submittedTaskIDs []int64
+	gomock.InOrder(
+		s.mockTaskProcessor.EXPECT().TrySubmit(gomock.Any()).DoAndReturn(func(submitTask task.Task) (bool, error) {
+			submittedTaskIDs = append(submittedTaskIDs, submitTask.GetTaskID())
+			return true, nil
+		}).Times(1),
+		s.mockTaskProcessor.EXPECT().TrySubmit(gomock.Any()).Return(false, nil).Times(1),
+		s.mockTaskProcessor.EXPECT().TrySubmit(gomock.Any()).DoAndReturn(func(submitTask task.Task) (bool, error) {
+			submittedTaskIDs = append(submittedTaskIDs, submitTask.GetTaskID())
+			return true, nil
+		}).Times(1),
+	)
+
+	timerQueueProcessBase := s.newTestTimerQueueProcessorBase(processingQueueStates, updateMaxReadLevel, nil, nil, nil)
+	timerQueueProcessBase.options.DisableRedispatch = dynamicconfig.GetBoolPropertyFn(true)
+
+	// the read stops right after the submitted task instead of at the visibility timestamp
+	timerQueueProcessBase.processQueueCollections(map[int]struct{}{queueLevel: {}})
+	activeQueue := timerQueueProcessBase.processingQueueCollections[0].ActiveQueue()
+	s.True(taskKeyEquals(newTimerTaskKey(visibilityTimestamp, 59), activeQueue.State().ReadLevel()))
+	s.Len(activeQueue.(*processingQueueImpl).outstandingTasks, 1)
+
+	// the next poll reads both tasks again but only submits the dropped one
+	timerQueueProcessBase.processQueueCollections(map[int]struct{}{queueLevel: {}})
+	s.Equal([]int64{59, 60}, submittedTaskIDs)
+	s.Equal(shardMaxReadLevel, activeQueue.State().ReadLevel())
+	s.Len(activeQueue.(*processingQueueImpl).outstandingTasks, 2)
+}
+
+func (s *timerQueueProcessorBaseSuite) TestProcessQueueCollections_MaxReadTimeRange() {
+	mockClusterMetadata := s.mockShard.Resource.ClusterMetadata
+	mockClusterMetadata.EXPECT().GetCurrentClusterName().Return(s.clusterName).AnyTimes()
+
+	now := time.Now()
+	queueLevel := 0
+	maxReadTimeRange := 10 * time.Minute
+	ackLevel := newTimerTaskKey(now.Add(-time.Hour), 0)
+	cappedMaxReadLevel := newTimerTaskKey(now.Add(-time.Hour).Add(maxReadTimeRange), 0)
+	shardMaxReadLevel := newTimerTaskKey(now, 0)
+	maxLevel := newTimerTaskKey(now.Add(10*time.Second), 0)
+	processingQueueStates := []ProcessingQueueState{
+		NewProcessingQueueState(
+			queueLevel,
+			ackLevel,
+			maxLevel,
+			NewDomainFilter(nil, true),
+		),
+	}
+	updateMaxReadLevel := func() task.Key {
+		return shardMaxReadLevel
+	}
+
+	request := &persistence.GetTimerIndexTasksRequest{
+		MinTimestamp:  ackLevel.(timerTaskKey).visibilityTimestamp,
+		MaxTimestamp:  cappedMaxReadLevel.(timerTaskKey).visibilityTimestamp,
+		BatchSize:     s.mockShard.GetConfig().TimerTaskBatchSize(),
+		NextPageToken: nil,
+	}
+	lookAheadRequest := &persistence.GetTimerIndexTasksRequest{
+		MinTimestamp:  cappedMaxReadLevel.(timerTaskKey).visibilityTimestamp,
+		MaxTimestamp:  maximumTimerTaskKey.(timerTaskKey).visibilityTimestamp,
+		BatchSize:     1,
+		NextPageToken: nil,
+	}
+	mockExecutionMgr := s.mockShard.Resource.ExecutionMgr
+	mockExecutionMgr.On("GetTimerIndexTasks", mock.Anything, request).Return(&persistence.GetTimerIndexTasksResponse{}, nil).Once()
+	mockExecutionMgr.On("GetTimerIndexTasks", mock.Anything, lookAheadRequest).Return(&persistence.GetTimerIndexTasksResponse{}, nil).Once()
+
+	timerQueueProcessBase := s.newTestTimerQueueProcessorBase(processingQueueStates, updateMaxReadLevel, nil, nil, nil)
+	timerQueueProcessBase.options.MaxReadTimeRange = dynamicconfig.GetDurationPropertyFn(maxReadTimeRange)
+	timerQueueProcessBase.processQueueCollections(map[int]struct{}{queueLevel: {}})
+
+	activeQueue := timerQueueProcessBase.processingQueueCollections[0].ActiveQueue()
+	s.Equal(ackLevel, activeQueue.State().AckLevel())
+	s.Equal(cappedMaxReadLevel, activeQueue.State().ReadLevel())
+
+	// the read level has reached the capped max read level, no read should happen
+	// until the ack level moves forward
+	timerQueueProcessBase.processQueueCollections(map[int]struct{}{queueLevel: {}})
+	s.Equal(cappedMaxReadLevel, activeQueue.State().ReadLevel())
+	_, ok := timerQueueProcessBase.backoffTimer[queueLevel]
+	s.True(ok)
+}
+
 func (s *timerQueueProcessorBaseSuite) newTestTimerQueueProcessorBase(
 	processingQueueStates []ProcessingQueueState,
 	updateMaxReadLevel updateMaxReadLevelFn,
@@ -886,3 +1031,77 @@ func (s *timerQueueProcessorBaseSuite) newTestTimerQueueProcessorBase(
 		s.metricsClient,
 	)
 }
+
+func (s *timerQueueProcessorBaseSuite) TestMetricsScope() {
+	newProcessorWithScopeFn := func(
+		testScope tally.TestScope,
+		isActive bool,
+		metricsScopeFn func(metrics.Client, int) metrics.Scope,
+	) *timerQueueProcessorBase {
+		options := newTimerQueueProcessorOptions(s.mockShard.GetConfig(), isActive, false)
+		options.MetricsScopeFn = metricsScopeFn
+		return newTimerQueueProcessorBase(
+			s.clusterName,
+			s.mockShard,
+			nil,
+			s.mockTaskProcessor,
+			NewLocalTimerGate(s.mockShard.GetTimeSource()),
+			options,
+			nil,
+			nil,
+			nil,
+			nil,
+			nil,
+			nil,
+			s.logger,
+			metrics.NewClient(testScope, metrics.History),
+		)
+	}
+
+	submittedOperations := func(testScope tally.TestScope) []string {
+		var operations []string
+		for _, counter := range testScope.Snapshot().Counters() {
+			if counter.Name() == "processing_queue_task_submitted_counter" {
+				operations = append(operations, counter.Tags()["operation"])
+			}
+		}
+		return operations
+	}
+
+	mockTask := task.NewMockTask(s.controller)
+	mockTask.EXPECT().GetDomainID().Return("testDomain").AnyTimes()
+	s.mockTaskProcessor.EXPECT().TrySubmit(mockTask).Return(true, nil).Times(3)
+
+	testCases := []struct {
+		isActive           bool
+		metricsScopeFn     func(metrics.Client, int) metrics.Scope
+		expectedOperations []string
+	}{
+		{
+			isActive:           true,
+			expectedOperations: []string{"TimerActiveQueueProcessor"},
+		},
+		{
+			isActive:           false,
+			expectedOperations: []string{"TimerStandbyQueueProcessor"},
+		},
+		{
+			isActive: true,
+			metricsScopeFn: func(metricsClient metrics.Client, scopeIdx int) metrics.Scope {
+				s.Equal(metrics.TimerActiveQueueProcessorScope, scopeIdx)
+				return metricsClient.Scope(metrics.TimerQueueProcessorScope)
+			},
+			expectedOperations: []string{"TimerQueueProcessor"},
+		},
+	}
+
+	for _, tc := range testCases {
+		testScope := tally.NewTestScope("", nil)
+		timerQueueProcessBase := newProcessorWithScopeFn(testScope, tc.isActive, tc.metricsScopeFn)
+
+		submitted, err := timerQueueProcessBase.submitTask(0, mockTask)
+		s.NoError(err)
+		s.True(submitted)
+		s.Equal(tc.expectedOperations, submittedOperations(testScope))
+	}
+}
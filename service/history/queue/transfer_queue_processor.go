@@ -478,6 +478,10 @@ func newTransferQueueActiveProcessor(
 		return nil
 	}
 
+	options.LoadProcessingQueueStates = func() []ProcessingQueueState {
+		return loadTransferProcessingQueueStates(currentClusterName, shard, options, logger)
+	}
+
 	return newTransferQueueProcessorBase(
 		shard,
 		loadTransferProcessingQueueStates(currentClusterName, shard, options, logger),
@@ -534,6 +538,10 @@ func newTransferQueueStandbyProcessor(
 		return nil
 	}
 
+	options.LoadProcessingQueueStates = func() []ProcessingQueueState {
+		return loadTransferProcessingQueueStates(clusterName, shard, options, logger)
+	}
+
 	return newTransferQueueProcessorBase(
 		shard,
 		loadTransferProcessingQueueStates(clusterName, shard, options, logger),
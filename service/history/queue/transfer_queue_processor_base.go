@@ -57,10 +57,18 @@ type (
 		changeable bool
 	}
 
+	// taskIDGap is a range of task IDs [start, end] missing between transfer tasks read from persistence
+	taskIDGap struct {
+		start     int64
+		end       int64
+		firstSeen time.Time
+	}
+
 	transferQueueProcessorBase struct {
 		*processorBase
 
-		taskInitializer task.Initializer
+		// taskInitializer creates tasks for the processing queue collection at the given level
+		taskInitializer func(level int, taskInfo task.Info) task.Task
 
 		notifyCh      chan struct{}
 		nextPollTime  map[int]pollTime
@@ -70,6 +78,12 @@ type (
 		lastSplitTime           time.Time
 		lastMaxReadLevel        int64
 		estimatedTasksPerMinute int64
+
+		// taskIDGaps are the task ID gaps not yet reported keyed by their start, and lastObservedTaskID
+		// is the largest task ID read by the default level. They are only accessed by the processor
+		// pump goroutine when gap detection is enabled
+		taskIDGaps         map[int64]*taskIDGap
+		lastObservedTaskID int64
 	}
 )
 
@@ -108,7 +122,7 @@ func newTransferQueueProcessorBase(
 	return &transferQueueProcessorBase{
 		processorBase: processorBase,
 
-		taskInitializer: func(taskInfo task.Info) task.Task {
+		taskInitializer: func(level int, taskInfo task.Info) task.Task {
 			return task.NewTransferTask(
 				shard,
 				taskInfo,
@@ -117,10 +131,12 @@ func newTransferQueueProcessorBase(
 				taskFilter,
 				taskExecutor,
 				taskProcessor,
-				processorBase.redispatcher.AddTask,
+				func(nackedTask task.Task) {
+					processorBase.redispatchNackedTask(level, nackedTask)
+				},
 				shard.GetTimeSource(),
 				shard.GetConfig().TransferTaskMaxRetryCount,
-				nil,
+				processorBase,
 			)
 		},
 
@@ -130,6 +146,8 @@ func newTransferQueueProcessorBase(
 
 		lastSplitTime:    time.Time{},
 		lastMaxReadLevel: 0,
+
+		taskIDGaps: make(map[int64]*taskIDGap),
 	}
 }
 
@@ -147,8 +165,10 @@ func (t *transferQueueProcessorBase) Start() {
 		t.upsertPollTime(queueCollections.Level(), time.Time{}, true)
 	}
 
-	t.shutdownWG.Add(1)
+	t.shutdownWG.Add(2)
 	go t.processorPump()
+	go t.compactorPump()
+	t.startTaskEventPump()
 }
 
 func (t *transferQueueProcessorBase) Stop() {
@@ -195,6 +215,17 @@ func (t *transferQueueProcessorBase) backoffPollTime(level int) {
 	)), false)
 }
 
+// pollAllLevelsNow polls all processing queue collections immediately, ignoring existing backoffs
+func (t *transferQueueProcessorBase) pollAllLevelsNow() {
+	for _, queueCollection := range t.processingQueueCollections {
+		t.nextPollTime[queueCollection.Level()] = pollTime{
+			time:       time.Time{},
+			changeable: true,
+		}
+	}
+	t.nextPollTimer.Update(time.Time{})
+}
+
 func (t *transferQueueProcessorBase) processorPump() {
 	defer t.shutdownWG.Done()
 
@@ -216,17 +247,21 @@ processorPumpLoop:
 		case <-t.shutdownCh:
 			break processorPumpLoop
 		case <-t.notifyCh:
+			iteration := t.startPumpIteration(pumpIterationIdle)
 			// notify all queue collections as they are waiting for the notification when there's
 			// no more task to process. For non-default queue, we choose to do periodic polling
 			// in the future, then we don't need to notify them.
 			for _, queueCollection := range t.processingQueueCollections {
 				t.upsertPollTime(queueCollection.Level(), time.Time{}, true)
 			}
+			t.finishPumpIteration(iteration)
 		case <-t.nextPollTimer.FireChan():
+			iteration := t.startPumpIteration(pumpIterationIdle)
 			maxRedispatchQueueSize := t.options.MaxRedispatchQueueSize()
 			if t.redispatcher.Size() > maxRedispatchQueueSize {
+				iteration.iterationType = pumpIterationRedispatch
 				// has too many pending tasks in re-dispatch queue, block loading tasks from persistence
-				t.redispatcher.Redispatch(maxRedispatchQueueSize)
+				t.redispatch(context.Background(), maxRedispatchQueueSize)
 				if t.redispatcher.Size() > maxRedispatchQueueSize {
 					// if redispatcher still has a large number of tasks
 					// this only happens when system is under very high load
@@ -238,6 +273,15 @@ processorPumpLoop:
 				}
 				// re-enqueue the event to see if we need keep re-dispatching or load new tasks from persistence
 				t.nextPollTimer.Update(time.Time{})
+				t.finishPumpIteration(iteration)
+				continue processorPumpLoop
+			}
+
+			if t.shouldRedispatch() {
+				iteration.iterationType = pumpIterationRedispatch
+				t.redispatch(context.Background(), 0)
+				t.nextPollTimer.Update(time.Time{})
+				t.finishPumpIteration(iteration)
 				continue processorPumpLoop
 			}
 
@@ -252,25 +296,45 @@ processorPumpLoop:
 				}
 			}
 
+			if len(levels) != 0 {
+				iteration.iterationType = pumpIterationRead
+			}
 			t.processQueueCollections(levels)
+			t.finishPumpIteration(iteration)
 		case <-updateAckTimer.C:
-			processFinished, err := t.updateAckLevel()
-			if err == shard.ErrShardClosed || (err == nil && processFinished) {
+			iteration := t.startPumpIteration(pumpIterationUpdateAckLevel)
+			processFinished, err := t.updateAckLevel(context.Background())
+			if err == shard.ErrShardClosed || err == errQueueShutdownTimeout || err == ErrProcessorShuttingDown || (err == nil && processFinished) {
+				t.finishPumpIteration(iteration)
 				go t.Stop()
 				break processorPumpLoop
 			}
+			t.reportTaskIDGaps()
 			updateAckTimer.Reset(backoff.JitDuration(
 				t.options.UpdateAckInterval(),
 				t.options.UpdateAckIntervalJitterCoefficient(),
 			))
+			t.finishPumpIteration(iteration)
 		case <-splitQueueTimer.C:
+			iteration := t.startPumpIteration(pumpIterationSplit)
 			t.splitQueue()
 			splitQueueTimer.Reset(backoff.JitDuration(
 				t.options.SplitQueueInterval(),
 				t.options.SplitQueueIntervalJitterCoefficient(),
 			))
+			t.finishPumpIteration(iteration)
+		case <-t.compactNotifyCh:
+			iteration := t.startPumpIteration(pumpIterationCompact)
+			t.pruneProcessingQueueCollections()
+			t.finishPumpIteration(iteration)
+		case <-t.drainNotifyCh:
+			iteration := t.startPumpIteration(pumpIterationIdle)
+			t.pollAllLevelsNow()
+			t.finishPumpIteration(iteration)
 		case notification := <-t.actionNotifyCh:
+			iteration := t.startPumpIteration(pumpIterationAction)
 			t.handleActionNotification(notification)
+			t.finishPumpIteration(iteration)
 		}
 	}
 }
@@ -298,6 +362,7 @@ func (t *transferQueueProcessorBase) processQueueCollections(levels map[int]stru
 		readLevel := activeQueue.State().ReadLevel()
 		maxReadLevel := minTaskKey(activeQueue.State().MaxLevel(), t.updateMaxReadLevel())
 		domainFilter := activeQueue.State().DomainFilter()
+		taskTypeFilter := activeQueue.State().TaskTypeFilter()
 
 		if !readLevel.Less(maxReadLevel) {
 			// no task need to be processed for now, wait for new task notification
@@ -306,8 +371,15 @@ func (t *transferQueueProcessorBase) processQueueCollections(levels map[int]stru
 			continue
 		}
 
+		if backoffDuration := t.getReadBackoffDuration(level); backoffDuration > 0 {
+			// reads for this level are throttled, mark the poll time as unchangeable so
+			// that new task notifications won't trigger a read before the backoff ends
+			t.upsertPollTime(level, t.shard.GetTimeSource().Now().Add(backoffDuration), false)
+			continue
+		}
+
 		ctx, cancel := context.WithTimeout(context.Background(), loadQueueTaskThrottleRetryDelay)
-		if err := t.rateLimiter.Wait(ctx); err != nil {
+		if err := t.waitReadRateLimit(ctx); err != nil {
 			cancel()
 			if level != defaultProcessingQueueLevel {
 				t.backoffPollTime(level)
@@ -324,35 +396,61 @@ func (t *transferQueueProcessorBase) processQueueCollections(levels map[int]stru
 			t.upsertPollTime(level, time.Time{}, true) // re-enqueue the event
 			continue
 		}
+		if level == defaultProcessingQueueLevel {
+			t.observeTaskIDGaps(readLevel, transferTaskInfos)
+		}
 
+		t.releaseParkedTasks()
+		t.refreshOutstandingTaskCount()
 		tasks := make(map[task.Key]task.Task)
 		taskChFull := false
-		for _, taskInfo := range transferTaskInfos {
-			if !domainFilter.Filter(taskInfo.GetDomainID()) {
+		var droppedReadLevel task.Key
+		for idx, taskInfo := range transferTaskInfos {
+			if !domainFilter.Filter(taskInfo.GetDomainID()) || !taskTypeFilter.Filter(taskInfo.GetTaskType()) {
 				continue
 			}
 
-			task := t.taskInitializer(taskInfo)
-			tasks[newTransferTaskKey(taskInfo.GetTaskID())] = task
-			submitted, err := t.submitTask(task)
+			task := t.taskInitializer(level, taskInfo)
+			if !t.verifyTaskCategory(task) {
+				continue
+			}
+			assignQueuePriority(task, activeQueue.State())
+			submitted, err := t.submitTask(level, task)
+			if err == errTaskDropped {
+				// redispatch is disabled, stop right before the dropped
+				// task so that it's read again by the next poll
+				droppedReadLevel = readLevel
+				if idx > 0 {
+					droppedReadLevel = newTransferTaskKey(transferTaskInfos[idx-1].GetTaskID())
+				}
+				break
+			}
 			if err != nil {
-				// only err here is due to the fact that processor has been shutdown
+				// only other err here is due to the fact that processor has been shutdown
 				// return instead of continue
 				return
 			}
+			tasks[newTransferTaskKey(taskInfo.GetTaskID())] = task
 			taskChFull = taskChFull || !submitted
 		}
 
 		var newReadLevel task.Key
-		if !more {
+		if droppedReadLevel != nil {
+			newReadLevel = droppedReadLevel
+		} else if !more {
 			newReadLevel = maxReadLevel
 		} else {
 			newReadLevel = newTransferTaskKey(transferTaskInfos[len(transferTaskInfos)-1].GetTaskID())
 		}
+		unlock := t.lockQueueCollections(lockOperationAddTasks)
 		queueCollection.AddTasks(tasks, newReadLevel)
+		unlock()
 		newActiveQueue := queueCollection.ActiveQueue()
 
-		if more || (newActiveQueue != nil && newActiveQueue != activeQueue) {
+		if droppedReadLevel != nil {
+			// back off instead of reading the dropped task again right away
+			t.backoffPollTime(level)
+		} else if more || (newActiveQueue != nil && newActiveQueue != activeQueue) {
 			// more tasks for the current active queue or the active queue has changed
 			if level != defaultProcessingQueueLevel && taskChFull {
 				t.backoffPollTime(level)
@@ -367,6 +465,70 @@ func (t *transferQueueProcessorBase) processQueueCollections(levels map[int]stru
 	}
 }
 
+// observeTaskIDGaps records task IDs missing between the read level and the tasks read from persistence,
+// and forgets recorded gaps covered by the read tasks. Only reads of the default level are observed as
+// reads of other levels cover ranges already read by the default level.
+// Only the processor pump goroutine should call this method
+func (t *transferQueueProcessorBase) observeTaskIDGaps(
+	readLevel task.Key,
+	taskInfos []*persistence.TransferTaskInfo,
+) {
+	if t.options.TaskGapTimeout == nil || t.options.TaskGapTimeout() <= 0 {
+		return
+	}
+
+	now := t.shard.GetTimeSource().Now()
+	prevTaskID := readLevel.(transferTaskKey).taskID
+	for _, taskInfo := range taskInfos {
+		taskID := taskInfo.GetTaskID()
+		if taskID <= t.lastObservedTaskID {
+			// the range is read again, e.g. after a reset, and the task may fill a gap
+			for start, gap := range t.taskIDGaps {
+				if gap.start <= taskID && taskID <= gap.end {
+					delete(t.taskIDGaps, start)
+				}
+			}
+		}
+		if taskID > prevTaskID+1 {
+			if _, ok := t.taskIDGaps[prevTaskID+1]; !ok {
+				t.taskIDGaps[prevTaskID+1] = &taskIDGap{
+					start:     prevTaskID + 1,
+					end:       taskID - 1,
+					firstSeen: now,
+				}
+			}
+		}
+		prevTaskID = taskID
+		t.lastObservedTaskID = common.MaxInt64(t.lastObservedTaskID, taskID)
+	}
+}
+
+// reportTaskIDGaps reports task ID gaps missing for longer than TaskGapTimeout and forgets them.
+// Ack levels are derived from loaded tasks and already move past missing task IDs, so gaps are
+// only reported to surface tasks lost by persistence. Only the processor pump goroutine should call this method
+func (t *transferQueueProcessorBase) reportTaskIDGaps() {
+	if t.options.TaskGapTimeout == nil || t.options.TaskGapTimeout() <= 0 {
+		if len(t.taskIDGaps) != 0 {
+			t.taskIDGaps = make(map[int64]*taskIDGap)
+		}
+		return
+	}
+
+	now := t.shard.GetTimeSource().Now()
+	for start, gap := range t.taskIDGaps {
+		if now.Sub(gap.firstSeen) < t.options.TaskGapTimeout() {
+			continue
+		}
+
+		t.metricsScope.IncCounter(metrics.ProcessingQueueTaskGapCounter)
+		t.logger.Warn("Transfer task IDs missing from persistence",
+			tag.MinLevel(gap.start),
+			tag.MaxLevel(gap.end),
+		)
+		delete(t.taskIDGaps, start)
+	}
+}
+
 func (t *transferQueueProcessorBase) splitQueue() {
 	currentTime := t.shard.GetTimeSource().Now()
 	currentMaxReadLevel := t.updateMaxReadLevel().(transferTaskKey).taskID
@@ -403,7 +565,10 @@ func (t *transferQueueProcessorBase) splitQueue() {
 		},
 	)
 
-	t.splitProcessingQueueCollection(splitPolicy, func(level int, pollTime time.Time) {
+	ctx, cancel := t.newShutdownContext()
+	defer cancel()
+
+	t.splitProcessingQueueCollection(ctx, splitPolicy, func(level int, pollTime time.Time) {
 		t.upsertPollTime(level, pollTime, true)
 	})
 }
@@ -413,6 +578,10 @@ func (t *transferQueueProcessorBase) handleActionNotification(notification actio
 		switch notification.action.ActionType {
 		case ActionTypeReset:
 			t.upsertPollTime(defaultProcessingQueueLevel, time.Time{}, true)
+		case ActionTypeCollapseLevel, ActionTypeReconcile, ActionTypeSplit:
+			for _, queueCollection := range t.processingQueueCollections {
+				t.upsertPollTime(queueCollection.Level(), time.Time{}, true)
+			}
 		}
 	})
 }
@@ -469,10 +638,41 @@ func newTransferQueueProcessorOptions(
 		UpdateAckIntervalJitterCoefficient:   config.TransferProcessorUpdateAckIntervalJitterCoefficient,
 		RedispatchIntervalJitterCoefficient:  config.TaskRedispatchIntervalJitterCoefficient,
 		MaxRedispatchQueueSize:               config.TransferProcessorMaxRedispatchQueueSize,
+		RedispatchBatchSizeByDomainID:        config.QueueProcessorRedispatchBatchSizeByDomainID,
 		SplitQueueInterval:                   config.TransferProcessorSplitQueueInterval,
 		SplitQueueIntervalJitterCoefficient:  config.TransferProcessorSplitQueueIntervalJitterCoefficient,
 		PollBackoffInterval:                  config.QueueProcessorPollBackoffInterval,
 		PollBackoffIntervalJitterCoefficient: config.QueueProcessorPollBackoffIntervalJitterCoefficient,
+		ShutdownTimeout:                      config.QueueProcessorShutdownTimeout,
+		MinPollInterval:                      config.QueueProcessorMinPollInterval,
+		LockMetricsSampleRate:                config.QueueProcessorLockMetricsSampleRate,
+		MaxConcurrentRedispatch:              config.QueueProcessorMaxConcurrentRedispatch,
+		SkipRedispatchWhenBusy:               config.QueueProcessorSkipRedispatchWhenBusy,
+		EnableBackgroundCompaction:           config.QueueProcessorEnableBackgroundCompaction,
+		BackgroundCompactionInterval:         config.QueueProcessorBackgroundCompactionInterval,
+		EnableDomainTaggedMetrics:            config.QueueProcessorEnableDomainTaggedMetrics,
+		EnableLevelTaggedMetrics:             config.QueueProcessorEnableLevelTaggedMetrics,
+		StuckAckLevelThreshold:               config.QueueProcessorStuckAckLevelThreshold,
+		RedispatchWeight:                     config.QueueProcessorRedispatchWeight,
+		EnableOutstandingTaskShedding:        config.QueueProcessorEnableOutstandingTaskShedding,
+		MaxOutstandingTasksPerDomain:         config.QueueProcessorMaxOutstandingTasksPerDomain,
+		RedispatchMinBatchSize:               config.QueueProcessorRedispatchMinBatchSize,
+		RedispatchMaxBatchSize:               config.QueueProcessorRedispatchMaxBatchSize,
+		MaxInFlightTasks:                     config.QueueProcessorMaxInFlightTasks,
+		MaxAckReadGap:                        config.QueueProcessorMaxAckReadGap,
+		PinnedDomainLevels:                   config.QueueProcessorPinnedDomainLevels,
+		RedispatchRequeueDelay:               config.QueueProcessorRedispatchRequeueDelay,
+		RedispatchRequeueMaxDelay:            config.QueueProcessorRedispatchRequeueMaxDelay,
+		NewTaskLevelStrategy:                 config.QueueProcessorNewTaskLevelStrategy,
+		DisableRedispatch:                    config.QueueProcessorDisableRedispatch,
+		WarmupDuration:                       config.QueueProcessorWarmupDuration,
+		EnableTaskSubmitAge:                  config.QueueProcessorEnableTaskSubmitAge,
+		SplitCooldown:                        config.QueueProcessorSplitCooldown,
+		EnableOrderedProcessingByDomainID:    config.QueueProcessorEnableOrderedProcessingByDomainID,
+		RedispatchDecisionLogSize:            config.QueueProcessorRedispatchDecisionLogSize,
+		MaxRedispatchQueueSizePerDomain:      config.QueueProcessorMaxRedispatchQueueSizePerDomain,
+		TaskGapTimeout:                       config.TransferProcessorTaskGapTimeout,
+		RedispatchPolicies:                   newRedispatchPolicies(config),
 	}
 
 	if isFailover {
@@ -492,6 +692,7 @@ func newTransferQueueProcessorOptions(
 		options.EnableStuckTaskSplitByDomainID = config.QueueProcessorEnableStuckTaskSplitByDomainID
 		options.StuckTaskSplitThreshold = config.QueueProcessorStuckTaskSplitThreshold
 		options.SplitLookAheadDurationByDomainID = config.QueueProcessorSplitLookAheadDurationByDomainID
+		options.MaxPollRPSByLevel = config.QueueProcessorMaxPollRPSByLevel
 
 		options.EnablePersistQueueStates = config.QueueProcessorEnablePersistQueueStates
 		options.EnableLoadQueueStates = config.QueueProcessorEnableLoadQueueStates
@@ -21,6 +21,9 @@
 package queue
 
 import (
+	"context"
+	"errors"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -30,10 +33,14 @@ import (
 	"github.com/stretchr/testify/suite"
 	"github.com/uber-go/tally"
 
+	"github.com/uber/cadence/common"
+	"github.com/uber/cadence/common/clock"
 	"github.com/uber/cadence/common/log"
 	"github.com/uber/cadence/common/log/loggerimpl"
 	"github.com/uber/cadence/common/metrics"
 	"github.com/uber/cadence/common/persistence"
+	"github.com/uber/cadence/common/service/dynamicconfig"
+	t "github.com/uber/cadence/common/task"
 	"github.com/uber/cadence/service/history/config"
 	"github.com/uber/cadence/service/history/constants"
 	"github.com/uber/cadence/service/history/shard"
@@ -293,6 +300,475 @@ func (s *transferQueueProcessorBaseSuite) TestProcessQueueCollections_WithNextPa
 	}
 }
 
+func (s *transferQueueProcessorBaseSuite) TestProcessQueueCollections_RedispatchDisabled() {
+	queueLevel := 0
+	ackLevel := newTransferTaskKey(0)
+	maxLevel := newTransferTaskKey(1000)
+	processingQueueStates := []ProcessingQueueState{
+		NewProcessingQueueState(
+			queueLevel,
+			ackLevel,
+			maxLevel,
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+		),
+	}
+	updateMaxReadLevel := func() task.Key {
+		return newTransferTaskKey(10000)
+	}
+	taskInfos := []*persistence.TransferTaskInfo{
+		{
+			TaskID:   1,
+			DomainID: "testDomain1",
+		},
+		{
+			TaskID:   10,
+			DomainID: "testDomain2",
+		},
+		{
+			TaskID:   100,
+			DomainID: "testDomain1",
+		},
+		{
+			TaskID:   500,
+			DomainID: "testDomain1",
+		},
+	}
+	mockExecutionManager := s.mockShard.Resource.ExecutionMgr
+	mockExecutionManager.On("GetTransferTasks", mock.Anything, &persistence.GetTransferTasksRequest{
+		ReadLevel:    ackLevel.(transferTaskKey).taskID,
+		MaxReadLevel: maxLevel.(transferTaskKey).taskID,
+		BatchSize:    s.mockShard.GetConfig().TransferTaskBatchSize(),
+	}).Return(&persistence.GetTransferTasksResponse{
+		Tasks:         taskInfos,
+		NextPageToken: nil,
+	}, nil).Once()
+
+	gomock.InOrder(
+		s.mockTaskProcessor.EXPECT().TrySubmit(gomock.Any()).Return(true, nil).Times(1),
+		s.mockTaskProcessor.EXPECT().TrySubmit(gomock.Any()).Return(false, nil).Times(1),
+	)
+
+	processorBase := s.newTestTransferQueueProcessorBase(
+		processingQueueStates,
+		updateMaxReadLevel,
+		nil,
+		nil,
+		nil,
+	)
+	processorBase.options.DisableRedispatch = dynamicconfig.GetBoolPropertyFn(true)
+
+	processorBase.processQueueCollections(map[int]struct{}{0: {}})
+
+	// the rejected task is dropped and the read stops right before it
+	s.Zero(processorBase.redispatcher.Size())
+	queue := processorBase.processingQueueCollections[0].Queues()[0].(*processingQueueImpl)
+	s.True(taskKeyEquals(newTransferTaskKey(10), queue.State().ReadLevel()))
+	s.Len(queue.outstandingTasks, 1)
+	s.Contains(queue.outstandingTasks, newTransferTaskKey(1))
+
+	ackLevel, _ = queue.UpdateAckLevel()
+	s.True(ackLevel.Less(newTransferTaskKey(100)))
+	s.False(processorBase.nextPollTime[queueLevel].time.Before(processorBase.shard.GetTimeSource().Now()))
+}
+
+func (s *transferQueueProcessorBaseSuite) TestProcessQueueCollections_RedispatchQueueFull() {
+	queueLevel := 0
+	ackLevel := newTransferTaskKey(0)
+	maxLevel := newTransferTaskKey(1000)
+	processingQueueStates := []ProcessingQueueState{
+		NewProcessingQueueState(
+			queueLevel,
+			ackLevel,
+			maxLevel,
+			NewDomainFilter(nil, true),
+		),
+	}
+	updateMaxReadLevel := func() task.Key {
+		return newTransferTaskKey(10000)
+	}
+	taskInfos := []*persistence.TransferTaskInfo{
+		{
+			TaskID:   1,
+			DomainID: "testDomain1",
+		},
+		{
+			TaskID:   10,
+			DomainID: "testDomain1",
+		},
+		{
+			TaskID:   100,
+			DomainID: "testDomain2",
+		},
+		{
+			TaskID:   500,
+			DomainID: "testDomain1",
+		},
+		{
+			TaskID:   600,
+			DomainID: "testDomain2",
+		},
+	}
+	mockExecutionManager := s.mockShard.Resource.ExecutionMgr
+	mockExecutionManager.On("GetTransferTasks", mock.Anything, &persistence.GetTransferTasksRequest{
+		ReadLevel:    ackLevel.(transferTaskKey).taskID,
+		MaxReadLevel: maxLevel.(transferTaskKey).taskID,
+		BatchSize:    s.mockShard.GetConfig().TransferTaskBatchSize(),
+	}).Return(&persistence.GetTransferTasksResponse{
+		Tasks:         taskInfos,
+		NextPageToken: nil,
+	}, nil).Once()
+
+	// tasks of testDomain1 are always rejected and its redispatch queue is capped at one task
+	var submittedDomainIDs []string
+	s.mockTaskProcessor.EXPECT().TrySubmit(gomock.Any()).DoAndReturn(func(submitTask task.Task) (bool, error) {
+		if submitTask.GetDomainID() == "testDomain1" {
+			return false, nil
+		}
+		submittedDomainIDs = append(submittedDomainIDs, submitTask.GetDomainID())
+		return true, nil
+	}).AnyTimes()
+
+	processorBase := s.newTestTransferQueueProcessorBase(
+		processingQueueStates,
+		updateMaxReadLevel,
+		nil,
+		nil,
+		nil,
+	)
+	processorBase.options.MaxRedispatchQueueSizePerDomain = func(domainID string) int {
+		if domainID == "testDomain1" {
+			return 1
+		}
+		return 0
+	}
+
+	processorBase.processQueueCollections(map[int]struct{}{0: {}})
+
+	// tasks of testDomain2 read after the capped domain's tasks are still submitted
+	// and the read level is not held back by the capped domain
+	s.Equal([]string{"testDomain2", "testDomain2"}, submittedDomainIDs)
+	s.Equal(1, processorBase.redispatcher.SizeByDomainID("testDomain1"))
+	s.Len(processorBase.parkedTasksByDomain["testDomain1"], 2)
+	queue := processorBase.processingQueueCollections[0].Queues()[0].(*processingQueueImpl)
+	s.True(taskKeyEquals(maxLevel, queue.State().ReadLevel()))
+	s.Len(queue.outstandingTasks, len(taskInfos))
+
+	// parked tasks stay pending, so the ack level is not advanced past them
+	ackLevel, _ = queue.UpdateAckLevel()
+	s.True(ackLevel.Less(newTransferTaskKey(1)))
+}
+
+func (s *transferQueueProcessorBaseSuite) TestProcessQueueCollections_TaskIDGap() {
+	now := time.Now()
+	timeSource := clock.NewEventTimeSource().Update(now)
+	s.mockShard.Resource.TimeSource = timeSource
+
+	queueLevel := 0
+	ackLevel := newTransferTaskKey(0)
+	maxLevel := newTransferTaskKey(1000)
+	processingQueueStates := []ProcessingQueueState{
+		NewProcessingQueueState(
+			queueLevel,
+			ackLevel,
+			maxLevel,
+			NewDomainFilter(nil, true),
+		),
+	}
+	updateMaxReadLevel := func() task.Key {
+		return newTransferTaskKey(10000)
+	}
+	taskInfos := []*persistence.TransferTaskInfo{}
+	for _, taskID := range []int64{1, 2, 5, 6, 9} {
+		taskInfos = append(taskInfos, &persistence.TransferTaskInfo{
+			TaskID:   taskID,
+			DomainID: "testDomain1",
+		})
+	}
+	mockExecutionManager := s.mockShard.Resource.ExecutionMgr
+	mockExecutionManager.On("GetTransferTasks", mock.Anything, &persistence.GetTransferTasksRequest{
+		ReadLevel:    ackLevel.(transferTaskKey).taskID,
+		MaxReadLevel: maxLevel.(transferTaskKey).taskID,
+		BatchSize:    s.mockShard.GetConfig().TransferTaskBatchSize(),
+	}).Return(&persistence.GetTransferTasksResponse{
+		Tasks:         taskInfos,
+		NextPageToken: nil,
+	}, nil).Once()
+
+	s.mockTaskProcessor.EXPECT().TrySubmit(gomock.Any()).Return(true, nil).AnyTimes()
+
+	processorBase := s.newTestTransferQueueProcessorBase(
+		processingQueueStates,
+		updateMaxReadLevel,
+		nil,
+		nil,
+		nil,
+	)
+	processorBase.options.TaskGapTimeout = dynamicconfig.GetDurationPropertyFn(time.Minute)
+	testScope := tally.NewTestScope("", nil)
+	processorBase.metricsScope = metrics.NewClient(testScope, metrics.History).Scope(metrics.TransferActiveQueueProcessorScope)
+
+	processorBase.processQueueCollections(map[int]struct{}{0: {}})
+	s.Len(processorBase.taskIDGaps, 2)
+
+	numGaps := func() int64 {
+		var count int64
+		for _, counter := range testScope.Snapshot().Counters() {
+			if counter.Name() == "processing_queue_task_gap_counter" {
+				count += counter.Value()
+			}
+		}
+		return count
+	}
+
+	processorBase.reportTaskIDGaps()
+	s.Zero(numGaps())
+
+	timeSource.Update(now.Add(2 * time.Minute))
+	processorBase.reportTaskIDGaps()
+	s.Equal(int64(2), numGaps())
+	s.Empty(processorBase.taskIDGaps)
+}
+
+func (s *transferQueueProcessorBaseSuite) TestDrainNow_IgnoreRateLimiter() {
+	ackLevel := newTransferTaskKey(0)
+	maxLevel := newTransferTaskKey(1000)
+	processingQueueStates := []ProcessingQueueState{
+		NewProcessingQueueState(
+			defaultProcessingQueueLevel,
+			ackLevel,
+			maxLevel,
+			NewDomainFilter(nil, true),
+		),
+	}
+	updateMaxReadLevel := func() task.Key {
+		return maxLevel
+	}
+	mockExecutionManager := s.mockShard.Resource.ExecutionMgr
+	mockExecutionManager.On("GetTransferTasks", mock.Anything, &persistence.GetTransferTasksRequest{
+		ReadLevel:    ackLevel.(transferTaskKey).taskID,
+		MaxReadLevel: maxLevel.(transferTaskKey).taskID,
+		BatchSize:    s.mockShard.GetConfig().TransferTaskBatchSize(),
+	}).Return(&persistence.GetTransferTasksResponse{
+		Tasks: []*persistence.TransferTaskInfo{
+			{
+				TaskID:   1,
+				DomainID: "testDomain1",
+			},
+			{
+				TaskID:   10,
+				DomainID: "testDomain2",
+			},
+		},
+		NextPageToken: nil,
+	}, nil).Once()
+
+	s.mockTaskProcessor.EXPECT().TrySubmit(gomock.Any()).DoAndReturn(func(submittedTask task.Task) (bool, error) {
+		submittedTask.Ack()
+		return true, nil
+	}).Times(2)
+
+	processorBase := s.newTestTransferQueueProcessorBase(
+		processingQueueStates,
+		updateMaxReadLevel,
+		nil,
+		nil,
+		nil,
+	)
+	processorBase.options.UpdateAckInterval = dynamicconfig.GetDurationPropertyFn(time.Hour)
+	limiter := &testRejectAllLimiter{waitCh: make(chan struct{}, 1)}
+	processorBase.rateLimiter = limiter
+
+	processorBase.Start()
+	defer processorBase.Stop()
+
+	// reads are blocked by the rate limiter until the processor is drained
+	select {
+	case <-limiter.waitCh:
+	case <-time.After(5 * time.Second):
+		s.Fail("read should wait on the rate limiter")
+	}
+	_, readFinished := processorBase.getDrainProgress()
+	s.False(readFinished)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.Zero(processorBase.DrainNow(ctx))
+	s.NoError(ctx.Err())
+	s.False(processorBase.isDraining())
+}
+
+func (s *transferQueueProcessorBaseSuite) TestUpdateAckLevel_ShardOwnershipLost() {
+	processingQueueStates := []ProcessingQueueState{
+		NewProcessingQueueState(
+			defaultProcessingQueueLevel,
+			newTransferTaskKey(0),
+			newTransferTaskKey(1000),
+			NewDomainFilter(nil, true),
+		),
+	}
+	updateMaxReadLevel := func() task.Key {
+		// no task to read
+		return newTransferTaskKey(0)
+	}
+	updateClusterAckLevel := func(task.Key) error {
+		return &persistence.ShardOwnershipLostError{ShardID: 10, Msg: "range ID changed"}
+	}
+
+	processorBase := s.newTestTransferQueueProcessorBase(
+		processingQueueStates,
+		updateMaxReadLevel,
+		updateClusterAckLevel,
+		nil,
+		nil,
+	)
+	processorBase.options.UpdateAckInterval = dynamicconfig.GetDurationPropertyFn(10 * time.Millisecond)
+	testScope := tally.NewTestScope("", nil)
+	processorBase.metricsScope = metrics.NewClient(testScope, metrics.History).Scope(metrics.TransferActiveQueueProcessorScope)
+
+	processorBase.Start()
+	defer processorBase.Stop()
+
+	// the processor stops itself once the ack level update finds out the shard ownership is lost
+	select {
+	case <-processorBase.shutdownCh:
+	case <-time.After(5 * time.Second):
+		s.FailNow("processor is not stopped after shard ownership is lost")
+	}
+	s.Equal(common.DaemonStatusStopped, atomic.LoadInt32(&processorBase.status))
+
+	// the last pump iteration is still recorded
+	var numAckLevelIterations int64
+	for _, counter := range testScope.Snapshot().Counters() {
+		if counter.Name() == "processing_queue_pump_iteration" && counter.Tags()["pumpIteration"] == pumpIterationUpdateAckLevel {
+			numAckLevelIterations += counter.Value()
+		}
+	}
+	s.Equal(int64(1), numAckLevelIterations)
+}
+
+func (s *transferQueueProcessorBaseSuite) TestProcessQueueCollections_QueuePriority() {
+	queueLevel := 1
+	queuePriority := t.GetTaskPriority(t.HighPriorityClass, t.DefaultPrioritySubclass)
+	ackLevel := newTransferTaskKey(0)
+	maxLevel := newTransferTaskKey(1000)
+	processingQueueStates := []ProcessingQueueState{
+		NewProcessingQueueStateWithPriority(
+			queueLevel,
+			ackLevel,
+			maxLevel,
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+			queuePriority,
+		),
+	}
+	updateMaxReadLevel := func() task.Key {
+		return newTransferTaskKey(10000)
+	}
+	taskInfos := []*persistence.TransferTaskInfo{
+		{
+			TaskID:   1,
+			DomainID: "testDomain1",
+		},
+		{
+			TaskID:   10,
+			DomainID: "testDomain1",
+		},
+	}
+	mockExecutionManager := s.mockShard.Resource.ExecutionMgr
+	mockExecutionManager.On("GetTransferTasks", mock.Anything, &persistence.GetTransferTasksRequest{
+		ReadLevel:    ackLevel.(transferTaskKey).taskID,
+		MaxReadLevel: maxLevel.(transferTaskKey).taskID,
+		BatchSize:    s.mockShard.GetConfig().TransferTaskBatchSize(),
+	}).Return(&persistence.GetTransferTasksResponse{
+		Tasks:         taskInfos,
+		NextPageToken: nil,
+	}, nil).Once()
+
+	submittedPriorities := []int{}
+	s.mockTaskProcessor.EXPECT().TrySubmit(gomock.Any()).DoAndReturn(func(task task.Task) (bool, error) {
+		submittedPriorities = append(submittedPriorities, task.Priority())
+		return true, nil
+	}).Times(len(taskInfos))
+
+	processorBase := s.newTestTransferQueueProcessorBase(
+		processingQueueStates,
+		updateMaxReadLevel,
+		nil,
+		nil,
+		nil,
+	)
+
+	processorBase.processQueueCollections(map[int]struct{}{queueLevel: {}})
+
+	s.Equal([]int{queuePriority, queuePriority}, submittedPriorities)
+}
+
+func (s *transferQueueProcessorBaseSuite) TestProcessQueueCollections_TaskCategoryMismatch() {
+	queueLevel := 0
+	ackLevel := newTransferTaskKey(0)
+	maxLevel := newTransferTaskKey(1000)
+	processingQueueStates := []ProcessingQueueState{
+		NewProcessingQueueState(
+			queueLevel,
+			ackLevel,
+			maxLevel,
+			NewDomainFilter(map[string]struct{}{"testDomain1": {}}, false),
+		),
+	}
+	updateMaxReadLevel := func() task.Key {
+		return maxLevel
+	}
+	taskInfos := []*persistence.TransferTaskInfo{
+		{
+			TaskID:   1,
+			DomainID: "testDomain1",
+		},
+		{
+			TaskID:   10,
+			DomainID: "testDomain1",
+		},
+	}
+	mockExecutionManager := s.mockShard.Resource.ExecutionMgr
+	mockExecutionManager.On("GetTransferTasks", mock.Anything, &persistence.GetTransferTasksRequest{
+		ReadLevel:    ackLevel.(transferTaskKey).taskID,
+		MaxReadLevel: maxLevel.(transferTaskKey).taskID,
+		BatchSize:    s.mockShard.GetConfig().TransferTaskBatchSize(),
+	}).Return(&persistence.GetTransferTasksResponse{
+		Tasks:         taskInfos,
+		NextPageToken: nil,
+	}, nil).Once()
+
+	s.mockTaskProcessor.EXPECT().TrySubmit(gomock.Any()).Return(true, nil).Times(1)
+
+	processorBase := s.newTestTransferQueueProcessorBase(
+		processingQueueStates,
+		updateMaxReadLevel,
+		nil,
+		nil,
+		nil,
+	)
+	transferTaskInitializer := processorBase.taskInitializer
+	processorBase.taskInitializer = func(level int, taskInfo task.Info) task.Task {
+		if taskInfo.GetTaskID() != 10 {
+			return transferTaskInitializer(level, taskInfo)
+		}
+		// a timer task wrongly routed to the transfer processor
+		timerTask := task.NewMockTask(s.controller)
+		timerTask.EXPECT().GetTaskCategory().Return(task.CategoryTimer).AnyTimes()
+		timerTask.EXPECT().GetDomainID().Return(taskInfo.GetDomainID()).AnyTimes()
+		timerTask.EXPECT().GetWorkflowID().Return(taskInfo.GetWorkflowID()).AnyTimes()
+		timerTask.EXPECT().GetRunID().Return(taskInfo.GetRunID()).AnyTimes()
+		timerTask.EXPECT().GetTaskID().Return(taskInfo.GetTaskID()).AnyTimes()
+		return timerTask
+	}
+
+	processorBase.processQueueCollections(map[int]struct{}{queueLevel: {}})
+
+	queueCollection := processorBase.processingQueueCollections[0]
+	outstandingTasks := queueCollection.Queues()[0].(*processingQueueImpl).outstandingTasks
+	s.Len(outstandingTasks, 1)
+	s.Contains(outstandingTasks, newTransferTaskKey(1))
+}
+
 func (s *transferQueueProcessorBaseSuite) TestReadTasks_NoNextPage() {
 	readLevel := newTransferTaskKey(3)
 	maxReadLevel := newTransferTaskKey(100)
@@ -373,3 +849,23 @@ func (s *transferQueueProcessorBaseSuite) newTestTransferQueueProcessorBase(
 		s.metricsClient,
 	)
 }
+
+type testRejectAllLimiter struct {
+	// waitCh is signalled each time Wait is called, if it's not nil
+	waitCh chan struct{}
+}
+
+func (l *testRejectAllLimiter) Allow() bool {
+	return false
+}
+
+func (l *testRejectAllLimiter) Wait(ctx context.Context) error {
+	if l.waitCh != nil {
+		select {
+		case l.waitCh <- struct{}{}:
+		default:
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	return errors.New("rate limited")
+}
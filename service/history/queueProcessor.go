@@ -133,6 +133,7 @@ func newQueueProcessorBase(
 		queueTaskProcessor: queueTaskProcessor,
 		redispatcher: task.NewRedispatcher(
 			queueTaskProcessor,
+			shard.GetTimeSource(),
 			&task.RedispatcherOptions{
 				TaskRedispatchInterval:                  options.RedispatchInterval,
 				TaskRedispatchIntervalJitterCoefficient: options.RedispatchIntervalJitterCoefficient,
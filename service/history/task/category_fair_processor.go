@@ -0,0 +1,278 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package task
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/uber/cadence/common"
+	"github.com/uber/cadence/common/log"
+	"github.com/uber/cadence/common/log/tag"
+	"github.com/uber/cadence/common/metrics"
+	"github.com/uber/cadence/service/history/shard"
+)
+
+const (
+	// categoryFairDispatchRetryInterval is the interval the dispatcher waits before retrying
+	// when the underlying processor is busy and no task can be submitted for any category
+	categoryFairDispatchRetryInterval = 10 * time.Millisecond
+)
+
+type (
+	categoryFairProcessorImpl struct {
+		processor    Processor
+		logger       log.Logger
+		metricsScope metrics.Scope
+
+		status     int32
+		shutdownCh chan struct{}
+		shutdownWG sync.WaitGroup
+		notifyCh   chan struct{}
+		categories []Category
+		taskChs    map[Category]chan Task
+		// pendingTasks contains the task of each category rejected by the underlying processor,
+		// which is retried before other tasks of the category. It's only accessed by the
+		// dispatch loop, or by Stop after the dispatch loop exits
+		pendingTasks map[Category]Task
+	}
+)
+
+var _ Processor = (*categoryFairProcessorImpl)(nil)
+
+// NewCategoryFairProcessor creates a task processor which buffers submitted tasks by task
+// category and submits them to the given processor in round robin order of categories,
+// so that when transfer and timer queues share the processor, neither of them starves the other
+func NewCategoryFairProcessor(
+	processor Processor,
+	bufferSize int,
+	logger log.Logger,
+	metricsClient metrics.Client,
+) Processor {
+	categories := []Category{CategoryTransfer, CategoryTimer, CategoryReplication}
+	taskChs := make(map[Category]chan Task, len(categories))
+	for _, category := range categories {
+		taskChs[category] = make(chan Task, bufferSize)
+	}
+
+	return &categoryFairProcessorImpl{
+		processor:    processor,
+		logger:       logger,
+		metricsScope: metricsClient.Scope(metrics.TaskCategoryFairProcessorScope),
+		status:       common.DaemonStatusInitialized,
+		shutdownCh:   make(chan struct{}),
+		notifyCh:     make(chan struct{}, 1),
+		categories:   categories,
+		taskChs:      taskChs,
+		pendingTasks: make(map[Category]Task),
+	}
+}
+
+func (p *categoryFairProcessorImpl) Start() {
+	if !atomic.CompareAndSwapInt32(&p.status, common.DaemonStatusInitialized, common.DaemonStatusStarted) {
+		return
+	}
+
+	p.processor.Start()
+
+	p.shutdownWG.Add(1)
+	go p.dispatchLoop()
+
+	p.logger.Info("Category fair task processor started.")
+}
+
+func (p *categoryFairProcessorImpl) Stop() {
+	if !atomic.CompareAndSwapInt32(&p.status, common.DaemonStatusStarted, common.DaemonStatusStopped) {
+		return
+	}
+
+	close(p.shutdownCh)
+	// stop the underlying processor first so that the dispatcher won't be blocked on submitting tasks
+	p.processor.Stop()
+	p.shutdownWG.Wait()
+	p.nackBufferedTasks()
+
+	p.logger.Info("Category fair task processor stopped.")
+}
+
+func (p *categoryFairProcessorImpl) StopShardProcessor(
+	shard shard.Context,
+) {
+	p.processor.StopShardProcessor(shard)
+}
+
+func (p *categoryFairProcessorImpl) Submit(
+	task Task,
+) error {
+	taskCh, err := p.getTaskCh(task)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case taskCh <- task:
+		p.notifyDispatcher()
+		return nil
+	case <-p.shutdownCh:
+		return errTaskProcessorNotRunning
+	}
+}
+
+func (p *categoryFairProcessorImpl) TrySubmit(
+	task Task,
+) (bool, error) {
+	taskCh, err := p.getTaskCh(task)
+	if err != nil {
+		return false, err
+	}
+
+	select {
+	case taskCh <- task:
+		p.notifyDispatcher()
+		return true, nil
+	case <-p.shutdownCh:
+		return false, errTaskProcessorNotRunning
+	default:
+		return false, nil
+	}
+}
+
+func (p *categoryFairProcessorImpl) getTaskCh(
+	task Task,
+) (chan Task, error) {
+	if atomic.LoadInt32(&p.status) == common.DaemonStatusStopped {
+		return nil, errTaskProcessorNotRunning
+	}
+
+	category := task.GetTaskCategory()
+	taskCh, ok := p.taskChs[category]
+	if !ok {
+		return nil, fmt.Errorf("unknown task category: %v", category)
+	}
+	return taskCh, nil
+}
+
+func (p *categoryFairProcessorImpl) notifyDispatcher() {
+	select {
+	case p.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+// dispatchLoop takes at most one task from each category in turn and tries to submit it to
+// the underlying processor. If the processor is busy, the task is kept as the pending task of its
+// category and the next category is tried, so that one category can't block the others.
+// It blocks when all categories are empty or only have pending tasks
+func (p *categoryFairProcessorImpl) dispatchLoop() {
+	defer p.shutdownWG.Done()
+
+	for {
+		dispatched, hasPendingTasks := false, false
+		for _, category := range p.categories {
+			task, ok := p.nextTask(category)
+			if !ok {
+				continue
+			}
+
+			submitted, err := p.processor.TrySubmit(task)
+			if err != nil {
+				select {
+				case <-p.shutdownCh:
+					// keep the task so that it's nacked by Stop
+					p.pendingTasks[category] = task
+					return
+				default:
+				}
+				p.logger.Error("Failed to submit task to task processor", tag.Error(err))
+				p.metricsScope.IncCounter(metrics.TaskCategoryFairProcessorSubmitFailedCounter)
+				// nack the task so that it can be retried by the queue processor
+				task.Nack()
+			} else if !submitted {
+				p.pendingTasks[category] = task
+				hasPendingTasks = true
+				continue
+			}
+
+			delete(p.pendingTasks, category)
+			dispatched = true
+		}
+
+		if dispatched {
+			continue
+		}
+
+		var retryTimer *time.Timer
+		var retryCh <-chan time.Time
+		if hasPendingTasks {
+			retryTimer = time.NewTimer(categoryFairDispatchRetryInterval)
+			retryCh = retryTimer.C
+		}
+		select {
+		case <-p.notifyCh:
+		case <-retryCh:
+		case <-p.shutdownCh:
+			return
+		}
+		if retryTimer != nil {
+			retryTimer.Stop()
+		}
+	}
+}
+
+// nextTask returns the pending task of the category if there's one,
+// otherwise the next buffered task of the category
+func (p *categoryFairProcessorImpl) nextTask(
+	category Category,
+) (Task, bool) {
+	if task, ok := p.pendingTasks[category]; ok {
+		return task, true
+	}
+
+	select {
+	case task := <-p.taskChs[category]:
+		return task, true
+	default:
+		return nil, false
+	}
+}
+
+// nackBufferedTasks nacks all tasks not yet submitted to the underlying processor,
+// so that they are retried by the queue processor instead of being lost.
+// It must only be called after the dispatch loop exits
+func (p *categoryFairProcessorImpl) nackBufferedTasks() {
+	numNacked := 0
+	for _, category := range p.categories {
+		for {
+			task, ok := p.nextTask(category)
+			if !ok {
+				break
+			}
+			delete(p.pendingTasks, category)
+			task.Nack()
+			numNacked++
+		}
+	}
+
+	if numNacked != 0 {
+		p.logger.Info("Nacked buffered tasks on shutdown.", tag.Counter(numNacked))
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2020 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package task
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/uber-go/tally"
+
+	"github.com/uber/cadence/common/log/loggerimpl"
+	"github.com/uber/cadence/common/metrics"
+)
+
+type (
+	categoryFairProcessorSuite struct {
+		suite.Suite
+		*require.Assertions
+
+		controller    *gomock.Controller
+		mockProcessor *MockProcessor
+
+		processor *categoryFairProcessorImpl
+	}
+)
+
+func TestCategoryFairProcessorSuite(t *testing.T) {
+	s := new(categoryFairProcessorSuite)
+	suite.Run(t, s)
+}
+
+func (s *categoryFairProcessorSuite) SetupTest() {
+	s.Assertions = require.New(s.T())
+
+	s.controller = gomock.NewController(s.T())
+	s.mockProcessor = NewMockProcessor(s.controller)
+
+	s.processor = NewCategoryFairProcessor(
+		s.mockProcessor,
+		10,
+		loggerimpl.NewDevelopmentForTest(s.Suite),
+		metrics.NewClient(tally.NoopScope, metrics.History),
+	).(*categoryFairProcessorImpl)
+}
+
+func (s *categoryFairProcessorSuite) TearDownTest() {
+	s.controller.Finish()
+}
+
+func (s *categoryFairProcessorSuite) TestSubmit_InterleaveCategories() {
+	numTasksPerCategory := 3
+	submittedCh := make(chan Category, 2*numTasksPerCategory)
+	s.mockProcessor.EXPECT().Start().Times(1)
+	s.mockProcessor.EXPECT().Stop().Times(1)
+	s.mockProcessor.EXPECT().TrySubmit(gomock.Any()).DoAndReturn(func(task Task) (bool, error) {
+		submittedCh <- task.GetTaskCategory()
+		return true, nil
+	}).Times(2 * numTasksPerCategory)
+
+	// all transfer tasks are submitted before any timer task
+	for _, category := range []Category{CategoryTransfer, CategoryTimer} {
+		for i := 0; i != numTasksPerCategory; i++ {
+			mockTask := NewMockTask(s.controller)
+			mockTask.EXPECT().GetTaskCategory().Return(category).AnyTimes()
+			submitted, err := s.processor.TrySubmit(mockTask)
+			s.NoError(err)
+			s.True(submitted)
+		}
+	}
+
+	s.processor.Start()
+	defer s.processor.Stop()
+
+	var submittedCategories []Category
+	for len(submittedCategories) != 2*numTasksPerCategory {
+		select {
+		case category := <-submittedCh:
+			submittedCategories = append(submittedCategories, category)
+		case <-time.After(time.Second):
+			s.FailNow("timed out waiting for tasks to be submitted")
+		}
+	}
+	s.Equal([]Category{
+		CategoryTransfer, CategoryTimer,
+		CategoryTransfer, CategoryTimer,
+		CategoryTransfer, CategoryTimer,
+	}, submittedCategories)
+}
+
+func (s *categoryFairProcessorSuite) TestTrySubmit_BufferFull() {
+	for i := 0; i != 10; i++ {
+		mockTask := NewMockTask(s.controller)
+		mockTask.EXPECT().GetTaskCategory().Return(CategoryTimer).AnyTimes()
+		submitted, err := s.processor.TrySubmit(mockTask)
+		s.NoError(err)
+		s.True(submitted)
+	}
+
+	timerTask := NewMockTask(s.controller)
+	timerTask.EXPECT().GetTaskCategory().Return(CategoryTimer).AnyTimes()
+	submitted, err := s.processor.TrySubmit(timerTask)
+	s.NoError(err)
+	s.False(submitted)
+
+	// buffer of other categories is not affected
+	transferTask := NewMockTask(s.controller)
+	transferTask.EXPECT().GetTaskCategory().Return(CategoryTransfer).AnyTimes()
+	submitted, err = s.processor.TrySubmit(transferTask)
+	s.NoError(err)
+	s.True(submitted)
+}
+
+func (s *categoryFairProcessorSuite) TestDispatch_CategoryBusy() {
+	numTransferTasks := 3
+	submittedCh := make(chan Task, numTransferTasks)
+	s.mockProcessor.EXPECT().Start().Times(1)
+	s.mockProcessor.EXPECT().Stop().Times(1)
+	s.mockProcessor.EXPECT().TrySubmit(gomock.Any()).DoAndReturn(func(task Task) (bool, error) {
+		if task.GetTaskCategory() == CategoryTimer {
+			// the underlying processor is busy processing timer tasks
+			return false, nil
+		}
+		submittedCh <- task
+		return true, nil
+	}).AnyTimes()
+
+	// timer tasks are not submitted and nacked when the processor is stopped
+	for i := 0; i != 2; i++ {
+		timerTask := NewMockTask(s.controller)
+		timerTask.EXPECT().GetTaskCategory().Return(CategoryTimer).AnyTimes()
+		timerTask.EXPECT().Nack().Times(1)
+		submitted, err := s.processor.TrySubmit(timerTask)
+		s.NoError(err)
+		s.True(submitted)
+	}
+
+	s.processor.Start()
+
+	// tasks of other categories are still submitted while the timer task is rejected
+	for i := 0; i != numTransferTasks; i++ {
+		transferTask := NewMockTask(s.controller)
+		transferTask.EXPECT().GetTaskCategory().Return(CategoryTransfer).AnyTimes()
+		s.NoError(s.processor.Submit(transferTask))
+
+		select {
+		case submittedTask := <-submittedCh:
+			s.True(submittedTask == transferTask)
+		case <-time.After(time.Second):
+			s.FailNow("timed out waiting for transfer task to be submitted")
+		}
+	}
+
+	s.processor.Stop()
+	s.Empty(s.processor.pendingTasks)
+	for _, taskCh := range s.processor.taskChs {
+		s.Empty(taskCh)
+	}
+}
@@ -47,6 +47,7 @@ type (
 		task.PriorityTask
 		Info
 		GetQueueType() QueueType
+		GetTaskCategory() Category
 		GetShard() shard.Context
 		GetAttempt() int
 	}
@@ -85,12 +86,24 @@ type (
 	Redispatcher interface {
 		common.Daemon
 		AddTask(Task)
-		Redispatch(targetSize int)
+		Redispatch(targetSize int) *RedispatchResult
+		// RedispatchMatched is the same as Redispatch, but only tasks
+		// matched by the filter will be redispatched
+		RedispatchMatched(targetSize int, filter MatchFn) *RedispatchResult
 		Size() int
+		// SizeByDomainID returns the number of queued tasks of the domain,
+		// it's always 0 unless per domain size tracking is enabled
+		SizeByDomainID(domainID string) int
+		// Snapshot returns all tasks in the redispatch queue without removing them
+		Snapshot() []Task
 	}
 
 	// QueueType is the type of task queue
 	QueueType int
+
+	// Category is the category of a task. Tasks of different
+	// categories are identified by different types of task keys
+	Category int
 )
 
 const (
@@ -105,3 +118,26 @@ const (
 	// QueueTypeReplication is the queue type for replication queue processor
 	QueueTypeReplication
 )
+
+const (
+	// CategoryTransfer is the category for transfer tasks
+	CategoryTransfer Category = iota + 1
+	// CategoryTimer is the category for timer tasks
+	CategoryTimer
+	// CategoryReplication is the category for replication tasks
+	CategoryReplication
+)
+
+// Category returns the category of tasks in the queue
+func (q QueueType) Category() Category {
+	switch q {
+	case QueueTypeActiveTransfer, QueueTypeStandbyTransfer:
+		return CategoryTransfer
+	case QueueTypeActiveTimer, QueueTypeStandbyTimer:
+		return CategoryTimer
+	case QueueTypeReplication:
+		return CategoryReplication
+	default:
+		return 0
+	}
+}
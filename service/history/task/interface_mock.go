@@ -398,6 +398,20 @@ func (mr *MockTaskMockRecorder) GetQueueType() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetQueueType", reflect.TypeOf((*MockTask)(nil).GetQueueType))
 }
 
+// GetTaskCategory mocks base method
+func (m *MockTask) GetTaskCategory() Category {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetTaskCategory")
+	ret0, _ := ret[0].(Category)
+	return ret0
+}
+
+// GetTaskCategory indicates an expected call of GetTaskCategory
+func (mr *MockTaskMockRecorder) GetTaskCategory() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTaskCategory", reflect.TypeOf((*MockTask)(nil).GetTaskCategory))
+}
+
 // GetShard mocks base method
 func (m *MockTask) GetShard() shard.Context {
 	m.ctrl.T.Helper()
@@ -685,9 +699,11 @@ func (mr *MockRedispatcherMockRecorder) AddTask(arg0 interface{}) *gomock.Call {
 }
 
 // Redispatch mocks base method
-func (m *MockRedispatcher) Redispatch(targetSize int) {
+func (m *MockRedispatcher) Redispatch(targetSize int) *RedispatchResult {
 	m.ctrl.T.Helper()
-	m.ctrl.Call(m, "Redispatch", targetSize)
+	ret := m.ctrl.Call(m, "Redispatch", targetSize)
+	ret0, _ := ret[0].(*RedispatchResult)
+	return ret0
 }
 
 // Redispatch indicates an expected call of Redispatch
@@ -696,6 +712,48 @@ func (mr *MockRedispatcherMockRecorder) Redispatch(targetSize interface{}) *gomo
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Redispatch", reflect.TypeOf((*MockRedispatcher)(nil).Redispatch), targetSize)
 }
 
+// RedispatchMatched mocks base method
+func (m *MockRedispatcher) RedispatchMatched(targetSize int, filter MatchFn) *RedispatchResult {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RedispatchMatched", targetSize, filter)
+	ret0, _ := ret[0].(*RedispatchResult)
+	return ret0
+}
+
+// RedispatchMatched indicates an expected call of RedispatchMatched
+func (mr *MockRedispatcherMockRecorder) RedispatchMatched(targetSize, filter interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RedispatchMatched", reflect.TypeOf((*MockRedispatcher)(nil).RedispatchMatched), targetSize, filter)
+}
+
+// SizeByDomainID mocks base method
+func (m *MockRedispatcher) SizeByDomainID(domainID string) int {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SizeByDomainID", domainID)
+	ret0, _ := ret[0].(int)
+	return ret0
+}
+
+// SizeByDomainID indicates an expected call of SizeByDomainID
+func (mr *MockRedispatcherMockRecorder) SizeByDomainID(domainID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SizeByDomainID", reflect.TypeOf((*MockRedispatcher)(nil).SizeByDomainID), domainID)
+}
+
+// Snapshot mocks base method
+func (m *MockRedispatcher) Snapshot() []Task {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Snapshot")
+	ret0, _ := ret[0].([]Task)
+	return ret0
+}
+
+// Snapshot indicates an expected call of Snapshot
+func (mr *MockRedispatcherMockRecorder) Snapshot() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Snapshot", reflect.TypeOf((*MockRedispatcher)(nil).Snapshot))
+}
+
 // Size mocks base method
 func (m *MockRedispatcher) Size() int {
 	m.ctrl.T.Helper()
@@ -21,12 +21,16 @@
 package task
 
 import (
+	"math"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/uber/cadence/common"
 	"github.com/uber/cadence/common/backoff"
+	"github.com/uber/cadence/common/clock"
+	"github.com/uber/cadence/common/collection"
 	"github.com/uber/cadence/common/log"
 	"github.com/uber/cadence/common/log/tag"
 	"github.com/uber/cadence/common/metrics"
@@ -35,46 +39,165 @@ import (
 
 const (
 	defaultBufferSize = 200
+
+	// adaptive redispatch batch size is doubled if the submit success ratio of
+	// a redispatch pass is at least adaptiveBatchGrowRatio, and halved if the
+	// ratio is below adaptiveBatchShrinkRatio
+	adaptiveBatchGrowRatio   = 0.9
+	adaptiveBatchShrinkRatio = 0.5
+)
+
+const (
+	// SubmitActionSubmitted means the task is submitted and removed from the redispatch queue
+	SubmitActionSubmitted SubmitAction = iota + 1
+	// SubmitActionRequeue means the task is added back to the redispatch queue
+	SubmitActionRequeue
+	// SubmitActionDrop means the task is acked and removed from the redispatch queue
+	SubmitActionDrop
 )
 
 type (
 	redispatchNotification struct {
 		targetSize int
 		doneCh     chan struct{}
+		// filter is optional, only tasks matched by the filter will be redispatched
+		filter MatchFn
+		// result is optional, submit stats will be recorded if not nil
+		result *RedispatchResult
+	}
+
+	// RedispatchResult contains the outcome of a redispatch pass
+	RedispatchResult struct {
+		// SubmitStatsByDomainID maps domainID to the number of
+		// tasks submitted or rejected during the redispatch pass
+		SubmitStatsByDomainID map[string]*RedispatchSubmitStats
+	}
+
+	// RedispatchSubmitStats contains the number of tasks submitted and
+	// rejected by the task processor for a domain
+	RedispatchSubmitStats struct {
+		Submitted int
+		Rejected  int
 	}
 
+	// TransformFn transforms a task before it's redispatched,
+	// returning false means the task should be dropped
+	TransformFn func(Task) (Task, bool)
+
+	// MatchFn returns true if the task should be included in a redispatch pass
+	MatchFn func(Task) bool
+
+	// SubmitAction is the action taken for a task after trying to resubmit it
+	SubmitAction int
+
+	// SubmitResultClassifierFn decides the action for a task
+	// based on the result of resubmitting it to the task processor
+	SubmitResultClassifierFn func(task Task, submitted bool, err error) SubmitAction
+
 	// RedispatcherOptions configs redispatch interval
 	RedispatcherOptions struct {
 		TaskRedispatchInterval                  dynamicconfig.DurationPropertyFn
 		TaskRedispatchIntervalJitterCoefficient dynamicconfig.FloatPropertyFn
+		// TaskTransform is optional and applied to each task before resubmitting it. It's always
+		// applied to the task originally added, as transformed tasks are not kept in the queue.
+		// Dropped tasks are acked so that they won't block the queue ack level.
+		TaskTransform TransformFn
+		// TaskPaused is optional, tasks for which it returns true
+		// are kept in the redispatch queue without being resubmitted.
+		TaskPaused func(Task) bool
+		// TaskShouldSubmit is optional and consulted right before resubmitting a task, tasks for
+		// which it returns false are held in the redispatch queue and retried in a later pass.
+		// Unlike TaskPaused, it's meant for holding individual tasks, e.g. until a dependency is ready.
+		TaskShouldSubmit func(Task) bool
+		// TaskRedispatchBatchSizeByDomainID is optional, it limits the number of tasks
+		// of a domain resubmitted in one redispatch pass. A non-positive value means no limit.
+		TaskRedispatchBatchSizeByDomainID dynamicconfig.IntPropertyFnWithDomainIDFilter
+		// TaskSubmitResultClassifier is optional, it decides whether a task is submitted, requeued
+		// or dropped after being resubmitted. By default a task is requeued if it's not submitted.
+		TaskSubmitResultClassifier SubmitResultClassifierFn
+		// TaskRedispatchMinBatchSize and TaskRedispatchMaxBatchSize are optional. When both are
+		// specified and the max batch size is positive, the number of tasks resubmitted in one
+		// redispatch pass adapts to the submit success ratio of previous passes within the bounds.
+		TaskRedispatchMinBatchSize dynamicconfig.IntPropertyFn
+		TaskRedispatchMaxBatchSize dynamicconfig.IntPropertyFn
+		// TaskRequeueDelay and TaskRequeueMaxDelay are optional. When the requeue delay is positive,
+		// a requeued task won't be resubmitted until the delay elapses. The delay doubles for each
+		// consecutive requeue of the task and is capped by the max delay if it's positive.
+		TaskRequeueDelay    dynamicconfig.DurationPropertyFn
+		TaskRequeueMaxDelay dynamicconfig.DurationPropertyFn
+		// TaskRedispatchRateFactor is optional. When it returns a value in (0, 1), the number of tasks
+		// resubmitted in one redispatch pass is scaled down by the factor, and at least one task is resubmitted.
+		TaskRedispatchRateFactor func() float64
+		// TaskSubmitAgeEnabled is optional. When it returns true, the age of each resubmitted task,
+		// i.e. the time since its visibility timestamp, is recorded tagged by domain and task type.
+		// For transfer tasks that's the time since the task is created and for timer tasks the time
+		// since the timer fires. It's disabled by default as the metric is tagged by domain.
+		TaskSubmitAgeEnabled dynamicconfig.BoolPropertyFn
+		// TaskRedispatched is optional and invoked with the action taken for each task resubmitted
+		// during a redispatch pass. It's invoked while the redispatcher lock is held and should not block.
+		TaskRedispatched func(task Task, action SubmitAction)
+		// TaskSizeByDomainIDTracked enables tracking the number of queued tasks of each domain,
+		// which is required by SizeByDomainID. It's disabled by default as it requires looking
+		// up the domainID of each task added to the redispatcher.
+		TaskSizeByDomainIDTracked bool
+		// TaskQueueRingBufferCapacity is optional. When it's positive, the redispatch queue of each
+		// priority is a ring buffer preallocated with the capacity, see collection.NewRingBufferQueue.
+		// Slots are reused as tasks are requeued, which reduces allocations during redispatch storms
+		// at the cost of memory held for the capacity of each priority. By default a queue backed by
+		// a slice is used, which only holds memory for queued tasks.
+		TaskQueueRingBufferCapacity int
+	}
+
+	// redispatchTask records when a task is added to the redispatcher
+	// and how many times it has been requeued since then
+	redispatchTask struct {
+		task        Task
+		enqueueTime time.Time
+		numRequeues int
 	}
 
 	redispatcherImpl struct {
 		sync.Mutex
 
 		taskProcessor Processor
+		timeSource    clock.TimeSource
 		options       *RedispatcherOptions
 		logger        log.Logger
 		metricsScope  metrics.Scope
+		// submitScopes are tagged by whether the task is submitted and created
+		// once, so that timing each submit doesn't allocate a new scope
+		submitScopes map[bool]metrics.Scope
 
 		status          int32
 		shutdownCh      chan struct{}
 		shutdownWG      sync.WaitGroup
 		redispatchCh    chan redispatchNotification
 		redispatchTimer *time.Timer
-		taskQueues      map[int][]Task // priority -> redispatch queue
+		taskQueues      map[int]collection.Queue // priority -> redispatch queue of redispatchTask
+		// delayedTasks contains requeued tasks waiting for their requeue delay,
+		// they are moved back to taskQueues when the delay elapses
+		delayedTasks collection.DelayedQueue
+
+		// batchSize is the current adaptive redispatch batch size,
+		// 0 means it hasn't been initialized
+		batchSize int
+		// sizeByDomainID is the number of queued tasks of each domain,
+		// only tracked when TaskSizeByDomainIDTracked is set
+		sizeByDomainID map[string]int
 	}
 )
 
 // NewRedispatcher creates a new task Redispatcher
 func NewRedispatcher(
 	taskProcessor Processor,
+	timeSource clock.TimeSource,
 	options *RedispatcherOptions,
 	logger log.Logger,
 	metricsScope metrics.Scope,
 ) Redispatcher {
 	return &redispatcherImpl{
 		taskProcessor:   taskProcessor,
+		timeSource:      timeSource,
 		options:         options,
 		logger:          logger,
 		metricsScope:    metricsScope,
@@ -82,7 +205,13 @@ func NewRedispatcher(
 		shutdownCh:      make(chan struct{}),
 		redispatchCh:    make(chan redispatchNotification, 1),
 		redispatchTimer: nil,
-		taskQueues:      make(map[int][]Task),
+		taskQueues:      make(map[int]collection.Queue),
+		delayedTasks:    collection.NewDelayedQueue(timeSource),
+		sizeByDomainID:  make(map[string]int),
+		submitScopes: map[bool]metrics.Scope{
+			true:  metricsScope.Tagged(metrics.SubmitResultTag(true)),
+			false: metricsScope.Tagged(metrics.SubmitResultTag(false)),
+		},
 	}
 }
 
@@ -121,28 +250,48 @@ func (r *redispatcherImpl) Stop() {
 func (r *redispatcherImpl) AddTask(
 	task Task,
 ) {
+	if task == nil {
+		// caller bug, reject the task here instead of crashing the redispatch loop later
+		r.logger.Warn("Ignoring nil task added to redispatcher")
+		r.metricsScope.IncCounter(metrics.TaskRedispatchNilTaskCounter)
+		return
+	}
+
 	r.Lock()
 	defer r.Unlock()
 
-	priority := task.Priority()
-	queue, ok := r.taskQueues[priority]
-	if !ok {
-		queue = make([]Task, 0)
+	r.getTaskQueueLocked(task.Priority()).Add(redispatchTask{
+		task:        task,
+		enqueueTime: r.timeSource.Now(),
+	})
+	if r.options.TaskSizeByDomainIDTracked {
+		r.sizeByDomainID[task.GetDomainID()]++
 	}
-	r.taskQueues[priority] = append(queue, task)
 
 	r.setupTimerLocked()
 }
 
 func (r *redispatcherImpl) Redispatch(
 	targetSize int,
-) {
+) *RedispatchResult {
+	return r.RedispatchMatched(targetSize, nil)
+}
+
+func (r *redispatcherImpl) RedispatchMatched(
+	targetSize int,
+	filter MatchFn,
+) *RedispatchResult {
 	doneCh := make(chan struct{})
+	result := &RedispatchResult{
+		SubmitStatsByDomainID: make(map[string]*RedispatchSubmitStats),
+	}
 
 	select {
 	case r.redispatchCh <- redispatchNotification{
 		targetSize: targetSize,
 		doneCh:     doneCh,
+		filter:     filter,
+		result:     result,
 	}:
 	case <-r.shutdownCh:
 		close(doneCh)
@@ -150,6 +299,7 @@ func (r *redispatcherImpl) Redispatch(
 
 	// block until the redispatch is done
 	<-doneCh
+	return result
 }
 
 func (r *redispatcherImpl) Size() int {
@@ -159,6 +309,39 @@ func (r *redispatcherImpl) Size() int {
 	return r.sizeLocked()
 }
 
+func (r *redispatcherImpl) SizeByDomainID(
+	domainID string,
+) int {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.sizeByDomainID[domainID]
+}
+
+func (r *redispatcherImpl) Snapshot() []Task {
+	r.Lock()
+	defer r.Unlock()
+
+	priorities := make([]int, 0, len(r.taskQueues))
+	for priority := range r.taskQueues {
+		priorities = append(priorities, priority)
+	}
+	sort.Ints(priorities)
+
+	tasks := make([]Task, 0, r.sizeLocked())
+	for _, priority := range priorities {
+		for _, item := range r.taskQueues[priority].Snapshot() {
+			if queuedTask := item.(redispatchTask); queuedTask.task != nil {
+				tasks = append(tasks, queuedTask.task)
+			}
+		}
+	}
+	for _, item := range r.delayedTasks.Snapshot() {
+		tasks = append(tasks, item.(redispatchTask).task)
+	}
+	return tasks
+}
+
 func (r *redispatcherImpl) redispatchLoop() {
 	defer r.shutdownWG.Done()
 
@@ -179,19 +362,21 @@ func (r *redispatcherImpl) redispatchTasks(
 	defer r.Unlock()
 
 	defer func() {
-		if notification.doneCh != nil {
-			close(notification.doneCh)
-		}
 		if r.sizeLocked() > 0 {
 			// there are still tasks left in the queue, setup a redispatch timer for those tasks
 			r.setupTimerLocked()
 		}
+		r.emitOldestTaskAgeLocked()
+		if notification.doneCh != nil {
+			close(notification.doneCh)
+		}
 	}()
 
 	if r.isStopped() {
 		return
 	}
 
+	r.moveReadyDelayedTasksLocked()
 	queueSize := r.sizeLocked()
 	r.metricsScope.RecordTimer(metrics.TaskRedispatchQueuePendingTasksTimer, time.Duration(queueSize))
 
@@ -201,20 +386,73 @@ func (r *redispatcherImpl) redispatchTasks(
 		// target size has already been met, no need to redispatch
 		return
 	}
+	batchSize, adaptiveBatchSize := r.getBatchSizeLocked()
+	if adaptiveBatchSize {
+		targetRedispatched = common.MinInt(targetRedispatched, batchSize)
+	}
+	if r.options.TaskRedispatchRateFactor != nil {
+		if factor := r.options.TaskRedispatchRateFactor(); factor > 0 && factor < 1 {
+			targetRedispatched = common.MaxInt(1, int(math.Ceil(float64(targetRedispatched)*factor)))
+		}
+	}
+	numSubmitted, numRejected := 0, 0
+
+	// number of tasks submitted for each domain in this pass,
+	// only tracked when there's a per domain batch size limit
+	var submittedByDomainID map[string]int
+	if r.options.TaskRedispatchBatchSizeByDomainID != nil {
+		submittedByDomainID = make(map[string]int)
+	}
 
 	totalRedispatched := 0
-	for priority, queue := range r.taskQueues {
-		queueLen := len(queue)
+	for _, queue := range r.taskQueues {
+		queueLen := queue.Len()
 		for i := 0; i != queueLen; i++ {
 			if totalRedispatched >= targetRedispatched {
 				break
 			}
 
-			task := queue[0]
-			queue[0] = nil
-			queue = queue[1:]
+			queuedTask := queue.Remove().(redispatchTask)
+			task := queuedTask.task
+			if task == nil {
+				r.logger.Warn("Dropping nil task from redispatch queue")
+				r.metricsScope.IncCounter(metrics.TaskRedispatchNilTaskCounter)
+				continue
+			}
+
+			if (notification.filter != nil && !notification.filter(task)) ||
+				(r.options.TaskPaused != nil && r.options.TaskPaused(task)) {
+				queue.Add(queuedTask)
+				continue
+			}
 
-			submitted, err := r.taskProcessor.TrySubmit(task)
+			if submittedByDomainID != nil {
+				batchSize := r.options.TaskRedispatchBatchSizeByDomainID(task.GetDomainID())
+				if batchSize > 0 && submittedByDomainID[task.GetDomainID()] >= batchSize {
+					queue.Add(queuedTask)
+					continue
+				}
+			}
+
+			if r.options.TaskTransform != nil {
+				transformedTask, keep := r.options.TaskTransform(task)
+				if !keep {
+					task.Ack()
+					r.removeTaskSizeLocked(task)
+					totalRedispatched++
+					continue
+				}
+				task = transformedTask
+			}
+
+			if r.options.TaskShouldSubmit != nil && !r.options.TaskShouldSubmit(task) {
+				// keep the original task so that it's transformed again when retried
+				r.metricsScope.IncCounter(metrics.TaskRedispatchHeldCounter)
+				queue.Add(queuedTask)
+				continue
+			}
+
+			submitted, err := r.trySubmit(task)
 			if err != nil {
 				if r.isStopped() {
 					// if error is due to shard shutdown
@@ -226,9 +464,34 @@ func (r *redispatcherImpl) redispatchTasks(
 				}
 			}
 
-			if err != nil || !submitted {
-				// failed to submit, enqueue again
-				queue = append(queue, task)
+			action := r.classifySubmitResult(task, submitted, err)
+			switch action {
+			case SubmitActionSubmitted:
+				numSubmitted++
+				r.emitTaskSubmitAge(task)
+				r.removeTaskSizeLocked(queuedTask.task)
+			case SubmitActionRequeue:
+				// failed to submit, enqueue again with the original enqueue time, the original
+				// task is kept so that it's transformed again from scratch when retried
+				queuedTask.numRequeues++
+				if delay := r.getRequeueDelay(queuedTask.numRequeues); delay > 0 {
+					r.delayedTasks.Add(queuedTask, r.timeSource.Now().Add(delay))
+				} else {
+					queue.Add(queuedTask)
+				}
+				numRejected++
+			case SubmitActionDrop:
+				task.Ack()
+				r.removeTaskSizeLocked(queuedTask.task)
+			}
+			if r.options.TaskRedispatched != nil {
+				r.options.TaskRedispatched(task, action)
+			}
+			if submittedByDomainID != nil && action == SubmitActionSubmitted {
+				submittedByDomainID[task.GetDomainID()]++
+			}
+			if notification.result != nil {
+				notification.result.recordSubmit(task.GetDomainID(), action == SubmitActionSubmitted)
 			}
 
 			if err == nil && !submitted {
@@ -239,12 +502,159 @@ func (r *redispatcherImpl) redispatchTasks(
 			totalRedispatched++
 		}
 
-		r.taskQueues[priority] = queue
-
 		if r.isStopped() {
 			return
 		}
 	}
+
+	if adaptiveBatchSize {
+		r.adjustBatchSizeLocked(numSubmitted, numRejected)
+	}
+}
+
+// getBatchSizeLocked returns the adaptive redispatch batch size, false is returned
+// if adaptive batch size is not enabled. The batch size starts at the min batch size
+func (r *redispatcherImpl) getBatchSizeLocked() (int, bool) {
+	minBatchSize, maxBatchSize, ok := r.getBatchSizeBounds()
+	if !ok {
+		return 0, false
+	}
+
+	if r.batchSize == 0 {
+		r.batchSize = minBatchSize
+	}
+	// bounds may have changed since the last redispatch pass
+	r.batchSize = common.MaxInt(minBatchSize, common.MinInt(r.batchSize, maxBatchSize))
+	return r.batchSize, true
+}
+
+// adjustBatchSizeLocked grows or shrinks the adaptive redispatch batch size
+// within the bounds based on the submit success ratio of the last redispatch pass
+func (r *redispatcherImpl) adjustBatchSizeLocked(
+	numSubmitted int,
+	numRejected int,
+) {
+	minBatchSize, maxBatchSize, ok := r.getBatchSizeBounds()
+	numAttempted := numSubmitted + numRejected
+	if !ok || numAttempted == 0 {
+		return
+	}
+
+	successRatio := float64(numSubmitted) / float64(numAttempted)
+	switch {
+	case successRatio >= adaptiveBatchGrowRatio:
+		r.batchSize = common.MinInt(r.batchSize*2, maxBatchSize)
+	case successRatio < adaptiveBatchShrinkRatio:
+		r.batchSize = common.MaxInt(r.batchSize/2, minBatchSize)
+	}
+}
+
+func (r *redispatcherImpl) getBatchSizeBounds() (int, int, bool) {
+	if r.options.TaskRedispatchMinBatchSize == nil || r.options.TaskRedispatchMaxBatchSize == nil {
+		return 0, 0, false
+	}
+	maxBatchSize := r.options.TaskRedispatchMaxBatchSize()
+	if maxBatchSize <= 0 {
+		return 0, 0, false
+	}
+	minBatchSize := common.MaxInt(1, common.MinInt(r.options.TaskRedispatchMinBatchSize(), maxBatchSize))
+	return minBatchSize, maxBatchSize, true
+}
+
+// trySubmit submits the task to the task processor and records the submit latency
+// tagged by whether the task is submitted
+func (r *redispatcherImpl) trySubmit(
+	task Task,
+) (bool, error) {
+	startTime := time.Now()
+	submitted, err := r.taskProcessor.TrySubmit(task)
+	r.submitScopes[err == nil && submitted].RecordHistogramDuration(metrics.TaskRedispatchSubmitLatency, time.Since(startTime))
+	return submitted, err
+}
+
+// moveReadyDelayedTasksLocked moves requeued tasks whose requeue
+// delay has elapsed back to the redispatch queues
+func (r *redispatcherImpl) moveReadyDelayedTasksLocked() {
+	for item := r.delayedTasks.Remove(); item != nil; item = r.delayedTasks.Remove() {
+		queuedTask := item.(redispatchTask)
+		r.getTaskQueueLocked(queuedTask.task.Priority()).Add(queuedTask)
+	}
+}
+
+// getTaskQueueLocked returns the redispatch queue of the priority, creating it if it doesn't exist
+func (r *redispatcherImpl) getTaskQueueLocked(
+	priority int,
+) collection.Queue {
+	queue, ok := r.taskQueues[priority]
+	if !ok {
+		if r.options.TaskQueueRingBufferCapacity > 0 {
+			queue = collection.NewRingBufferQueue(r.options.TaskQueueRingBufferCapacity)
+		} else {
+			queue = collection.NewConcurrentQueue()
+		}
+		r.taskQueues[priority] = queue
+	}
+	return queue
+}
+
+// getRequeueDelay returns how long a task should wait before being resubmitted
+// after its numRequeues-th requeue, 0 means the task can be resubmitted immediately
+func (r *redispatcherImpl) getRequeueDelay(
+	numRequeues int,
+) time.Duration {
+	if r.options.TaskRequeueDelay == nil {
+		return 0
+	}
+	delay := r.options.TaskRequeueDelay()
+	if delay <= 0 {
+		return 0
+	}
+
+	var maxDelay time.Duration
+	if r.options.TaskRequeueMaxDelay != nil {
+		maxDelay = r.options.TaskRequeueMaxDelay()
+	}
+	for i := 1; i < numRequeues && delay < math.MaxInt64/2; i++ {
+		if maxDelay > 0 && delay >= maxDelay {
+			break
+		}
+		delay *= 2
+	}
+	if maxDelay > 0 && delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
+
+func (r *redispatcherImpl) classifySubmitResult(
+	task Task,
+	submitted bool,
+	err error,
+) SubmitAction {
+	if r.options.TaskSubmitResultClassifier != nil {
+		return r.options.TaskSubmitResultClassifier(task, submitted, err)
+	}
+
+	if err != nil || !submitted {
+		return SubmitActionRequeue
+	}
+	return SubmitActionSubmitted
+}
+
+func (r *RedispatchResult) recordSubmit(
+	domainID string,
+	submitted bool,
+) {
+	stats, ok := r.SubmitStatsByDomainID[domainID]
+	if !ok {
+		stats = &RedispatchSubmitStats{}
+		r.SubmitStatsByDomainID[domainID] = stats
+	}
+	if submitted {
+		stats.Submitted++
+	} else {
+		stats.Rejected++
+	}
 }
 
 func (r *redispatcherImpl) setupTimerLocked() {
@@ -271,15 +681,71 @@ func (r *redispatcherImpl) setupTimerLocked() {
 	}
 }
 
+// emitOldestTaskAgeLocked emits how long the oldest task has been
+// waiting in the redispatch queue, 0 is emitted if the queue is empty
+func (r *redispatcherImpl) emitOldestTaskAgeLocked() {
+	var oldestEnqueueTime time.Time
+	updateOldestEnqueueTime := func(queuedTask redispatchTask) {
+		if oldestEnqueueTime.IsZero() || queuedTask.enqueueTime.Before(oldestEnqueueTime) {
+			oldestEnqueueTime = queuedTask.enqueueTime
+		}
+	}
+	for _, queue := range r.taskQueues {
+		for _, item := range queue.Snapshot() {
+			updateOldestEnqueueTime(item.(redispatchTask))
+		}
+	}
+	for _, item := range r.delayedTasks.Snapshot() {
+		updateOldestEnqueueTime(item.(redispatchTask))
+	}
+
+	var age time.Duration
+	if !oldestEnqueueTime.IsZero() {
+		age = r.timeSource.Now().Sub(oldestEnqueueTime)
+	}
+	r.metricsScope.UpdateGauge(metrics.TaskRedispatchQueueOldestTaskAgeGauge, age.Seconds())
+}
+
+// emitTaskSubmitAge records how long the task has waited since its
+// visibility timestamp when it's resubmitted, if enabled
+func (r *redispatcherImpl) emitTaskSubmitAge(
+	task Task,
+) {
+	if r.options.TaskSubmitAgeEnabled == nil || !r.options.TaskSubmitAgeEnabled() {
+		return
+	}
+
+	r.metricsScope.Tagged(
+		metrics.DomainTag(task.GetDomainID()),
+		metrics.TaskTypeTag(task.GetTaskType()),
+	).RecordHistogramDuration(metrics.TaskRedispatchSubmitAge, r.timeSource.Now().Sub(task.GetVisibilityTimestamp()))
+}
+
 func (r *redispatcherImpl) sizeLocked() int {
-	size := 0
+	size := r.delayedTasks.Size()
 	for _, queue := range r.taskQueues {
-		size += len(queue)
+		size += queue.Len()
 	}
 
 	return size
 }
 
+// removeTaskSizeLocked updates the per domain size after the task leaves the queue
+func (r *redispatcherImpl) removeTaskSizeLocked(
+	task Task,
+) {
+	if !r.options.TaskSizeByDomainIDTracked {
+		return
+	}
+
+	domainID := task.GetDomainID()
+	if r.sizeByDomainID[domainID] <= 1 {
+		delete(r.sizeByDomainID, domainID)
+	} else {
+		r.sizeByDomainID[domainID]--
+	}
+}
+
 func (r *redispatcherImpl) isStopped() bool {
 	return atomic.LoadInt32(&r.status) == common.DaemonStatusStopped
 }
@@ -31,6 +31,7 @@ import (
 	"github.com/stretchr/testify/suite"
 	"github.com/uber-go/tally"
 
+	"github.com/uber/cadence/common/clock"
 	"github.com/uber/cadence/common/log"
 	"github.com/uber/cadence/common/log/loggerimpl"
 	"github.com/uber/cadence/common/metrics"
@@ -111,6 +112,7 @@ func (s *redispatcherSuite) TestRedispatch_WithTargetSize() {
 	for i := 0; i != numTasks; i++ {
 		mockTask := NewMockTask(s.controller)
 		mockTask.EXPECT().Priority().Return(rand.Intn(5)).AnyTimes()
+		mockTask.EXPECT().GetDomainID().Return("testDomainID").AnyTimes()
 		s.redispatcher.AddTask(mockTask)
 		s.mockProcessor.EXPECT().TrySubmit(gomock.Any()).Return(true, nil).MaxTimes(1)
 	}
@@ -129,6 +131,7 @@ func (s *redispatcherSuite) TestRedispatch_Random() {
 	for i := 0; i != numTasks; i++ {
 		mockTask := NewMockTask(s.controller)
 		mockTask.EXPECT().Priority().Return(rand.Intn(5)).AnyTimes()
+		mockTask.EXPECT().GetDomainID().Return("testDomainID").AnyTimes()
 		s.redispatcher.AddTask(mockTask)
 		submitted := false
 		if rand.Intn(2) == 0 {
@@ -144,9 +147,626 @@ func (s *redispatcherSuite) TestRedispatch_Random() {
 	s.True(s.redispatcher.Size() >= numTasks-dispatched)
 }
 
+func (s *redispatcherSuite) TestRedispatchMatched() {
+	numTasks := 10
+	matchedTasks := make(map[Task]struct{})
+
+	for i := 0; i != numTasks; i++ {
+		mockTask := NewMockTask(s.controller)
+		mockTask.EXPECT().Priority().Return(rand.Intn(5)).AnyTimes()
+		mockTask.EXPECT().GetDomainID().Return("testDomainID").AnyTimes()
+		s.redispatcher.AddTask(mockTask)
+		if i%2 == 0 {
+			matchedTasks[mockTask] = struct{}{}
+			s.mockProcessor.EXPECT().TrySubmit(NewMockTaskMatcher(mockTask)).Return(true, nil).Times(1)
+		} else {
+			// unmatched tasks may be submitted by the periodic redispatch after the test
+			s.mockProcessor.EXPECT().TrySubmit(NewMockTaskMatcher(mockTask)).Return(false, nil).AnyTimes()
+		}
+	}
+
+	result := s.redispatcher.RedispatchMatched(0, func(task Task) bool {
+		_, ok := matchedTasks[task]
+		return ok
+	})
+
+	s.Equal(numTasks/2, result.SubmitStatsByDomainID["testDomainID"].Submitted)
+	s.Equal(0, result.SubmitStatsByDomainID["testDomainID"].Rejected)
+	s.Equal(numTasks-len(matchedTasks), s.redispatcher.Size())
+	s.redispatcher.Lock()
+	matchedTaskQueued := false
+	for _, queue := range s.redispatcher.taskQueues {
+		for _, item := range queue.Snapshot() {
+			if _, ok := matchedTasks[item.(redispatchTask).task]; ok {
+				matchedTaskQueued = true
+			}
+		}
+	}
+	s.redispatcher.Unlock()
+	s.False(matchedTaskQueued)
+}
+
+func (s *redispatcherSuite) TestRedispatch_TaskTransform() {
+	rewrittenTask := NewMockTask(s.controller)
+	rewrittenTask.EXPECT().Priority().Return(0).AnyTimes()
+	rewrittenTask.EXPECT().GetDomainID().Return("testDomainID").AnyTimes()
+	originalTask := NewMockTask(s.controller)
+	originalTask.EXPECT().Priority().Return(0).AnyTimes()
+	droppedTask := NewMockTask(s.controller)
+	droppedTask.EXPECT().Priority().Return(0).AnyTimes()
+	droppedTask.EXPECT().Ack().Times(1)
+	unchangedTask := NewMockTask(s.controller)
+	unchangedTask.EXPECT().Priority().Return(0).AnyTimes()
+	unchangedTask.EXPECT().GetDomainID().Return("testDomainID").AnyTimes()
+
+	s.redispatcher.options.TaskTransform = func(task Task) (Task, bool) {
+		switch task {
+		case originalTask:
+			return rewrittenTask, true
+		case droppedTask:
+			return nil, false
+		default:
+			return task, true
+		}
+	}
+
+	s.mockProcessor.EXPECT().TrySubmit(NewMockTaskMatcher(rewrittenTask)).Return(true, nil).Times(1)
+	s.mockProcessor.EXPECT().TrySubmit(NewMockTaskMatcher(unchangedTask)).Return(true, nil).Times(1)
+
+	s.redispatcher.AddTask(originalTask)
+	s.redispatcher.AddTask(droppedTask)
+	s.redispatcher.AddTask(unchangedTask)
+
+	s.redispatcher.Redispatch(0)
+	s.Equal(0, s.redispatcher.Size())
+}
+
+func (s *redispatcherSuite) TestRedispatch_TaskTransform_Requeued() {
+	s.redispatcher.options.TaskSizeByDomainIDTracked = true
+	originalTask := NewMockTask(s.controller)
+	originalTask.EXPECT().Priority().Return(0).AnyTimes()
+	originalTask.EXPECT().GetDomainID().Return("testDomainID").AnyTimes()
+
+	var transformedTasks []Task
+	s.redispatcher.options.TaskTransform = func(task Task) (Task, bool) {
+		s.True(task == originalTask)
+		transformedTask := NewMockTask(s.controller)
+		transformedTask.EXPECT().Priority().Return(0).AnyTimes()
+		transformedTask.EXPECT().GetDomainID().Return("testDomainID").AnyTimes()
+		transformedTasks = append(transformedTasks, transformedTask)
+		return transformedTask, true
+	}
+
+	numAttempts := 0
+	s.mockProcessor.EXPECT().TrySubmit(gomock.Any()).DoAndReturn(func(task Task) (bool, error) {
+		numAttempts++
+		s.True(task == transformedTasks[len(transformedTasks)-1])
+		return numAttempts > 2, nil
+	}).Times(3)
+
+	s.redispatcher.AddTask(originalTask)
+
+	// the task is requeued twice, the original task should be kept in the queue
+	for attempt := 1; attempt <= 2; attempt++ {
+		s.redispatcher.Redispatch(0)
+		s.Len(transformedTasks, attempt)
+		remainingTasks := s.redispatcher.Snapshot()
+		s.Len(remainingTasks, 1)
+		s.True(remainingTasks[0] == originalTask)
+		s.Equal(1, s.redispatcher.SizeByDomainID("testDomainID"))
+	}
+
+	s.redispatcher.Redispatch(0)
+	s.Len(transformedTasks, 3)
+	s.Zero(s.redispatcher.Size())
+	s.Zero(s.redispatcher.SizeByDomainID("testDomainID"))
+}
+
+func (s *redispatcherSuite) TestRedispatch_TaskShouldSubmit() {
+	heldTask := NewMockTask(s.controller)
+	heldTask.EXPECT().Priority().Return(0).AnyTimes()
+	heldTask.EXPECT().GetDomainID().Return("testDomainID").AnyTimes()
+	readyTask := NewMockTask(s.controller)
+	readyTask.EXPECT().Priority().Return(0).AnyTimes()
+	readyTask.EXPECT().GetDomainID().Return("testDomainID").AnyTimes()
+
+	dependencyReady := false
+	s.redispatcher.options.TaskShouldSubmit = func(task Task) bool {
+		return task != heldTask || dependencyReady
+	}
+
+	s.mockProcessor.EXPECT().TrySubmit(NewMockTaskMatcher(readyTask)).Return(true, nil).Times(1)
+	s.redispatcher.AddTask(heldTask)
+	s.redispatcher.AddTask(readyTask)
+
+	// the held task stays in the queue without being submitted
+	s.redispatcher.Redispatch(0)
+	s.Equal([]Task{heldTask}, s.redispatcher.Snapshot())
+
+	// the held task is retried in the next pass
+	dependencyReady = true
+	s.mockProcessor.EXPECT().TrySubmit(NewMockTaskMatcher(heldTask)).Return(true, nil).Times(1)
+	s.redispatcher.Redispatch(0)
+	s.Zero(s.redispatcher.Size())
+}
+
+func (s *redispatcherSuite) TestRedispatch_SubmitResultClassifier() {
+	errTaskInvalid := errors.New("task no longer valid")
+	s.redispatcher.options.TaskSubmitResultClassifier = func(task Task, submitted bool, err error) SubmitAction {
+		if err == errTaskInvalid {
+			return SubmitActionDrop
+		}
+		if err != nil || !submitted {
+			return SubmitActionRequeue
+		}
+		return SubmitActionSubmitted
+	}
+
+	invalidTask := NewMockTask(s.controller)
+	invalidTask.EXPECT().Priority().Return(0).AnyTimes()
+	invalidTask.EXPECT().GetDomainID().Return("testDomainID").AnyTimes()
+	invalidTask.EXPECT().Ack().Times(1)
+	s.mockProcessor.EXPECT().TrySubmit(NewMockTaskMatcher(invalidTask)).Return(false, errTaskInvalid).Times(1)
+	s.redispatcher.AddTask(invalidTask)
+
+	failedTask := NewMockTask(s.controller)
+	failedTask.EXPECT().Priority().Return(0).AnyTimes()
+	failedTask.EXPECT().GetDomainID().Return("testDomainID").AnyTimes()
+	s.mockProcessor.EXPECT().TrySubmit(NewMockTaskMatcher(failedTask)).Return(false, errors.New("some random error")).Times(1)
+	s.redispatcher.AddTask(failedTask)
+
+	submittedTask := NewMockTask(s.controller)
+	submittedTask.EXPECT().Priority().Return(0).AnyTimes()
+	submittedTask.EXPECT().GetDomainID().Return("testDomainID").AnyTimes()
+	s.mockProcessor.EXPECT().TrySubmit(NewMockTaskMatcher(submittedTask)).Return(true, nil).Times(1)
+	s.redispatcher.AddTask(submittedTask)
+
+	s.redispatcher.Redispatch(0)
+	remainingTasks := s.redispatcher.Snapshot()
+	s.Len(remainingTasks, 1)
+	s.True(remainingTasks[0] == failedTask)
+}
+
+func (s *redispatcherSuite) TestRedispatch_SizeByDomainID() {
+	s.redispatcher.options.TaskSizeByDomainIDTracked = true
+	errTaskInvalid := errors.New("task no longer valid")
+	s.redispatcher.options.TaskSubmitResultClassifier = func(task Task, submitted bool, err error) SubmitAction {
+		if err == errTaskInvalid {
+			return SubmitActionDrop
+		}
+		if err != nil || !submitted {
+			return SubmitActionRequeue
+		}
+		return SubmitActionSubmitted
+	}
+
+	submittedTask := NewMockTask(s.controller)
+	submittedTask.EXPECT().Priority().Return(0).AnyTimes()
+	submittedTask.EXPECT().GetDomainID().Return("testDomainID").AnyTimes()
+	s.mockProcessor.EXPECT().TrySubmit(NewMockTaskMatcher(submittedTask)).Return(true, nil).Times(1)
+	s.redispatcher.AddTask(submittedTask)
+
+	invalidTask := NewMockTask(s.controller)
+	invalidTask.EXPECT().Priority().Return(0).AnyTimes()
+	invalidTask.EXPECT().GetDomainID().Return("testDomainID").AnyTimes()
+	invalidTask.EXPECT().Ack().Times(1)
+	s.mockProcessor.EXPECT().TrySubmit(NewMockTaskMatcher(invalidTask)).Return(false, errTaskInvalid).Times(1)
+	s.redispatcher.AddTask(invalidTask)
+
+	failedTask := NewMockTask(s.controller)
+	failedTask.EXPECT().Priority().Return(0).AnyTimes()
+	failedTask.EXPECT().GetDomainID().Return("otherDomainID").AnyTimes()
+	s.mockProcessor.EXPECT().TrySubmit(NewMockTaskMatcher(failedTask)).Return(false, errors.New("some random error")).Times(1)
+	s.redispatcher.AddTask(failedTask)
+
+	s.Equal(2, s.redispatcher.SizeByDomainID("testDomainID"))
+	s.Equal(1, s.redispatcher.SizeByDomainID("otherDomainID"))
+
+	s.redispatcher.Redispatch(0)
+	s.Zero(s.redispatcher.SizeByDomainID("testDomainID"))
+	s.Equal(1, s.redispatcher.SizeByDomainID("otherDomainID"))
+}
+
+func (s *redispatcherSuite) TestRedispatch_SubmitStatsByDomainID() {
+	numTasks := 5
+	rejectedDomainID := "rejectedDomain"
+	submittedDomainID := "submittedDomain"
+
+	for i := 0; i != numTasks; i++ {
+		rejectedTask := NewMockTask(s.controller)
+		rejectedTask.EXPECT().Priority().Return(0).AnyTimes()
+		rejectedTask.EXPECT().GetDomainID().Return(rejectedDomainID).AnyTimes()
+		s.mockProcessor.EXPECT().TrySubmit(NewMockTaskMatcher(rejectedTask)).Return(false, errors.New("some random error")).MinTimes(1)
+		s.redispatcher.AddTask(rejectedTask)
+
+		submittedTask := NewMockTask(s.controller)
+		submittedTask.EXPECT().Priority().Return(1).AnyTimes()
+		submittedTask.EXPECT().GetDomainID().Return(submittedDomainID).AnyTimes()
+		s.mockProcessor.EXPECT().TrySubmit(NewMockTaskMatcher(submittedTask)).Return(true, nil).Times(1)
+		s.redispatcher.AddTask(submittedTask)
+	}
+
+	result := s.redispatcher.Redispatch(0)
+	s.Equal(map[string]*RedispatchSubmitStats{
+		rejectedDomainID: {
+			Submitted: 0,
+			Rejected:  numTasks,
+		},
+		submittedDomainID: {
+			Submitted: numTasks,
+			Rejected:  0,
+		},
+	}, result.SubmitStatsByDomainID)
+	s.Equal(numTasks, s.redispatcher.Size())
+}
+
+func (s *redispatcherSuite) TestRedispatch_BatchSizeByDomainID() {
+	numTasks := 5
+	heavyDomainID := "heavyDomain"
+	heavyDomainBatchSize := 2
+	otherDomainID := "otherDomain"
+
+	s.redispatcher.options.TaskRedispatchBatchSizeByDomainID = func(domainID string) int {
+		if domainID == heavyDomainID {
+			return heavyDomainBatchSize
+		}
+		return 0
+	}
+
+	for i := 0; i != numTasks; i++ {
+		heavyDomainTask := NewMockTask(s.controller)
+		heavyDomainTask.EXPECT().Priority().Return(0).AnyTimes()
+		heavyDomainTask.EXPECT().GetDomainID().Return(heavyDomainID).AnyTimes()
+		s.redispatcher.AddTask(heavyDomainTask)
+
+		otherDomainTask := NewMockTask(s.controller)
+		otherDomainTask.EXPECT().Priority().Return(0).AnyTimes()
+		otherDomainTask.EXPECT().GetDomainID().Return(otherDomainID).AnyTimes()
+		s.redispatcher.AddTask(otherDomainTask)
+	}
+	s.mockProcessor.EXPECT().TrySubmit(gomock.Any()).Return(true, nil).Times(numTasks + heavyDomainBatchSize)
+
+	result := s.redispatcher.Redispatch(0)
+	s.Equal(map[string]*RedispatchSubmitStats{
+		heavyDomainID: {
+			Submitted: heavyDomainBatchSize,
+			Rejected:  0,
+		},
+		otherDomainID: {
+			Submitted: numTasks,
+			Rejected:  0,
+		},
+	}, result.SubmitStatsByDomainID)
+	s.Equal(numTasks-heavyDomainBatchSize, s.redispatcher.Size())
+}
+
+func (s *redispatcherSuite) TestRedispatch_AdaptiveBatchSize() {
+	s.redispatcher.options.TaskRedispatchMinBatchSize = dynamicconfig.GetIntPropertyFn(2)
+	s.redispatcher.options.TaskRedispatchMaxBatchSize = dynamicconfig.GetIntPropertyFn(8)
+
+	numTasks := 50
+	for i := 0; i != numTasks; i++ {
+		mockTask := NewMockTask(s.controller)
+		mockTask.EXPECT().Priority().Return(0).AnyTimes()
+		mockTask.EXPECT().GetDomainID().Return("testDomainID").AnyTimes()
+		s.redispatcher.AddTask(mockTask)
+	}
+
+	submitSucceeds := true
+	s.mockProcessor.EXPECT().TrySubmit(gomock.Any()).DoAndReturn(func(task Task) (bool, error) {
+		if submitSucceeds {
+			return true, nil
+		}
+		return false, errors.New("some random error")
+	}).AnyTimes()
+
+	testCases := []struct {
+		submitSucceeds    bool
+		expectedAttempted int
+		expectedBatchSize int
+	}{
+		// batch size starts at the min batch size and grows on success
+		{submitSucceeds: true, expectedAttempted: 2, expectedBatchSize: 4},
+		{submitSucceeds: true, expectedAttempted: 4, expectedBatchSize: 8},
+		// batch size never goes beyond the max batch size
+		{submitSucceeds: true, expectedAttempted: 8, expectedBatchSize: 8},
+		{submitSucceeds: false, expectedAttempted: 8, expectedBatchSize: 4},
+		{submitSucceeds: true, expectedAttempted: 4, expectedBatchSize: 8},
+		{submitSucceeds: false, expectedAttempted: 8, expectedBatchSize: 4},
+		{submitSucceeds: false, expectedAttempted: 4, expectedBatchSize: 2},
+		// batch size never goes below the min batch size
+		{submitSucceeds: false, expectedAttempted: 2, expectedBatchSize: 2},
+	}
+	for _, tc := range testCases {
+		submitSucceeds = tc.submitSucceeds
+		result := s.redispatcher.Redispatch(0)
+		stats := result.SubmitStatsByDomainID["testDomainID"]
+		s.Equal(tc.expectedAttempted, stats.Submitted+stats.Rejected)
+		s.Equal(tc.expectedBatchSize, s.redispatcher.batchSize)
+	}
+}
+
+func (s *redispatcherSuite) TestRedispatch_OldestTaskAge() {
+	now := time.Now()
+	timeSource := clock.NewEventTimeSource().Update(now)
+	testScope := tally.NewTestScope("", nil)
+
+	s.redispatcher.Stop()
+	s.redispatcher = NewRedispatcher(
+		s.mockProcessor,
+		timeSource,
+		s.redispatcher.options,
+		s.logger,
+		metrics.NewClient(testScope, metrics.History).Scope(0),
+	).(*redispatcherImpl)
+	s.redispatcher.Start()
+
+	oldTask := NewMockTask(s.controller)
+	oldTask.EXPECT().Priority().Return(0).AnyTimes()
+	oldTask.EXPECT().GetDomainID().Return("testDomainID").AnyTimes()
+	newTask := NewMockTask(s.controller)
+	newTask.EXPECT().Priority().Return(0).AnyTimes()
+	newTask.EXPECT().GetDomainID().Return("testDomainID").AnyTimes()
+	s.mockProcessor.EXPECT().TrySubmit(gomock.Any()).Return(false, nil).AnyTimes()
+
+	s.redispatcher.AddTask(oldTask)
+	timeSource.Update(now.Add(10 * time.Minute))
+	s.redispatcher.AddTask(newTask)
+
+	s.redispatcher.Redispatch(0)
+	s.Equal(2, s.redispatcher.Size())
+
+	found := false
+	for _, gauge := range testScope.Snapshot().Gauges() {
+		if gauge.Name() == "task_redispatch_queue_oldest_task_age" {
+			found = true
+			s.Equal((10 * time.Minute).Seconds(), gauge.Value())
+		}
+	}
+	s.True(found)
+}
+
+func (s *redispatcherSuite) TestRedispatch_SubmitLatency() {
+	testScope := tally.NewTestScope("", nil)
+
+	s.redispatcher.Stop()
+	s.redispatcher = NewRedispatcher(
+		s.mockProcessor,
+		clock.NewRealTimeSource(),
+		s.redispatcher.options,
+		s.logger,
+		metrics.NewClient(testScope, metrics.History).Scope(0),
+	).(*redispatcherImpl)
+	s.redispatcher.Start()
+
+	submittedTask := NewMockTask(s.controller)
+	submittedTask.EXPECT().Priority().Return(0).AnyTimes()
+	submittedTask.EXPECT().GetDomainID().Return("testDomainID").AnyTimes()
+	rejectedTask := NewMockTask(s.controller)
+	rejectedTask.EXPECT().Priority().Return(1).AnyTimes()
+	rejectedTask.EXPECT().GetDomainID().Return("testDomainID").AnyTimes()
+	s.mockProcessor.EXPECT().TrySubmit(submittedTask).Return(true, nil).Times(1)
+	s.mockProcessor.EXPECT().TrySubmit(rejectedTask).Return(false, nil).AnyTimes()
+
+	s.redispatcher.AddTask(submittedTask)
+	s.redispatcher.AddTask(rejectedTask)
+	s.redispatcher.Redispatch(0)
+
+	numSubmitsByResult := make(map[string]int64)
+	for _, histogram := range testScope.Snapshot().Histograms() {
+		if histogram.Name() != "task_redispatch_submit_latency" {
+			continue
+		}
+		for _, count := range histogram.Durations() {
+			numSubmitsByResult[histogram.Tags()["submitResult"]] += count
+		}
+	}
+	s.Equal(int64(1), numSubmitsByResult["success"])
+	s.True(numSubmitsByResult["failure"] >= 1)
+}
+
+func (s *redispatcherSuite) TestRedispatch_NilTask() {
+	testScope := tally.NewTestScope("", nil)
+
+	s.redispatcher.Stop()
+	s.redispatcher = NewRedispatcher(
+		s.mockProcessor,
+		clock.NewRealTimeSource(),
+		s.redispatcher.options,
+		s.logger,
+		metrics.NewClient(testScope, metrics.History).Scope(0),
+	).(*redispatcherImpl)
+	s.redispatcher.Start()
+
+	numTasks := 3
+	for i := 0; i != numTasks; i++ {
+		mockTask := NewMockTask(s.controller)
+		mockTask.EXPECT().Priority().Return(0).AnyTimes()
+		mockTask.EXPECT().GetDomainID().Return("testDomainID").AnyTimes()
+		s.mockProcessor.EXPECT().TrySubmit(mockTask).Return(true, nil).Times(1)
+		s.redispatcher.AddTask(mockTask)
+	}
+
+	s.redispatcher.AddTask(nil)
+	s.Equal(numTasks, s.redispatcher.Size())
+
+	// simulate a nil task ending up in the redispatch queue through a bug
+	s.redispatcher.Lock()
+	s.redispatcher.taskQueues[0].Add(redispatchTask{task: nil})
+	s.redispatcher.Unlock()
+	s.Len(s.redispatcher.Snapshot(), numTasks)
+
+	s.redispatcher.Redispatch(0)
+	s.Zero(s.redispatcher.Size())
+
+	var numNilTasks int64
+	for _, counter := range testScope.Snapshot().Counters() {
+		if counter.Name() == "task_redispatch_nil_task" {
+			numNilTasks += counter.Value()
+		}
+	}
+	s.Equal(int64(2), numNilTasks)
+}
+
+func (s *redispatcherSuite) TestRedispatch_SubmitAge() {
+	now := time.Now()
+	timeSource := clock.NewEventTimeSource().Update(now)
+	testScope := tally.NewTestScope("", nil)
+
+	s.redispatcher.Stop()
+	options := s.redispatcher.options
+	options.TaskSubmitAgeEnabled = dynamicconfig.GetBoolPropertyFn(true)
+	s.redispatcher = NewRedispatcher(
+		s.mockProcessor,
+		timeSource,
+		options,
+		s.logger,
+		metrics.NewClient(testScope, metrics.History).Scope(0),
+	).(*redispatcherImpl)
+	s.redispatcher.Start()
+
+	submittedTask := NewMockTask(s.controller)
+	submittedTask.EXPECT().Priority().Return(0).AnyTimes()
+	submittedTask.EXPECT().GetDomainID().Return("testDomainID").AnyTimes()
+	submittedTask.EXPECT().GetTaskType().Return(1).AnyTimes()
+	submittedTask.EXPECT().GetVisibilityTimestamp().Return(now.Add(-3 * time.Second)).AnyTimes()
+	rejectedTask := NewMockTask(s.controller)
+	rejectedTask.EXPECT().Priority().Return(1).AnyTimes()
+	rejectedTask.EXPECT().GetDomainID().Return("testDomainID").AnyTimes()
+	s.mockProcessor.EXPECT().TrySubmit(NewMockTaskMatcher(submittedTask)).Return(true, nil).Times(1)
+	s.mockProcessor.EXPECT().TrySubmit(NewMockTaskMatcher(rejectedTask)).Return(false, nil).AnyTimes()
+
+	s.redispatcher.AddTask(submittedTask)
+	s.redispatcher.AddTask(rejectedTask)
+	timeSource.Update(now.Add(2 * time.Second))
+	s.redispatcher.Redispatch(0)
+
+	numRecorded := 0
+	for _, histogram := range testScope.Snapshot().Histograms() {
+		if histogram.Name() != "task_redispatch_submit_age" {
+			continue
+		}
+		s.Equal("testDomainID", histogram.Tags()["domain"])
+		s.Equal("1", histogram.Tags()["taskType"])
+		for upperBound, count := range histogram.Durations() {
+			if count == 0 {
+				continue
+			}
+			// the task has waited 5 seconds since its visibility timestamp
+			s.True(upperBound >= 5*time.Second)
+			s.True(upperBound < 10*time.Second)
+			numRecorded += int(count)
+		}
+	}
+	s.Equal(1, numRecorded)
+}
+
+func (s *redispatcherSuite) TestRedispatch_RequeueDelay() {
+	now := time.Now()
+	timeSource := clock.NewEventTimeSource().Update(now)
+	requeueDelay := time.Minute
+
+	s.redispatcher.Stop()
+	options := *s.redispatcher.options
+	options.TaskRequeueDelay = dynamicconfig.GetDurationPropertyFn(requeueDelay)
+	options.TaskRequeueMaxDelay = dynamicconfig.GetDurationPropertyFn(5 * time.Minute)
+	// only redispatch explicitly, so that the time source is not read concurrently
+	options.TaskRedispatchInterval = dynamicconfig.GetDurationPropertyFn(time.Hour)
+	s.redispatcher = NewRedispatcher(
+		s.mockProcessor,
+		timeSource,
+		&options,
+		s.logger,
+		s.metricsScope,
+	).(*redispatcherImpl)
+	s.redispatcher.Start()
+
+	mockTask := NewMockTask(s.controller)
+	mockTask.EXPECT().Priority().Return(0).AnyTimes()
+	mockTask.EXPECT().GetDomainID().Return("testDomainID").AnyTimes()
+	numSubmits := 0
+	s.mockProcessor.EXPECT().TrySubmit(mockTask).DoAndReturn(func(Task) (bool, error) {
+		numSubmits++
+		// reject the task twice before accepting it
+		return numSubmits > 2, nil
+	}).Times(3)
+
+	s.redispatcher.AddTask(mockTask)
+	s.redispatcher.Redispatch(0)
+	s.Equal(1, numSubmits)
+	s.Equal(1, s.redispatcher.Size())
+
+	// the rejected task is not retried until the requeue delay elapses
+	s.redispatcher.Redispatch(0)
+	s.Equal(1, numSubmits)
+	s.Equal([]Task{mockTask}, s.redispatcher.Snapshot())
+
+	timeSource.Update(now.Add(requeueDelay))
+	s.redispatcher.Redispatch(0)
+	s.Equal(2, numSubmits)
+
+	// the delay doubles after the second rejection
+	timeSource.Update(now.Add(2 * requeueDelay))
+	s.redispatcher.Redispatch(0)
+	s.Equal(2, numSubmits)
+
+	timeSource.Update(now.Add(3 * requeueDelay))
+	s.redispatcher.Redispatch(0)
+	s.Equal(3, numSubmits)
+	s.Zero(s.redispatcher.Size())
+}
+
+func (s *redispatcherSuite) TestRedispatch_RingBufferQueue() {
+	s.redispatcher.options.TaskQueueRingBufferCapacity = 2
+
+	// more tasks than the capacity are added, the ring buffer grows and keeps them in order
+	var tasks []Task
+	for i := 0; i != 5; i++ {
+		mockTask := NewMockTask(s.controller)
+		mockTask.EXPECT().Priority().Return(0).AnyTimes()
+		mockTask.EXPECT().GetDomainID().Return("testDomainID").AnyTimes()
+		tasks = append(tasks, mockTask)
+		s.redispatcher.AddTask(mockTask)
+	}
+	s.Equal(tasks, s.redispatcher.Snapshot())
+
+	rejectedTask := tasks[1]
+	for _, task := range tasks {
+		if task == rejectedTask {
+			continue
+		}
+		s.mockProcessor.EXPECT().TrySubmit(NewMockTaskMatcher(task.(*MockTask))).Return(true, nil).Times(1)
+	}
+	gomock.InOrder(
+		s.mockProcessor.EXPECT().TrySubmit(NewMockTaskMatcher(rejectedTask.(*MockTask))).Return(false, errors.New("some random error")).Times(1),
+		s.mockProcessor.EXPECT().TrySubmit(NewMockTaskMatcher(rejectedTask.(*MockTask))).Return(true, nil).Times(1),
+	)
+
+	// the rejected task is requeued to the ring buffer and submitted by the next pass
+	s.redispatcher.Redispatch(0)
+	s.Equal([]Task{rejectedTask}, s.redispatcher.Snapshot())
+	s.redispatcher.Redispatch(0)
+	s.Zero(s.redispatcher.Size())
+}
+
+func (s *redispatcherSuite) TestGetRequeueDelay() {
+	s.Zero(s.redispatcher.getRequeueDelay(1))
+
+	s.redispatcher.options.TaskRequeueDelay = dynamicconfig.GetDurationPropertyFn(time.Second)
+	s.Equal(time.Second, s.redispatcher.getRequeueDelay(1))
+	s.Equal(4*time.Second, s.redispatcher.getRequeueDelay(3))
+	s.True(s.redispatcher.getRequeueDelay(100) > 0)
+
+	s.redispatcher.options.TaskRequeueMaxDelay = dynamicconfig.GetDurationPropertyFn(10 * time.Second)
+	s.Equal(8*time.Second, s.redispatcher.getRequeueDelay(4))
+	s.Equal(10*time.Second, s.redispatcher.getRequeueDelay(5))
+	s.Equal(10*time.Second, s.redispatcher.getRequeueDelay(100))
+}
+
 func (s *redispatcherSuite) newTestRedispatcher() *redispatcherImpl {
 	return NewRedispatcher(
 		s.mockProcessor,
+		clock.NewRealTimeSource(),
 		&RedispatcherOptions{
 			TaskRedispatchInterval:                  dynamicconfig.GetDurationPropertyFn(time.Millisecond * 50),
 			TaskRedispatchIntervalJitterCoefficient: dynamicconfig.GetFloatPropertyFn(0.15),
@@ -426,6 +426,10 @@ func (t *taskBase) GetQueueType() QueueType {
 	return t.queueType
 }
 
+func (t *taskBase) GetTaskCategory() Category {
+	return t.queueType.Category()
+}
+
 func (t *taskBase) shouldResubmitOnNack() bool {
 	// TODO: for now only resubmit active task on Nack()
 	// we can also consider resubmit standby tasks that fails due to certain error types
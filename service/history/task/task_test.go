@@ -209,6 +209,23 @@ func (s *taskSuite) TestTaskPriority() {
 	s.Equal(priority, taskBase.Priority())
 }
 
+func (s *taskSuite) TestTaskCategory() {
+	taskBase := s.newTestQueueTaskBase(func(task Info) (bool, error) {
+		return true, nil
+	})
+	s.Equal(CategoryTransfer, taskBase.GetTaskCategory())
+
+	for queueType, expectedCategory := range map[QueueType]Category{
+		QueueTypeActiveTransfer:  CategoryTransfer,
+		QueueTypeStandbyTransfer: CategoryTransfer,
+		QueueTypeActiveTimer:     CategoryTimer,
+		QueueTypeStandbyTimer:    CategoryTimer,
+		QueueTypeReplication:     CategoryReplication,
+	} {
+		s.Equal(expectedCategory, queueType.Category())
+	}
+}
+
 func (s *taskSuite) TestTaskNack_ResubmitSucceeded() {
 	task := &transferTask{
 		taskBase: s.newTestQueueTaskBase(
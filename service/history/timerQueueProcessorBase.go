@@ -133,6 +133,7 @@ func newTimerQueueProcessorBase(
 		queueTaskProcessor: queueTaskProcessor,
 		redispatcher: task.NewRedispatcher(
 			queueTaskProcessor,
+			shard.GetTimeSource(),
 			redispatcherOptions,
 			logger,
 			metricsScope,